@@ -2,18 +2,18 @@ package podbridge5
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/containers/buildah"
 	is "github.com/containers/image/v5/storage"
-	imageTypes "github.com/containers/image/v5/types"
 	"github.com/containers/storage"
 	"github.com/seoyhaein/utils"
-	"os"
+	"io"
 )
 
 // BuildConfig holds basic configuration for building an image,
 type BuildConfig struct {
+	Version   int             `json:"version"`           // 설정 스키마 버전 (ConfigSchemaVersion)
+	Include   []string        `json:"include,omitempty"` // 먼저 읽어서 이 파일로 덮어쓸 기본 설정 파일들 (NewConfigFromFile 참고)
 	Image     ImageConfig     `json:"image"`
 	Container ContainerConfig `json:"container"`
 }
@@ -40,9 +40,26 @@ type ImageConfig struct {
 	DockerfilePath  string              `json:"dockerfilePath"`  // Dockerfile 경로
 	Directories     []string            `json:"directories"`     // 빌드 과정 중 컨테이너 내부에서 생성할 디렉토리 목록
 	ScriptMap       map[string][]string `json:"scriptMap"`       // 각 디렉토리에 복사할 스크립트 파일 목록
+	ScriptModes     map[string]FileMode `json:"scriptModes"`     // ScriptMap 의 소스 경로별 권한과 소유자. 없으면 0755, root:root
 	PermissionFiles []string            `json:"permissionFiles"` // 파일 권한 설정이 필요한 파일 목록 (최종 경로 기준)
 	WorkDir         string              `json:"workDir"`         // 빌드 시 컨테이너의 작업 디렉토리
 	CMD             []string            `json:"cmd"`             // 빌드 완료 후 컨테이너 시작 시 실행할 명령어
+	ContextDir      string              `json:"contextDir"`      // Dockerfile 빌드 컨텍스트. 비어 있으면 Dockerfile 이 있는 디렉토리
+	BuildArgs       map[string]string   `json:"buildArgs"`       // Dockerfile ARG 값
+	Target          string              `json:"target"`          // 멀티 스테이지 빌드의 대상 스테이지
+	Labels          map[string]string   `json:"labels"`          // 이미지에 붙일 라벨
+	Platform        string              `json:"platform"`        // 예: "linux/amd64", "linux/arm64/v8"
+	Platforms       []string            `json:"platforms"`       // CreateMultiArchImage 로 빌드할 플랫폼 목록 (예: ["linux/amd64", "linux/arm64"])
+	IgnoreFile      string              `json:"ignoreFile"`      // 비어 있으면 컨텍스트의 .containerignore, .dockerignore
+	Steps           []BuildStep         `json:"steps"`           // 있으면 Directories, ScriptMap, PermissionFiles, 패키지 설치 대신 순서대로 실행
+	User            *UserConfig         `json:"user"`            // 있으면 빌드 중에 만들어 이미지의 USER 로 설정. 없으면 root 로 실행
+	Packages        []string            `json:"packages"`        // 베이스 이미지의 패키지 매니저(apk, apt, dnf, yum, microdnf, zypper)로 설치할 패키지. 이미 설치된 패키지는 건너뛴다
+	DistroPackages  map[string][]string `json:"distroPackages"`  // 패키지 매니저별로 추가 설치할 패키지 (예: {"apt": ["procps"], "dnf": ["procps-ng"]})
+	SBOM            string              `json:"sbom"`            // "spdx" 또는 "cyclonedx" 면 설치된 패키지의 SBOM 을 만들어 이미지 아카이브 옆에 저장한다
+	ArchiveFormat   string              `json:"archiveFormat"`   // ImageSavePath 에 저장할 형식. docker-archive(기본) 또는 oci-archive. Encryption 이 있으면 oci-archive
+	Encryption      *ImageEncryption    `json:"encryption"`      // 있으면 레이어를 암호화한 oci-archive 로 저장한다. 워커 노드에서는 LoadEncryptedImage 로 로드한다
+
+	configDir string // 설정 파일(include 포함)이 있는 디렉토리. NewConfigFromFile 이 채우며 절대 경로 소스를 복사할 때의 컨텍스트이다
 }
 
 /*
@@ -58,15 +75,22 @@ type ImageConfig struct {
 type ContainerConfig struct {
 	ExecutorShell    string              `json:"executorShell"`    // 예: "./executor.sh"
 	HealthcheckShell string              `json:"healthcheckShell"` // 예: "./healthcheck.sh"
-	InstallShell     string              `json:"installShell"`     // 예: "./install.sh"
+	InstallShell     string              `json:"installShell"`     // 사용하지 않음. 이전 설정과의 호환을 위해 남겨 둔다 (패키지는 Packages 로 설치)
 	UserScriptShell  string              `json:"userScriptShell"`  // 예: "./scripts/user_script.sh"
 	Directories      []string            `json:"directories"`      // 컨테이너 실행 시 내부에서 미리 생성할 디렉토리 목록
 	ScriptMap        map[string][]string `json:"scriptMap"`        // 각 디렉토리에 복사할 스크립트 파일 목록
+	ScriptModes      map[string]FileMode `json:"scriptModes"`      // ScriptMap 의 소스 경로별 권한과 소유자. 없으면 0755, root:root
 	PermissionFiles  []string            `json:"permissionFiles"`  // 파일 권한 설정이 필요한 파일 목록 (최종 경로 기준)
 	WorkDir          string              `json:"workDir"`          // 컨테이너의 작업 디렉토리
 	Cmd              []string            `json:"cmd"`              // 컨테이너 시작 시 실행할 명령어
+	Env              map[string]string   `json:"env"`              // 컨테이너 환경 변수
 	Resources        ResourceSettings    `json:"resources"`        // 컨테이너 리소스 제한 설정
 	Volumes          []VolumeConfig      `json:"volumes"`          // 볼륨 마운트 설정
+	Steps            []BuildStep         `json:"steps"`            // 있으면 Directories, ScriptMap, PermissionFiles, 패키지 설치 대신 순서대로 실행
+	Packages         []string            `json:"packages"`         // 베이스 이미지의 패키지 매니저로 설치할 패키지
+	DistroPackages   map[string][]string `json:"distroPackages"`   // 패키지 매니저별로 추가 설치할 패키지
+
+	configDir string // 설정 파일(include 포함)이 있는 디렉토리. ImageConfig 와 같다
 }
 
 /*
@@ -98,13 +122,20 @@ type ResourceSettings struct {
 type VolumeConfig struct {
 	HostPath      string `json:"hostPath"`
 	ContainerPath string `json:"containerPath"`
+	ReadOnly      bool   `json:"readOnly"` // true 면 컨테이너에서 읽기만 할 수 있다
 }
 
+// defaultPackages 는 executor.sh, healthcheck.sh 가 사용하는 도구들이다. (이전의 install.sh 가 설치하던 패키지)
+var defaultPackages = []string{"bash", "coreutils", "util-linux", "procps"}
+
 // NewConfig creates a new BuildConfig using the provided sourceImageName.
+// executor.sh, healthcheck.sh 가 사용하는 bash, flock(util-linux), pgrep(procps) 등을 설치하도록 defaultPackages 를 넣는다.
+// 이미 설치된 패키지는 건너뛰므로 도구가 있는 베이스 이미지는 네트워크 없이 빌드된다. 설치하지 않으려면 SetPackages(nil) 로 비운다.
 // TODO 리소스 설정하는 부분은 수정할 필요 있음.
 func NewConfig(sourceImageName string) *BuildConfig {
 	internalImgName := internalizeImageName(sourceImageName)
 	return &BuildConfig{
+		Version: ConfigSchemaVersion,
 		Image: ImageConfig{
 			SourceImageName: sourceImageName,
 			ImageName:       internalImgName,
@@ -112,36 +143,35 @@ func NewConfig(sourceImageName string) *BuildConfig {
 			DockerfilePath:  "./Dockerfile",
 			Directories:     []string{"/app", "/app/scripts"},
 			ScriptMap: map[string][]string{
-				"/app":         {"./executor.sh", "./healthcheck.sh", "./install.sh"},
+				"/app":         {"./executor.sh", "./healthcheck.sh"},
 				"/app/scripts": {"./scripts/user_script.sh"},
 			},
 			PermissionFiles: []string{
 				"/app/executor.sh",
-				"/app/install.sh",
 				"/app/healthcheck.sh",
 				"/app/scripts/user_script.sh",
 			},
-			WorkDir: "/app",
-			CMD:     []string{"/bin/sh", "-c", "/app/executor.sh"},
+			WorkDir:  "/app",
+			CMD:      []string{"/bin/sh", "-c", "/app/executor.sh"},
+			Packages: append([]string(nil), defaultPackages...),
 		},
 		Container: ContainerConfig{
 			ExecutorShell:    "./executor.sh",
 			HealthcheckShell: "./healthcheck.sh",
-			InstallShell:     "./install.sh",
 			UserScriptShell:  "./scripts/user_script.sh",
 			Directories:      []string{"/app", "/app/scripts"},
 			ScriptMap: map[string][]string{
-				"/app":         {"./executor.sh", "./healthcheck.sh", "./install.sh"},
+				"/app":         {"./executor.sh", "./healthcheck.sh"},
 				"/app/scripts": {"./scripts/user_script.sh"},
 			},
 			PermissionFiles: []string{
 				"/app/executor.sh",
-				"/app/install.sh",
 				"/app/healthcheck.sh",
 				"/app/scripts/user_script.sh",
 			},
-			WorkDir: "/app",
-			Cmd:     []string{"/bin/sh", "-c", "/app/executor.sh"},
+			WorkDir:  "/app",
+			Cmd:      []string{"/bin/sh", "-c", "/app/executor.sh"},
+			Packages: append([]string(nil), defaultPackages...),
 			Resources: ResourceSettings{
 				CPU: struct {
 					CPUQuota  int64  `json:"cpuQuota"`
@@ -180,12 +210,11 @@ func NewConfig(sourceImageName string) *BuildConfig {
 			DockerfilePath:  "./Dockerfile",
 			Directories:     []string{"/app", "/app/scripts"},
 			ScriptMap: map[string][]string{
-				"/app":         {"./executor.sh", "./healthcheck.sh", "./install.sh"},
+				"/app":         {"./executor.sh", "./healthcheck.sh"},
 				"/app/scripts": {"./scripts/user_script.sh"},
 			},
 			PermissionFiles: []string{
 				"/app/executor.sh",
-				"/app/install.sh",
 				"/app/healthcheck.sh",
 				"/app/scripts/user_script.sh",
 			},
@@ -195,16 +224,14 @@ func NewConfig(sourceImageName string) *BuildConfig {
 		Container: ContainerConfig{
 			ExecutorShell:    "./executor.sh",
 			HealthcheckShell: "./healthcheck.sh",
-			InstallShell:     "./install.sh",
 			UserScriptShell:  "./scripts/user_script.sh",
 			Directories:      []string{"/app", "/app/scripts"},
 			ScriptMap: map[string][]string{
-				"/app":         {"./executor.sh", "./healthcheck.sh", "./install.sh"},
+				"/app":         {"./executor.sh", "./healthcheck.sh"},
 				"/app/scripts": {"./scripts/user_script.sh"},
 			},
 			PermissionFiles: []string{
 				"/app/executor.sh",
-				"/app/install.sh",
 				"/app/healthcheck.sh",
 				"/app/scripts/user_script.sh",
 			},
@@ -237,12 +264,11 @@ func NewConfig(sourceImageName string) *BuildConfig {
 		BuildSettings: ImageBuildSettings{
 			Directories: []string{"/app", "/app/scripts"},
 			ScriptMap: map[string][]string{
-				"/app":         {"./executor.sh", "./healthcheck.sh", "./install.sh"},
+				"/app":         {"./executor.sh", "./healthcheck.sh"},
 				"/app/scripts": {"./scripts/user_script.sh"},
 			},
 			PermissionFiles: []string{
 				"/app/executor.sh",
-				"/app/install.sh",
 				"/app/healthcheck.sh",
 				"/app/scripts/user_script.sh",
 			},
@@ -255,23 +281,24 @@ func NewConfig(sourceImageName string) *BuildConfig {
 
 // NewConfigFromFile 은 지정된 파일에서 설정을 읽어와 BuildConfig 구조체를 생성
 // Important: config.json 는 SourceImageName 와 ImageName 는 기본적으로 설정이 안되어 있다. 이 필드들은 사용자로 부터 받아야 하기 때문에 이렇게 처리했다.
+// 알 수 없는 필드는 에러이고, 이전 형식(버전 1)의 파일은 현재 형식으로 바꿔서 읽는다. (ParseConfig 참고)
+// 확장자로 JSON(.json), YAML(.yaml, .yml), TOML(.toml) 형식을 고른다.
+// 문자열 값의 ${VAR}, ${VAR:-default} 는 환경 변수로 펼치고, 상대 호스트 경로(scriptMap 소스, dockerfilePath, volumes 의 hostPath 등)는
+// 그 경로를 적은 설정 파일의 디렉토리 기준으로 해석한다.
+// include 에 적은 파일들을 먼저 순서대로 읽고 이 파일로 덮어쓴다. 맵은 키별로 합치고 목록과 값은 바꾸며, null 은 값을 지운다.
+// 이름을 채운 뒤 Validate 로 설정을 확인한다.
 func NewConfigFromFile(configPath string) (*BuildConfig, error) {
 	configPath, err := utils.CheckPath(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check path: %w", err)
 	}
 
-	data, err := os.ReadFile(configPath)
+	cfg, err := loadConfigFile(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+		return nil, fmt.Errorf("failed to decode configuration %s: %w", configPath, err)
 	}
 
-	var cfg BuildConfig
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to decode configuration: %w", err)
-	}
-
-	return &cfg, nil
+	return cfg, nil
 }
 
 // 아래는 스트리밍 방식임.
@@ -327,6 +354,18 @@ func (config *BuildConfig) SetScriptMap(scriptMap map[string][]string) {
 	config.Container.ScriptMap = scriptMap
 }
 
+// SetScriptModes 설정 시, 이미지와 컨테이너 양쪽에 동일한 스크립트 권한을 적용합니다.
+func (config *BuildConfig) SetScriptModes(scriptModes map[string]FileMode) {
+	config.Image.ScriptModes = scriptModes
+	config.Container.ScriptModes = scriptModes
+}
+
+// SetPackages 설정 시, 이미지와 컨테이너 양쪽에 동일한 설치 패키지 목록을 적용합니다.
+func (config *BuildConfig) SetPackages(packages []string) {
+	config.Image.Packages = packages
+	config.Container.Packages = packages
+}
+
 // SetPermissionFiles 설정 시, 이미지와 컨테이너 양쪽에 동일한 파일 권한 목록을 적용합니다.
 func (config *BuildConfig) SetPermissionFiles(permissionFiles []string) {
 	config.Image.PermissionFiles = permissionFiles
@@ -349,126 +388,169 @@ func (config *BuildConfig) SetCMD(cmd []string) {
 // BuildConfig and Image Creation Functions
 // ------------------------------------------------------
 
+// ImageBuildReport 는 CreateImage, CreateImageWithDockerfile 의 결과이다.
+// 에러가 나도 builder 를 만든 뒤라면 함께 반환하므로, 호출하는 쪽은 defer report.Delete() 로 정리한다.
+type ImageBuildReport struct {
+	ImageID string           // 만든 이미지 또는 캐시된 이미지의 ID
+	Cached  bool             // 지문이 같은 이미지가 있어 빌드를 건너뛰었는지
+	Builder *buildah.Builder // 빌드에 쓴 builder. 빌드를 건너뛰었으면 nil 이다
+}
+
+// Delete 는 빌드에 쓴 builder 를 지운다. report 나 builder 가 nil 이면 아무것도 하지 않는다.
+func (r *ImageBuildReport) Delete() error {
+	if r == nil || r.Builder == nil {
+		return nil
+	}
+	return r.Builder.Delete()
+}
+
 // CreateImage 메서드는 BuildSettings 에 설정된 값들을 반영하여 이미지를 생성
-func (config *BuildConfig) CreateImage() (*buildah.Builder, string, error) {
+// WithBuildOutput, WithBuildEvents 로 단계별 진행 상황과 출력을 받을 수 있다.
+// 베이스 이미지와 빌드 입력(스크립트 내용 포함)이 같은 이미지가 이미 있으면 빌드하지 않고 Cached 가 true 인 report 를 반환한다.
+// WithNoCache 를 주면 항상 다시 빌드한다. WithBuildDryRun 을 주면 빌드하지 않고 빌드 계획만 기록한다.
+func (config *BuildConfig) CreateImage(opts ...ImageBuildOption) (*ImageBuildReport, error) {
 	if pbCtx == nil {
-		return nil, "", fmt.Errorf("pbCtx is nil")
+		return nil, fmt.Errorf("pbCtx is nil")
 	}
+	o := newImageBuildOptions(opts...)
+	if d := o.buildDryRun(pbCtx); d != nil {
+		return nil, d.createImage(pbCtx, config, o)
+	}
+	p := newBuildProgress(pbCtx, config.Image.buildSteps()+o.signSteps()+o.vulnCheckSteps(), o)
 
-	// 새로운 빌더 생성 (SourceImageName 을 베이스로 사용)
-	builder, err := newBuilder(pbCtx, pbStore, config.Image.SourceImageName)
+	// 베이스 이미지 준비 (오프라인 모드에서는 번들에서 로드) 후 지문 확인
+	srcID, err := sourceImageID(pbCtx, config.Image.SourceImageName)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create new builder: %w", err)
+		return nil, err
 	}
-
-	// ImageConfig.Directories 에 지정된 디렉토리 생성
-	if err = createDirectories(builder, config.Image.Directories); err != nil {
-		return builder, "", fmt.Errorf("failed to create directories: %w", err)
+	fp, cachedID, err := config.Image.useCache(pbCtx, srcID, o, p)
+	if err != nil {
+		return nil, err
 	}
-
-	// ImageConfig.ScriptMap 에 지정된 스크립트 복사
-	if err = copyScripts(builder, config.Image.ScriptMap); err != nil {
-		return builder, "", fmt.Errorf("failed to copy scripts: %w", err)
+	if cachedID != "" {
+		return &ImageBuildReport{ImageID: cachedID, Cached: true}, nil
 	}
 
-	// ImageConfig.PermissionFiles 에 지정된 파일 권한 설정
-	if err = setFilePermissions(builder, config.Image.PermissionFiles); err != nil {
-		return builder, "", fmt.Errorf("failed to set file permissions: %w", err)
+	// 새로운 빌더 생성 (SourceImageName 을 베이스로 사용)
+	builder, err := newBuilder(pbCtx, pbStore, config.Image.SourceImageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new builder: %w", err)
 	}
-
-	// 종속성 설치
-	if err = installDependencies(builder); err != nil {
-		return builder, "", fmt.Errorf("failed to install dependency: %w", err)
+	report := &ImageBuildReport{Builder: builder}
+	if err := o.checkSourceVulnerabilities(builder, p); err != nil {
+		return report, err
 	}
 
-	// 작업 디렉토리 및 CMD 설정 (ImageConfig.WorkDir, CMD)
-	builder.SetWorkDir(config.Image.WorkDir)
-	builder.SetCmd(config.Image.CMD)
-
-	// 이미지 참조 생성 (ImageName 기반으로)
-	imageRef, err := is.Transport.ParseReference(config.Image.ImageName)
+	// ImageConfig.Steps 또는 기본 단계(디렉토리 생성, 스크립트 복사, 권한 설정, 패키지 설치) 실행 및 WorkDir, CMD 설정
+	if err = config.Image.setup(builder, p); err != nil {
+		return report, err
+	}
+	if err := o.checkVulnerabilities(builder, config.Image.ImageName, p); err != nil {
+		return report, err
+	}
+	builder.SetLabel(FingerprintLabel, fp)
+	builder.SetLabel(ImageNameLabel, config.Image.ImageName)
+	sbom, err := config.Image.attachSBOM(builder, p)
 	if err != nil {
-		return builder, "", fmt.Errorf("failed to parse image reference: %w", err)
+		return report, err
 	}
 
 	// 이미지를 커밋
-	imageID, _, _, err := builder.Commit(pbCtx, imageRef, buildah.CommitOptions{
-		PreferredManifestType: buildah.Dockerv2ImageManifest,
-		SystemContext:         &imageTypes.SystemContext{},
-	})
+	imageID, err := commitImage(pbCtx, builder, config.Image.ImageName, p)
 	if err != nil {
-		return builder, "", fmt.Errorf("failed to commit image: %w", err)
+		return report, err
+	}
+	report.ImageID = imageID
+	if err := o.signImage(pbCtx, config.Image.ImageName, p); err != nil {
+		return report, err
 	}
 
 	// 이미지를 저장
-	if err = saveImage(pbCtx, config.Image.ImageSavePath, config.Image.ImageName, imageID, false); err != nil {
-		return builder, imageID, fmt.Errorf("failed to save image: %w", err)
+	err = p.step("SAVE "+config.Image.ImageSavePath, func(io.Writer, io.Writer) error {
+		return config.Image.saveArchive(pbCtx, imageID)
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to save image: %w", err)
+	}
+	if err := sbom.save(config.Image.ImageSavePath); err != nil {
+		return report, err
 	}
 
-	return builder, imageID, nil
+	return report, nil
 }
 
 // TODO  만약 사용자가 os 만 선택한 경우도 생각해야 한다.
 
 // CreateImageWithDockerfile builds an image from a Dockerfile using the BuildConfig.
-func (config *BuildConfig) CreateImageWithDockerfile(ctx context.Context, store storage.Store) (*buildah.Builder, string, error) {
+// 단계 번호는 Dockerfile 의 단계에 이어서 매겨진다.
+// Dockerfile 빌드 결과와 빌드 입력이 같은 이미지가 이미 있으면 이후 단계를 건너뛰고 Cached 가 true 인 report 를 반환한다.
+func (config *BuildConfig) CreateImageWithDockerfile(ctx context.Context, store storage.Store, opts ...ImageBuildOption) (*ImageBuildReport, error) {
+	o := newImageBuildOptions(opts...)
+	if o.buildDryRun(ctx) != nil {
+		return nil, errBuildDryRunUnsupported
+	}
+	p := newBuildProgress(ctx, 0, o)
+
 	// Dockerfile 경로를 기반으로 이미지를 빌드
-	id, err := buildImageFromDockerfile(ctx, config.Image.DockerfilePath)
+	id, err := buildImageFromDockerfile(ctx, &config.Image, p)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to build image from Dockerfile: %w", err)
+		return nil, fmt.Errorf("failed to build image from Dockerfile: %w", err)
 	}
+	fp, cachedID, err := config.Image.useCache(ctx, id, o, p)
+	if err != nil {
+		return nil, err
+	}
+	if cachedID != "" {
+		return &ImageBuildReport{ImageID: cachedID, Cached: true}, nil
+	}
+	p.extend(config.Image.buildSteps() + o.signSteps() + o.vulnCheckSteps())
 
 	// 새로운 빌더 생성
 	builder, err := newBuilder(ctx, store, id)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create new builder: %w", err)
+		return nil, fmt.Errorf("failed to create new builder: %w", err)
 	}
-
-	// ImageConfig.Directories 에 지정된 디렉토리 생성
-	if err = createDirectories(builder, config.Image.Directories); err != nil {
-		return builder, "", fmt.Errorf("failed to create directories: %w", err)
+	report := &ImageBuildReport{Builder: builder}
+	if err := o.checkSourceVulnerabilities(builder, p); err != nil {
+		return report, err
 	}
 
-	// ImageConfig.ScriptMap 에 지정된 스크립트 복사
-	if err = copyScripts(builder, config.Image.ScriptMap); err != nil {
-		return builder, "", fmt.Errorf("failed to copy scripts: %w", err)
+	// ImageConfig.Steps 또는 기본 단계(디렉토리 생성, 스크립트 복사, 권한 설정, 패키지 설치) 실행 및 WorkDir, CMD 설정
+	if err = config.Image.setup(builder, p); err != nil {
+		return report, err
 	}
-
-	// ImageConfig.PermissionFiles 에 지정된 파일 권한 설정
-	if err = setFilePermissions(builder, config.Image.PermissionFiles); err != nil {
-		return builder, "", fmt.Errorf("failed to set file permissions: %w", err)
+	if err := o.checkVulnerabilities(builder, config.Image.ImageName, p); err != nil {
+		return report, err
 	}
-
-	// 종속성 설치
-	if err = installDependencies(builder); err != nil {
-		return builder, "", fmt.Errorf("failed to install dependency: %w", err)
-	}
-
-	// 작업 디렉토리 및 CMD 설정 (ImageConfig.WorkDir, CMD)
-	builder.SetWorkDir(config.Image.WorkDir)
-	builder.SetCmd(config.Image.CMD)
-
-	// 이미지 참조 생성
-	imageRef, err := is.Transport.ParseReference(config.Image.ImageName)
+	builder.SetLabel(FingerprintLabel, fp)
+	builder.SetLabel(ImageNameLabel, config.Image.ImageName)
+	sbom, err := config.Image.attachSBOM(builder, p)
 	if err != nil {
-		return builder, "", fmt.Errorf("failed to parse image reference: %w", err)
+		return report, err
 	}
 
 	// 이미지를 커밋
-	imageID, _, _, err := builder.Commit(ctx, imageRef, buildah.CommitOptions{
-		PreferredManifestType: buildah.Dockerv2ImageManifest,
-		SystemContext:         &imageTypes.SystemContext{},
-	})
+	imageID, err := commitImage(ctx, builder, config.Image.ImageName, p)
 	if err != nil {
-		return builder, "", fmt.Errorf("failed to commit image: %w", err)
+		return report, err
+	}
+	report.ImageID = imageID
+	if err := o.signImage(ctx, config.Image.ImageName, p); err != nil {
+		return report, err
 	}
 
 	// 이미지를 저장
-	if err = saveImage(ctx, config.Image.ImageSavePath, config.Image.ImageName, imageID, false); err != nil {
-		return builder, imageID, fmt.Errorf("failed to save image: %w", err)
+	err = p.step("SAVE "+config.Image.ImageSavePath, func(io.Writer, io.Writer) error {
+		return config.Image.saveArchive(ctx, imageID)
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to save image: %w", err)
+	}
+	if err := sbom.save(config.Image.ImageSavePath); err != nil {
+		return report, err
 	}
 
-	return builder, imageID, nil
+	return report, nil
 }
 
 /*func (img *ImageConfig) CreateImage(ctx context.Context, store storage.Store) (*buildah.Builder, string, error) {
@@ -506,7 +588,7 @@ func (config *BuildConfig) CreateImageWithDockerfile(ctx context.Context, store
 	// 이미지를 커밋
 	imageID, _, _, err := builder.Commit(ctx, imageRef, buildah.CommitOptions{
 		PreferredManifestType: buildah.Dockerv2ImageManifest,
-		SystemContext:         &imageTypes.SystemContext{},
+		SystemContext:         systemContext(),
 	})
 	if err != nil {
 		return builder, "", fmt.Errorf("failed to commit image: %w", err)
@@ -522,31 +604,41 @@ func (config *BuildConfig) CreateImageWithDockerfile(ctx context.Context, store
 
 //TODO 생각하기 ContainerConfig 로 할 필요가 있을까??
 
-// SetupContainer sets up the container environment based on ContainerConfig.
-func (c *ContainerConfig) SetupContainer(builder *buildah.Builder) error {
-	// ContainerConfig.Directories 에 지정된 디렉토리 생성
-	if err := createDirectories(builder, c.Directories); err != nil {
-		return fmt.Errorf("failed to create directories: %w", err)
-	}
-
-	// ContainerConfig.ScriptMap 에 지정된 스크립트 복사
-	if err := copyScripts(builder, c.ScriptMap); err != nil {
-		return fmt.Errorf("failed to copy scripts: %w", err)
-	}
-
-	// ContainerConfig.PermissionFiles 에 지정된 파일 권한 설정
-	if err := setFilePermissions(builder, c.PermissionFiles); err != nil {
-		return fmt.Errorf("failed to set file permissions: %w", err)
-	}
-
-	// 종속성 설치
-	if err := installDependencies(builder); err != nil {
-		return fmt.Errorf("failed to install dependencies: %w", err)
+// commitImage 는 builder 를 name 으로 커밋하고 이미지 ID 를 반환한다.
+func commitImage(ctx context.Context, builder *buildah.Builder, name string, p *buildProgress) (string, error) {
+	imageRef, err := is.Transport.ParseReference(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference: %w", err)
+	}
+	var imageID string
+	err = p.step("COMMIT "+name, func(io.Writer, io.Writer) error {
+		var cErr error
+		imageID, _, _, cErr = builder.Commit(ctx, imageRef, buildah.CommitOptions{
+			PreferredManifestType: buildah.Dockerv2ImageManifest,
+			SystemContext:         systemContext(),
+		})
+		return cErr
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to commit image: %w", err)
 	}
+	return imageID, nil
+}
 
-	// 작업 디렉토리 및 CMD 설정
-	builder.SetWorkDir(c.WorkDir)
-	builder.SetCmd(c.Cmd)
-
-	return nil
+// SetupContainer sets up the container environment based on ContainerConfig.
+func (c *ContainerConfig) SetupContainer(builder *buildah.Builder) error {
+	if len(c.Steps) > 0 {
+		setWorkDirAndCmd(builder, c.WorkDir, c.Cmd)
+		return applySteps(builder, c.Steps, c.configDir, nil)
+	}
+	return defaultSetup(builder, setupConfig{
+		dirs:            c.Directories,
+		contextDir:      c.configDir,
+		scripts:         c.ScriptMap,
+		modes:           c.ScriptModes,
+		permissionFiles: c.PermissionFiles,
+		workDir:         c.WorkDir,
+		cmd:             c.Cmd,
+		packages:        packageSet{common: c.Packages, distro: c.DistroPackages},
+	}, nil)
 }
@@ -38,3 +38,14 @@ func TestSetSourceImageNameAndImageName(t *testing.T) {
 
 	Log.Printf("SourceImageName: %q, ImageName: %q", config.Image.SourceImageName, config.Image.ImageName)
 }
+
+func TestImageBuildReportDelete(t *testing.T) {
+	// 캐시를 쓴 빌드나 builder 를 만들기 전에 실패한 빌드도 defer report.Delete() 로 정리할 수 있어야 한다.
+	var report *ImageBuildReport
+	if err := report.Delete(); err != nil {
+		t.Errorf("expected nil report to be a no-op, got %v", err)
+	}
+	if err := (&ImageBuildReport{ImageID: "sha256:cached", Cached: true}).Delete(); err != nil {
+		t.Errorf("expected cached report to be a no-op, got %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package podbridge5
+
+import (
+	"errors"
+	"fmt"
+	"github.com/containers/buildah/define"
+	"github.com/containers/podman/v5/pkg/domain/entities/types"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+	"github.com/openshift/imagebuilder/dockerfile/parser"
+	"github.com/seoyhaein/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 빌드 컨텍스트에 ignore 파일이 지정되지 않았을 때 찾는 기본 파일들 (podman 과 같은 순서)
+var defaultIgnoreFiles = []string{".containerignore", ".dockerignore"}
+
+// buildContext 는 Dockerfile 빌드 전에 경로를 정리하고 검증한 결과이다.
+type buildContext struct {
+	contextDir string   // 절대 경로, 심볼릭 링크 해석됨
+	dockerfile string   // 절대 경로, 심볼릭 링크 해석됨
+	excludes   []string // ignore 파일에서 읽은 패턴
+}
+
+// resolveBuildContext 는 ImageConfig 의 ContextDir, DockerfilePath, IgnoreFile 을 정리한다.
+// ContextDir 가 비어 있으면 Dockerfile 이 있는 디렉토리를 컨텍스트로 사용한다.
+// ContextDir 가 지정되어 있으면 상대 경로의 DockerfilePath, IgnoreFile 은 컨텍스트 기준으로 해석한다.
+// 프로세스의 작업 디렉토리에 따라 빌드 결과가 달라지지 않도록 하기 위함이다.
+func (img *ImageConfig) resolveBuildContext() (*buildContext, error) {
+	if utils.IsEmptyString(img.DockerfilePath) {
+		return nil, errors.New("dockerfile path cannot be empty")
+	}
+
+	dockerfile := img.DockerfilePath
+	contextDir := img.ContextDir
+	if utils.IsEmptyString(contextDir) {
+		abs, err := filepath.Abs(dockerfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve dockerfile path %s: %w", dockerfile, err)
+		}
+		dockerfile = abs
+		contextDir = filepath.Dir(abs)
+	} else if !filepath.IsAbs(dockerfile) {
+		dockerfile = filepath.Join(contextDir, dockerfile)
+	}
+
+	contextDir, err := resolvePath(contextDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid build context: %w", err)
+	}
+	if st, err := os.Stat(contextDir); err != nil || !st.IsDir() {
+		return nil, fmt.Errorf("build context %s is not a directory", contextDir)
+	}
+	dockerfile, err = resolvePath(dockerfile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dockerfile: %w", err)
+	}
+	if _, ok := relInside(contextDir, dockerfile); !ok {
+		return nil, fmt.Errorf("dockerfile %s is outside of build context %s", dockerfile, contextDir)
+	}
+
+	excludes, err := readIgnoreFile(contextDir, img.IgnoreFile)
+	if err != nil {
+		return nil, err
+	}
+	return &buildContext{contextDir: contextDir, dockerfile: dockerfile, excludes: excludes}, nil
+}
+
+// buildOptions 는 ImageConfig 의 빌드 설정을 podman build 옵션으로 변환한다.
+func (img *ImageConfig) buildOptions(bc *buildContext) (types.BuildOptions, error) {
+	options := types.BuildOptions{
+		BuildOptions: define.BuildOptions{
+			ContextDirectory: bc.contextDir,
+			PullPolicy:       builderPullPolicy(),
+			Isolation:        define.IsolationOCI,
+			SystemContext:    systemContext(),
+			Args:             img.BuildArgs,
+			Target:           img.Target,
+			Excludes:         bc.excludes,
+		},
+		ContainerFiles: []string{bc.dockerfile},
+	}
+
+	// 라벨 순서를 고정해서 같은 설정이면 같은 빌드 요청이 되도록 한다.
+	for key, value := range img.Labels {
+		options.Labels = append(options.Labels, key+"="+value)
+	}
+	sort.Strings(options.Labels)
+
+	if !utils.IsEmptyString(img.Platform) {
+		goos, arch, variant, err := parsePlatform(img.Platform)
+		if err != nil {
+			return options, err
+		}
+		options.Platforms = append(options.Platforms, struct{ OS, Arch, Variant string }{OS: goos, Arch: arch, Variant: variant})
+	}
+	return options, nil
+}
+
+// validate 는 Dockerfile 의 COPY/ADD 소스가 모두 빌드 컨텍스트 안에 있고, 존재하며, ignore 파일에 의해 제외되지 않는지 확인한다.
+// 다른 스테이지에서 복사하는 경우(--from), URL, 변수가 들어간 경로, heredoc 은 빌드 시점에만 알 수 있으므로 건너뛴다.
+func (bc *buildContext) validate() error {
+	f, err := os.Open(bc.dockerfile)
+	if err != nil {
+		return fmt.Errorf("failed to open dockerfile: %w", err)
+	}
+	defer func() {
+		if cErr := f.Close(); cErr != nil {
+			Log.Warnf("Failed to close dockerfile: %v", cErr)
+		}
+	}()
+	result, err := parser.Parse(f)
+	if err != nil {
+		return fmt.Errorf("failed to parse dockerfile %s: %w", bc.dockerfile, err)
+	}
+
+	var matcher *patternmatcher.PatternMatcher
+	if len(bc.excludes) > 0 {
+		if matcher, err = patternmatcher.New(bc.excludes); err != nil {
+			return fmt.Errorf("invalid ignore pattern: %w", err)
+		}
+	}
+
+	for _, node := range result.AST.Children {
+		cmd := strings.ToLower(node.Value)
+		if cmd != "copy" && cmd != "add" {
+			continue
+		}
+		if len(node.Heredocs) > 0 || hasFromFlag(node.Flags) {
+			continue
+		}
+		var args []string
+		for n := node.Next; n != nil; n = n.Next {
+			args = append(args, n.Value)
+		}
+		if len(args) < 2 {
+			return fmt.Errorf("line %d: %s requires at least one source and a destination", node.StartLine, strings.ToUpper(cmd))
+		}
+		for _, src := range args[:len(args)-1] {
+			if err := bc.checkSource(src, matcher); err != nil {
+				return fmt.Errorf("line %d: %s %s: %w", node.StartLine, strings.ToUpper(cmd), src, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkSource 는 COPY/ADD 소스 하나를 검사한다.
+func (bc *buildContext) checkSource(src string, matcher *patternmatcher.PatternMatcher) error {
+	if strings.Contains(src, "://") || strings.HasPrefix(src, "git@") || strings.Contains(src, "$") {
+		return nil
+	}
+	// 소스는 "/" 로 시작해도 컨텍스트 기준이다.
+	path := filepath.Join(bc.contextDir, filepath.FromSlash(src))
+	rel, ok := relInside(bc.contextDir, path)
+	if !ok {
+		return errors.New("source is outside of build context")
+	}
+
+	if strings.ContainsAny(src, "*?[") {
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		if len(matches) == 0 {
+			return errors.New("no files match in build context")
+		}
+		for _, m := range matches {
+			if err := bc.checkResolved(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, err := os.Lstat(path); err != nil {
+		return errors.New("source does not exist in build context")
+	}
+	if err := bc.checkResolved(path); err != nil {
+		return err
+	}
+	if matcher != nil && rel != "." {
+		excluded, err := matcher.MatchesOrParentMatches(filepath.ToSlash(rel))
+		if err != nil {
+			return fmt.Errorf("failed to match ignore patterns: %w", err)
+		}
+		if excluded {
+			return errors.New("source is excluded by ignore file")
+		}
+	}
+	return nil
+}
+
+// checkResolved 는 심볼릭 링크를 따라갔을 때도 컨텍스트 안에 있는지 확인한다.
+func (bc *buildContext) checkResolved(path string) error {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+	if _, ok := relInside(bc.contextDir, resolved); !ok {
+		return fmt.Errorf("%s resolves outside of build context", path)
+	}
+	return nil
+}
+
+// readIgnoreFile 은 ignoreFile 을 읽는다. 비어 있으면 컨텍스트의 .containerignore, .dockerignore 순서로 찾는다.
+func readIgnoreFile(contextDir, ignoreFile string) ([]string, error) {
+	candidates := defaultIgnoreFiles
+	explicit := !utils.IsEmptyString(ignoreFile)
+	if explicit {
+		candidates = []string{ignoreFile}
+	}
+	for _, name := range candidates {
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(contextDir, path)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) && !explicit {
+				continue
+			}
+			return nil, fmt.Errorf("failed to open ignore file %s: %w", path, err)
+		}
+		excludes, err := ignorefile.ReadAll(f)
+		if cErr := f.Close(); cErr != nil {
+			Log.Warnf("Failed to close ignore file: %v", cErr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ignore file %s: %w", path, err)
+		}
+		return excludes, nil
+	}
+	return nil, nil
+}
+
+// hasFromFlag 는 COPY --from=<stage> 형태인지 확인한다.
+func hasFromFlag(flags []string) bool {
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, "--from=") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePath 는 경로를 절대 경로로 바꾸고 심볼릭 링크를 해석한다.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// relInside 는 path 가 root 안에 있으면 root 기준 상대 경로와 true 를 반환한다.
+func relInside(root, path string) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
@@ -0,0 +1,190 @@
+package podbridge5
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeContextFiles 는 dir 아래에 files 를 만든다.
+func writeContextFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResolveBuildContext(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		"build/Dockerfile": "FROM alpine\n",
+		"Dockerfile":       "FROM alpine\n",
+		".containerignore": "# comment\n*.log\n/tmp\n",
+		"custom.ignore":    "secrets\n",
+	})
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("context defaults to dockerfile directory", func(t *testing.T) {
+		img := &ImageConfig{DockerfilePath: filepath.Join(dir, "build", "Dockerfile")}
+		bc, err := img.resolveBuildContext()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if bc.contextDir != filepath.Join(root, "build") {
+			t.Errorf("expected context %s, got %s", filepath.Join(root, "build"), bc.contextDir)
+		}
+	})
+
+	t.Run("relative dockerfile is resolved against context", func(t *testing.T) {
+		img := &ImageConfig{ContextDir: dir, DockerfilePath: "build/Dockerfile"}
+		bc, err := img.resolveBuildContext()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if bc.dockerfile != filepath.Join(root, "build", "Dockerfile") {
+			t.Errorf("unexpected dockerfile %s", bc.dockerfile)
+		}
+		if strings.Join(bc.excludes, ",") != "*.log,tmp" {
+			t.Errorf("expected excludes from .containerignore, got %v", bc.excludes)
+		}
+	})
+
+	t.Run("explicit ignore file", func(t *testing.T) {
+		img := &ImageConfig{ContextDir: dir, DockerfilePath: "Dockerfile", IgnoreFile: "custom.ignore"}
+		bc, err := img.resolveBuildContext()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Join(bc.excludes, ",") != "secrets" {
+			t.Errorf("expected excludes from custom.ignore, got %v", bc.excludes)
+		}
+	})
+
+	t.Run("missing explicit ignore file", func(t *testing.T) {
+		img := &ImageConfig{ContextDir: dir, DockerfilePath: "Dockerfile", IgnoreFile: "missing.ignore"}
+		if _, err := img.resolveBuildContext(); err == nil {
+			t.Fatal("expected error for missing ignore file")
+		}
+	})
+
+	t.Run("dockerfile outside context", func(t *testing.T) {
+		img := &ImageConfig{ContextDir: filepath.Join(dir, "build"), DockerfilePath: filepath.Join(dir, "Dockerfile")}
+		if _, err := img.resolveBuildContext(); err == nil {
+			t.Fatal("expected error for dockerfile outside of context")
+		}
+	})
+
+	t.Run("empty dockerfile path", func(t *testing.T) {
+		if _, err := (&ImageConfig{ContextDir: dir}).resolveBuildContext(); err == nil {
+			t.Fatal("expected error for empty dockerfile path")
+		}
+	})
+}
+
+func TestBuildContextValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		wantErr    string
+	}{
+		{"valid sources", "FROM alpine\nCOPY app.sh /app/\nADD scripts/ /app/scripts/\nCOPY *.sh /app/\n", ""},
+		{"absolute source is context relative", "FROM alpine\nCOPY /app.sh /app/\n", ""},
+		{"json form", "FROM alpine\nCOPY [\"app.sh\", \"/app/\"]\n", ""},
+		{"copy from stage", "FROM alpine AS b\nFROM alpine\nCOPY --from=b /etc/passwd /tmp/\n", ""},
+		{"url and variable", "FROM alpine\nARG SRC\nADD https://example.com/a.tar /tmp/\nCOPY $SRC /tmp/\n", ""},
+		{"escapes context", "FROM alpine\nCOPY ../outside.sh /app/\n", "outside of build context"},
+		{"missing source", "FROM alpine\nCOPY missing.sh /app/\n", "does not exist"},
+		{"glob without match", "FROM alpine\nCOPY *.py /app/\n", "no files match"},
+		{"excluded source", "FROM alpine\nCOPY debug.log /app/\n", "excluded by ignore file"},
+		{"excluded parent", "FROM alpine\nCOPY secrets/key /app/\n", "excluded by ignore file"},
+		{"missing destination", "FROM alpine\nCOPY app.sh\n", "requires at least one source"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeContextFiles(t, dir, map[string]string{
+				"ctx/Dockerfile":       tt.dockerfile,
+				"ctx/app.sh":           "#!/bin/sh\n",
+				"ctx/scripts/run.sh":   "#!/bin/sh\n",
+				"ctx/debug.log":        "log\n",
+				"ctx/secrets/key":      "key\n",
+				"ctx/.containerignore": "*.log\nsecrets\n",
+				"outside.sh":           "#!/bin/sh\n",
+			})
+			img := &ImageConfig{ContextDir: filepath.Join(dir, "ctx"), DockerfilePath: "Dockerfile"}
+			bc, err := img.resolveBuildContext()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = bc.validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestBuildContextValidate_SymlinkOutside(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		"ctx/Dockerfile": "FROM alpine\nCOPY link.sh /app/\n",
+		"outside.sh":     "#!/bin/sh\n",
+	})
+	if err := os.Symlink(filepath.Join(dir, "outside.sh"), filepath.Join(dir, "ctx", "link.sh")); err != nil {
+		t.Skipf("symlink not supported: %v", err)
+	}
+	bc, err := (&ImageConfig{ContextDir: filepath.Join(dir, "ctx"), DockerfilePath: "Dockerfile"}).resolveBuildContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.validate(); err == nil {
+		t.Fatal("expected error for symlink pointing outside of context")
+	}
+}
+
+func TestImageConfigBuildOptions(t *testing.T) {
+	bc := &buildContext{contextDir: "/ctx", dockerfile: "/ctx/Dockerfile", excludes: []string{"*.log"}}
+	img := &ImageConfig{
+		BuildArgs: map[string]string{"VERSION": "1.0"},
+		Target:    "runtime",
+		Labels:    map[string]string{"b": "2", "a": "1"},
+		Platform:  "linux/arm64/v8",
+	}
+	options, err := img.buildOptions(bc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if options.ContextDirectory != "/ctx" || options.ContainerFiles[0] != "/ctx/Dockerfile" {
+		t.Errorf("unexpected context %s / %v", options.ContextDirectory, options.ContainerFiles)
+	}
+	if options.Args["VERSION"] != "1.0" || options.Target != "runtime" {
+		t.Errorf("build args or target not passed through: %v %q", options.Args, options.Target)
+	}
+	if strings.Join(options.Labels, ",") != "a=1,b=2" {
+		t.Errorf("unexpected labels %v", options.Labels)
+	}
+	if len(options.Platforms) != 1 || options.Platforms[0].OS != "linux" || options.Platforms[0].Arch != "arm64" || options.Platforms[0].Variant != "v8" {
+		t.Errorf("unexpected platforms %+v", options.Platforms)
+	}
+	if len(options.Excludes) != 1 || options.Excludes[0] != "*.log" {
+		t.Errorf("unexpected excludes %v", options.Excludes)
+	}
+
+	img.Platform = "arm64"
+	if _, err := img.buildOptions(bc); err == nil {
+		t.Fatal("expected error for invalid platform")
+	}
+}
@@ -0,0 +1,372 @@
+package podbridge5
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/seoyhaein/utils"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 설정 파일 스키마 버전
+const (
+	// ConfigSchemaLegacy 는 executorShell, buildSettings 등을 최상위에 두던 이전 형식이다. 읽을 때 최신 형식으로 바꾼다.
+	ConfigSchemaLegacy = 1
+	// ConfigSchemaVersion 은 image, container 로 나뉜 현재 형식이다.
+	ConfigSchemaVersion = 2
+)
+
+// legacyConfig 는 버전 1(평평한 형식)의 config.json 이다.
+type legacyConfig struct {
+	Version          int    `json:"version"`
+	SourceImageName  string `json:"sourceImageName"`
+	ImageName        string `json:"imageName"`
+	ImageSavePath    string `json:"imageSavePath"`
+	ExecutorShell    string `json:"executorShell"`
+	DockerfilePath   string `json:"dockerfilePath"`
+	HealthcheckShell string `json:"healthcheckShell"`
+	InstallShell     string `json:"installShell"`
+	UserScriptShell  string `json:"userScriptShell"`
+	BuildSettings    struct {
+		Directories     []string            `json:"directories"`
+		ScriptMap       map[string][]string `json:"scriptMap"`
+		PermissionFiles []string            `json:"permissionFiles"`
+		WorkDir         string              `json:"workDir"`
+		CMD             []string            `json:"cmd"`
+	} `json:"buildSettings"`
+}
+
+// migrate 는 이전 형식을 현재 형식으로 바꾼다. buildSettings 는 이미지와 컨테이너 양쪽에 적용된다.
+// 이전 형식은 installShell(install.sh)을 빌드마다 실행해 패키지를 설치했으므로, install.sh 를 쓰던 설정은
+// install.sh 가 설치하던 패키지(defaultPackages)를 Packages 로 옮긴다. install.sh 를 쓰지 않던 설정은 Packages 를 비워 둔다.
+func (lc *legacyConfig) migrate() *BuildConfig {
+	bs := lc.BuildSettings
+	var packages []string
+	if lc.usesInstallScript() {
+		packages = defaultPackages
+	}
+	return &BuildConfig{
+		Version: ConfigSchemaVersion,
+		Image: ImageConfig{
+			SourceImageName: lc.SourceImageName,
+			ImageName:       lc.ImageName,
+			ImageSavePath:   lc.ImageSavePath,
+			DockerfilePath:  lc.DockerfilePath,
+			Directories:     bs.Directories,
+			ScriptMap:       bs.ScriptMap,
+			PermissionFiles: bs.PermissionFiles,
+			WorkDir:         bs.WorkDir,
+			CMD:             bs.CMD,
+			Packages:        append([]string(nil), packages...),
+		},
+		Container: ContainerConfig{
+			ExecutorShell:    lc.ExecutorShell,
+			HealthcheckShell: lc.HealthcheckShell,
+			InstallShell:     lc.InstallShell,
+			UserScriptShell:  lc.UserScriptShell,
+			Directories:      append([]string(nil), bs.Directories...),
+			ScriptMap:        bs.ScriptMap,
+			PermissionFiles:  append([]string(nil), bs.PermissionFiles...),
+			WorkDir:          bs.WorkDir,
+			Cmd:              append([]string(nil), bs.CMD...),
+			Packages:         append([]string(nil), packages...),
+		},
+	}
+}
+
+// usesInstallScript 는 이전 형식이 installShell 이나 scriptMap 의 install.sh 로 패키지를 설치했는지 확인한다.
+func (lc *legacyConfig) usesInstallScript() bool {
+	if !utils.IsEmptyString(lc.InstallShell) {
+		return true
+	}
+	for _, srcs := range lc.BuildSettings.ScriptMap {
+		for _, src := range srcs {
+			if path.Base(src) == "install.sh" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseConfig 는 config.json 의 내용을 읽는다. 알 수 없는 필드가 있으면 에러를 반환하고, 에러에는 줄과 열이 들어 있다.
+// version 이 없으면 최상위에 image 또는 container 가 있는지로 형식을 판단하고, 이전 형식이면 현재 형식으로 바꾼다.
+// 필수 필드와 파일 존재 여부는 확인하지 않는다. 필요한 값을 모두 채운 뒤 Validate 를 호출한다.
+// include, 환경 변수, 상대 경로는 파일 위치가 필요하므로 NewConfigFromFile 에서만 처리한다.
+func ParseConfig(data []byte) (*BuildConfig, error) {
+	version, err := configVersion(data, true)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := decodeConfig(data, version, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Include) > 0 {
+		return nil, errors.New("include is only supported when loading a config file (NewConfigFromFile)")
+	}
+	if version == ConfigSchemaLegacy {
+		Log.Infof("Migrating config from schema version %d to %d", ConfigSchemaLegacy, ConfigSchemaVersion)
+	}
+	return cfg, nil
+}
+
+// configVersion 은 data 의 스키마 버전을 판단한다. locate 가 true 면 문법 에러에 줄과 열을 붙인다.
+func configVersion(data []byte, locate bool) (int, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return 0, configDecodeError(located(data, locate), err)
+	}
+
+	if raw, ok := top["version"]; ok {
+		var version int
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return 0, fmt.Errorf("invalid config version %s: expected an integer", raw)
+		}
+		return version, nil
+	}
+	for _, key := range []string{"image", "container", "include"} {
+		if _, ok := top[key]; ok {
+			return ConfigSchemaVersion, nil
+		}
+	}
+	return ConfigSchemaLegacy, nil
+}
+
+// decodeConfig 는 version 형식의 data 를 엄격하게 읽고, 이전 형식이면 현재 형식으로 바꾼다.
+func decodeConfig(data []byte, version int, locate bool) (*BuildConfig, error) {
+	switch version {
+	case ConfigSchemaLegacy:
+		var lc legacyConfig
+		if err := decodeStrict(data, &lc, locate); err != nil {
+			return nil, err
+		}
+		return lc.migrate(), nil
+	case ConfigSchemaVersion:
+		var cfg BuildConfig
+		if err := decodeStrict(data, &cfg, locate); err != nil {
+			return nil, err
+		}
+		cfg.Version = ConfigSchemaVersion
+		return &cfg, nil
+	}
+	return nil, fmt.Errorf("unsupported config version %d (supported: %d, %d)", version, ConfigSchemaLegacy, ConfigSchemaVersion)
+}
+
+// decodeStrict 는 알 수 없는 필드를 허용하지 않고 data 를 v 로 읽는다.
+func decodeStrict(data []byte, v any, locate bool) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return configDecodeError(located(data, locate), err)
+	}
+	return nil
+}
+
+// located 는 locate 가 false 면 nil 을 반환해서 configDecodeError 가 위치를 붙이지 않게 한다.
+// YAML, TOML 에서 바꾼 JSON 의 줄과 열은 원래 파일의 위치가 아니기 때문이다.
+func located(data []byte, locate bool) []byte {
+	if !locate {
+		return nil
+	}
+	return data
+}
+
+// configDecodeError 는 json 에러에 문제가 된 위치의 줄과 열을 붙인다.
+// 문법 에러는 잘못된 문자, 타입 에러는 값의 마지막 문자, 알 수 없는 필드는 그 키의 위치이다.
+func configDecodeError(data []byte, err error) error {
+	offset := -1
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = int(syntaxErr.Offset) - 1
+	case errors.As(err, &typeErr):
+		offset = int(typeErr.Offset) - 1
+		err = fmt.Errorf("field %q: cannot use %s as %s", typeErr.Field, typeErr.Value, typeErr.Type)
+	default:
+		// DisallowUnknownFields 의 에러에는 위치가 없으므로 키를 직접 찾는다.
+		if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			if name, uErr := strconv.Unquote(name); uErr == nil {
+				pattern := regexp.MustCompile(`"` + regexp.QuoteMeta(name) + `"\s*:`)
+				if loc := pattern.FindIndex(data); loc != nil {
+					offset = loc[0]
+				}
+			}
+		}
+	}
+	if offset < 0 || offset >= len(data) {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := offset - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("invalid config at line %d, column %d: %w", line, col, err)
+}
+
+// Validate 는 설정이 빌드와 실행에 쓰일 수 있는지 확인하고, 발견한 문제를 모두 모아 반환한다.
+// 필수 필드(sourceImageName, imageName), ScriptMap 의 소스 파일 존재 여부, PermissionFiles 가 Directories 아래에 있는지,
+// 그리고 Steps, ScriptModes, Packages, User, SBOM, 아카이브 형식과 암호화, 플랫폼 값을 확인한다. 각 에러는 "image.scriptMap[\"/app\"][1]" 처럼 필드 경로로 시작한다.
+// ScriptMap 의 상대 경로는 현재 작업 디렉토리를 기준으로 확인한다.
+func (config *BuildConfig) Validate() error {
+	var errs []error
+	add := func(field string, err error) {
+		errs = append(errs, fmt.Errorf("%s: %w", field, err))
+	}
+
+	if config.Version != 0 && config.Version != ConfigSchemaVersion {
+		add("version", fmt.Errorf("expected %d, got %d", ConfigSchemaVersion, config.Version))
+	}
+
+	img := &config.Image
+	for _, f := range []struct{ field, name string }{
+		{"image.sourceImageName", img.SourceImageName},
+		{"image.imageName", img.ImageName},
+	} {
+		if utils.IsEmptyString(f.name) {
+			add(f.field, errors.New("is required"))
+		} else if _, err := reference.ParseNormalizedNamed(f.name); err != nil {
+			add(f.field, err)
+		}
+	}
+	if len(img.Steps) == 0 {
+		errs = append(errs, validateLayout("image", img.Directories, img.ScriptMap, img.PermissionFiles)...)
+	} else if err := validateSteps(img.Steps); err != nil {
+		add("image.steps", err)
+	}
+	errs = append(errs, validateCommon("image", img.WorkDir, img.ScriptModes, img.packages())...)
+	if img.User != nil {
+		if err := img.User.Validate(); err != nil {
+			add("image.user", err)
+		}
+	}
+	if err := validateSBOMFormat(img.SBOM); err != nil {
+		add("image.sbom", err)
+	}
+	if err := img.validateArchiveFormat(); err != nil {
+		add("image.archiveFormat", err)
+	}
+	if img.Encryption != nil {
+		if err := img.Encryption.Validate(); err != nil {
+			add("image.encryption", err)
+		}
+	}
+	if img.Platform != "" {
+		if _, _, _, err := parsePlatform(img.Platform); err != nil {
+			add("image.platform", err)
+		}
+	}
+	if len(img.Platforms) > 0 {
+		if err := img.validatePlatforms(); err != nil {
+			add("image.platforms", err)
+		}
+	}
+
+	c := &config.Container
+	if len(c.Steps) == 0 {
+		errs = append(errs, validateLayout("container", c.Directories, c.ScriptMap, c.PermissionFiles)...)
+	} else if err := validateSteps(c.Steps); err != nil {
+		add("container.steps", err)
+	}
+	errs = append(errs, validateCommon("container", c.WorkDir, c.ScriptModes, packageSet{common: c.Packages, distro: c.DistroPackages})...)
+	for i, v := range c.Volumes {
+		field := fmt.Sprintf("container.volumes[%d]", i)
+		if utils.IsEmptyString(v.HostPath) {
+			add(field+".hostPath", errors.New("is required"))
+		}
+		if !path.IsAbs(v.ContainerPath) {
+			add(field+".containerPath", fmt.Errorf("%q must be an absolute path", v.ContainerPath))
+		}
+	}
+	for k := range c.Env {
+		if k == "" || strings.Contains(k, "=") {
+			add("container.env", fmt.Errorf("invalid variable name %q", k))
+		}
+	}
+	if c.Resources.CPU.CPUQuota < -1 {
+		add("container.resources.cpu.cpuQuota", errors.New("must be positive, or -1 for no limit"))
+	}
+	if c.Resources.Memory.MemLimit < 0 {
+		add("container.resources.memory.memLimit", errors.New("must not be negative"))
+	}
+	if c.Resources.OOMScore < -1000 || c.Resources.OOMScore > 1000 {
+		add("container.resources.oomScore", fmt.Errorf("%d is out of range [-1000, 1000]", c.Resources.OOMScore))
+	}
+	return errors.Join(errs...)
+}
+
+// validateLayout 은 Directories, ScriptMap, PermissionFiles 를 확인한다.
+func validateLayout(prefix string, dirs []string, scripts map[string][]string, permissionFiles []string) []error {
+	var errs []error
+	for i, dir := range dirs {
+		if !path.IsAbs(dir) {
+			errs = append(errs, fmt.Errorf("%s.directories[%d]: %q must be an absolute path", prefix, i, dir))
+		}
+	}
+
+	dests := make([]string, 0, len(scripts))
+	for dest := range scripts {
+		dests = append(dests, dest)
+	}
+	sort.Strings(dests)
+	for _, dest := range dests {
+		field := fmt.Sprintf("%s.scriptMap[%q]", prefix, dest)
+		if !path.IsAbs(dest) {
+			errs = append(errs, fmt.Errorf("%s: %q must be an absolute path", field, dest))
+		}
+		for i, src := range scripts[dest] {
+			if _, err := os.Stat(src); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					err = fmt.Errorf("%s does not exist", src)
+				}
+				errs = append(errs, fmt.Errorf("%s[%d]: %w", field, i, err))
+			}
+		}
+	}
+
+	for i, f := range permissionFiles {
+		field := fmt.Sprintf("%s.permissionFiles[%d]", prefix, i)
+		if !path.IsAbs(f) {
+			errs = append(errs, fmt.Errorf("%s: %q must be an absolute path", field, f))
+			continue
+		}
+		if !underAnyDir(f, dirs) {
+			errs = append(errs, fmt.Errorf("%s: %s is not under any of %s.directories %v", field, f, prefix, dirs))
+		}
+	}
+	return errs
+}
+
+// validateCommon 은 이미지와 컨테이너가 함께 가진 WorkDir, ScriptModes, 패키지를 확인한다.
+func validateCommon(prefix, workDir string, modes map[string]FileMode, packages packageSet) []error {
+	var errs []error
+	if workDir != "" && !path.IsAbs(workDir) {
+		errs = append(errs, fmt.Errorf("%s.workDir: %q must be an absolute path", prefix, workDir))
+	}
+	if err := validateScriptModes(modes); err != nil {
+		errs = append(errs, fmt.Errorf("%s.scriptModes: %w", prefix, err))
+	}
+	if err := packages.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("%s.packages: %w", prefix, err))
+	}
+	return errs
+}
+
+// underAnyDir 는 file 이 dirs 중 하나의 아래에 있는지 확인한다.
+func underAnyDir(file string, dirs []string) bool {
+	file = path.Clean(file)
+	for _, dir := range dirs {
+		dir = path.Clean(dir)
+		if dir == "/" || strings.HasPrefix(file, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
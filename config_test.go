@@ -0,0 +1,223 @@
+package podbridge5
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testLegacyConfig = `{
+  "sourceImageName": "docker.io/library/alpine:latest",
+  "imageName": "docker.io/library/alpine-internal:latest",
+  "imageSavePath": "./",
+  "executorShell": "./executor.sh",
+  "dockerfilePath": "./Dockerfile",
+  "healthcheckShell": "./healthcheck.sh",
+  "installShell": "./install.sh",
+  "userScriptShell": "./scripts/user_script.sh",
+  "buildSettings": {
+    "directories": ["/app"],
+    "scriptMap": {"/app": ["./executor.sh"]},
+    "permissionFiles": ["/app/executor.sh"],
+    "workDir": "/app",
+    "cmd": ["/bin/sh", "-c", "/app/executor.sh"]
+  }
+}`
+
+func TestParseConfig_Legacy(t *testing.T) {
+	cfg, err := ParseConfig([]byte(testLegacyConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Version != ConfigSchemaVersion {
+		t.Errorf("expected migrated version %d, got %d", ConfigSchemaVersion, cfg.Version)
+	}
+	if cfg.Image.SourceImageName != "docker.io/library/alpine:latest" || cfg.Image.WorkDir != "/app" || !reflect.DeepEqual(cfg.Image.Directories, []string{"/app"}) {
+		t.Errorf("unexpected image config %+v", cfg.Image)
+	}
+	if cfg.Container.ExecutorShell != "./executor.sh" || !reflect.DeepEqual(cfg.Container.Cmd, []string{"/bin/sh", "-c", "/app/executor.sh"}) {
+		t.Errorf("unexpected container config %+v", cfg.Container)
+	}
+	// installShell 이 설치하던 패키지를 Packages 로 옮긴다.
+	if !reflect.DeepEqual(cfg.Image.Packages, defaultPackages) || !reflect.DeepEqual(cfg.Container.Packages, defaultPackages) {
+		t.Errorf("expected installShell to migrate to the install.sh packages, got %v and %v", cfg.Image.Packages, cfg.Container.Packages)
+	}
+}
+
+func TestParseConfig_LegacyPackages(t *testing.T) {
+	for name, tt := range map[string]struct {
+		config string
+		want   []string
+	}{
+		"install.sh in scriptMap": {`{"imageName": "tester", "buildSettings": {"scriptMap": {"/app": ["./scripts/install.sh"]}}}`, defaultPackages},
+		"no install script":       {`{"imageName": "tester", "buildSettings": {"scriptMap": {"/app": ["./executor.sh"]}}}`, nil},
+	} {
+		cfg, err := ParseConfig([]byte(tt.config))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(cfg.Image.Packages, tt.want) || !reflect.DeepEqual(cfg.Container.Packages, tt.want) {
+			t.Errorf("%s: expected packages %v, got %v and %v", name, tt.want, cfg.Image.Packages, cfg.Container.Packages)
+		}
+	}
+}
+
+func TestParseConfig_Versions(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`{"image": {"imageName": "tester"}}`))
+	if err != nil || cfg.Version != ConfigSchemaVersion || cfg.Image.ImageName != "tester" {
+		t.Fatalf("expected unversioned nested config to be read as version %d, got %+v (%v)", ConfigSchemaVersion, cfg, err)
+	}
+	if _, err := ParseConfig([]byte(`{"version": 3, "image": {}}`)); err == nil || !strings.Contains(err.Error(), "unsupported config version 3") {
+		t.Errorf("expected unsupported version error, got %v", err)
+	}
+	if _, err := ParseConfig([]byte(`{"version": "2"}`)); err == nil {
+		t.Error("expected error for non-integer version")
+	}
+	// 버전 1 을 명시하면 nested 필드는 알 수 없는 필드이다
+	if _, err := ParseConfig([]byte(`{"version": 1, "image": {}}`)); err == nil {
+		t.Error("expected error for nested fields in a version 1 config")
+	}
+}
+
+func TestParseConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"unknown field", "{\n  \"version\": 2,\n  \"image\": {\n    \"imageNmae\": \"tester\"\n  }\n}", `line 4, column 5: json: unknown field "imageNmae"`},
+		{"wrong type", "{\"version\": 2,\n \"image\": {\"directories\": \"/app\"}}", `line 2, column 32: field "image.directories": cannot use string as []string`},
+		{"syntax error", "{\"version\": 2,\n \"image\": {,}}", "line 2, column 12: invalid character ','"},
+		{"unknown legacy field", `{"executorShel": "./executor.sh"}`, `unknown field "executorShel"`},
+		{"trailing data", `{"version": 2} {}`, "line 1, column 16: invalid character '{' after top-level value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestNewConfigFromFile_Checked(t *testing.T) {
+	cfg, err := NewConfigFromFile("config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Version != ConfigSchemaVersion || len(cfg.Image.ScriptMap) == 0 || cfg.Container.ExecutorShell == "" {
+		t.Fatalf("expected checked-in config.json to use the nested format, got %+v", cfg)
+	}
+	// config.json 은 이미지 이름을 비워 두므로 이름만 채우면 통과해야 한다
+	cfg.SetSourceImageNameAndImageName("docker.io/library/alpine:latest")
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected checked-in config.json to validate, got %v", err)
+	}
+}
+
+func TestBuildConfigValidate(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{"executor.sh": "#!/bin/sh\n"})
+	executor := filepath.Join(dir, "executor.sh")
+
+	cfg := NewConfig("docker.io/library/alpine:latest")
+	cfg.SetScriptMap(map[string][]string{"/app": {executor}})
+	cfg.SetPermissionFiles([]string{"/app/executor.sh"})
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected valid config, got %v", err)
+	}
+
+	cfg.Image.SourceImageName = ""
+	cfg.Image.ScriptMap = map[string][]string{"/app": {executor, executor + ".missing"}}
+	cfg.Image.PermissionFiles = []string{"/app/executor.sh", "/opt/run.sh", "relative.sh"}
+	cfg.Image.WorkDir = "app"
+	cfg.Container.Volumes = []VolumeConfig{{HostPath: "/data", ContainerPath: "input"}}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{
+		"image.sourceImageName: is required",
+		`image.scriptMap["/app"][1]: ` + executor + ".missing does not exist",
+		"image.permissionFiles[1]: /opt/run.sh is not under any of image.directories",
+		`image.permissionFiles[2]: "relative.sh" must be an absolute path`,
+		`image.workDir: "app" must be an absolute path`,
+		`container.volumes[0].containerPath: "input" must be an absolute path`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "permissionFiles[0]") {
+		t.Errorf("expected /app/executor.sh to be accepted, got\n%v", err)
+	}
+}
+
+func TestBuildConfigValidate_Encryption(t *testing.T) {
+	cfg := NewConfig("docker.io/library/alpine:latest")
+	cfg.SetScriptMap(nil)
+	cfg.Image.ArchiveFormat = SaveFormatDockerArchive
+	cfg.Image.Encryption = &ImageEncryption{}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{"image.archiveFormat: encryption requires oci-archive", "image.encryption: "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in\n%v", want, err)
+		}
+	}
+}
+
+func TestBuildConfigValidate_Steps(t *testing.T) {
+	cfg := NewConfig("docker.io/library/alpine:latest")
+	cfg.SetScriptMap(nil)
+	cfg.SetPermissionFiles(nil)
+	// Steps 를 사용하면 Directories, ScriptMap, PermissionFiles 는 확인하지 않는다
+	cfg.Image.PermissionFiles = []string{"/opt/ignored.sh"}
+	cfg.Image.Steps = []BuildStep{{Type: "unknown"}}
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "image.steps: step 1") || strings.Contains(err.Error(), "permissionFiles") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !errors.Is(err, err) || len(strings.Split(err.Error(), "\n")) != 1 {
+		t.Errorf("expected a single error, got %v", err)
+	}
+}
+
+func TestUnderAnyDir(t *testing.T) {
+	dirs := []string{"/app", "/app/scripts/"}
+	for file, want := range map[string]bool{
+		"/app/executor.sh":         true,
+		"/app/scripts/user.sh":     true,
+		"/application/executor.sh": false,
+		"/app":                     false,
+		"/opt/../app/executor.sh":  true,
+		"/app/../opt/executor.sh":  false,
+	} {
+		if got := underAnyDir(file, dirs); got != want {
+			t.Errorf("underAnyDir(%q) = %v, want %v", file, got, want)
+		}
+	}
+	if !underAnyDir("/anything", []string{"/"}) {
+		t.Error("expected every path to be under /")
+	}
+}
+
+func TestNewConfigFromFile_Legacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(testLegacyConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Image.ImageName != "docker.io/library/alpine-internal:latest" {
+		t.Errorf("unexpected image name %q", cfg.Image.ImageName)
+	}
+}
@@ -0,0 +1,349 @@
+package podbridge5
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// 설정 파일 형식. NewConfigFromFile 은 확장자로 형식을 고른다.
+const (
+	ConfigFormatJSON = "json" // .json
+	ConfigFormatYAML = "yaml" // .yaml, .yml
+	ConfigFormatTOML = "toml" // .toml
+)
+
+// configEnvPattern 은 문자열 값 안의 ${VAR}, ${VAR:-default} 와 이스케이프 $${ 이다.
+var configEnvPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// configFormat 은 파일 확장자로 설정 형식을 정한다.
+func configFormat(file string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".json":
+		return ConfigFormatJSON, nil
+	case ".yaml", ".yml":
+		return ConfigFormatYAML, nil
+	case ".toml":
+		return ConfigFormatTOML, nil
+	default:
+		return "", fmt.Errorf("unsupported config file extension %q: use .json, .yaml, .yml or .toml", ext)
+	}
+}
+
+// configToJSON 은 YAML, TOML 문서를 같은 구조의 JSON 으로 바꾼다.
+// 그래야 json 태그와 ParseConfig 의 엄격한 검사, 이전 형식 변환을 형식에 관계없이 그대로 쓸 수 있다.
+func configToJSON(data []byte, format string) ([]byte, error) {
+	switch format {
+	case ConfigFormatYAML:
+		out, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+		// 빈 YAML 파일은 null 이 된다. 아무것도 덮어쓰지 않는 오버레이로 취급한다.
+		if bytes.Equal(bytes.TrimSpace(out), []byte("null")) {
+			return []byte("{}"), nil
+		}
+		return out, nil
+	case ConfigFormatTOML:
+		var doc map[string]any
+		if _, err := toml.Decode(string(data), &doc); err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+		return json.Marshal(doc)
+	default:
+		return data, nil
+	}
+}
+
+// configLoader 는 include 를 따라가며 설정 파일들을 읽어 하나의 문서로 합친다.
+type configLoader struct {
+	stack []string // 지금 읽고 있는 파일들. include 순환을 찾는 데 쓴다.
+	dirs  []string // 읽은 파일들의 디렉토리. 복사할 때의 컨텍스트를 정하는 데 쓴다.
+}
+
+// load 는 file 과 그 파일이 include 하는 파일들을 합친 문서와 그 스키마 버전을 반환한다.
+// 각 파일은 합치기 전에 엄격하게 검사하므로 알 수 없는 필드는 그 필드가 있는 파일 이름과 함께 보고된다.
+func (l *configLoader) load(file string) (map[string]any, int, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, f := range l.stack {
+		if f == file {
+			return nil, 0, fmt.Errorf("config include cycle: %s -> %s", strings.Join(l.stack, " -> "), file)
+		}
+	}
+	l.stack = append(l.stack, file)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
+	doc, version, err := readConfigDocument(file)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", file, err)
+	}
+
+	dir := filepath.Dir(file)
+	l.dirs = append(l.dirs, dir)
+	if err := expandConfigEnv(doc); err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", file, err)
+	}
+	resolveConfigPaths(doc, version, dir)
+
+	includes, _ := doc["include"].([]any)
+	delete(doc, "include")
+	if len(includes) == 0 {
+		return doc, version, nil
+	}
+
+	merged := make(map[string]any)
+	for _, inc := range includes {
+		name, _ := inc.(string)
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		base, baseVersion, err := l.load(name)
+		if err != nil {
+			return nil, 0, err
+		}
+		if baseVersion != ConfigSchemaVersion {
+			return nil, 0, fmt.Errorf("%s: included config %s must use config version %d", file, name, ConfigSchemaVersion)
+		}
+		mergeConfigDocument(merged, base)
+	}
+	mergeConfigDocument(merged, doc)
+	return merged, ConfigSchemaVersion, nil
+}
+
+// readConfigDocument 는 설정 파일 하나를 엄격하게 검사한 뒤 값이 그대로 보존되는 일반 문서로 읽는다.
+// JSON 파일의 에러에는 줄과 열이 붙는다.
+func readConfigDocument(file string) (map[string]any, int, error) {
+	format, err := configFormat(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read file: %w", err)
+	}
+	data, err := configToJSON(raw, format)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	locate := format == ConfigFormatJSON
+	version, err := configVersion(data, locate)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var doc map[string]any
+	if err := decodeDocument(data, &doc); err != nil {
+		return nil, 0, fmt.Errorf("invalid config: %w", err)
+	}
+	if doc == nil {
+		doc = make(map[string]any)
+	}
+	if _, ok := doc["include"]; ok && version != ConfigSchemaVersion {
+		return nil, 0, fmt.Errorf("include requires config version %d", ConfigSchemaVersion)
+	}
+	if _, err := decodeConfig(data, version, locate); err != nil {
+		return nil, 0, err
+	}
+	return doc, version, nil
+}
+
+// expandConfigEnv 는 문서의 모든 문자열 값과 맵 키에서 환경 변수를 펼친다.
+// 숫자, 불리언 값은 펼치지 않으므로 환경 변수로 바꿔야 하는 값은 문자열 필드여야 한다.
+func expandConfigEnv(doc map[string]any) error {
+	_, err := expandConfigValue(doc)
+	return err
+}
+
+func expandConfigValue(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return expandConfigString(v)
+	case []any:
+		for i := range v {
+			e, err := expandConfigValue(v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = e
+		}
+	case map[string]any:
+		// 펼친 키를 다시 펼치지 않도록 새 맵에 담은 뒤 바꾼다.
+		expanded := make(map[string]any, len(v))
+		for k, e := range v {
+			key, err := expandConfigString(k)
+			if err != nil {
+				return nil, err
+			}
+			if expanded[key], err = expandConfigValue(e); err != nil {
+				return nil, err
+			}
+		}
+		for k := range v {
+			delete(v, k)
+		}
+		for k, e := range expanded {
+			v[k] = e
+		}
+	}
+	return v, nil
+}
+
+// expandConfigString 은 ${VAR} 를 환경 변수 값으로 바꾼다. ${VAR:-default} 는 VAR 가 없거나 비어 있으면 default 를 쓴다.
+// 기본값 없이 설정되지 않은 변수는 오타일 가능성이 높으므로 에러이다.
+// $VAR 형식은 펼치지 않고, $${VAR} 는 ${VAR} 로 남긴다. Cmd 의 셸 변수처럼 컨테이너 안에서 펼쳐야 하는 값에 쓴다.
+func expandConfigString(s string) (string, error) {
+	var missing []string
+	out := configEnvPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "$${" {
+			return "${"
+		}
+		sub := configEnvPattern.FindStringSubmatch(m)
+		name, def := sub[1], sub[2]
+		if val, ok := os.LookupEnv(name); ok && (val != "" || !strings.Contains(m, ":-")) {
+			return val
+		}
+		if strings.Contains(m, ":-") {
+			return def
+		}
+		missing = append(missing, name)
+		return m
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set (use ${%s:-default} to give a default)", missing[0], missing[0])
+	}
+	return out, nil
+}
+
+// mergeConfigDocument 는 src 를 dst 위에 덮어쓴다. 맵(image, labels, scriptMap 등)은 키별로 합치고,
+// 목록과 값은 src 의 것으로 바꾼다. src 의 null 은 dst 의 값을 지운다. (예: 기본 설정의 user 를 없앤다)
+func mergeConfigDocument(dst, src map[string]any) {
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		if sm, ok := v.(map[string]any); ok {
+			if dm, ok := dst[k].(map[string]any); ok {
+				mergeConfigDocument(dm, sm)
+				continue
+			}
+			dm := make(map[string]any, len(sm))
+			mergeConfigDocument(dm, sm)
+			dst[k] = dm
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// resolveConfigPaths 는 문서의 상대 호스트 경로를 설정 파일이 있는 dir 기준의 절대 경로로 바꾼다.
+// 컨테이너 안의 경로(directories, permissionFiles, workDir 등)는 건드리지 않는다.
+// 경로는 그 경로를 적은 파일 기준이므로 scriptModes 의 키는 같은 파일의 scriptMap 과 같은 방식으로 적어야 한다.
+func resolveConfigPaths(doc map[string]any, version int, dir string) {
+	if version == ConfigSchemaLegacy {
+		resolvePathFields(doc, dir, "imageSavePath", "dockerfilePath", "executorShell", "healthcheckShell", "installShell", "userScriptShell")
+		if bs, ok := doc["buildSettings"].(map[string]any); ok {
+			resolveScriptPaths(bs, dir)
+		}
+		return
+	}
+	if img, ok := doc["image"].(map[string]any); ok {
+		resolvePathFields(img, dir, "imageSavePath", "contextDir")
+		// contextDir 가 있으면 dockerfilePath, ignoreFile 은 컨텍스트 기준이다. (resolveBuildContext)
+		if ctxDir, _ := img["contextDir"].(string); ctxDir == "" {
+			resolvePathFields(img, dir, "dockerfilePath", "ignoreFile")
+		}
+		resolveScriptPaths(img, dir)
+	}
+	if c, ok := doc["container"].(map[string]any); ok {
+		resolvePathFields(c, dir, "executorShell", "healthcheckShell", "installShell", "userScriptShell")
+		resolveScriptPaths(c, dir)
+		volumes, _ := c["volumes"].([]any)
+		for _, v := range volumes {
+			if vol, ok := v.(map[string]any); ok {
+				resolvePathFields(vol, dir, "hostPath")
+			}
+		}
+	}
+}
+
+// resolveScriptPaths 는 scriptMap 의 소스, scriptModes 의 키, copy, add 단계의 소스를 dir 기준으로 바꾼다.
+func resolveScriptPaths(section map[string]any, dir string) {
+	if scripts, ok := section["scriptMap"].(map[string]any); ok {
+		for _, srcs := range scripts {
+			resolvePathList(srcs, dir)
+		}
+	}
+	if modes, ok := section["scriptModes"].(map[string]any); ok {
+		resolved := make(map[string]any, len(modes))
+		for src, m := range modes {
+			resolved[resolveConfigPath(dir, src)] = m
+		}
+		section["scriptModes"] = resolved
+	}
+	steps, _ := section["steps"].([]any)
+	for _, s := range steps {
+		if step, ok := s.(map[string]any); ok && (step["type"] == StepCopy || step["type"] == StepAdd) {
+			resolvePathList(step["src"], dir)
+		}
+	}
+}
+
+func resolvePathFields(m map[string]any, dir string, keys ...string) {
+	for _, k := range keys {
+		if p, ok := m[k].(string); ok {
+			m[k] = resolveConfigPath(dir, p)
+		}
+	}
+}
+
+func resolvePathList(v any, dir string) {
+	list, _ := v.([]any)
+	for i, e := range list {
+		if p, ok := e.(string); ok {
+			list[i] = resolveConfigPath(dir, p)
+		}
+	}
+}
+
+// resolveConfigPath 는 비어 있지 않은 상대 경로를 dir 기준의 절대 경로로 바꾼다. URL 은 그대로 둔다.
+func resolveConfigPath(dir, p string) string {
+	if p == "" || filepath.IsAbs(p) || strings.Contains(p, "://") {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+// loadConfigFile 은 file 과 include 한 파일들을 읽어 합친 BuildConfig 를 만든다.
+// 스크립트와 copy, add 소스는 설정 파일 기준의 절대 경로가 되므로, 설정 파일들의 공통 디렉토리를 복사 컨텍스트로 기록한다.
+func loadConfigFile(file string) (*BuildConfig, error) {
+	l := &configLoader{}
+	doc, version, err := l.load(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := decodeConfig(data, version, false)
+	if err != nil {
+		return nil, err
+	}
+	if version == ConfigSchemaLegacy {
+		Log.Infof("Migrating config from schema version %d to %d", ConfigSchemaLegacy, ConfigSchemaVersion)
+	}
+	cfg.Image.configDir = commonDir(l.dirs)
+	cfg.Container.configDir = cfg.Image.configDir
+	return cfg, nil
+}
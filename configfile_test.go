@@ -0,0 +1,304 @@
+package podbridge5
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewConfigFromFile_Formats(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		"scripts/executor.sh": "#!/bin/sh\n",
+		"config.json": `{
+  "version": 2,
+  "image": {
+    "sourceImageName": "docker.io/library/alpine:latest",
+    "imageName": "tester:latest",
+    "imageSavePath": "out",
+    "directories": ["/app"],
+    "scriptMap": {"/app": ["./scripts/executor.sh"]},
+    "scriptModes": {"./scripts/executor.sh": {"mode": "0700"}},
+    "labels": {"team": "bio"}
+  },
+  "container": {
+    "resources": {"memory": {"memLimit": 1024}},
+    "volumes": [{"hostPath": "data", "containerPath": "/app/input"}]
+  }
+}`,
+		"config.yaml": `
+version: 2
+image:
+  sourceImageName: docker.io/library/alpine:latest
+  imageName: tester:latest
+  imageSavePath: out
+  directories: [/app]
+  scriptMap:
+    /app: [./scripts/executor.sh]
+  scriptModes:
+    ./scripts/executor.sh: {mode: "0700"}
+  labels:
+    team: bio
+container:
+  resources:
+    memory: {memLimit: 1024}
+  volumes:
+    - hostPath: data
+      containerPath: /app/input
+`,
+		"config.toml": `
+version = 2
+
+[image]
+sourceImageName = "docker.io/library/alpine:latest"
+imageName = "tester:latest"
+imageSavePath = "out"
+directories = ["/app"]
+scriptMap = { "/app" = ["./scripts/executor.sh"] }
+scriptModes = { "./scripts/executor.sh" = { mode = "0700" } }
+labels = { team = "bio" }
+
+[container.resources.memory]
+memLimit = 1024
+
+[[container.volumes]]
+hostPath = "data"
+containerPath = "/app/input"
+`,
+	})
+
+	var want *BuildConfig
+	for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+		cfg, err := NewConfigFromFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		executor := filepath.Join(dir, "scripts", "executor.sh")
+		if got := cfg.Image.ScriptMap["/app"]; len(got) != 1 || got[0] != executor {
+			t.Errorf("%s: expected script path relative to the config file, got %v", name, got)
+		}
+		if _, ok := cfg.Image.ScriptModes[executor]; !ok {
+			t.Errorf("%s: expected scriptModes key to be resolved like scriptMap, got %v", name, cfg.Image.ScriptModes)
+		}
+		if cfg.Image.ImageSavePath != filepath.Join(dir, "out") || cfg.Container.Volumes[0].HostPath != filepath.Join(dir, "data") {
+			t.Errorf("%s: expected host paths relative to the config file, got %q, %q", name, cfg.Image.ImageSavePath, cfg.Container.Volumes[0].HostPath)
+		}
+		if cfg.Container.Volumes[0].ContainerPath != "/app/input" || cfg.Image.Directories[0] != "/app" {
+			t.Errorf("%s: container paths must not be resolved", name)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if want == nil {
+			want = cfg
+		} else if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("%s: expected the same config as config.json\n got %+v\nwant %+v", name, cfg, want)
+		}
+	}
+}
+
+func TestNewConfigFromFile_Errors(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		"config.ini":      "",
+		"unknown.yaml":    "version: 2\nimage:\n  imageNmae: x\n",
+		"unknown.json":    "{\n  \"image\": {\"imageNmae\": \"x\"}\n}",
+		"bad.toml":        "[image\n",
+		"missing.yaml":    "image:\n  imageSavePath: ${PB_TEST_UNSET_VAR}\n",
+		"legacy.json":     `{"version": 1, "include": ["base.yaml"]}`,
+		"cycle-a.yaml":    "include: [cycle-b.yaml]\n",
+		"cycle-b.yaml":    "include: [cycle-a.yaml]\n",
+		"withlegacy.yml":  "include: [legacybase.json]\n",
+		"legacybase.json": `{"imageName": "x"}`,
+	})
+	for name, want := range map[string]string{
+		"config.ini":     `unsupported config file extension ".ini"`,
+		"unknown.yaml":   `unknown.yaml: invalid config: json: unknown field "imageNmae"`,
+		"unknown.json":   `line 2, column 13: json: unknown field "imageNmae"`,
+		"bad.toml":       "bad.toml: invalid config: toml:",
+		"missing.yaml":   "environment variable PB_TEST_UNSET_VAR is not set",
+		"legacy.json":    "include requires config version 2",
+		"cycle-a.yaml":   "config include cycle: " + filepath.Join(dir, "cycle-a.yaml") + " -> " + filepath.Join(dir, "cycle-b.yaml") + " -> " + filepath.Join(dir, "cycle-a.yaml"),
+		"withlegacy.yml": "must use config version 2",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfigFromFile(filepath.Join(dir, name))
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Fatalf("expected error containing %q, got %v", want, err)
+			}
+		})
+	}
+}
+
+func TestNewConfigFromFile_Include(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		"base/executor.sh":     "#!/bin/sh\n",
+		"pipelines/user.sh":    "#!/bin/sh\n",
+		"pipelines/Dockerfile": "FROM alpine\n",
+		"base/registry.yaml":   "image:\n  labels: {registry: harbor}\n",
+		"base/defaults.yaml": `
+version: 2
+image:
+  sourceImageName: docker.io/library/alpine:latest
+  imageName: base:latest
+  imageSavePath: ${PB_TEST_SAVE_DIR:-images}
+  directories: [/app, /app/scripts]
+  scriptMap:
+    /app: [executor.sh]
+  permissionFiles: [/app/executor.sh]
+  packages: [bash, procps]
+  labels: {team: bio, tier: base}
+  user: {name: app}
+`,
+		"pipelines/rnaseq.yaml": `
+include:
+  - ../base/defaults.yaml
+  - ../base/registry.yaml
+image:
+  imageName: rnaseq:${PB_TEST_TAG}
+  dockerfilePath: Dockerfile
+  scriptMap:
+    /app/scripts: [user.sh]
+  packages: [bash]
+  labels: {tier: pipeline}
+  user: null
+  cmd: [/bin/sh, -c, "echo $${HOME}"]
+`,
+	})
+	t.Setenv("PB_TEST_TAG", "v1")
+
+	cfg, err := NewConfigFromFile(filepath.Join(dir, "pipelines", "rnaseq.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := cfg.Image
+	if img.SourceImageName != "docker.io/library/alpine:latest" || img.ImageName != "rnaseq:v1" {
+		t.Errorf("unexpected names %q, %q", img.SourceImageName, img.ImageName)
+	}
+	if img.ImageSavePath != filepath.Join(dir, "base", "images") {
+		t.Errorf("expected default save path relative to the base config, got %q", img.ImageSavePath)
+	}
+	if img.DockerfilePath != filepath.Join(dir, "pipelines", "Dockerfile") {
+		t.Errorf("expected dockerfile relative to the overlay, got %q", img.DockerfilePath)
+	}
+	wantScripts := map[string][]string{
+		"/app":         {filepath.Join(dir, "base", "executor.sh")},
+		"/app/scripts": {filepath.Join(dir, "pipelines", "user.sh")},
+	}
+	if !reflect.DeepEqual(img.ScriptMap, wantScripts) {
+		t.Errorf("expected merged scriptMap %v, got %v", wantScripts, img.ScriptMap)
+	}
+	if !reflect.DeepEqual(img.Packages, []string{"bash"}) {
+		t.Errorf("expected overlay to replace packages, got %v", img.Packages)
+	}
+	wantLabels := map[string]string{"team": "bio", "tier": "pipeline", "registry": "harbor"}
+	if !reflect.DeepEqual(img.Labels, wantLabels) {
+		t.Errorf("expected merged labels %v, got %v", wantLabels, img.Labels)
+	}
+	if img.User != nil {
+		t.Errorf("expected null to remove the base user, got %+v", img.User)
+	}
+	if img.CMD[2] != "echo ${HOME}" {
+		t.Errorf("expected escaped variable to be kept, got %q", img.CMD[2])
+	}
+	if cfg.Version != ConfigSchemaVersion || cfg.Include != nil {
+		t.Errorf("unexpected version %d or include %v", cfg.Version, cfg.Include)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected merged config to validate, got %v", err)
+	}
+}
+
+func TestExpandConfigString(t *testing.T) {
+	t.Setenv("PB_TEST_SET", "value")
+	t.Setenv("PB_TEST_EMPTY", "")
+	for in, want := range map[string]string{
+		"plain":                      "plain",
+		"${PB_TEST_SET}":             "value",
+		"a-${PB_TEST_SET}-b":         "a-value-b",
+		"${PB_TEST_EMPTY}":           "",
+		"${PB_TEST_EMPTY:-fallback}": "fallback",
+		"${PB_TEST_UNSET:-/opt/x}":   "/opt/x",
+		"${PB_TEST_UNSET:-}":         "",
+		"$${PB_TEST_SET}":            "${PB_TEST_SET}",
+		"$PB_TEST_SET":               "$PB_TEST_SET",
+		"$$ and ${1}":                "$$ and ${1}",
+	} {
+		got, err := expandConfigString(in)
+		if err != nil || got != want {
+			t.Errorf("expandConfigString(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := expandConfigString("${PB_TEST_UNSET}"); err == nil {
+		t.Error("expected an error for an unset variable without a default")
+	}
+}
+
+func TestCopyContextDir(t *testing.T) {
+	for _, tt := range []struct {
+		configDir string
+		srcs      []string
+		want      string
+		wantErr   bool
+	}{
+		{"", []string{"./executor.sh"}, ".", false},
+		{"/etc/pb", []string{"/etc/pb/a.sh", "b.sh"}, ".", false},
+		// 설정 파일에서 읽은 소스는 파일 시스템 루트가 아니라 설정 파일의 디렉토리로 제한한다.
+		{"/etc/pb", []string{"/etc/pb/scripts/executor.sh"}, "/etc/pb", false},
+		{"/etc/pb", []string{"/etc/pb/a.sh", "https://example.com/b.tar.gz"}, "/etc/pb", false},
+		{"/etc/pb", []string{"/opt/tools/a.sh", "/opt/tools/lib/b.sh"}, "", true},
+		{"/etc/pb", []string{"/etc/shadow"}, "", true},
+		{"/etc/pb", []string{"/etc/pb/a.sh", "b.sh", "/etc/shadow"}, "", true},
+		{"", []string{"/etc/pb/executor.sh"}, "/etc/pb", false},
+		{"", []string{"/etc/pb/a/x.sh", "/etc/pb/b/y.sh"}, "/etc/pb", false},
+		{"", []string{"https://example.com/b.tar.gz"}, ".", false},
+	} {
+		got, err := copyContextDir(tt.configDir, tt.srcs...)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("copyContextDir(%q, %v) = %q, %v; want %q, error %v", tt.configDir, tt.srcs, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCopyContextDir_ParentOfConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		"secret.sh":       "#!/bin/sh\n",
+		"app/executor.sh": "#!/bin/sh\n",
+		"app/config.yaml": "version: 2\nimage:\n  sourceImageName: alpine:latest\n  imageName: tester:latest\n" +
+			"  scriptMap:\n    /app: [./executor.sh, ../secret.sh]\n",
+	})
+	cfg, err := NewConfigFromFile(filepath.Join(dir, "app", "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcs := cfg.Image.ScriptMap["/app"]
+	if len(srcs) != 2 {
+		t.Fatalf("unexpected scriptMap %v", cfg.Image.ScriptMap)
+	}
+	if got, err := copyContextDir(cfg.Image.configDir, srcs[0]); err != nil || got != filepath.Join(dir, "app") {
+		t.Errorf("copyContextDir(%q) = %q, %v; want %q", srcs[0], got, err, filepath.Join(dir, "app"))
+	}
+	// resolveConfigPath 가 "../secret.sh" 를 설정 디렉토리 밖의 절대 경로로 바꾸므로 복사할 수 없어야 한다.
+	if _, err := copyContextDir(cfg.Image.configDir, srcs[1]); err == nil {
+		t.Errorf("expected an error for %s outside %s", srcs[1], cfg.Image.configDir)
+	}
+}
+
+func TestNewConfigFromFile_ConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		"base/base.yaml":  "version: 2\nimage:\n  workDir: /app\n",
+		"app/config.yaml": "version: 2\ninclude: [../base/base.yaml]\nimage:\n  sourceImageName: alpine:latest\n  imageName: tester:latest\n",
+	})
+	cfg, err := NewConfigFromFile(filepath.Join(dir, "app", "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// include 한 파일의 소스도 복사할 수 있도록 두 설정 파일의 공통 디렉토리를 컨텍스트로 쓴다.
+	if cfg.Image.configDir != dir || cfg.Container.configDir != dir {
+		t.Errorf("expected config dir %q, got %q and %q", dir, cfg.Image.configDir, cfg.Container.configDir)
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/podman/v5/libpod/define"
 	"github.com/containers/podman/v5/pkg/bindings/containers"
-	"github.com/containers/podman/v5/pkg/bindings/images"
 	"github.com/containers/podman/v5/pkg/specgen"
 	"github.com/seoyhaein/utils"
 	"strings"
@@ -128,6 +127,25 @@ func WithCommand(cmd []string) ContainerOptions {
 	}
 }
 
+// WithWorkDir 컨테이너의 작업 디렉토리 설정. 이미지의 WORKDIR 을 덮어쓴다.
+func WithWorkDir(dir string) ContainerOptions {
+	return func(spec *specgen.SpecGenerator) error {
+		spec.WorkDir = dir
+		return nil
+	}
+}
+
+// WithTimeLimit 컨테이너의 최대 실행 시간 설정. 시간이 지나면 주 프로세스에 SIGKILL 을 보낸다. (초 단위로 올림)
+func WithTimeLimit(limit time.Duration) ContainerOptions {
+	return func(spec *specgen.SpecGenerator) error {
+		if limit <= 0 {
+			return fmt.Errorf("time limit must be positive, got %s", limit)
+		}
+		spec.Timeout = uint((limit + time.Second - 1) / time.Second)
+		return nil
+	}
+}
+
 // WithHealthChecker healthcheck 설정에 문제가 발생하면 에러를 반환
 func WithHealthChecker(inCmd, interval string, retries uint, timeout, startPeriod string) ContainerOptions {
 	// 한 번만 파싱/검증
@@ -143,6 +161,7 @@ func WithHealthChecker(inCmd, interval string, retries uint, timeout, startPerio
 }
 
 // StartContainer 컨테이너를 만들고 시작함.
+// ContextWithDryRun 의 ctx 이면 컨테이너를 만들거나 시작하지 않고 기록만 하며, 새 컨테이너의 ID 는 비어 있다.
 func StartContainer(ctx context.Context, spec *specgen.SpecGenerator) (string, error) {
 	if spec == nil {
 		return "", errors.New("spec is nil")
@@ -153,6 +172,11 @@ func StartContainer(ctx context.Context, spec *specgen.SpecGenerator) (string, e
 		return "", fmt.Errorf("create container: %w", err)
 	}
 
+	if d := dryRunFrom(ctx); d != nil {
+		d.record("start container", ccr.Name, "", nil)
+		return ccr.ID, nil
+	}
+
 	if err := containers.Start(ctx, ccr.ID, &containers.StartOptions{}); err != nil {
 		return "", fmt.Errorf("start container: %w", err)
 	}
@@ -161,6 +185,7 @@ func StartContainer(ctx context.Context, spec *specgen.SpecGenerator) (string, e
 }
 
 // CreateContainer 컨테이너 생성
+// ContextWithDryRun 의 ctx 이면 spec 검증, 이름 확인, 이미지 확인만 하고 pull 과 생성은 기록만 한다.
 func CreateContainer(ctx context.Context, conSpec *specgen.SpecGenerator) (*CreateContainerResult, error) {
 	if err := conSpec.Validate(); err != nil {
 		Log.Errorf("validation failed: %v", err)
@@ -183,19 +208,20 @@ func CreateContainer(ctx context.Context, conSpec *specgen.SpecGenerator) (*Crea
 		return handleExistingContainer(ctx, conSpec.Name)
 	}
 
-	// 이미지가 존재하는지 확인
-	imageExists, err := images.Exists(ctx, conSpec.Image, nil)
-	if err != nil {
-		Log.Errorf("Failed to check if image exists: %v", err)
-		return nil, fmt.Errorf("failed to check if image exists: %w", err)
+	// dry-run 에서는 이미지 확인까지만 하고 만들 spec 을 기록한다. 반환되는 ID 는 비어 있다.
+	if d := dryRunFrom(ctx); d != nil {
+		return d.createContainer(ctx, conSpec)
 	}
 
-	if !imageExists {
-		Log.Infof("Pulling %s image...", conSpec.Image)
-		if _, err := images.Pull(ctx, conSpec.Image, &images.PullOptions{}); err != nil {
-			Log.Errorf("Failed to pull image: %v", err)
-			return nil, fmt.Errorf("failed to pull image: %w", err)
-		}
+	// 이미지가 존재하는지 확인하고, 없으면 pull (오프라인 모드에서는 번들에서 로드)
+	if err := ensureImage(ctx, conSpec.Image); err != nil {
+		Log.Errorf("Failed to prepare image: %v", err)
+		return nil, fmt.Errorf("failed to prepare image: %w", err)
+	}
+	// SetImageTrustPolicy 로 정책을 설정했으면 서명을 검증
+	if err := checkImageSignature(ctx, conSpec.Image); err != nil {
+		Log.Errorf("Refusing to run image %s: %v", conSpec.Image, err)
+		return nil, err
 	}
 
 	Log.Infof("Creating %s container using %s image...", conSpec.Name, conSpec.Image)
@@ -262,33 +288,33 @@ func handleExistingContainer(ctx context.Context, containerName string) (*Create
 		return nil, fmt.Errorf("failed to inspect container %q: %w", containerName, err)
 	}
 
-	s := info.State
-	var status ContainerStatus
+	return &CreateContainerResult{
+		Name:   containerName,
+		ID:     info.ID,
+		Status: containerStatus(info.State),
+	}, nil
+}
 
+// containerStatus 는 inspect 한 컨테이너 상태를 ContainerStatus 로 바꾼다. healthcheck 결과는 반영하지 않는다.
+func containerStatus(s *define.InspectContainerState) ContainerStatus {
 	switch {
 	case s.Running:
-		status = Running
+		return Running
 	case s.Paused:
-		status = Paused
+		return Paused
 	case s.Dead:
-		status = Dead
-	case s.ExitCode >= 0:
+		return Dead
+	case s.Status == "created" || s.Status == "configured" || s.Status == "initialized":
+		// 생성만 되고 아직 시작되지 않은 상태. 종료 코드가 0 이므로 먼저 확인한다.
+		return Created
+	case s.ExitCode == 0:
 		// 프로세스가 종료된 상태
-		if s.ExitCode == 0 {
-			status = Exited
-		} else {
-			status = ExitedErr
-		}
+		return Exited
+	case s.ExitCode > 0:
+		return ExitedErr
 	default:
-		// 생성만 되고 아직 시작되지 않은 상태
-		status = Created
+		return Created
 	}
-
-	return &CreateContainerResult{
-		Name:   containerName,
-		ID:     info.ID,
-		Status: status,
-	}, nil
 }
 
 func setHealthChecker(inCmd, interval string, retries uint, timeout, startPeriod string) (*manifest.Schema2HealthConfig, error) {
@@ -0,0 +1,70 @@
+package podbridge5
+
+import (
+	"errors"
+	"fmt"
+	"github.com/containers/podman/v5/pkg/specgen"
+	"github.com/seoyhaein/utils"
+)
+
+// ResourceSettings 의 CPU 값 중 일부만 지정했을 때 나머지에 쓰는 cgroup 기본값
+const (
+	defaultCPUPeriod uint64 = 100000 // 100ms
+	defaultCPUShares uint64 = 1024
+	unlimitedCPU     int64  = -1
+)
+
+// ToSpec 은 ContainerConfig 의 리소스 제한, 볼륨, 환경 변수, 작업 디렉토리, 명령어를 반영해
+// image 로 name 컨테이너를 만드는 SpecGenerator 를 만든다. 반환된 spec 은 CreateContainer 에 그대로 넘긴다.
+// 0 인 리소스 값은 제한하지 않는다. opts 는 설정 다음에 적용되므로 WithPod, WithHealthChecker 등을 더하거나 설정 값을 덮어쓸 수 있다.
+func (c *ContainerConfig) ToSpec(image, name string, opts ...ContainerOptions) (*specgen.SpecGenerator, error) {
+	if utils.IsEmptyString(image) {
+		return nil, errors.New("image name cannot be empty")
+	}
+	if utils.IsEmptyString(name) {
+		return nil, errors.New("container name cannot be empty")
+	}
+	specOpts := append([]ContainerOptions{WithImageName(image), WithName(name)}, c.specOptions()...)
+	spec, err := NewSpec(append(specOpts, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spec for container %s: %w", name, err)
+	}
+	return spec, nil
+}
+
+// specOptions 는 ContainerConfig 를 ContainerOptions 로 바꾼다.
+func (c *ContainerConfig) specOptions() []ContainerOptions {
+	var opts []ContainerOptions
+	if cpu := c.Resources.CPU; cpu.CPUQuota != 0 || cpu.CPUPeriod != 0 || cpu.CPUShares != 0 {
+		quota, period, shares := cpu.CPUQuota, cpu.CPUPeriod, cpu.CPUShares
+		if quota == 0 {
+			quota = unlimitedCPU
+		}
+		if period == 0 {
+			period = defaultCPUPeriod
+		}
+		if shares == 0 {
+			shares = defaultCPUShares
+		}
+		opts = append(opts, WithCPULimits(quota, period, shares))
+	}
+	if c.Resources.Memory.MemLimit > 0 {
+		opts = append(opts, WithMemoryLimit(c.Resources.Memory.MemLimit))
+	}
+	if c.Resources.OOMScore != 0 {
+		opts = append(opts, WithOOMScoreAdj(c.Resources.OOMScore))
+	}
+	for _, v := range c.Volumes {
+		opts = append(opts, WithBindMount(v.HostPath, v.ContainerPath, v.ReadOnly))
+	}
+	if len(c.Env) > 0 {
+		opts = append(opts, WithEnvs(c.Env))
+	}
+	if c.WorkDir != "" {
+		opts = append(opts, WithWorkDir(c.WorkDir))
+	}
+	if len(c.Cmd) > 0 {
+		opts = append(opts, WithCommand(c.Cmd))
+	}
+	return opts
+}
@@ -0,0 +1,82 @@
+package podbridge5
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestContainerConfigToSpec(t *testing.T) {
+	input, output := t.TempDir(), t.TempDir()
+	c := NewConfig("docker.io/library/alpine:latest").Container
+	c.Env = map[string]string{"SAMPLE": "s1"}
+	c.Volumes = []VolumeConfig{
+		{HostPath: input, ContainerPath: "/app/input", ReadOnly: true},
+		{HostPath: output, ContainerPath: "/app/output"},
+	}
+
+	spec, err := c.ToSpec("tester:latest", "node1", WithPod("pod1"), WithEnv("SAMPLE", "s2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Image != "tester:latest" || spec.Name != "node1" || spec.Pod != "pod1" {
+		t.Errorf("unexpected image %q, name %q, pod %q", spec.Image, spec.Name, spec.Pod)
+	}
+	cpu := spec.ResourceLimits.CPU
+	if *cpu.Quota != 50000 || *cpu.Period != 100000 || *cpu.Shares != 1024 {
+		t.Errorf("unexpected cpu limits %d/%d/%d", *cpu.Quota, *cpu.Period, *cpu.Shares)
+	}
+	if *spec.ResourceLimits.Memory.Limit != 536870912 || *spec.OOMScoreAdj != -500 {
+		t.Errorf("unexpected memory limit %d or oom score %d", *spec.ResourceLimits.Memory.Limit, *spec.OOMScoreAdj)
+	}
+	if len(spec.Mounts) != 2 {
+		t.Fatalf("expected 2 mounts, got %+v", spec.Mounts)
+	}
+	if m := spec.Mounts[0]; m.Type != "bind" || m.Source != input || m.Destination != "/app/input" || !reflect.DeepEqual(m.Options, []string{"rbind", "ro"}) {
+		t.Errorf("unexpected read-only mount %+v", m)
+	}
+	if m := spec.Mounts[1]; m.Source != output || !reflect.DeepEqual(m.Options, []string{"rbind", "rw"}) {
+		t.Errorf("unexpected writable mount %+v", m)
+	}
+	if spec.Env["SAMPLE"] != "s2" {
+		t.Errorf("expected options to override config env, got %v", spec.Env)
+	}
+	if spec.WorkDir != "/app" || !reflect.DeepEqual(spec.Command, []string{"/bin/sh", "-c", "/app/executor.sh"}) {
+		t.Errorf("unexpected workdir %q or command %v", spec.WorkDir, spec.Command)
+	}
+}
+
+func TestContainerConfigToSpec_Defaults(t *testing.T) {
+	var c ContainerConfig
+	spec, err := c.ToSpec("tester:latest", "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.ResourceLimits != nil || spec.OOMScoreAdj != nil || spec.Mounts != nil || spec.Env != nil || spec.WorkDir != "" || spec.Command != nil {
+		t.Errorf("expected an empty config to leave the image defaults, got %+v", spec)
+	}
+
+	c.Resources.CPU.CPUShares = 512
+	spec, err = c.ToSpec("tester:latest", "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpu := spec.ResourceLimits.CPU; *cpu.Quota != -1 || *cpu.Period != 100000 || *cpu.Shares != 512 {
+		t.Errorf("expected shares only with no quota, got %d/%d/%d", *cpu.Quota, *cpu.Period, *cpu.Shares)
+	}
+}
+
+func TestContainerConfigToSpec_Errors(t *testing.T) {
+	c := ContainerConfig{Volumes: []VolumeConfig{{HostPath: "/no/such/dir", ContainerPath: "/data"}}}
+	for _, tt := range []struct {
+		image, name, want string
+	}{
+		{"", "node1", "image name cannot be empty"},
+		{"tester:latest", "", "container name cannot be empty"},
+		{"tester:latest", "node1", `failed to create spec for container node1: host path "/no/such/dir" does not exist`},
+	} {
+		if _, err := c.ToSpec(tt.image, tt.name); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ToSpec(%q, %q): expected %q, got %v", tt.image, tt.name, tt.want, err)
+		}
+	}
+}
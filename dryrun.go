@@ -0,0 +1,367 @@
+package podbridge5
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/containers/podman/v5/pkg/bindings/pods"
+	"github.com/containers/podman/v5/pkg/domain/entities"
+	"github.com/containers/podman/v5/pkg/domain/entities/types"
+	"github.com/containers/podman/v5/pkg/specgen"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DryRun 은 dry-run 모드에서 실제로 하지 않은 작업들을 모은다.
+// ContextWithDryRun 으로 ctx 에 붙이면 CreateContainer, StartContainer, NewPod, CreatePod, CreateVolume, WriteFolderToVolume 이,
+// WithBuildDryRun 으로 넘기거나 pbCtx 에 붙어 있으면 CreateImage 가 입력 검증, 이미지 확인, 이름 충돌 확인처럼 읽기만 하는 작업만 하고
+// podman 과 스토리지를 바꾸는 작업(pull, 생성, 시작, 빌드, 저장)은 기록만 한다.
+type DryRun struct {
+	mu      sync.Mutex
+	out     io.Writer
+	actions []DryRunAction
+}
+
+// DryRunAction 은 dry-run 에서 하지 않은 작업 하나이다.
+type DryRunAction struct {
+	Operation string `json:"operation"`        // 예: "pull image", "create container", "build image"
+	Target    string `json:"target"`           // 이미지, 컨테이너, pod, 볼륨 이름
+	Detail    string `json:"detail,omitempty"` // 사람이 읽을 수 있는 부가 설명
+	Spec      any    `json:"spec,omitempty"`   // 만들어질 spec (SpecGenerator, PodSpec, 빌드 계획 등)
+}
+
+func (a DryRunAction) String() string {
+	if a.Detail == "" {
+		return a.Operation + " " + a.Target
+	}
+	return fmt.Sprintf("%s %s (%s)", a.Operation, a.Target, a.Detail)
+}
+
+// NewDryRun 은 하지 않은 작업과 그 spec 을 out 에 출력하는 DryRun 을 만든다. out 이 nil 이면 Actions 로만 확인할 수 있다.
+func NewDryRun(out io.Writer) *DryRun {
+	return &DryRun{out: out}
+}
+
+// Actions 는 지금까지 기록된 작업들을 순서대로 반환한다.
+func (d *DryRun) Actions() []DryRunAction {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DryRunAction(nil), d.actions...)
+}
+
+// record 는 작업을 기록하고 out 에 "[dry-run] ..." 한 줄과 들여쓴 JSON spec 을 출력한다.
+func (d *DryRun) record(operation, target, detail string, spec any) {
+	a := DryRunAction{Operation: operation, Target: target, Detail: detail, Spec: spec}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.actions = append(d.actions, a)
+	if d.out == nil {
+		return
+	}
+	_, _ = fmt.Fprintf(d.out, "[dry-run] %s\n", a)
+	if spec == nil {
+		return
+	}
+	data, err := json.MarshalIndent(spec, "  ", "  ")
+	if err != nil {
+		_, _ = fmt.Fprintf(d.out, "  (failed to render spec: %v)\n", err)
+		return
+	}
+	_, _ = fmt.Fprintf(d.out, "  %s\n", data)
+}
+
+type dryRunKey struct{}
+
+// ContextWithDryRun 은 d 에 작업을 기록하는 dry-run 모드의 ctx 를 반환한다. podman 연결은 그대로 사용한다.
+func ContextWithDryRun(ctx context.Context, d *DryRun) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, d)
+}
+
+// dryRunFrom 은 ctx 가 dry-run 모드이면 그 DryRun 을, 아니면 nil 을 반환한다.
+func dryRunFrom(ctx context.Context) *DryRun {
+	if ctx == nil {
+		return nil
+	}
+	d, _ := ctx.Value(dryRunKey{}).(*DryRun)
+	return d
+}
+
+// resolveImage 는 ensureImage 와 같은 순서로 이미지를 찾는다. 로컬에 있으면 서명 정책을 확인하고,
+// 없으면 오프라인 번들에 있는지 확인한 뒤 로드를, 온라인이면 pull 을 기록한다.
+func (d *DryRun) resolveImage(ctx context.Context, image string) error {
+	exists, err := images.Exists(ctx, image, nil)
+	if err != nil {
+		return fmt.Errorf("failed to check if image %q exists: %w", image, err)
+	}
+	if exists {
+		return checkImageSignature(ctx, image)
+	}
+	if dir := offlineBundleDir(); dir != "" {
+		entry, err := findInBundle(dir, image)
+		if err != nil {
+			return fmt.Errorf("failed to load image %q from bundle: %w", image, err)
+		}
+		d.record("load image", image, "from "+filepath.Join(dir, entry.File), nil)
+		return nil
+	}
+	d.record("pull image", image, "", nil)
+	return nil
+}
+
+// createContainer 는 CreateContainer 가 검증과 이름 확인을 마친 뒤 호출한다.
+func (d *DryRun) createContainer(ctx context.Context, spec *specgen.SpecGenerator) (*CreateContainerResult, error) {
+	if err := d.resolveImage(ctx, spec.Image); err != nil {
+		return nil, fmt.Errorf("failed to prepare image: %w", err)
+	}
+	d.record("create container", spec.Name, "image "+spec.Image, spec)
+	return &CreateContainerResult{Name: spec.Name, Status: Created}, nil
+}
+
+// createPod 는 pod spec 을 검증하고 같은 이름의 pod 가 없는지 확인한다.
+func (d *DryRun) createPod(ctx context.Context, spec *entities.PodSpec) error {
+	if spec == nil {
+		return errors.New("pod spec is nil")
+	}
+	if err := spec.PodSpecGen.Validate(); err != nil {
+		return fmt.Errorf("pod validation failed: %w", err)
+	}
+	name := spec.PodSpecGen.Name
+	if name != "" {
+		exists, err := pods.Exists(ctx, name, nil)
+		if err != nil {
+			return fmt.Errorf("failed to check if pod %q exists: %w", name, err)
+		}
+		if exists {
+			return fmt.Errorf("pod creation failed: pod %q already exists", name)
+		}
+	} else {
+		name = "(generated name)"
+	}
+	d.record("create pod", name, "", spec)
+	return nil
+}
+
+// createVolume 은 같은 이름의 볼륨이 있으면 ignoreIfExists 에 따라 에러 또는 기존 볼륨을 반환한다.
+func (d *DryRun) createVolume(ctx context.Context, name string, ignoreIfExists bool) (*types.VolumeConfigResponse, error) {
+	vcr := &types.VolumeConfigResponse{}
+	vcr.Name = name
+	if name == "" {
+		d.record("create volume", "(generated name)", "", nil)
+		return vcr, nil
+	}
+	exists, err := VolumeExists(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if volume %q exists: %w", name, err)
+	}
+	if exists {
+		if !ignoreIfExists {
+			return nil, fmt.Errorf("failed to create volume: volume %q already exists", name)
+		}
+		return vcr, nil
+	}
+	d.record("create volume", name, "", nil)
+	return vcr, nil
+}
+
+// writeFolderToVolume 은 WriteFolderToVolume 이 mode 에 따라 할 볼륨 작업과 임시 컨테이너로 복사할 파일들을 기록한다.
+func (d *DryRun) writeFolderToVolume(ctx context.Context, volumeName, mountPath, hostDir string, mode VolumeMode, exists bool) error {
+	switch mode {
+	case ModeOverwrite:
+		if exists {
+			d.record("remove volume", volumeName, "overwrite", nil)
+		}
+		d.record("create volume", volumeName, "", nil)
+	case ModeSkip:
+		if exists {
+			Log.Infof("[dry-run] volume %s already exists, skipping", volumeName)
+			return nil
+		}
+		d.record("create volume", volumeName, "", nil)
+	case ModeUpdate:
+		if !exists {
+			d.record("create volume", volumeName, "", nil)
+		}
+	default:
+		return fmt.Errorf("WriteFolderToVolume: unknown mode: %d", mode)
+	}
+
+	spec, err := folderWriterSpec(volumeName, mountPath)
+	if err != nil {
+		return fmt.Errorf("WriteFolderToVolume: build container spec: %w", err)
+	}
+	if err := d.resolveImage(ctx, spec.Image); err != nil {
+		return fmt.Errorf("WriteFolderToVolume: %w", err)
+	}
+	var files int
+	var size int64
+	err = filepath.WalkDir(hostDir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files++
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("WriteFolderToVolume: walk %s: %w", hostDir, err)
+	}
+	d.record("write volume", volumeName, fmt.Sprintf("copy %d files (%d bytes) from %s to %s", files, size, hostDir, mountPath), spec)
+	return nil
+}
+
+// dryRunBuild 는 CreateImage 의 dry-run 에서 출력하는 빌드 계획이다.
+type dryRunBuild struct {
+	Source string   `json:"source"`
+	Image  string   `json:"image"`
+	Steps  []string `json:"steps"`
+}
+
+// createImage 는 CreateImage 가 검증하는 빌드 입력을 검증하고 베이스 이미지를 확인한 뒤, 캐시된 이미지를 쓸지 빌드할지를 기록한다.
+func (d *DryRun) createImage(ctx context.Context, config *BuildConfig, o *imageBuildOptions) error {
+	img := &config.Image
+	if err := img.validateSetup(); err != nil {
+		return err
+	}
+	if err := d.resolveImage(ctx, img.SourceImageName); err != nil {
+		return fmt.Errorf("failed to prepare source image: %w", err)
+	}
+
+	// 베이스 이미지가 로컬에 있으면 지문을 계산해 캐시된 이미지를 쓸지 확인한다.
+	if exists, err := images.Exists(ctx, img.SourceImageName, nil); err == nil && exists && !o.noCache {
+		report, err := images.GetImage(ctx, img.SourceImageName, nil)
+		if err != nil {
+			return fmt.Errorf("failed to inspect source image %q: %w", img.SourceImageName, err)
+		}
+		signedBy, err := o.signingIdentity()
+		if err != nil {
+			return err
+		}
+		fp, err := img.fingerprint(report.ID, signedBy)
+		if err != nil {
+			return err
+		}
+		cachedID, err := img.cachedImage(ctx, fp)
+		if err != nil {
+			return err
+		}
+		if cachedID != "" {
+			d.record("use cached image", img.ImageName, cachedID, nil)
+			return nil
+		}
+	}
+
+	detail := "from " + img.SourceImageName
+	exists, err := images.Exists(ctx, img.ImageName, nil)
+	if err != nil {
+		return fmt.Errorf("failed to check if image %q exists: %w", img.ImageName, err)
+	}
+	if exists {
+		detail += ", replaces the existing image name"
+	}
+	d.record("build image", img.ImageName, detail, dryRunBuild{
+		Source: img.SourceImageName,
+		Image:  img.ImageName,
+		Steps:  img.buildPlan(o),
+	})
+	return nil
+}
+
+// buildPlan 은 CreateImage 가 실행할 단계들을 진행 상황에 표시되는 이름으로 나열한다.
+// 패키지는 빌드할 때 베이스 이미지의 패키지 매니저를 확인한 뒤 정해지므로 설정에 적힌 그대로 표시한다.
+func (img *ImageConfig) buildPlan(o *imageBuildOptions) []string {
+	var plan []string
+	run := func(args ...string) {
+		plan = append(plan, "RUN "+strings.Join(args, " "))
+	}
+	if o.vulnCheck != nil {
+		plan = append(plan, "VULNCHECK "+img.SourceImageName)
+	}
+	if img.User != nil {
+		run("/bin/sh", "-c", img.User.createCommand())
+	}
+	if len(img.Steps) == 0 {
+		for _, dir := range img.Directories {
+			run("mkdir", "-p", dir)
+		}
+		if img.User != nil && len(img.Directories) > 0 {
+			run(append([]string{"chown", "-R", img.User.owner()}, img.Directories...)...)
+		}
+		for _, dest := range sortedKeys(img.ScriptMap) {
+			for _, src := range img.ScriptMap[dest] {
+				plan = append(plan, fmt.Sprintf("COPY %s %s", src, dest))
+			}
+		}
+		if targets := permissionTargets(img.PermissionFiles, explicitModeTargets(img.ScriptMap, img.ScriptModes)); len(targets) > 0 {
+			run(append([]string{"chmod", defaultPermissionMode}, targets...)...)
+		}
+		plan = append(plan, img.installPlan()...)
+	} else {
+		plan = append(plan, img.installPlan()...)
+		for _, s := range img.Steps {
+			plan = append(plan, s.String())
+		}
+		if paths := img.userOwnedPaths(); img.User != nil && len(paths) > 0 {
+			run(append([]string{"chown", "-R", img.User.owner()}, paths...)...)
+		}
+	}
+	if img.WorkDir != "" {
+		plan = append(plan, "WORKDIR "+img.WorkDir)
+	}
+	if len(img.CMD) > 0 {
+		plan = append(plan, "CMD "+strings.Join(img.CMD, " "))
+	}
+	if img.User != nil {
+		plan = append(plan, "USER "+img.User.Name)
+	}
+	if o.vulnCheck != nil {
+		plan = append(plan, "VULNCHECK "+img.ImageName)
+	}
+	if img.SBOM != "" {
+		plan = append(plan, "SBOM "+img.SBOM)
+	}
+	plan = append(plan, "COMMIT "+img.ImageName)
+	if o.signingKey != nil {
+		plan = append(plan, "SIGN "+img.ImageName)
+	}
+	return append(plan, "SAVE "+img.ImageSavePath)
+}
+
+// installPlan 은 설치할 패키지 단계이다. 패키지 매니저별 패키지는 괄호 안에 표시한다.
+func (img *ImageConfig) installPlan() []string {
+	if img.packages().empty() {
+		return nil
+	}
+	parts := append([]string{"INSTALL"}, img.Packages...)
+	for _, m := range sortedKeys(img.DistroPackages) {
+		parts = append(parts, fmt.Sprintf("(%s: %s)", m, strings.Join(img.DistroPackages[m], " ")))
+	}
+	return []string{strings.Join(parts, " ")}
+}
+
+// WithBuildDryRun 은 CreateImage 가 이미지를 빌드하지 않고 d 에 빌드 계획을 기록하게 한다.
+// 빌드 입력 검증, 베이스 이미지 확인, 캐시 확인만 하며 nil report 를 반환한다.
+func WithBuildDryRun(d *DryRun) ImageBuildOption {
+	return func(o *imageBuildOptions) {
+		o.dryRun = d
+	}
+}
+
+// buildDryRun 은 빌드에 쓸 dry-run 이다. WithBuildDryRun 이 없으면 ctx 에 붙은 dry-run 을 쓴다.
+func (o *imageBuildOptions) buildDryRun(ctx context.Context) *DryRun {
+	if o.dryRun != nil {
+		return o.dryRun
+	}
+	return dryRunFrom(ctx)
+}
+
+// errBuildDryRunUnsupported 는 CreateImage 가 아닌 빌드 함수에 WithBuildDryRun 을 넘겼을 때의 에러이다.
+var errBuildDryRunUnsupported = errors.New("dry-run is only supported by CreateImage")
@@ -0,0 +1,159 @@
+package podbridge5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDryRunRecord(t *testing.T) {
+	var out bytes.Buffer
+	d := NewDryRun(&out)
+	spec, err := NewSpec(WithImageName("tester:latest"), WithName("node1"), WithEnv("SAMPLE", "s1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.record("pull image", "tester:latest", "", nil)
+	d.record("create container", "node1", "image tester:latest", spec)
+
+	got := d.Actions()
+	if len(got) != 2 || got[1].Spec != spec {
+		t.Fatalf("unexpected actions %+v", got)
+	}
+	if s := got[1].String(); s != "create container node1 (image tester:latest)" {
+		t.Errorf("unexpected action string %q", s)
+	}
+	printed := out.String()
+	if !strings.HasPrefix(printed, "[dry-run] pull image tester:latest\n[dry-run] create container node1 (image tester:latest)\n  {\n") {
+		t.Errorf("unexpected output:\n%s", printed)
+	}
+	if !strings.Contains(printed, `"SAMPLE": "s1"`) {
+		t.Errorf("expected the rendered spec in the output:\n%s", printed)
+	}
+
+	got[0].Target = "changed"
+	if d.Actions()[0].Target != "tester:latest" {
+		t.Error("Actions must return a copy")
+	}
+}
+
+func TestContextWithDryRun(t *testing.T) {
+	ctx := context.Background()
+	if dryRunFrom(ctx) != nil {
+		t.Error("expected no dry-run on a plain context")
+	}
+	d := NewDryRun(nil)
+	if dryRunFrom(ContextWithDryRun(ctx, d)) != d {
+		t.Error("expected the dry-run attached to the context")
+	}
+}
+
+func TestImageConfigBuildPlan(t *testing.T) {
+	img := ImageConfig{
+		ImageName:       "tester:latest",
+		ImageSavePath:   "/tmp/images",
+		Directories:     []string{"/app"},
+		ScriptMap:       map[string][]string{"/app/": {"./executor.sh", "./install.sh"}},
+		ScriptModes:     map[string]FileMode{"./install.sh": {Mode: "0700"}},
+		PermissionFiles: []string{"/app/executor.sh", "/app/install.sh"},
+		Packages:        []string{"curl"},
+		DistroPackages:  map[string][]string{"apt": {"procps"}},
+		WorkDir:         "/app",
+		CMD:             []string{"/app/executor.sh"},
+		SBOM:            SBOMFormatSPDX,
+	}
+	want := []string{
+		"RUN mkdir -p /app",
+		"COPY ./executor.sh /app/",
+		"COPY ./install.sh /app/",
+		"RUN chmod 0755 /app/executor.sh",
+		"INSTALL curl (apt: procps)",
+		"WORKDIR /app",
+		"CMD /app/executor.sh",
+		"SBOM spdx",
+		"COMMIT tester:latest",
+		"SAVE /tmp/images",
+	}
+	if got := img.buildPlan(newImageBuildOptions()); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected plan\n got %q\nwant %q", got, want)
+	}
+
+	img = ImageConfig{
+		ImageName:     "tester:latest",
+		ImageSavePath: "/tmp/images",
+		WorkDir:       "/app",
+		User:          &UserConfig{Name: "app"},
+		Steps:         []BuildStep{{Type: StepRun, Shell: "make"}},
+	}
+	got := img.buildPlan(newImageBuildOptions(WithSigningKey(&SigningKey{})))
+	if len(got) == 0 || !strings.HasPrefix(got[0], "RUN /bin/sh -c ") {
+		t.Fatalf("expected the user to be created first, got %q", got)
+	}
+	want = []string{"RUN make", "RUN chown -R app:app /app", "WORKDIR /app", "USER app", "COMMIT tester:latest", "SIGN tester:latest", "SAVE /tmp/images"}
+	if !reflect.DeepEqual(got[1:], want) {
+		t.Errorf("unexpected plan\n got %q\nwant %q", got[1:], want)
+	}
+
+	// 베이스 이미지는 빌드 단계 전에, 결과 이미지는 커밋 전에 검사한다.
+	img = ImageConfig{SourceImageName: "alpine:latest", ImageName: "tester:latest", ImageSavePath: "/tmp/images", Steps: []BuildStep{{Type: StepRun, Shell: "make"}}}
+	got = img.buildPlan(newImageBuildOptions(WithVulnerabilityCheck(&VulnerabilityCheck{Database: "db"})))
+	want = []string{"VULNCHECK alpine:latest", "RUN make", "VULNCHECK tester:latest", "COMMIT tester:latest", "SAVE /tmp/images"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected plan\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestDryRunErrors(t *testing.T) {
+	ctx := ContextWithDryRun(context.Background(), NewDryRun(nil))
+	if err := WriteFolderToVolume(ctx, "vol1", "/data", "/no/such/dir", ModeOverwrite); err == nil || !strings.Contains(err.Error(), "stat hostDir") {
+		t.Errorf("expected hostDir to be checked in dry-run, got %v", err)
+	}
+	config := NewConfig("docker.io/library/alpine:latest")
+	if _, err := config.CreateImageWithDockerfile(ctx, nil, WithBuildDryRun(NewDryRun(nil))); !errors.Is(err, errBuildDryRunUnsupported) {
+		t.Errorf("expected dry-run to be rejected, got %v", err)
+	}
+	if _, err := config.CreateImageWithDockerfile(ctx, nil); !errors.Is(err, errBuildDryRunUnsupported) {
+		t.Errorf("expected the dry-run on ctx to be rejected, got %v", err)
+	}
+}
+
+func TestCreateImage_DryRunFromContext(t *testing.T) {
+	saved := pbCtx
+	defer func() { pbCtx = saved }()
+	d := NewDryRun(nil)
+	pbCtx = ContextWithDryRun(context.Background(), d)
+
+	// pbCtx 에 붙은 dry-run 도 따른다. 빌드 입력 검증에서 실패하므로 podman 에 연결하지 않는다.
+	config := NewConfig("docker.io/library/alpine:latest")
+	config.Image.Steps = []BuildStep{{Type: "bogus"}}
+	report, err := config.CreateImage()
+	if err == nil || !strings.Contains(err.Error(), "unknown step type") || report != nil {
+		t.Fatalf("expected the dry-run to validate the steps, got %v, %v", report, err)
+	}
+	if len(d.Actions()) != 0 {
+		t.Errorf("expected nothing to be recorded, got %+v", d.Actions())
+	}
+}
+
+func TestImageConfigValidateSetup(t *testing.T) {
+	// CreateImage 처럼 소스 파일이 있는지는 보지 않는다. 상대 경로는 빌드할 때 현재 디렉토리 기준으로 해석된다.
+	img := ImageConfig{ScriptMap: map[string][]string{"/app/": {"./no-such-script.sh"}}}
+	if err := img.validateSetup(); err != nil {
+		t.Errorf("expected missing sources to be left to the build, got %v", err)
+	}
+	for name, img := range map[string]ImageConfig{
+		"step":    {Steps: []BuildStep{{Type: StepRun}}},
+		"mode":    {ScriptModes: map[string]FileMode{"./a.sh": {Mode: "999"}}},
+		"sbom":    {SBOM: "xml"},
+		"user":    {User: &UserConfig{}},
+		"archive": {ArchiveFormat: SaveFormatOCIDir},
+		"encrypt": {ArchiveFormat: SaveFormatDockerArchive, Encryption: &ImageEncryption{}},
+	} {
+		if err := img.validateSetup(); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package podbridge5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/containers/buildah"
+	cp "github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/oci/archive"
+	"github.com/containers/image/v5/signature"
+	is "github.com/containers/image/v5/storage"
+	imageTypes "github.com/containers/image/v5/types"
+	encconfig "github.com/containers/ocicrypt/config"
+	"github.com/containers/ocicrypt/helpers"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/seoyhaein/utils"
+	"strings"
+)
+
+// ImageEncryption 의 수신자 프로토콜
+const (
+	EncryptionProtocolJWE   = "jwe"   // jwe:<공개 키 PEM 경로>
+	EncryptionProtocolPKCS7 = "pkcs7" // pkcs7:<x509 인증서 경로>
+)
+
+// ImageEncryption 은 ocicrypt 로 이미지 레이어를 암호화하는 설정이다.
+// 암호화된 레이어는 OCI 매니페스트에서만 표현할 수 있으므로 oci-archive, oci-dir 로 저장하거나 oci 형식으로 push 할 때만 사용할 수 있다.
+// executor.sh, healthcheck.sh 가 들어 있는 내부 이미지의 아카이브가 유출되어도 키 없이는 레이어를 읽을 수 없다.
+type ImageEncryption struct {
+	Recipients []string `json:"recipients"` // "jwe:/keys/pub.pem", "pkcs7:/keys/cert.pem"
+	Layers     []int    `json:"layers"`     // 암호화할 레이어 인덱스 (음수는 뒤에서부터, -1 이 마지막 레이어). 비어 있으면 모든 레이어
+}
+
+// Validate 는 수신자의 프로토콜과 키 파일을 검사한다.
+func (e *ImageEncryption) Validate() error {
+	if len(e.Recipients) == 0 {
+		return errors.New("encryption requires at least one recipient")
+	}
+	for _, r := range e.Recipients {
+		protocol, path, ok := strings.Cut(r, ":")
+		if !ok {
+			return fmt.Errorf("invalid recipient %q: expected <protocol>:<key file>", r)
+		}
+		switch protocol {
+		case EncryptionProtocolJWE, EncryptionProtocolPKCS7:
+		default:
+			return fmt.Errorf("unsupported recipient protocol %q: use %s or %s", protocol, EncryptionProtocolJWE, EncryptionProtocolPKCS7)
+		}
+		if exists, _, err := utils.FileExists(path); err != nil || !exists {
+			return fmt.Errorf("recipient key %s does not exist", path)
+		}
+	}
+	return nil
+}
+
+// encryptConfig 는 buildah, containers/image 에 넘길 암호화 설정과 레이어 목록을 만든다.
+func (e *ImageEncryption) encryptConfig() (*encconfig.EncryptConfig, *[]int, error) {
+	if err := e.Validate(); err != nil {
+		return nil, nil, err
+	}
+	cc, err := helpers.CreateCryptoConfig(e.Recipients, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create encryption config: %w", err)
+	}
+	// 비어 있지 않은 슬라이스는 해당 레이어만, 길이 0 인 슬라이스는 모든 레이어를 뜻한다.
+	layers := []int{}
+	if len(e.Layers) > 0 {
+		layers = append(layers, e.Layers...)
+	}
+	return cc.EncryptConfig, &layers, nil
+}
+
+// ImageDecryption 은 워커 노드에서 암호화된 이미지를 로드할 때 사용할 개인 키이다.
+type ImageDecryption struct {
+	PrivateKeys  []string `json:"privateKeys"`  // 개인 키 PEM 경로. 암호가 있으면 "<경로>:pass=<암호>" 또는 "<경로>:file=<암호 파일>"
+	Certificates []string `json:"certificates"` // PKCS7 로 암호화된 레이어를 풀 때 필요한 x509 인증서 경로
+}
+
+// decryptConfig 는 containers/image 에 넘길 복호화 설정을 만든다.
+func (d *ImageDecryption) decryptConfig() (*encconfig.DecryptConfig, error) {
+	if len(d.PrivateKeys) == 0 {
+		return nil, errors.New("decryption requires at least one private key")
+	}
+	for _, k := range d.PrivateKeys {
+		path, _, _ := strings.Cut(k, ":")
+		if exists, _, err := utils.FileExists(path); err != nil || !exists {
+			return nil, fmt.Errorf("private key %s does not exist", path)
+		}
+	}
+	certs := make([]string, 0, len(d.Certificates))
+	for _, c := range d.Certificates {
+		if exists, _, err := utils.FileExists(c); err != nil || !exists {
+			return nil, fmt.Errorf("certificate %s does not exist", c)
+		}
+		certs = append(certs, EncryptionProtocolPKCS7+":"+c)
+	}
+	cc, err := helpers.CreateDecryptCryptoConfig(d.PrivateKeys, certs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create decryption config: %w", err)
+	}
+	return cc.DecryptConfig, nil
+}
+
+// pushEncrypted 는 podman bindings 가 레이어 암호화를 지원하지 않으므로 buildah.Push 로 로컬 스토리지의 이미지를 암호화해서 push 한다.
+// 인증, TLS 설정은 pushWithRetry 가 만든 bindings 옵션을 그대로 따른다.
+func pushEncrypted(ctx context.Context, name, dest string, enc *ImageEncryption, pushOpts *images.PushOptions) error {
+	if pbStore == nil {
+		return errors.New("pbStore is nil: call Init before pushing encrypted images")
+	}
+	encConfig, layers, err := enc.encryptConfig()
+	if err != nil {
+		return err
+	}
+	ref, err := docker.ParseReference("//" + dest)
+	if err != nil {
+		return fmt.Errorf("failed to parse destination %q: %w", dest, err)
+	}
+
+	sysCtx := systemContext()
+	if authFile := pushOpts.GetAuthfile(); authFile != "" {
+		sysCtx.AuthFilePath = authFile
+	} else if user := pushOpts.GetUsername(); user != "" {
+		sysCtx.DockerAuthConfig = &imageTypes.DockerAuthConfig{Username: user, Password: pushOpts.GetPassword()}
+	}
+	if pushOpts.SkipTLSVerify != nil {
+		sysCtx.DockerInsecureSkipTLSVerify = imageTypes.NewOptionalBool(*pushOpts.SkipTLSVerify)
+	}
+
+	opts := buildah.PushOptions{
+		Store:            pbStore,
+		SystemContext:    sysCtx,
+		ManifestType:     buildah.OCIv1ImageManifest,
+		OciEncryptConfig: encConfig,
+		OciEncryptLayers: layers,
+		// 암호화하면 매니페스트 digest 가 바뀌어 기존 서명이 깨지므로 서명은 빼고 push 한다.
+		RemoveSignatures: true,
+		ReportWriter:     pushOpts.GetProgressWriter(),
+		Quiet:            pushOpts.GetProgressWriter() == nil,
+	}
+	if _, _, err := buildah.Push(ctx, name, ref, opts); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LoadEncryptedImage 는 SaveImage 로 암호화해서 저장한 oci-archive 를 keys 로 복호화하며 로컬 스토리지에 로드한다.
+// podman 의 load 는 복호화를 지원하지 않으므로 pbStore 에 직접 복사한다. Init 이 먼저 호출되어 있어야 한다.
+// LoadImage 와 마찬가지로 로드 전에 사이드카 체크섬과 blob digest 를 검증한다.
+func LoadEncryptedImage(ctx context.Context, archivePath string, keys *ImageDecryption) (*LoadReport, error) {
+	if pbStore == nil {
+		return nil, errors.New("pbStore is nil: call Init before loading encrypted images")
+	}
+	if utils.IsEmptyString(archivePath) {
+		return nil, errors.New("archive path cannot be empty")
+	}
+	if keys == nil {
+		return nil, errors.New("decryption keys cannot be nil")
+	}
+	decConfig, err := keys.decryptConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyChecksumFile(archivePath); err != nil {
+		return nil, err
+	}
+	info, err := verifyArchive(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify archive %s: %w", archivePath, err)
+	}
+	if info.format != ArchiveFormatOCI || info.compression != CompressionNone {
+		return nil, fmt.Errorf("encrypted images must be an uncompressed %s, got %s (%s)", ArchiveFormatOCI, info.format, info.compression)
+	}
+	if len(info.tags) == 0 {
+		return nil, fmt.Errorf("archive %s has no image name", archivePath)
+	}
+	name, err := normalizeImageName(info.tags[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid image name %q in archive: %w", info.tags[0], err)
+	}
+
+	srcRef, err := archive.NewReference(archivePath, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s reference for %s: %w", ArchiveFormatOCI, archivePath, err)
+	}
+	destRef, err := is.Transport.ParseStoreReference(pbStore, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image reference %s: %w", name, err)
+	}
+	// 서명 검증은 컨테이너를 만들 때 ImageTrustPolicy 로 하므로 여기서는 모든 이미지를 받아들인다.
+	pc, err := signature.NewPolicyContext(&signature.Policy{Default: signature.PolicyRequirements{signature.NewPRInsecureAcceptAnything()}})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = pc.Destroy() }()
+	sysCtx := systemContext()
+	if _, err := cp.Image(ctx, pc, destRef, srcRef, &cp.Options{
+		SourceCtx:        sysCtx,
+		DestinationCtx:   sysCtx,
+		OciDecryptConfig: decConfig,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to load encrypted archive %s: %w", archivePath, err)
+	}
+
+	img, err := is.Transport.GetStoreImage(pbStore, destRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find loaded image %s: %w", name, err)
+	}
+	return &LoadReport{
+		Format:      info.format,
+		Compression: info.compression,
+		Images:      []LoadedImage{{ID: img.ID, Names: []string{name}}},
+	}, nil
+}
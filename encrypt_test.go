@@ -0,0 +1,209 @@
+package podbridge5
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/containers/ocicrypt"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeRSAKeyPair 는 dir 에 JWE 암호화에 사용할 RSA 개인 키와 공개 키 PEM 파일을 만든다.
+func writeRSAKeyPair(t *testing.T, dir string) (privPath, pubPath string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privPath = filepath.Join(dir, "priv.pem")
+	pubPath = filepath.Join(dir, "pub.pem")
+	if err := os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return privPath, pubPath
+}
+
+func TestImageEncryptionValidate(t *testing.T) {
+	_, pub := writeRSAKeyPair(t, t.TempDir())
+	tests := []struct {
+		name    string
+		enc     ImageEncryption
+		wantErr bool
+	}{
+		{"jwe", ImageEncryption{Recipients: []string{"jwe:" + pub}}, false},
+		{"no recipients", ImageEncryption{}, true},
+		{"no protocol", ImageEncryption{Recipients: []string{pub}}, true},
+		{"pgp", ImageEncryption{Recipients: []string{"pgp:tester@example.com"}}, true},
+		{"missing key", ImageEncryption{Recipients: []string{"pkcs7:" + pub + ".missing"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.enc.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestImageEncryptionLayers(t *testing.T) {
+	_, pub := writeRSAKeyPair(t, t.TempDir())
+	_, layers, err := (&ImageEncryption{Recipients: []string{"jwe:" + pub}}).encryptConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layers == nil || len(*layers) != 0 {
+		t.Errorf("expected all layers to be encrypted, got %v", layers)
+	}
+	_, layers, err = (&ImageEncryption{Recipients: []string{"jwe:" + pub}, Layers: []int{-1}}).encryptConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*layers, []int{-1}) {
+		t.Errorf("unexpected layers %v", *layers)
+	}
+}
+
+func TestImageEncryptionRoundTrip(t *testing.T) {
+	priv, pub := writeRSAKeyPair(t, t.TempDir())
+	ec, _, err := (&ImageEncryption{Recipients: []string{"jwe:" + pub}}).encryptConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc, err := (&ImageDecryption{PrivateKeys: []string{priv}}).decryptConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layer := []byte("#!/bin/sh\necho executor\n")
+	desc := v1.Descriptor{MediaType: v1.MediaTypeImageLayer, Digest: digest.FromBytes(layer), Size: int64(len(layer))}
+	encReader, finalize, err := ocicrypt.EncryptLayer(ec, bytes.NewReader(layer), desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := io.ReadAll(encReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, []byte("executor")) {
+		t.Fatal("expected layer content to be encrypted")
+	}
+	annotations, err := finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encDesc := v1.Descriptor{MediaType: v1.MediaTypeImageLayer + "+encrypted", Annotations: annotations}
+	decReader, _, err := ocicrypt.DecryptLayer(dc, bytes.NewReader(encrypted), encDesc, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := io.ReadAll(decReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, layer) {
+		t.Errorf("decrypted layer = %q, want %q", decrypted, layer)
+	}
+
+	// 다른 키로는 복호화할 수 없다.
+	otherPriv, _ := writeRSAKeyPair(t, t.TempDir())
+	otherDC, err := (&ImageDecryption{PrivateKeys: []string{otherPriv}}).decryptConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ocicrypt.DecryptLayer(otherDC, bytes.NewReader(encrypted), encDesc, false); err == nil {
+		t.Error("expected error when decrypting with the wrong key")
+	}
+}
+
+func TestImageDecryptionConfig(t *testing.T) {
+	priv, _ := writeRSAKeyPair(t, t.TempDir())
+	if _, err := (&ImageDecryption{}).decryptConfig(); err == nil {
+		t.Error("expected error without private keys")
+	}
+	if _, err := (&ImageDecryption{PrivateKeys: []string{priv + ".missing"}}).decryptConfig(); err == nil {
+		t.Error("expected error for missing private key")
+	}
+	if _, err := (&ImageDecryption{PrivateKeys: []string{priv}, Certificates: []string{priv + ".crt"}}).decryptConfig(); err == nil {
+		t.Error("expected error for missing certificate")
+	}
+	if _, err := LoadEncryptedImage(context.Background(), "image.oci.tar", nil); err == nil {
+		t.Error("expected error without decryption keys")
+	}
+}
+
+func TestPushImage_EncryptionOptions(t *testing.T) {
+	_, pub := writeRSAKeyPair(t, t.TempDir())
+	enc := &ImageEncryption{Recipients: []string{"jwe:" + pub}}
+	tests := []struct {
+		name string
+		opts *PushOptions
+	}{
+		{"with signing key", &PushOptions{Encryption: enc, SigningKey: &SigningKey{PrivateKeyPath: "k"}}},
+		{"docker format", &PushOptions{Encryption: enc, Format: ManifestFormatDockerV2S2}},
+		{"invalid recipient", &PushOptions{Encryption: &ImageEncryption{Recipients: []string{"jwe:" + pub + ".missing"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := PushImage(context.Background(), "tester-internal:latest", "", tt.opts); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+	if _, err := PushManifestList(context.Background(), "tester-internal:latest", "", &PushOptions{Encryption: enc}); err == nil {
+		t.Error("expected error for encrypted manifest list")
+	}
+}
+
+func TestImageConfigArchiveOptions(t *testing.T) {
+	_, pub := writeRSAKeyPair(t, t.TempDir())
+	enc := &ImageEncryption{Recipients: []string{"jwe:" + pub}}
+	tests := []struct {
+		name     string
+		img      ImageConfig
+		wantFmt  string
+		wantFile string
+		wantErr  bool
+	}{
+		{"default", ImageConfig{}, SaveFormatDockerArchive, "tester-latest.tar", false},
+		{"oci", ImageConfig{ArchiveFormat: SaveFormatOCIArchive}, SaveFormatOCIArchive, "tester-latest.oci.tar", false},
+		{"encrypted", ImageConfig{Encryption: enc}, SaveFormatOCIArchive, "tester-latest.oci.tar", false},
+		{"encrypted docker", ImageConfig{ArchiveFormat: SaveFormatDockerArchive, Encryption: enc}, SaveFormatDockerArchive, "tester-latest.tar", true},
+		{"oci dir", ImageConfig{ArchiveFormat: SaveFormatOCIDir}, SaveFormatOCIDir, "tester-latest.oci", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.img.ImageName = "docker.io/library/tester:latest"
+			opts := tt.img.archiveOptions()
+			if opts.format() != tt.wantFmt {
+				t.Errorf("expected format %s, got %s", tt.wantFmt, opts.format())
+			}
+			if got := opts.fileName(tt.img.ImageName); got != tt.wantFile {
+				t.Errorf("expected file name %s, got %s", tt.wantFile, got)
+			}
+			if opts.Encryption != tt.img.Encryption {
+				t.Error("expected the image encryption to be passed to SaveImage")
+			}
+			if err := tt.img.validateArchiveFormat(); (err != nil) != tt.wantErr {
+				t.Fatalf("validateArchiveFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
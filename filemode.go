@@ -0,0 +1,124 @@
+package podbridge5
+
+import (
+	"fmt"
+	"github.com/containers/buildah"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	// FileModePreserve 는 복사할 때 원본 파일의 권한을 유지한다.
+	FileModePreserve = "preserve"
+
+	// defaultScriptMode 는 ScriptMap 으로 복사하는 파일의 기본 권한이다. 소유자만 쓸 수 있다.
+	defaultScriptMode = "0755"
+	// defaultPermissionMode 는 PermissionFiles 에 적용하는 권한이다.
+	defaultPermissionMode = "0755"
+)
+
+// FileMode 는 ScriptMap 으로 복사하는 파일 하나의 권한과 소유자이다.
+// 비어 있는 값은 최소 권한 기본값(0755, root:root, setuid/setgid 비트 제거)을 사용한다.
+//
+// config.json 예:
+//
+//	"scriptModes": {
+//	  "./executor.sh": {"mode": "0750", "owner": "app", "group": "app"},
+//	  "./scripts/user_script.sh": {"mode": "0640"}
+//	}
+type FileMode struct {
+	Mode       string `json:"mode"`       // 8진수 권한 (예: "0750"). 비어 있으면 0755, "preserve" 면 원본 파일의 권한을 유지
+	Owner      string `json:"owner"`      // 사용자 이름 또는 UID. 비어 있으면 root
+	Group      string `json:"group"`      // 그룹 이름 또는 GID. Owner 만 지정하면 Owner 의 기본 그룹
+	KeepSetuid bool   `json:"keepSetuid"` // setuid, setgid 비트를 유지한다. Mode 가 "preserve" 일 때만 의미가 있다
+}
+
+// Validate 는 Mode 가 올바른 8진수 권한인지 확인한다.
+func (m FileMode) Validate() error {
+	if m.Mode == "" || m.Mode == FileModePreserve {
+		return nil
+	}
+	v, err := strconv.ParseUint(m.Mode, 8, 32)
+	if err != nil || v > 0o7777 {
+		return fmt.Errorf("invalid file mode %q", m.Mode)
+	}
+	if v&0o002 != 0 {
+		Log.Warnf("File mode %s is world-writable", m.Mode)
+	}
+	return nil
+}
+
+// chmod 는 buildah.AddAndCopyOptions.Chmod 에 넣을 값이다. 빈 문자열이면 원본 권한을 유지한다.
+func (m FileMode) chmod() string {
+	switch m.Mode {
+	case "":
+		return defaultScriptMode
+	case FileModePreserve:
+		return ""
+	}
+	return m.Mode
+}
+
+// chown 은 buildah.AddAndCopyOptions.Chown 에 넣을 "user[:group]" 값이다.
+func (m FileMode) chown() string {
+	if m.Owner == "" && m.Group == "" {
+		return "0:0"
+	}
+	owner := m.Owner
+	if owner == "" {
+		owner = "0"
+	}
+	if m.Group == "" {
+		return owner
+	}
+	return owner + ":" + m.Group
+}
+
+// addAndCopyOptions 는 이 권한과 소유자로 파일을 복사하는 옵션을 만든다.
+func (m FileMode) addAndCopyOptions() buildah.AddAndCopyOptions {
+	return NewAddAndCopyOptions(
+		WithChmod(m.chmod()),
+		WithChown(m.chown()),
+		WithStripSetuidBit(!m.KeepSetuid),
+		WithStripSetgidBit(!m.KeepSetuid),
+		WithHasher(digester.Hash()),
+		WithContextDir("."),
+		WithDryRun(false),
+	)
+}
+
+// validateScriptModes 는 ScriptModes 의 모든 권한을 검사한다.
+func validateScriptModes(modes map[string]FileMode) error {
+	for src, m := range modes {
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("script %s: %w", src, err)
+		}
+	}
+	return nil
+}
+
+// explicitModeTargets 는 ScriptModes 로 권한을 직접 지정한 파일들의 이미지 안 경로이다.
+// PermissionFiles 의 chmod 가 지정한 권한을 덮어쓰지 않도록 이 파일들은 건너뛴다.
+func explicitModeTargets(scripts map[string][]string, modes map[string]FileMode) map[string]bool {
+	targets := make(map[string]bool)
+	for dest, srcs := range scripts {
+		for _, src := range srcs {
+			if m, ok := modes[src]; ok && m.Mode != "" {
+				targets[path.Join(dest, filepath.Base(src))] = true
+			}
+		}
+	}
+	return targets
+}
+
+// permissionTargets 는 PermissionFiles 중 chmod 를 적용할 파일들이다.
+func permissionTargets(files []string, skip map[string]bool) []string {
+	var targets []string
+	for _, f := range files {
+		if !skip[path.Clean(f)] {
+			targets = append(targets, f)
+		}
+	}
+	return targets
+}
@@ -0,0 +1,100 @@
+package podbridge5
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestFileModeAddAndCopyOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      FileMode
+		wantChmod string
+		wantChown string
+		wantStrip bool
+	}{
+		{"default", FileMode{}, "0755", "0:0", true},
+		{"mode and owner", FileMode{Mode: "0750", Owner: "app", Group: "app"}, "0750", "app:app", true},
+		{"owner only", FileMode{Owner: "1000"}, "0755", "1000", true},
+		{"group only", FileMode{Group: "wheel"}, "0755", "0:wheel", true},
+		{"preserve with setuid", FileMode{Mode: FileModePreserve, KeepSetuid: true}, "", "0:0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.mode.Validate(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			opts := tt.mode.addAndCopyOptions()
+			if opts.Chmod != tt.wantChmod || opts.Chown != tt.wantChown {
+				t.Errorf("got chmod %q chown %q, want %q %q", opts.Chmod, opts.Chown, tt.wantChmod, tt.wantChown)
+			}
+			if opts.StripSetuidBit != tt.wantStrip || opts.StripSetgidBit != tt.wantStrip {
+				t.Errorf("expected strip bits %v, got setuid=%v setgid=%v", tt.wantStrip, opts.StripSetuidBit, opts.StripSetgidBit)
+			}
+		})
+	}
+}
+
+func TestFileModeValidate_Invalid(t *testing.T) {
+	for _, mode := range []string{"0o755", "rwxr-xr-x", "0899", "17777"} {
+		if err := (FileMode{Mode: mode}).Validate(); err == nil {
+			t.Errorf("expected error for mode %q", mode)
+		}
+	}
+	if err := validateScriptModes(map[string]FileMode{"./a.sh": {Mode: "abc"}}); err == nil {
+		t.Error("expected error from validateScriptModes")
+	}
+}
+
+func TestNewAddAndCopyOptions_LeastPrivilege(t *testing.T) {
+	opts := newAddAndCopyOptions()
+	if opts.Chmod != "0755" || opts.Chown != "0:0" || !opts.StripSetuidBit || !opts.StripSetgidBit {
+		t.Errorf("unexpected default options %+v", opts)
+	}
+}
+
+func TestPermissionTargets_SkipsExplicitModes(t *testing.T) {
+	scripts := map[string][]string{
+		"/app":         {"./executor.sh", "./install.sh"},
+		"/app/scripts": {"./scripts/user_script.sh"},
+	}
+	modes := map[string]FileMode{
+		"./executor.sh":            {Mode: "0700"},
+		"./scripts/user_script.sh": {Owner: "app"}, // 권한을 지정하지 않았으므로 chmod 대상에 남는다
+	}
+	files := []string{"/app/executor.sh", "/app/install.sh", "/app/scripts/user_script.sh"}
+	got := permissionTargets(files, explicitModeTargets(scripts, modes))
+	want := []string{"/app/install.sh", "/app/scripts/user_script.sh"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("permissionTargets() = %v, want %v", got, want)
+	}
+}
+
+func TestImageConfigScriptModes_FromJSON(t *testing.T) {
+	data := []byte(`{"image": {"scriptModes": {"./executor.sh": {"mode": "0750", "owner": "app", "group": "app"}}}}`)
+	var cfg BuildConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := FileMode{Mode: "0750", Owner: "app", Group: "app"}
+	if got := cfg.Image.ScriptModes["./executor.sh"]; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestImageConfigFingerprint_ScriptModes(t *testing.T) {
+	img, dir := newFingerprintConfig(t)
+	before, err := img.fingerprint("sha256:source", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.ScriptModes = map[string]FileMode{dir + "/executor.sh": {Mode: "0700"}}
+	after, err := img.fingerprint("sha256:source", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Error("expected fingerprint to change when script modes change")
+	}
+}
@@ -0,0 +1,238 @@
+package podbridge5
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/opencontainers/go-digest"
+	"github.com/seoyhaein/utils"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// FingerprintLabel 은 CreateImage 가 이미지에 남기는 빌드 지문 라벨이다.
+const FingerprintLabel = "io.podbridge5.fingerprint"
+
+// ImageNameLabel 은 CreateImage 가 이미지에 남기는 이미지 이름 라벨이다. 태그가 다른 빌드로 옮겨간 뒤에도 GarbageCollectImages 가 어느 이미지의 빌드인지 알 수 있다.
+const ImageNameLabel = "io.podbridge5.image"
+
+// fingerprintVersion 은 지문 계산 방식이 바뀌면 올린다. 이전 방식으로 만든 이미지는 다시 빌드된다.
+const fingerprintVersion = 3
+
+// buildFingerprint 는 지문 계산에 들어가는 값들이다. JSON 으로 직렬화한 결과의 digest 가 지문이 된다.
+type buildFingerprint struct {
+	Version         int                 `json:"version"`
+	SourceImage     string              `json:"sourceImage"` // 베이스 이미지 ID
+	Directories     []string            `json:"directories"`
+	Scripts         []scriptDigest      `json:"scripts"`
+	ScriptModes     map[string]FileMode `json:"scriptModes,omitempty"` // json.Marshal 은 map 키를 정렬한다
+	PermissionFiles []string            `json:"permissionFiles"`
+	WorkDir         string              `json:"workDir"`
+	Cmd             []string            `json:"cmd"`
+	User            *UserConfig         `json:"user,omitempty"`
+	Packages        []string            `json:"packages,omitempty"`
+	DistroPackages  map[string][]string `json:"distroPackages,omitempty"`
+	SBOM            string              `json:"sbom,omitempty"` // SBOM 라벨이 달라진다
+	Steps           []BuildStep         `json:"steps,omitempty"`
+	StepSources     []scriptDigest      `json:"stepSources,omitempty"` // copy, add 단계의 로컬 소스
+	SignedBy        string              `json:"signedBy,omitempty"`    // 서명 키 파일의 digest. 키가 바뀌면 다시 빌드해서 새 키로 서명한다
+}
+
+// scriptDigest 는 ScriptMap 의 파일 하나와 그 내용의 digest 이다.
+type scriptDigest struct {
+	Dest   string `json:"dest"`
+	Src    string `json:"src"`
+	Digest string `json:"digest"`
+}
+
+// WithNoCache 는 지문이 같은 이미지가 있어도 다시 빌드한다.
+func WithNoCache() ImageBuildOption {
+	return func(o *imageBuildOptions) {
+		o.noCache = true
+	}
+}
+
+// fingerprint 는 베이스 이미지 ID 와 ImageConfig 의 빌드 입력으로 지문을 계산한다.
+// ScriptMap 과 Steps 의 copy, add 소스 파일은 경로가 아니라 내용으로 계산하므로 파일을 수정하면 지문이 바뀐다.
+// signedBy 는 WithSigningKey 로 준 키의 digest 이다(signingIdentity). 서명하지 않으면 비워 둔다.
+func (img *ImageConfig) fingerprint(sourceImageID, signedBy string) (string, error) {
+	fp := buildFingerprint{
+		Version:         fingerprintVersion,
+		SourceImage:     sourceImageID,
+		Directories:     img.Directories,
+		ScriptModes:     img.ScriptModes,
+		PermissionFiles: img.PermissionFiles,
+		WorkDir:         img.WorkDir,
+		Cmd:             img.CMD,
+		User:            img.User,
+		Packages:        img.Packages,
+		DistroPackages:  img.DistroPackages,
+		SBOM:            img.SBOM,
+		SignedBy:        signedBy,
+	}
+	for _, dest := range sortedKeys(img.ScriptMap) {
+		for _, src := range img.ScriptMap[dest] {
+			sum, err := pathDigest(src)
+			if err != nil {
+				return "", fmt.Errorf("failed to hash script %s: %w", src, err)
+			}
+			fp.Scripts = append(fp.Scripts, scriptDigest{Dest: dest, Src: src, Digest: sum})
+		}
+	}
+	if len(img.Steps) > 0 {
+		fp.Steps = img.Steps
+		for _, src := range stepSources(img.Steps) {
+			sum, err := pathDigest(src)
+			if err != nil {
+				return "", fmt.Errorf("failed to hash step source %s: %w", src, err)
+			}
+			fp.StepSources = append(fp.StepSources, scriptDigest{Src: src, Digest: sum})
+		}
+	}
+	data, err := json.Marshal(fp)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode fingerprint: %w", err)
+	}
+	return digest.Canonical.FromBytes(data).String(), nil
+}
+
+// useCache 는 지문을 계산하고, 지문이 같은 이미지가 이미 있으면 그 이미지 ID 를 반환한다.
+// 캐시를 사용할 때 ImageSavePath 에 아카이브가 없으면 다시 저장하고, WithSigningKey 가 주어졌는데 서명이 없으면 서명한다.
+func (img *ImageConfig) useCache(ctx context.Context, sourceID string, o *imageBuildOptions, p *buildProgress) (fp, imageID string, err error) {
+	signedBy, err := o.signingIdentity()
+	if err != nil {
+		return "", "", err
+	}
+	fp, err = img.fingerprint(sourceID, signedBy)
+	if err != nil || o.noCache {
+		return fp, "", err
+	}
+	imageID, err = img.cachedImage(ctx, fp)
+	if err != nil || imageID == "" {
+		return fp, "", err
+	}
+	if err := img.ensureSavedImage(ctx, imageID); err != nil {
+		return fp, "", fmt.Errorf("failed to save image: %w", err)
+	}
+	Log.Infof("Image %s is up to date (%s), skipping build", img.ImageName, fp)
+	p.note("Using cached image %s (%s)", img.ImageName, imageID)
+	if err := o.checkCachedVulnerabilities(ctx, imageID, img.ImageName, p); err != nil {
+		return fp, "", err
+	}
+	if err := o.signCachedImage(ctx, imageID, img.ImageName, p); err != nil {
+		return fp, "", err
+	}
+	return fp, imageID, nil
+}
+
+// cachedImage 는 ImageName 으로 된 이미지가 이미 있고 지문이 fp 와 같으면 그 이미지 ID 를 반환한다.
+func (img *ImageConfig) cachedImage(ctx context.Context, fp string) (string, error) {
+	exists, err := images.Exists(ctx, img.ImageName, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to check if image %q exists: %w", img.ImageName, err)
+	}
+	if !exists {
+		return "", nil
+	}
+	report, err := images.GetImage(ctx, img.ImageName, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %q: %w", img.ImageName, err)
+	}
+	if report.Labels[FingerprintLabel] != fp {
+		return "", nil
+	}
+	return report.ID, nil
+}
+
+// sourceImageID 는 베이스 이미지가 로컬에 없으면 가져온 뒤 그 ID 를 반환한다.
+func sourceImageID(ctx context.Context, image string) (string, error) {
+	if utils.IsEmptyString(image) {
+		return "", errors.New("source image name cannot be empty")
+	}
+	if err := ensureImage(ctx, image); err != nil {
+		return "", fmt.Errorf("failed to prepare source image: %w", err)
+	}
+	report, err := images.GetImage(ctx, image, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect source image %q: %w", image, err)
+	}
+	return report.ID, nil
+}
+
+// ensureSavedImage 는 캐시된 이미지를 사용할 때 ImageSavePath 에 아카이브가 없으면 저장한다.
+// SBOM 은 빌드할 때만 만들 수 있으므로 없으면 경고만 남긴다. WithNoCache 로 다시 빌드하면 만들어진다.
+func (img *ImageConfig) ensureSavedImage(ctx context.Context, imageID string) error {
+	if img.SBOM != "" {
+		sbomPath := filepath.Join(img.ImageSavePath, sbomFileName(img.ImageName, img.SBOM))
+		if exists, _, _ := utils.FileExists(sbomPath); !exists {
+			Log.Warnf("SBOM %s for cached image %s is missing; rebuild with WithNoCache to regenerate it", sbomPath, img.ImageName)
+		}
+	}
+	archivePath := filepath.Join(img.ImageSavePath, img.archiveOptions().fileName(img.ImageName))
+	if exists, _, _ := utils.FileExists(archivePath); exists {
+		return nil
+	}
+	return img.saveArchive(ctx, imageID)
+}
+
+// pathDigest 는 파일이면 내용의 digest 를, 디렉토리면 하위 파일들의 상대 경로와 내용을 모두 포함한 digest 를 반환한다.
+// 새로 만든 digester 를 사용한다. image.go 의 digester 는 여러 번 쓰면 이전 내용이 누적된다.
+func pathDigest(path string) (string, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	d := digest.Canonical.Digester()
+	if !st.IsDir() {
+		if err := hashFile(d.Hash(), path); err != nil {
+			return "", err
+		}
+		return d.Digest().String(), nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(path, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		rel, err := filepath.Rel(path, f)
+		if err != nil {
+			return "", err
+		}
+		sum, err := pathDigest(f)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(d.Hash(), "%s\x00%s\n", filepath.ToSlash(rel), sum)
+	}
+	return d.Digest().String(), nil
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cErr := f.Close(); cErr != nil {
+			Log.Warnf("Failed to close file: %v", cErr)
+		}
+	}()
+	_, err = io.Copy(w, f)
+	return err
+}
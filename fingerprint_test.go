@@ -0,0 +1,120 @@
+package podbridge5
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newFingerprintConfig(t *testing.T) (*ImageConfig, string) {
+	t.Helper()
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		"executor.sh":         "#!/bin/sh\necho run\n",
+		"install.sh":          "#!/bin/sh\necho install\n",
+		"scripts/user.sh":     "#!/bin/sh\necho user\n",
+		"scripts/lib/util.sh": "#!/bin/sh\n",
+	})
+	img := &ImageConfig{
+		Directories: []string{"/app", "/app/scripts"},
+		ScriptMap: map[string][]string{
+			"/app":         {filepath.Join(dir, "executor.sh"), filepath.Join(dir, "install.sh")},
+			"/app/scripts": {filepath.Join(dir, "scripts")},
+		},
+		PermissionFiles: []string{"/app/executor.sh"},
+		WorkDir:         "/app",
+		CMD:             []string{"/bin/sh", "-c", "/app/executor.sh"},
+	}
+	return img, dir
+}
+
+func TestImageConfigFingerprint(t *testing.T) {
+	img, _ := newFingerprintConfig(t)
+	base, err := img.fingerprint("sha256:source", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	again, err := img.fingerprint("sha256:source", "")
+	if err != nil || again != base {
+		t.Fatalf("expected stable fingerprint, got %s and %s (%v)", base, again, err)
+	}
+
+	// 각 변경은 설정을 바꾸고, 지문 계산에 사용할 베이스 이미지 ID 를 반환한다.
+	changes := map[string]func(t *testing.T, img *ImageConfig, dir string) string{
+		"source image": func(*testing.T, *ImageConfig, string) string { return "sha256:other" },
+		"script content": func(t *testing.T, _ *ImageConfig, dir string) string {
+			if err := os.WriteFile(filepath.Join(dir, "install.sh"), []byte("#!/bin/sh\necho changed\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			return "sha256:source"
+		},
+		"file in script directory": func(t *testing.T, _ *ImageConfig, dir string) string {
+			if err := os.WriteFile(filepath.Join(dir, "scripts", "lib", "new.sh"), []byte("#!/bin/sh\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			return "sha256:source"
+		},
+		"cmd": func(_ *testing.T, img *ImageConfig, _ string) string {
+			img.CMD = []string{"/app/executor.sh"}
+			return "sha256:source"
+		},
+		"workdir": func(_ *testing.T, img *ImageConfig, _ string) string {
+			img.WorkDir = "/work"
+			return "sha256:source"
+		},
+		"permission files": func(_ *testing.T, img *ImageConfig, _ string) string {
+			img.PermissionFiles = append(img.PermissionFiles, "/app/install.sh")
+			return "sha256:source"
+		},
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			img, dir := newFingerprintConfig(t)
+			before, err := img.fingerprint("sha256:source", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := img.fingerprint(change(t, img, dir), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after == before {
+				t.Errorf("expected fingerprint to change when %s changes", name)
+			}
+		})
+	}
+}
+
+func TestImageConfigFingerprint_MissingScript(t *testing.T) {
+	img := &ImageConfig{ScriptMap: map[string][]string{"/app": {filepath.Join(t.TempDir(), "missing.sh")}}}
+	if _, err := img.fingerprint("sha256:source", ""); err == nil {
+		t.Fatal("expected error for missing script")
+	}
+}
+
+func TestPathDigest_Directory(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	// 파일 경계가 다르면 내용을 이어붙인 결과가 같아도 digest 가 달라야 한다.
+	writeContextFiles(t, a, map[string]string{"x": "ab", "y": "c"})
+	writeContextFiles(t, b, map[string]string{"x": "a", "y": "bc"})
+	da, err := pathDigest(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := pathDigest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if da == db {
+		t.Error("expected different digests for different directory contents")
+	}
+}
+
+func TestWithNoCache(t *testing.T) {
+	if newImageBuildOptions().noCache {
+		t.Error("expected cache to be enabled by default")
+	}
+	if !newImageBuildOptions(WithNoCache()).noCache {
+		t.Error("expected WithNoCache to disable cache")
+	}
+}
@@ -0,0 +1,281 @@
+package podbridge5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/containers/buildah"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/podman/v5/pkg/bindings/containers"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/containers/podman/v5/pkg/domain/entities/types"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GC 기본값
+const (
+	defaultGCKeep          = 3
+	defaultGCRecentJobs    = 24 * time.Hour
+	defaultGCBuilderMinAge = time.Hour
+)
+
+// GCOptions 는 GarbageCollectImages 의 설정이다. 0 인 값은 기본값을 사용한다.
+type GCOptions struct {
+	Keep          int           // 이미지 이름(저장소:태그)과 플랫폼별로 남길 최신 이미지 수. 0 이면 3
+	RecentJobs    time.Duration // 이 시간 안에 만들어지거나 종료된 컨테이너가 사용하는 이미지는 남긴다. 0 이면 24시간
+	BuilderMinAge time.Duration // 이보다 오래된 buildah 빌더 컨테이너만 지운다. 0 이면 1시간
+	SkipPrune     bool          // true 면 podbridge5 가 만들지 않은 dangling 이미지는 지우지 않는다
+	SkipBuilders  bool          // true 면 빌더 컨테이너는 지우지 않는다
+	DryRun        bool          // true 면 지우지 않고 지울 대상만 보고한다
+}
+
+func (o *GCOptions) keep() int {
+	if o.Keep <= 0 {
+		return defaultGCKeep
+	}
+	return o.Keep
+}
+
+func (o *GCOptions) recentJobs() time.Duration {
+	if o.RecentJobs <= 0 {
+		return defaultGCRecentJobs
+	}
+	return o.RecentJobs
+}
+
+func (o *GCOptions) builderMinAge() time.Duration {
+	if o.BuilderMinAge <= 0 {
+		return defaultGCBuilderMinAge
+	}
+	return o.BuilderMinAge
+}
+
+// GCReport 는 GarbageCollectImages 의 결과이다. DryRun 이면 지울 대상을 담는다.
+type GCReport struct {
+	RemovedImages   []string // 지운 podbridge5 이미지 ID
+	KeptImages      []string // 남긴 podbridge5 이미지 ID
+	PrunedImages    []string // 지운 dangling 이미지 ID
+	RemovedBuilders []string // 지운 빌더 컨테이너 이름
+	ReclaimedBytes  int64    // 확보한 공간. 이미지끼리 공유하는 레이어가 있으면 실제보다 클 수 있다
+	Errors          []error  // 지우지 못한 대상들의 에러. 하나가 실패해도 나머지는 계속 지운다
+}
+
+// GarbageCollectImages 는 podbridge5 가 만든 "-internal" 이미지 중 오래된 것들과, dangling 이미지, 남겨진 buildah 빌더 컨테이너를 지운다.
+// 이미지 이름(저장소:태그)과 플랫폼별로 최신 Keep 개와, 실행 중이거나 최근 RecentJobs 안에 실행된 컨테이너가 사용하는 이미지는 남긴다.
+// 대상 하나를 지우지 못하면 GCReport.Errors 에 남기고 계속 진행한다. 목록을 가져오지 못한 경우에만 에러를 반환한다.
+func GarbageCollectImages(ctx context.Context, opts *GCOptions) (*GCReport, error) {
+	if opts == nil {
+		opts = &GCOptions{}
+	}
+
+	summaries, err := images.List(ctx, new(images.ListOptions).WithAll(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	ctrs, err := containers.List(ctx, new(containers.ListOptions).WithAll(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	report := &GCReport{}
+	remove, kept := selectImagesToRemove(summaries, usedImages(ctrs, opts.recentJobs(), time.Now()), opts.keep())
+	for _, img := range kept {
+		report.KeptImages = append(report.KeptImages, img.ID)
+	}
+	for _, img := range remove {
+		if !opts.DryRun {
+			if _, errs := images.Remove(ctx, []string{img.ID}, nil); len(errs) > 0 {
+				report.Errors = append(report.Errors, fmt.Errorf("failed to remove image %s: %w", img.ID, errors.Join(errs...)))
+				continue
+			}
+		}
+		Log.Infof("GC: removed image %s (%s)", shortID(img.ID), imageGCKey(img))
+		report.RemovedImages = append(report.RemovedImages, img.ID)
+		report.ReclaimedBytes += img.Size
+	}
+
+	if !opts.SkipPrune {
+		pruneDangling(ctx, summaries, opts.DryRun, report)
+	}
+	if !opts.SkipBuilders {
+		removeStaleBuilders(opts.builderMinAge(), opts.DryRun, report)
+	}
+	return report, nil
+}
+
+// pruneDangling 은 podbridge5 가 만들지 않은 dangling 이미지를 지운다.
+// podbridge5 이미지는 지문 라벨로 제외한다. 이전 빌드의 이미지는 태그가 없어도 Keep 개수 안에 들면 남겨야 하기 때문이다.
+func pruneDangling(ctx context.Context, summaries []*types.ImageSummary, dryRun bool, report *GCReport) {
+	if dryRun {
+		for _, img := range summaries {
+			if img.Dangling && img.Containers == 0 && !isPodbridgeImage(img) {
+				report.PrunedImages = append(report.PrunedImages, img.ID)
+				report.ReclaimedBytes += img.Size
+			}
+		}
+		return
+	}
+	filters := map[string][]string{"label!": {FingerprintLabel}}
+	pruned, err := images.Prune(ctx, new(images.PruneOptions).WithFilters(filters))
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("failed to prune dangling images: %w", err))
+		return
+	}
+	for _, r := range pruned {
+		if r.Err != nil {
+			report.Errors = append(report.Errors, fmt.Errorf("failed to prune image %s: %w", r.Id, r.Err))
+			continue
+		}
+		report.PrunedImages = append(report.PrunedImages, r.Id)
+		report.ReclaimedBytes += int64(r.Size)
+	}
+}
+
+// removeStaleBuilders 는 빌드가 실패하거나 중단되어 남은 buildah 빌더 컨테이너 중 minAge 보다 오래된 것을 지운다.
+// 진행 중인 빌드의 빌더를 지우지 않도록 최근에 만들어진 빌더는 남긴다.
+func removeStaleBuilders(minAge time.Duration, dryRun bool, report *GCReport) {
+	if pbStore == nil {
+		Log.Warn("GC: pbStore is nil, skipping builder containers")
+		return
+	}
+	builders, err := buildah.OpenAllBuilders(pbStore)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Errorf("failed to list builder containers: %w", err))
+		return
+	}
+	cutoff := time.Now().Add(-minAge)
+	for _, b := range builders {
+		ctr, err := pbStore.Container(b.ContainerID)
+		if err != nil || ctr.Created.After(cutoff) {
+			continue
+		}
+		size, err := pbStore.ContainerSize(b.ContainerID)
+		if err != nil {
+			size = 0
+		}
+		if !dryRun {
+			if err := b.Delete(); err != nil {
+				report.Errors = append(report.Errors, fmt.Errorf("failed to remove builder %s: %w", b.Container, err))
+				continue
+			}
+		}
+		Log.Infof("GC: removed builder container %s", b.Container)
+		report.RemovedBuilders = append(report.RemovedBuilders, b.Container)
+		report.ReclaimedBytes += size
+	}
+}
+
+// selectImagesToRemove 는 podbridge5 이미지를 이미지 이름(저장소:태그)과 플랫폼별로 묶어, 최신 keep 개와 사용 중인 이미지를 남기고 나머지를 지울 대상으로 고른다.
+// 매니페스트 리스트는 플랫폼별 이미지를 참조하므로 건드리지 않는다.
+func selectImagesToRemove(summaries []*types.ImageSummary, used map[string]bool, keep int) (remove, kept []*types.ImageSummary) {
+	groups := make(map[string][]*types.ImageSummary)
+	var keys []string
+	for _, img := range summaries {
+		if !isPodbridgeImage(img) || (img.IsManifestList != nil && *img.IsManifestList) {
+			continue
+		}
+		key := imageGCKey(img)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], img)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		group := groups[key]
+		sort.SliceStable(group, func(i, j int) bool { return group[i].Created > group[j].Created })
+		for i, img := range group {
+			if i < keep || used[img.ID] || img.Containers > 0 {
+				kept = append(kept, img)
+				continue
+			}
+			remove = append(remove, img)
+		}
+	}
+	return remove, kept
+}
+
+// usedImages 는 실행 중이거나 window 안에 만들어지거나 종료된 컨테이너가 사용하는 이미지 ID 들이다.
+func usedImages(ctrs []types.ListContainer, window time.Duration, now time.Time) map[string]bool {
+	cutoff := now.Add(-window)
+	used := make(map[string]bool)
+	for _, c := range ctrs {
+		last := c.Created
+		if c.ExitedAt > 0 {
+			if exited := time.Unix(c.ExitedAt, 0); exited.After(last) {
+				last = exited
+			}
+		}
+		if c.State == "running" || last.After(cutoff) {
+			used[c.ImageID] = true
+		}
+	}
+	return used
+}
+
+// isPodbridgeImage 는 podbridge5 가 빌드한 이미지인지 확인한다. 지문 라벨이 없는 이전 버전의 이미지는 "-internal" 이름으로 찾는다.
+func isPodbridgeImage(img *types.ImageSummary) bool {
+	if _, ok := img.Labels[FingerprintLabel]; ok {
+		return true
+	}
+	for _, name := range img.RepoTags {
+		if strings.HasSuffix(imageRepository(name), "-internal") {
+			return true
+		}
+	}
+	return false
+}
+
+// imageGCKey 는 같은 이미지 이름(저장소와 태그)과 플랫폼의 빌드들을 묶는 키이다. (예: "tester-internal:latest linux/amd64")
+// 태그마다 따로 묶으므로 alpine-internal:3.18 과 :3.20 은 각자 Keep 개씩 남는다.
+// 태그가 옮겨가 dangling 이 된 이전 빌드도 ImageNameLabel 로 같은 묶음에 넣는다.
+func imageGCKey(img *types.ImageSummary) string {
+	name := img.Labels[ImageNameLabel]
+	if name == "" {
+		for _, tag := range img.RepoTags {
+			if tag != "<none>:<none>" {
+				name = tag
+				break
+			}
+		}
+	}
+	if name == "" {
+		name = img.ID
+	}
+	key := strings.TrimPrefix(imageTagName(name), "localhost/")
+	if img.Os != "" || img.Arch != "" {
+		key += " " + img.Os + "/" + img.Arch
+	}
+	return key
+}
+
+// imageTagName 은 digest 를 떼고 태그가 없으면 latest 를 붙인 짧은 이름이다. (예: "docker.io/library/a" -> "a:latest")
+// ImageNameLabel 과 RepoTags 의 표기가 달라도 같은 키가 되도록 정규화한다.
+func imageTagName(name string) string {
+	name, _, _ = strings.Cut(name, "@")
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return name
+	}
+	return reference.FamiliarString(reference.TagNameOnly(named))
+}
+
+// imageRepository 는 이미지 이름에서 태그와 digest 를 뗀 저장소 이름이다. (예: "localhost:5000/a:v1" -> "localhost:5000/a")
+func imageRepository(name string) string {
+	name, _, _ = strings.Cut(name, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[:i]
+	}
+	return name
+}
+
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,119 @@
+package podbridge5
+
+import (
+	"github.com/containers/podman/v5/pkg/domain/entities/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+func gcImage(id string, created int64, tags []string, labels map[string]string) *types.ImageSummary {
+	return &types.ImageSummary{ID: id, Created: created, RepoTags: tags, Labels: labels, Os: "linux", Arch: "amd64", Size: 100}
+}
+
+func imageIDs(imgs []*types.ImageSummary) string {
+	var ids []string
+	for _, img := range imgs {
+		ids = append(ids, img.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestSelectImagesToRemove(t *testing.T) {
+	built := func(name string) map[string]string {
+		return map[string]string{FingerprintLabel: "sha256:fp", ImageNameLabel: name}
+	}
+	summaries := []*types.ImageSummary{
+		gcImage("a1", 1, nil, built("tester-internal:latest")),
+		gcImage("a2", 2, nil, built("tester-internal:latest")),
+		gcImage("a3", 3, nil, built("tester-internal:latest")),
+		gcImage("a4", 4, []string{"localhost/tester-internal:latest"}, built("tester-internal:latest")),
+		gcImage("b1", 1, []string{"localhost/other-internal:v1"}, nil), // 라벨이 없는 이전 버전의 이미지
+		gcImage("b2", 2, []string{"localhost/other-internal:v2"}, nil),
+		gcImage("x1", 1, []string{"docker.io/library/alpine:latest"}, nil), // podbridge5 이미지가 아님
+	}
+
+	remove, kept := selectImagesToRemove(summaries, map[string]bool{"a1": true}, 1)
+	if got := imageIDs(remove); got != "a3,a2" {
+		t.Errorf("remove = %s, want a3,a2", got)
+	}
+	if got := imageIDs(kept); got != "b1,b2,a4,a1" {
+		t.Errorf("kept = %s, want b1,b2,a4,a1", got)
+	}
+}
+
+func TestSelectImagesToRemove_PerTag(t *testing.T) {
+	built := func(name string) map[string]string {
+		return map[string]string{FingerprintLabel: "sha256:fp", ImageNameLabel: name}
+	}
+	// 같은 저장소라도 태그가 다르면 따로 keep 개씩 남긴다.
+	summaries := []*types.ImageSummary{
+		gcImage("old18", 1, nil, built("docker.io/library/alpine-internal:3.18")),
+		gcImage("new18", 2, []string{"docker.io/library/alpine-internal:3.18"}, built("docker.io/library/alpine-internal:3.18")),
+		gcImage("old20", 3, nil, built("docker.io/library/alpine-internal:3.20")),
+		gcImage("new20", 4, []string{"docker.io/library/alpine-internal:3.20"}, built("docker.io/library/alpine-internal:3.20")),
+	}
+	remove, kept := selectImagesToRemove(summaries, nil, 1)
+	if got := imageIDs(remove); got != "old18,old20" {
+		t.Errorf("remove = %s, want old18,old20", got)
+	}
+	if got := imageIDs(kept); got != "new18,new20" {
+		t.Errorf("kept = %s, want new18,new20", got)
+	}
+}
+
+func TestSelectImagesToRemove_PerPlatformAndManifestList(t *testing.T) {
+	list := true
+	arm := gcImage("arm", 1, []string{"localhost/tester-internal:latest-linux-arm64"}, map[string]string{FingerprintLabel: "fp"})
+	arm.Arch = "arm64"
+	summaries := []*types.ImageSummary{
+		gcImage("amd", 2, []string{"localhost/tester-internal:latest-linux-amd64"}, map[string]string{FingerprintLabel: "fp"}),
+		arm,
+		{ID: "list", RepoTags: []string{"localhost/tester-internal:latest"}, IsManifestList: &list},
+	}
+	remove, kept := selectImagesToRemove(summaries, nil, 1)
+	if len(remove) != 0 || len(kept) != 2 {
+		t.Errorf("expected each platform to be kept, removed %s kept %s", imageIDs(remove), imageIDs(kept))
+	}
+}
+
+func TestUsedImages(t *testing.T) {
+	now := time.Now()
+	ctrs := []types.ListContainer{
+		{ImageID: "running", State: "running", Created: now.Add(-72 * time.Hour)},
+		{ImageID: "recent", State: "exited", Created: now.Add(-72 * time.Hour), ExitedAt: now.Add(-time.Hour).Unix()},
+		{ImageID: "old", State: "exited", Created: now.Add(-72 * time.Hour), ExitedAt: now.Add(-48 * time.Hour).Unix()},
+		{ImageID: "created", State: "created", Created: now.Add(-time.Minute)},
+	}
+	used := usedImages(ctrs, 24*time.Hour, now)
+	for id, want := range map[string]bool{"running": true, "recent": true, "old": false, "created": true} {
+		if used[id] != want {
+			t.Errorf("used[%s] = %v, want %v", id, used[id], want)
+		}
+	}
+}
+
+func TestImageGCKey(t *testing.T) {
+	tests := []struct {
+		img  *types.ImageSummary
+		want string
+	}{
+		{gcImage("1", 0, []string{"localhost/tester-internal:latest"}, nil), "tester-internal:latest linux/amd64"},
+		{gcImage("2", 0, []string{"<none>:<none>"}, map[string]string{ImageNameLabel: "tester-internal:v2"}), "tester-internal:v2 linux/amd64"},
+		{gcImage("3", 0, []string{"localhost:5000/team/tool-internal:v1"}, nil), "localhost:5000/team/tool-internal:v1 linux/amd64"},
+		{gcImage("4", 0, []string{"docker.io/library/alpine-internal:3.18"}, nil), "alpine-internal:3.18 linux/amd64"},
+		{gcImage("5", 0, []string{"<none>:<none>"}, map[string]string{ImageNameLabel: "docker.io/library/alpine-internal"}), "alpine-internal:latest linux/amd64"},
+	}
+	for _, tt := range tests {
+		if got := imageGCKey(tt.img); got != tt.want {
+			t.Errorf("imageGCKey(%s) = %q, want %q", tt.img.ID, got, tt.want)
+		}
+	}
+}
+
+func TestGCOptionsDefaults(t *testing.T) {
+	var o GCOptions
+	if o.keep() != defaultGCKeep || o.recentJobs() != defaultGCRecentJobs || o.builderMinAge() != defaultGCBuilderMinAge {
+		t.Errorf("unexpected defaults %d %s %s", o.keep(), o.recentJobs(), o.builderMinAge())
+	}
+}
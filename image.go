@@ -1,7 +1,6 @@
 package podbridge5
 
 import (
-	"compress/gzip"
 	"context"
 	"fmt"
 	"github.com/containers/buildah"
@@ -9,14 +8,13 @@ import (
 	"github.com/containers/common/pkg/config"
 	imageTypes "github.com/containers/image/v5/types"
 	"github.com/containers/podman/v5/pkg/bindings/images"
-	"github.com/containers/podman/v5/pkg/domain/entities/types"
 	"github.com/containers/storage"
 	"github.com/containers/storage/pkg/unshare"
 	"github.com/opencontainers/go-digest"
 	"github.com/seoyhaein/utils"
 	"io"
-	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -98,6 +96,14 @@ func WithSystemContext(sysCtx *imageTypes.SystemContext) BuilderOption {
 	}
 }
 
+// WithPullPolicy sets the pull policy for the base image of the builder.
+func WithPullPolicy(policy define.PullPolicy) BuilderOption {
+	return func(opts *buildah.BuilderOptions) error {
+		opts.PullPolicy = policy
+		return nil
+	}
+}
+
 // WithNetworkConfiguration sets the network configuration policy for the builder options. 함수 수정: 에러 발생 시 이를 반환
 func WithNetworkConfiguration(policy define.NetworkConfigurationPolicy) BuilderOption {
 	return func(opts *buildah.BuilderOptions) error {
@@ -297,22 +303,27 @@ func NewAddAndCopyOptions(opts ...func(*buildah.AddAndCopyOptions)) buildah.AddA
 // ------------------------------------------------------
 // Image Build Helper Functions
 // ------------------------------------------------------
-// TODO dockerfilePath 관점에서 ContextDirectory 생각해봐야 한다. 실제 caleb 적용시 수정될 수 있음. (중요)
-// buildImageFromDockerfile builds an image from the provided Dockerfile
-func buildImageFromDockerfile(ctx context.Context, dockerfilePath string) (string, error) {
+// buildImageFromDockerfile builds an image from the Dockerfile of the ImageConfig.
+// 빌드 전에 Dockerfile 과 COPY/ADD 소스가 모두 빌드 컨텍스트 안에 있는지 확인한다.
+// p 가 있으면 podman build 의 출력을 단계별 진행 상황으로 전달한다.
+func buildImageFromDockerfile(ctx context.Context, img *ImageConfig, p *buildProgress) (string, error) {
+	bc, err := img.resolveBuildContext()
+	if err != nil {
+		return "", err
+	}
+	if err := bc.validate(); err != nil {
+		return "", fmt.Errorf("invalid build context: %w", err)
+	}
 	// Define build options
-	options := types.BuildOptions{
-		BuildOptions: define.BuildOptions{
-			ContextDirectory: ".",
-			PullPolicy:       define.PullIfMissing,
-			Isolation:        define.IsolationOCI,
-			SystemContext:    &imageTypes.SystemContext{},
-		},
-		ContainerFiles: []string{dockerfilePath},
+	options, err := img.buildOptions(bc)
+	if err != nil {
+		return "", err
 	}
 	// Build the Dockerfile
+	out, done := p.podmanOutput()
+	options.Out = out
 	r, err := images.Build(ctx, options.ContainerFiles, options)
-	if err != nil {
+	if err = done(err); err != nil {
 		return "", err
 	}
 
@@ -322,25 +333,13 @@ func buildImageFromDockerfile(ctx context.Context, dockerfilePath string) (strin
 // newBuilder creates a new builder using the NewBuilder function with default options.
 // TODO 좀더 study 필요. 옵션들에 대해서.
 func newBuilder(ctx context.Context, store storage.Store, idName string) (*buildah.Builder, error) {
-	return NewBuilder(ctx, store,
-		WithFromImage(idName),
-		WithIsolation(define.IsolationOCI),
-		WithCommonBuildOptions(nil),
-		WithSystemContext(nil),
-		WithNetworkConfiguration(buildah.NetworkDefault),
-		WithFormat(buildah.Dockerv2ImageManifest),
-		WithCapabilities())
+	return newBuilderWithSystemContext(ctx, store, idName, systemContext())
 }
 
 // newAddAndCopyOptions creates default add and copy options.
+// 기본값은 0755, root:root 이고 setuid, setgid 비트를 제거한다.
 func newAddAndCopyOptions() buildah.AddAndCopyOptions {
-	return NewAddAndCopyOptions(
-		WithChmod("0o755"),
-		WithChown("0:0"),
-		WithHasher(digester.Hash()),
-		WithContextDir("."),
-		WithDryRun(false),
-	)
+	return FileMode{}.addAndCopyOptions()
 }
 
 // ------------------------------------------------------
@@ -348,9 +347,9 @@ func newAddAndCopyOptions() buildah.AddAndCopyOptions {
 // ------------------------------------------------------
 
 // createDirectories creates directories inside the builder.
-func createDirectories(builder *buildah.Builder, dirs []string) error {
+func createDirectories(builder *buildah.Builder, dirs []string, p *buildProgress) error {
 	for _, dir := range dirs {
-		err := builder.Run([]string{"mkdir", "-p", dir}, defaultRunOptions)
+		err := p.run(builder, []string{"mkdir", "-p", dir})
 		if err != nil {
 			return fmt.Errorf("failed to create directory %s: %w", dir, err)
 		}
@@ -359,32 +358,34 @@ func createDirectories(builder *buildah.Builder, dirs []string) error {
 }
 
 // setFilePermissions sets file permissions using chmod.
-func setFilePermissions(builder *buildah.Builder, files []string) error {
-	chmodArgs := append([]string{"chmod", "777"}, files...)
-	err := builder.Run(chmodArgs, defaultRunOptions)
-	if err != nil {
-		return fmt.Errorf("failed to set file permissions: %w", err)
+// 다른 사용자가 쓸 수 없도록 0755 로 설정한다.
+func setFilePermissions(builder *buildah.Builder, files []string, p *buildProgress) error {
+	if len(files) == 0 {
+		return nil
 	}
-	return nil
-}
-
-// TODO 생각하기 이게 필요할지 고민해야함. install.sh 까지도.
-// installDependencies runs the install.sh script.
-func installDependencies(builder *buildah.Builder) error {
-	chmodArgs := []string{"/app/install.sh"}
-	err := builder.Run(chmodArgs, defaultRunOptions)
+	chmodArgs := append([]string{"chmod", defaultPermissionMode}, files...)
+	err := p.run(builder, chmodArgs)
 	if err != nil {
-		return fmt.Errorf("failed to run install.sh: %w", err)
+		return fmt.Errorf("failed to set file permissions: %w", err)
 	}
 	return nil
 }
 
 // copyScripts copies scripts to the specified destination directories.
-func copyScripts(builder *buildah.Builder, scripts map[string][]string) error {
-	options := newAddAndCopyOptions()
-	for dest, srcList := range scripts {
-		for _, src := range srcList {
-			err := builder.Add(dest, false, options, src)
+// 진행 상황이 매번 같은 순서로 나오도록 목적지 디렉토리 순서대로 복사한다.
+// modes 에 소스 경로가 있으면 그 권한과 소유자로, 없으면 기본값으로 복사한다. configDir 은 copyContextDir 을 본다.
+func copyScripts(builder *buildah.Builder, scripts map[string][]string, modes map[string]FileMode, configDir string, p *buildProgress) error {
+	for _, dest := range sortedKeys(scripts) {
+		for _, src := range scripts[dest] {
+			contextDir, err := copyContextDir(configDir, src)
+			if err != nil {
+				return fmt.Errorf("failed to copy script %s to %s: %w", src, dest, err)
+			}
+			options := modes[src].addAndCopyOptions()
+			options.ContextDir = contextDir
+			err = p.step(fmt.Sprintf("COPY %s %s", src, dest), func(io.Writer, io.Writer) error {
+				return builder.Add(dest, false, options, src)
+			})
 			if err != nil {
 				return fmt.Errorf("failed to copy script %s to %s: %w", src, dest, err)
 			}
@@ -393,11 +394,95 @@ func copyScripts(builder *buildah.Builder, scripts map[string][]string) error {
 	return nil
 }
 
-// saveImage saves the built image to an archive file. TODO 파일 읽는 부분 살펴봐야 함. outputFile, err := os.OpenFile(archivePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+// sortedKeys 는 scripts 의 키를 정렬해서 반환한다.
+func sortedKeys(scripts map[string][]string) []string {
+	keys := mapKeys(scripts)
+	sort.Strings(keys)
+	return keys
+}
+
+// buildSteps 는 CreateImage 가 실행하는 단계 수이다. (Steps 또는 mkdir, COPY, chmod 에 commit, save)
+// 설치할 패키지가 있으면 설치 단계가, User 가 있으면 사용자 생성과 chown 단계가 더해진다.
+func (img *ImageConfig) buildSteps() int {
+	n := len(img.Steps) + 2
+	if len(img.Steps) == 0 {
+		n = len(img.Directories) + 3
+		for _, srcs := range img.ScriptMap {
+			n += len(srcs)
+		}
+	}
+	if !img.packages().empty() {
+		n++
+	}
+	if img.User != nil {
+		n++
+		if len(img.userOwnedPaths()) > 0 {
+			n++
+		}
+	}
+	if img.SBOM != "" {
+		n++
+	}
+	return n
+}
+
+// saveImage saves the built image to an archive file.
+// 기존 호출부를 위한 래퍼로, docker-archive 를 만들고 compress 이면 파일 전체를 gzip 으로 압축한다. 세부 설정은 SaveImage 를 사용한다.
 func saveImage(ctx context.Context, path, imageName, imageId string, compress bool) error {
 	// imageName 이미 태그를 포함한 완전한 이름이어야 함
 	// 예: "docker.io/library/alpine-internal:latest"
+	opts := &SaveOptions{
+		Format:      SaveFormatDockerArchive,
+		Compression: CompressionNone,
+		FileName:    archiveFileName(imageName, compress),
+	}
+	if compress {
+		opts.Compression = CompressionGzip
+	}
+	_, err := SaveImage(ctx, path, []string{imageId}, opts)
+	return err
+}
+
+// archiveOptions 는 빌드한 이미지를 ImageSavePath 에 저장할 때 사용할 SaveOptions 이다.
+// Encryption 이 있으면 암호화된 레이어를 표현할 수 있는 oci-archive 로 저장한다.
+func (img *ImageConfig) archiveOptions() *SaveOptions {
+	format := img.ArchiveFormat
+	if format == "" && img.Encryption != nil {
+		format = SaveFormatOCIArchive
+	}
+	return &SaveOptions{Format: format, Encryption: img.Encryption}
+}
+
+// validateArchiveFormat 은 ArchiveFormat 을 확인한다. docker-archive 는 암호화된 레이어를 담을 수 없으므로 Encryption 과 함께 쓸 수 없다.
+func (img *ImageConfig) validateArchiveFormat() error {
+	opts := img.archiveOptions()
+	switch opts.format() {
+	case SaveFormatDockerArchive:
+		if opts.Encryption != nil {
+			return fmt.Errorf("encryption requires %s, not %s", SaveFormatOCIArchive, SaveFormatDockerArchive)
+		}
+	case SaveFormatOCIArchive:
+	default:
+		return fmt.Errorf("unsupported archive format %q: use %s or %s", opts.Format, SaveFormatDockerArchive, SaveFormatOCIArchive)
+	}
+	return nil
+}
+
+// saveArchive 는 빌드한 이미지를 archiveOptions 에 따라 ImageSavePath 에 저장한다.
+// oci-archive 는 pbStore 에서 이름으로 찾아 저장하므로 이미지 ID 대신 ImageName 을 넘긴다.
+func (img *ImageConfig) saveArchive(ctx context.Context, imageID string) error {
+	opts := img.archiveOptions()
+	name := imageID
+	if opts.format() != SaveFormatDockerArchive {
+		name = img.ImageName
+	}
+	_, err := SaveImage(ctx, img.ImageSavePath, []string{name}, opts)
+	return err
+}
 
+// archiveFileName 은 saveImage 가 사용하는 아카이브 파일 이름을 만든다.
+// 예: "docker.io/library/alpine-internal:latest" -> "alpine-internal-latest.tar"
+func archiveFileName(imageName string, compress bool) string {
 	// 압축 여부에 따른 파일 확장자 설정
 	extension := ".tar"
 	if compress {
@@ -410,48 +495,7 @@ func saveImage(ctx context.Context, path, imageName, imageId string, compress bo
 	// 파일명에 콜론(:)은 문제가 될 수 있으므로 하이픈(-)으로 치환
 	safeImageName := strings.ReplaceAll(baseImage, ":", "-")
 	// 파일명 생성: safeImageName + 확장자
-	archiveFileName := fmt.Sprintf("%s%s", safeImageName, extension)
-	// 입력받은 path 에 바로 결합 (불필요한 디렉토리 구조가 생성되지 않도록)
-	archivePath := filepath.Join(path, archiveFileName)
-
-	// archive 파일이 위치할 디렉토리 생성
-	dir := filepath.Dir(archivePath)
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return fmt.Errorf("failed to create directory %s: %w", dir, err)
-	}
-
-	// 출력 파일 생성 (명시적으로 파일 권한 설정)
-	outputFile, err := os.OpenFile(archivePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
-	if err != nil {
-		return fmt.Errorf("failed to create output file %s: %w", archivePath, err)
-	}
-	defer func() {
-		if cErr := outputFile.Close(); cErr != nil {
-			Log.Warnf("Failed to close output file: %v", cErr)
-		}
-	}()
-
-	var writer io.Writer = outputFile
-
-	if compress {
-		// gzip.Writer 사용하여 데이터를 압축
-		gzipWriter := gzip.NewWriter(outputFile)
-		defer func() {
-			if zCerr := gzipWriter.Close(); zCerr != nil {
-				Log.Errorf("Failed to close gzip writer: %v", zCerr)
-			}
-		}()
-		writer = gzipWriter
-	}
-	// layer 를 별도로 압축을 할 수 있음.
-	exportOptions := &images.ExportOptions{
-		// 필요한 경우 추가 옵션을 설정
-	}
-
-	if err := images.Export(ctx, []string{imageId}, writer, exportOptions); err != nil {
-		return fmt.Errorf("failed to export image %s: %w", imageId, err)
-	}
-	return nil
+	return fmt.Sprintf("%s%s", safeImageName, extension)
 }
 
 // internalizeImageName 은 입력 이미지 이름에서 태그 앞에 "-internal"을 삽입하여 내부 전용 이미지 이름을 생성
@@ -14,7 +14,7 @@ func CreateInitContainer(ctx context.Context, podID, lowerdir, upperdir, workdir
 	spec, err := NewSpec(
 		WithPod(podID),
 		WithName("init-container"),
-		WithImageName("docker.io/library/alpine:latest"), // Use a lightweight image for the init container
+		WithImageName(helperImage), // Use a lightweight image for the init container
 		WithSysAdmin(),
 		WithUnconfinedSeccomp(),
 		WithCommand([]string{"sh", "-c",
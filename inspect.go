@@ -0,0 +1,212 @@
+package podbridge5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/containers/podman/v5/pkg/domain/entities/types"
+	"github.com/containers/podman/v5/pkg/inspect"
+	"github.com/seoyhaein/utils"
+	"sort"
+	"time"
+)
+
+// ImageInfo 는 InspectImage 가 반환하는 이미지 정보이다.
+type ImageInfo struct {
+	ID           string
+	Digest       string            // 매니페스트 digest
+	Names        []string          // 태그 (예: "localhost/tester-internal:latest")
+	RepoDigests  []string          // 레지스트리에서 받은 이미지면 "이름@digest"
+	Created      time.Time         // 이미지가 만들어진 시각
+	Size         int64             // 바이트
+	Os           string            // 예: "linux"
+	Architecture string            // 예: "amd64"
+	User         string            // 컨테이너를 실행할 사용자. 비어 있으면 root
+	WorkDir      string            // 컨테이너의 작업 디렉토리
+	Env          []string          // "KEY=value"
+	Entrypoint   []string          // ENTRYPOINT
+	Cmd          []string          // CMD
+	ExposedPorts []string          // 예: "8080/tcp". 정렬되어 있다
+	Volumes      []string          // 정렬되어 있다
+	Labels       map[string]string // 이미지 라벨. podbridge5 가 만든 이미지는 FingerprintLabel, ImageNameLabel 이 있다
+	Layers       []string          // 레이어 digest. 베이스 이미지의 레이어부터 순서대로
+	Fingerprint  string            // FingerprintLabel 의 값. podbridge5 가 만든 이미지가 아니면 비어 있다
+}
+
+// ImageHistoryEntry 는 이미지를 만든 명령 하나이다. 레이어를 만들지 않은 명령(ENV, CMD 등)은 Size 가 0 이다.
+type ImageHistoryEntry struct {
+	ID        string    // 레이어를 만든 이미지 ID. 로컬에 없는 중간 이미지는 "<missing>"
+	Created   time.Time // 명령이 실행된 시각
+	CreatedBy string    // 실행한 명령 (예: "/bin/sh -c apk add curl")
+	Tags      []string  // 이 단계의 이미지에 붙은 태그
+	Size      int64     // 이 명령이 추가한 바이트
+	Comment   string
+}
+
+// ImageListItem 은 ListImages 가 반환하는 이미지 하나이다.
+type ImageListItem struct {
+	ID         string
+	Names      []string // 태그. dangling 이미지면 비어 있다
+	Created    time.Time
+	Size       int64
+	Labels     map[string]string
+	Os         string
+	Arch       string
+	Dangling   bool // 태그가 없는 이미지
+	Containers int  // 이 이미지를 사용하는 컨테이너 수
+}
+
+// ImageFilter 는 ListImages 의 조건이다. 비어 있는 조건은 적용하지 않고, 모든 조건을 만족하는 이미지만 반환한다.
+type ImageFilter struct {
+	Labels        map[string]string // 라벨이 있고 값이 같은 이미지. 값이 비어 있으면 라벨이 있기만 하면 된다
+	Names         []string          // 이름(태그)이 하나라도 맞는 이미지. podman 의 reference 필터와 같다 (예: "tester-internal", "localhost/*:latest")
+	Dangling      bool              // true 면 dangling 이미지만
+	PodbridgeOnly bool              // true 면 podbridge5 가 만든 이미지만
+}
+
+// filters 는 podman 의 이미지 목록 필터이다.
+func (f *ImageFilter) filters() map[string][]string {
+	filters := make(map[string][]string)
+	for _, key := range sortedStringKeys(f.Labels) {
+		if v := f.Labels[key]; v != "" {
+			filters["label"] = append(filters["label"], key+"="+v)
+		} else {
+			filters["label"] = append(filters["label"], key)
+		}
+	}
+	if len(f.Names) > 0 {
+		filters["reference"] = append([]string(nil), f.Names...)
+	}
+	if f.Dangling {
+		filters["dangling"] = []string{"true"}
+	}
+	return filters
+}
+
+// InspectImage 는 이미지의 digest, 크기, 라벨, 환경 변수, CMD, ENTRYPOINT, 레이어 등을 반환한다.
+// CreateImage 가 WorkDir, CMD, USER 를 제대로 설정했는지 확인할 때 사용할 수 있다.
+func InspectImage(ctx context.Context, nameOrID string) (*ImageInfo, error) {
+	if utils.IsEmptyString(nameOrID) {
+		return nil, errors.New("image name cannot be empty")
+	}
+	report, err := images.GetImage(ctx, nameOrID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image %s: %w", nameOrID, err)
+	}
+	if report.ImageData == nil {
+		return nil, fmt.Errorf("failed to inspect image %s: empty report", nameOrID)
+	}
+	return newImageInfo(report.ImageData), nil
+}
+
+// newImageInfo 는 podman 의 inspect 결과에서 ImageInfo 를 만든다.
+func newImageInfo(data *inspect.ImageData) *ImageInfo {
+	info := &ImageInfo{
+		ID:           data.ID,
+		Digest:       data.Digest.String(),
+		Names:        data.RepoTags,
+		RepoDigests:  data.RepoDigests,
+		Size:         data.Size,
+		Os:           data.Os,
+		Architecture: data.Architecture,
+		User:         data.User,
+		Labels:       data.Labels,
+		Fingerprint:  data.Labels[FingerprintLabel],
+	}
+	if data.Created != nil {
+		info.Created = *data.Created
+	}
+	if c := data.Config; c != nil {
+		if c.User != "" {
+			info.User = c.User
+		}
+		info.WorkDir = c.WorkingDir
+		info.Env = c.Env
+		info.Entrypoint = c.Entrypoint
+		info.Cmd = c.Cmd
+		for port := range c.ExposedPorts {
+			info.ExposedPorts = append(info.ExposedPorts, port)
+		}
+		sort.Strings(info.ExposedPorts)
+		for v := range c.Volumes {
+			info.Volumes = append(info.Volumes, v)
+		}
+		sort.Strings(info.Volumes)
+		if info.Labels == nil {
+			info.Labels = c.Labels
+			info.Fingerprint = c.Labels[FingerprintLabel]
+		}
+	}
+	if data.RootFS != nil {
+		for _, layer := range data.RootFS.Layers {
+			info.Layers = append(info.Layers, layer.String())
+		}
+	}
+	return info
+}
+
+// ImageHistory 는 이미지를 만든 명령들을 최근 것부터 반환한다.
+func ImageHistory(ctx context.Context, nameOrID string) ([]ImageHistoryEntry, error) {
+	if utils.IsEmptyString(nameOrID) {
+		return nil, errors.New("image name cannot be empty")
+	}
+	history, err := images.History(ctx, nameOrID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history of image %s: %w", nameOrID, err)
+	}
+	entries := make([]ImageHistoryEntry, 0, len(history))
+	for _, h := range history {
+		entries = append(entries, ImageHistoryEntry{
+			ID:        h.ID,
+			Created:   time.Unix(h.Created, 0),
+			CreatedBy: h.CreatedBy,
+			Tags:      h.Tags,
+			Size:      h.Size,
+			Comment:   h.Comment,
+		})
+	}
+	return entries, nil
+}
+
+// ListImages 는 filter 를 만족하는 이미지들을 최근에 만든 것부터 반환한다. filter 가 nil 이면 모든 이미지를 반환한다.
+func ListImages(ctx context.Context, filter *ImageFilter) ([]ImageListItem, error) {
+	if filter == nil {
+		filter = &ImageFilter{}
+	}
+	opts := new(images.ListOptions).WithFilters(filter.filters())
+	summaries, err := images.List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	return filterImageSummaries(summaries, filter), nil
+}
+
+// filterImageSummaries 는 podman 필터로 거를 수 없는 조건(PodbridgeOnly)을 적용하고 최근에 만든 것부터 정렬한다.
+func filterImageSummaries(summaries []*types.ImageSummary, filter *ImageFilter) []ImageListItem {
+	items := make([]ImageListItem, 0, len(summaries))
+	for _, s := range summaries {
+		if filter.PodbridgeOnly && !isPodbridgeImage(s) {
+			continue
+		}
+		var names []string
+		for _, tag := range s.RepoTags {
+			if tag != "<none>:<none>" {
+				names = append(names, tag)
+			}
+		}
+		items = append(items, ImageListItem{
+			ID:         s.ID,
+			Names:      names,
+			Created:    time.Unix(s.Created, 0),
+			Size:       s.Size,
+			Labels:     s.Labels,
+			Os:         s.Os,
+			Arch:       s.Arch,
+			Dangling:   s.Dangling,
+			Containers: s.Containers,
+		})
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Created.After(items[j].Created) })
+	return items
+}
@@ -0,0 +1,93 @@
+package podbridge5
+
+import (
+	"github.com/containers/podman/v5/pkg/domain/entities/types"
+	"github.com/containers/podman/v5/pkg/inspect"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewImageInfo(t *testing.T) {
+	created := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	data := &inspect.ImageData{
+		ID:           "sha256:abc",
+		Digest:       digest.Digest("sha256:def"),
+		RepoTags:     []string{"localhost/tester-internal:latest"},
+		Created:      &created,
+		Size:         1024,
+		Os:           "linux",
+		Architecture: "amd64",
+		Labels:       map[string]string{FingerprintLabel: "sha256:fp"},
+		Config: &v1.ImageConfig{
+			User:         "app",
+			WorkingDir:   "/app",
+			Env:          []string{"PATH=/usr/bin"},
+			Cmd:          []string{"/app/executor.sh"},
+			ExposedPorts: map[string]struct{}{"9090/udp": {}, "8080/tcp": {}},
+			Volumes:      map[string]struct{}{"/data": {}},
+		},
+		RootFS: &inspect.RootFS{Type: "layers", Layers: []digest.Digest{"sha256:l1", "sha256:l2"}},
+	}
+
+	info := newImageInfo(data)
+	if info.WorkDir != "/app" || info.User != "app" || !reflect.DeepEqual(info.Cmd, []string{"/app/executor.sh"}) {
+		t.Errorf("unexpected config %+v", info)
+	}
+	if info.Digest != "sha256:def" || !info.Created.Equal(created) || info.Fingerprint != "sha256:fp" {
+		t.Errorf("unexpected metadata %+v", info)
+	}
+	if !reflect.DeepEqual(info.ExposedPorts, []string{"8080/tcp", "9090/udp"}) || !reflect.DeepEqual(info.Volumes, []string{"/data"}) {
+		t.Errorf("unexpected ports %v or volumes %v", info.ExposedPorts, info.Volumes)
+	}
+	if !reflect.DeepEqual(info.Layers, []string{"sha256:l1", "sha256:l2"}) {
+		t.Errorf("unexpected layers %v", info.Layers)
+	}
+}
+
+func TestNewImageInfo_NoConfig(t *testing.T) {
+	info := newImageInfo(&inspect.ImageData{ID: "sha256:abc"})
+	if info.ID != "sha256:abc" || info.WorkDir != "" || info.Layers != nil {
+		t.Errorf("unexpected info %+v", info)
+	}
+}
+
+func TestImageFilterFilters(t *testing.T) {
+	f := &ImageFilter{
+		Labels:   map[string]string{"team": "ml", FingerprintLabel: ""},
+		Names:    []string{"tester-internal"},
+		Dangling: true,
+	}
+	want := map[string][]string{
+		"label":     {FingerprintLabel, "team=ml"},
+		"reference": {"tester-internal"},
+		"dangling":  {"true"},
+	}
+	if got := f.filters(); !reflect.DeepEqual(got, want) {
+		t.Errorf("filters() = %v, want %v", got, want)
+	}
+	if got := (&ImageFilter{}).filters(); len(got) != 0 {
+		t.Errorf("expected no filters, got %v", got)
+	}
+}
+
+func TestFilterImageSummaries(t *testing.T) {
+	summaries := []*types.ImageSummary{
+		{ID: "alpine", Created: 3, RepoTags: []string{"docker.io/library/alpine:latest"}},
+		{ID: "old", Created: 1, RepoTags: []string{"<none>:<none>"}, Labels: map[string]string{FingerprintLabel: "fp"}, Dangling: true},
+		{ID: "new", Created: 2, RepoTags: []string{"localhost/tester-internal:latest"}},
+	}
+
+	items := filterImageSummaries(summaries, &ImageFilter{PodbridgeOnly: true})
+	if len(items) != 2 || items[0].ID != "new" || items[1].ID != "old" {
+		t.Fatalf("unexpected items %+v", items)
+	}
+	if items[1].Names != nil {
+		t.Errorf("expected dangling image to have no names, got %v", items[1].Names)
+	}
+	if all := filterImageSummaries(summaries, &ImageFilter{}); len(all) != 3 || all[0].ID != "alpine" {
+		t.Errorf("unexpected items %+v", all)
+	}
+}
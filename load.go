@@ -0,0 +1,308 @@
+package podbridge5
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/klauspost/compress/zstd"
+	"github.com/seoyhaein/utils"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// 아카이브 형식
+const (
+	ArchiveFormatDocker = "docker-archive"
+	ArchiveFormatOCI    = "oci-archive"
+)
+
+// 압축 형식
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var (
+	ErrDigestMismatch = errors.New("digest mismatch")
+
+	// docker-archive 안의 config(<hex>.json)와 layer(<hex>.tar) 파일 이름
+	dockerBlobName = regexp.MustCompile(`^([0-9a-f]{64})\.(json|tar)$`)
+	// oci-archive 안의 blob 파일 이름
+	ociBlobName = regexp.MustCompile(`^blobs/sha256/([0-9a-f]{64})$`)
+)
+
+// LoadReport 는 LoadImage 의 결과이다.
+type LoadReport struct {
+	Format      string        // docker-archive 또는 oci-archive
+	Compression string        // none, gzip, zstd
+	Images      []LoadedImage // 로드된 이미지들
+}
+
+// LoadedImage 는 로드된 이미지 하나의 ID 와 이름들이다.
+type LoadedImage struct {
+	ID    string
+	Names []string
+}
+
+// archiveInfo 는 검증 과정에서 아카이브로부터 읽어낸 정보이다.
+type archiveInfo struct {
+	format      string
+	compression string
+	tags        []string // 아카이브에 기록된 태그
+}
+
+// LoadImage 는 saveImage 로 만든 아카이브를 podman 으로 다시 로드한다.
+// docker-archive, oci-archive 와 이들의 gzip, zstd 압축본을 지원한다.
+// 로드 전에 아카이브 안의 blob digest 를 모두 검증하고, 로드 후 아카이브에 기록된 태그가 빠져 있으면 다시 붙인다.
+func LoadImage(ctx context.Context, archivePath string) (*LoadReport, error) {
+	if utils.IsEmptyString(archivePath) {
+		return nil, errors.New("archive path cannot be empty")
+	}
+
+	// 1. 검증 (사이드카 체크섬, digest, 형식, 태그 수집)
+	if err := verifyChecksumFile(archivePath); err != nil {
+		return nil, err
+	}
+	info, err := verifyArchive(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify archive %s: %w", archivePath, err)
+	}
+
+	// 2. 로드
+	rc, _, err := openArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cErr := rc.Close(); cErr != nil {
+			Log.Warnf("Failed to close archive: %v", cErr)
+		}
+	}()
+	loadReport, err := images.Load(ctx, rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load archive %s: %w", archivePath, err)
+	}
+
+	// 3. 태그 복원 및 ID 확인
+	report := &LoadReport{Format: info.format, Compression: info.compression}
+	byID := make(map[string]*LoadedImage)
+	var order []string
+	addName := func(id, name string) {
+		img, ok := byID[id]
+		if !ok {
+			img = &LoadedImage{ID: id}
+			byID[id] = img
+			order = append(order, id)
+		}
+		if name != "" && !utils.Contains(img.Names, name) {
+			img.Names = append(img.Names, name)
+		}
+	}
+
+	for _, name := range loadReport.Names {
+		inspect, err := images.GetImage(ctx, name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect loaded image %q: %w", name, err)
+		}
+		if strings.HasPrefix(name, "sha256:") || strings.HasPrefix(inspect.ID, name) {
+			addName(inspect.ID, "")
+		} else {
+			addName(inspect.ID, name)
+		}
+	}
+
+	// 태그가 있는 이미지가 하나뿐일 때만 빠진 태그를 안전하게 붙일 수 있다.
+	if len(order) == 1 {
+		img := byID[order[0]]
+		for _, tag := range info.tags {
+			normalized, err := normalizeImageName(tag)
+			if err != nil {
+				Log.Warnf("skip invalid tag %q in archive: %v", tag, err)
+				continue
+			}
+			if utils.Contains(img.Names, normalized) {
+				continue
+			}
+			named, _ := reference.ParseNormalizedNamed(normalized)
+			if err := tagAs(ctx, img.ID, named); err != nil {
+				return nil, err
+			}
+			img.Names = append(img.Names, normalized)
+		}
+	}
+
+	for _, id := range order {
+		report.Images = append(report.Images, *byID[id])
+	}
+	return report, nil
+}
+
+// openArchive 는 아카이브 파일을 열고, 압축되어 있으면 해제하는 reader 를 반환한다.
+func openArchive(archivePath string) (io.ReadCloser, string, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open archive %s: %w", archivePath, err)
+	}
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			_ = f.Close()
+			return nil, "", fmt.Errorf("failed to open gzip archive %s: %w", archivePath, err)
+		}
+		return &multiCloser{Reader: gz, closers: []io.Closer{gz, f}}, CompressionGzip, nil
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			_ = f.Close()
+			return nil, "", fmt.Errorf("failed to open zstd archive %s: %w", archivePath, err)
+		}
+		zrc := zr.IOReadCloser()
+		return &multiCloser{Reader: zrc, closers: []io.Closer{zrc, f}}, CompressionZstd, nil
+	default:
+		return &multiCloser{Reader: br, closers: []io.Closer{f}}, CompressionNone, nil
+	}
+}
+
+// verifyArchive 는 아카이브를 한 번 읽으면서 형식을 판별하고, 이름에 digest 가 들어 있는 모든 blob 의 sha256 을 확인한다.
+// manifest.json / index.json 에서 참조하는 파일이 빠져 있어도 에러를 반환한다.
+func verifyArchive(archivePath string) (*archiveInfo, error) {
+	rc, compression, err := openArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cErr := rc.Close(); cErr != nil {
+			Log.Warnf("Failed to close archive: %v", cErr)
+		}
+	}()
+
+	info := &archiveInfo{compression: compression}
+	present := make(map[string]bool)
+	var dockerManifest []byte
+	var ociIndex []byte
+	var ociLayout bool
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar archive: %w", err)
+		}
+		name := strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		present[name] = true
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		switch {
+		case name == "manifest.json":
+			if dockerManifest, err = io.ReadAll(io.LimitReader(tr, 16<<20)); err != nil {
+				return nil, fmt.Errorf("failed to read manifest.json: %w", err)
+			}
+		case name == "index.json":
+			if ociIndex, err = io.ReadAll(io.LimitReader(tr, 16<<20)); err != nil {
+				return nil, fmt.Errorf("failed to read index.json: %w", err)
+			}
+		case name == "oci-layout":
+			ociLayout = true
+		default:
+			var want string
+			if m := ociBlobName.FindStringSubmatch(name); m != nil {
+				want = m[1]
+			} else if m := dockerBlobName.FindStringSubmatch(name); m != nil {
+				want = m[1]
+			}
+			if want == "" {
+				continue
+			}
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			if got := hex.EncodeToString(h.Sum(nil)); got != want {
+				return nil, fmt.Errorf("%s: expected sha256:%s, got sha256:%s: %w", name, want, got, ErrDigestMismatch)
+			}
+		}
+	}
+
+	switch {
+	case dockerManifest != nil:
+		info.format = ArchiveFormatDocker
+		var entries []struct {
+			Config   string
+			RepoTags []string
+			Layers   []string
+		}
+		if err := json.Unmarshal(dockerManifest, &entries); err != nil {
+			return nil, fmt.Errorf("failed to decode manifest.json: %w", err)
+		}
+		for _, e := range entries {
+			for _, ref := range append([]string{e.Config}, e.Layers...) {
+				if !present[path.Clean(ref)] {
+					return nil, fmt.Errorf("manifest.json references missing file %s", ref)
+				}
+			}
+			info.tags = append(info.tags, e.RepoTags...)
+		}
+	case ociLayout && ociIndex != nil:
+		info.format = ArchiveFormatOCI
+		var index struct {
+			Manifests []struct {
+				Digest      string            `json:"digest"`
+				Annotations map[string]string `json:"annotations"`
+			} `json:"manifests"`
+		}
+		if err := json.Unmarshal(ociIndex, &index); err != nil {
+			return nil, fmt.Errorf("failed to decode index.json: %w", err)
+		}
+		for _, m := range index.Manifests {
+			blob := "blobs/" + strings.Replace(m.Digest, ":", "/", 1)
+			if !present[blob] {
+				return nil, fmt.Errorf("index.json references missing blob %s", m.Digest)
+			}
+			// oci-archive 의 ref.name 은 태그만 있을 수도 있으므로 이름 형태일 때만 태그로 사용한다.
+			if ref := m.Annotations["org.opencontainers.image.ref.name"]; strings.Contains(ref, "/") {
+				info.tags = append(info.tags, ref)
+			}
+		}
+	default:
+		return nil, errors.New("unknown archive format: neither docker-archive nor oci-archive")
+	}
+	return info, nil
+}
+
+// multiCloser 는 압축 해제 reader 와 원본 파일을 함께 닫는다.
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *multiCloser) Close() error {
+	var errs []error
+	for _, c := range m.closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
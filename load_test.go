@@ -0,0 +1,146 @@
+package podbridge5
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestArchive 는 entries 를 tar 로 묶어 compression 에 맞게 압축한 파일을 만든다.
+func writeTestArchive(t *testing.T, entries map[string][]byte, compression string) string {
+	t.Helper()
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	for name, data := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	switch compression {
+	case CompressionGzip:
+		gw := gzip.NewWriter(&out)
+		gw.Write(tarBuf.Bytes())
+		gw.Close()
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw.Write(tarBuf.Bytes())
+		zw.Close()
+	default:
+		out = tarBuf
+	}
+	path := filepath.Join(t.TempDir(), "image.tar")
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func dockerArchiveEntries(layer []byte) map[string][]byte {
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	configName := sha256Hex(config) + ".json"
+	layerName := sha256Hex(layer) + ".tar"
+	manifest := []byte(`[{"Config":"` + configName + `","RepoTags":["docker.io/library/tester-internal:latest"],"Layers":["` + layerName + `"]}]`)
+	return map[string][]byte{
+		"manifest.json": manifest,
+		configName:      config,
+		layerName:       layer,
+	}
+}
+
+func TestVerifyArchive_Docker(t *testing.T) {
+	for _, compression := range []string{CompressionNone, CompressionGzip, CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			path := writeTestArchive(t, dockerArchiveEntries([]byte("layer-data")), compression)
+			info, err := verifyArchive(path)
+			if err != nil {
+				t.Fatalf("verifyArchive failed: %v", err)
+			}
+			if info.format != ArchiveFormatDocker {
+				t.Errorf("expected %s, got %s", ArchiveFormatDocker, info.format)
+			}
+			if info.compression != compression {
+				t.Errorf("expected compression %s, got %s", compression, info.compression)
+			}
+			if len(info.tags) != 1 || info.tags[0] != "docker.io/library/tester-internal:latest" {
+				t.Errorf("unexpected tags %v", info.tags)
+			}
+		})
+	}
+}
+
+func TestVerifyArchive_DigestMismatch(t *testing.T) {
+	entries := dockerArchiveEntries([]byte("layer-data"))
+	for name := range entries {
+		if filepath.Ext(name) == ".tar" {
+			entries[name] = []byte("tampered")
+		}
+	}
+	path := writeTestArchive(t, entries, CompressionNone)
+	if _, err := verifyArchive(path); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected ErrDigestMismatch, got %v", err)
+	}
+}
+
+func TestVerifyArchive_MissingLayer(t *testing.T) {
+	entries := dockerArchiveEntries([]byte("layer-data"))
+	for name := range entries {
+		if filepath.Ext(name) == ".tar" {
+			delete(entries, name)
+		}
+	}
+	path := writeTestArchive(t, entries, CompressionNone)
+	if _, err := verifyArchive(path); err == nil {
+		t.Fatal("expected error for missing layer, got nil")
+	}
+}
+
+func TestVerifyArchive_OCI(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2}`)
+	digestHex := sha256Hex(manifest)
+	entries := map[string][]byte{
+		"oci-layout":                []byte(`{"imageLayoutVersion":"1.0.0"}`),
+		"index.json":                []byte(`{"schemaVersion":2,"manifests":[{"digest":"sha256:` + digestHex + `","annotations":{"org.opencontainers.image.ref.name":"docker.io/library/tester:1.0"}}]}`),
+		"blobs/sha256/" + digestHex: manifest,
+	}
+	path := writeTestArchive(t, entries, CompressionGzip)
+	info, err := verifyArchive(path)
+	if err != nil {
+		t.Fatalf("verifyArchive failed: %v", err)
+	}
+	if info.format != ArchiveFormatOCI {
+		t.Errorf("expected %s, got %s", ArchiveFormatOCI, info.format)
+	}
+	if len(info.tags) != 1 || info.tags[0] != "docker.io/library/tester:1.0" {
+		t.Errorf("unexpected tags %v", info.tags)
+	}
+}
+
+func TestVerifyArchive_UnknownFormat(t *testing.T) {
+	path := writeTestArchive(t, map[string][]byte{"hello.txt": []byte("hi")}, CompressionNone)
+	if _, err := verifyArchive(path); err == nil {
+		t.Fatal("expected error for unknown format, got nil")
+	}
+}
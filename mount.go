@@ -9,6 +9,7 @@ import (
 	"golang.org/x/sys/unix"
 	"os"
 	"path"
+	"path/filepath"
 	"syscall"
 )
 
@@ -36,6 +37,35 @@ func WithMount(source, destination, mountType string) ContainerOptions {
 	}
 }
 
+// WithBindMount 호스트의 source(파일 또는 디렉토리)를 컨테이너의 destination 에 bind mount 한다.
+// WithMount 와 달리 readOnly 가 false 면 컨테이너가 쓸 수 있다. (결과를 내보내는 출력 디렉토리 등)
+// 상대 경로 source 는 podman 이 다른 작업 디렉토리에서 해석하지 않도록 현재 디렉토리 기준의 절대 경로로 바꾼다.
+func WithBindMount(source, destination string, readOnly bool) ContainerOptions {
+	return func(spec *specgen.SpecGenerator) error {
+		source, err := filepath.Abs(source)
+		if err != nil {
+			return fmt.Errorf("failed to resolve host path %q: %w", source, err)
+		}
+		if _, err := os.Stat(source); err != nil {
+			return fmt.Errorf("host path %q does not exist: %w", source, err)
+		}
+		if !path.IsAbs(destination) {
+			return fmt.Errorf("container path %q must be an absolute path", destination)
+		}
+		options := []string{"rbind", "rw"}
+		if readOnly {
+			options[1] = "ro"
+		}
+		spec.Mounts = append(spec.Mounts, specgo.Mount{
+			Type:        "bind",
+			Source:      source,
+			Destination: destination,
+			Options:     options,
+		})
+		return nil
+	}
+}
+
 // MountOverlay mounts an OverlayFS at mergedDir, using lowerDir as read-only data
 // and upperDir for writable data, with workDir for internal overlay operations.
 // It handles both root and rootless environments, attempting native overlay in rootless
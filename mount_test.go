@@ -74,3 +74,32 @@ func TestWithMount(t *testing.T) {
 		}
 	})
 }
+
+func TestWithBindMount_RelativeSource(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "out"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	spec := &specgen.SpecGenerator{}
+	if err := WithBindMount("./out", "/out", false)(spec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(spec.Mounts) != 1 {
+		t.Fatalf("expected 1 mount, got %d", len(spec.Mounts))
+	}
+	if want := filepath.Join(dir, "out"); spec.Mounts[0].Source != want {
+		t.Errorf("expected absolute source %q, got %q", want, spec.Mounts[0].Source)
+	}
+	if got := spec.Mounts[0].Options; len(got) != 2 || got[1] != "rw" {
+		t.Errorf("expected rw options, got %v", got)
+	}
+}
@@ -0,0 +1,451 @@
+package podbridge5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/containers/buildah"
+	"github.com/containers/buildah/define"
+	libmanifests "github.com/containers/common/libimage/manifests"
+	cp "github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/archive"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/pkg/compression"
+	is "github.com/containers/image/v5/storage"
+	imageTypes "github.com/containers/image/v5/types"
+	"github.com/containers/podman/v5/pkg/emulation"
+	"github.com/containers/storage"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/seoyhaein/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// registeredEmulators 는 binfmt_misc 에 에뮬레이터가 등록된 플랫폼 목록(예: "linux/arm64")을 반환한다.
+// 테스트에서 바꿀 수 있도록 변수로 둔다.
+var registeredEmulators = emulation.Registered
+
+// PlatformResult 는 멀티 아키텍처 빌드에서 플랫폼 하나의 결과이다.
+type PlatformResult struct {
+	Platform  string        // 예: "linux/arm64"
+	ImageName string        // 플랫폼별 이미지 이름 (예: "tester-internal:latest-linux-arm64")
+	ImageID   string        // 빌드하거나 캐시에서 찾은 이미지 ID
+	Cached    bool          // 지문이 같은 이미지가 있어 빌드를 건너뛰었는지
+	Emulated  bool          // 호스트와 아키텍처가 달라 에뮬레이션으로 명령을 실행했는지
+	Duration  time.Duration // 이 플랫폼의 빌드에 걸린 시간
+	Err       error         // 실패한 경우의 에러
+}
+
+// MultiArchReport 는 CreateMultiArchImage 의 결과이다.
+type MultiArchReport struct {
+	ListName   string           // 매니페스트 리스트 이름 (ImageConfig.ImageName)
+	ListID     string           // 매니페스트 리스트의 로컬 이미지 ID
+	Platforms  []PlatformResult // ImageConfig.Platforms 순서
+	Saved      *SaveReport      // 저장하지 않았으면 nil
+	PushDigest string           // push 한 매니페스트 리스트의 digest. push 하지 않았으면 비어 있다.
+}
+
+// Failed 는 빌드에 실패한 플랫폼들의 결과를 반환한다.
+func (r *MultiArchReport) Failed() []PlatformResult {
+	var failed []PlatformResult
+	for _, res := range r.Platforms {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// MultiArchOptions 는 CreateMultiArchImage 가 만든 매니페스트 리스트를 저장하고 push 하는 설정이다.
+type MultiArchOptions struct {
+	Save     *SaveOptions // nil 이면 ImageSavePath 에 oci-archive 로 저장한다. 여러 플랫폼을 담을 수 있는 oci-archive, oci-dir 만 가능하다.
+	SkipSave bool         // true 면 저장하지 않는다.
+	PushDest string       // 비어 있지 않으면 리스트와 모든 플랫폼의 이미지를 이 이름으로 push 한다.
+	Push     *PushOptions // PushDest 로 push 할 때의 설정
+}
+
+// saveOptions 는 매니페스트 리스트를 저장할 SaveOptions 를 검사하고, 형식의 기본값을 oci-archive 로 채운다.
+func (o *MultiArchOptions) saveOptions() (*SaveOptions, error) {
+	saveOpts := SaveOptions{Format: SaveFormatOCIArchive}
+	if o.Save != nil {
+		saveOpts = *o.Save
+	}
+	switch saveOpts.Format {
+	case "":
+		saveOpts.Format = SaveFormatOCIArchive
+	case SaveFormatOCIArchive, SaveFormatOCIDir:
+	default:
+		return nil, fmt.Errorf("format %q cannot hold a manifest list: use %s or %s", saveOpts.Format, SaveFormatOCIArchive, SaveFormatOCIDir)
+	}
+	if saveOpts.Writer != nil {
+		return nil, errors.New("saving a manifest list to a writer is not supported")
+	}
+	if saveOpts.Encryption != nil {
+		return nil, errors.New("layer encryption is not supported for manifest lists")
+	}
+	switch saveOpts.compression() {
+	case CompressionNone, CompressionGzip, CompressionZstd, CompressionZstdChunked:
+	default:
+		return nil, fmt.Errorf("unknown compression %q", saveOpts.Compression)
+	}
+	return &saveOpts, nil
+}
+
+// CreateMultiArchImage 는 ImageConfig.Platforms 의 각 플랫폼에 대해 CreateImage 와 같은 과정으로 이미지를 빌드하고,
+// 이를 ImageName 이름의 매니페스트 리스트로 묶어 저장하거나 push 한다.
+// 호스트와 아키텍처가 다른 플랫폼은 binfmt_misc 에 에뮬레이터(qemu-user-static)가 등록되어 있어야 명령을 실행할 수 있다.
+// 한 플랫폼이 실패해도 나머지 플랫폼은 계속 빌드하고, 실패한 플랫폼이 있으면 리스트를 만들지 않고 각 플랫폼의 결과와 함께 에러를 반환한다.
+func (config *BuildConfig) CreateMultiArchImage(ctx context.Context, mopts *MultiArchOptions, opts ...ImageBuildOption) (*MultiArchReport, error) {
+	if pbStore == nil {
+		return nil, errors.New("pbStore is nil: call Init before building images")
+	}
+	img := &config.Image
+	if err := img.validatePlatforms(); err != nil {
+		return nil, err
+	}
+	if mopts == nil {
+		mopts = &MultiArchOptions{}
+	}
+	saveOpts, err := mopts.saveOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	o := newImageBuildOptions(opts...)
+	if o.buildDryRun(ctx) != nil {
+		return nil, errBuildDryRunUnsupported
+	}
+	p := newBuildProgress(ctx, img.multiArchSteps(mopts)+len(img.Platforms)*(o.signSteps()+o.vulnCheckSteps()), o)
+	report := &MultiArchReport{ListName: img.ImageName}
+
+	var failed []string
+	for _, platform := range img.Platforms {
+		res := img.buildPlatform(ctx, platform, o, p)
+		if res.Err != nil {
+			Log.Errorf("Failed to build %s for %s: %v", img.ImageName, platform, res.Err)
+			failed = append(failed, platform)
+		}
+		report.Platforms = append(report.Platforms, res)
+	}
+	if len(failed) > 0 {
+		return report, fmt.Errorf("failed to build image %s for %s", img.ImageName, strings.Join(failed, ", "))
+	}
+
+	err = p.step("MANIFEST "+img.ImageName, func(io.Writer, io.Writer) error {
+		var mErr error
+		report.ListID, mErr = createManifestList(ctx, img.ImageName, report.Platforms)
+		return mErr
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to create manifest list: %w", err)
+	}
+
+	if !mopts.SkipSave {
+		err = p.step("SAVE "+img.ImageSavePath, func(io.Writer, io.Writer) error {
+			var sErr error
+			report.Saved, sErr = saveManifestList(ctx, img.ImageSavePath, img.ImageName, saveOpts)
+			return sErr
+		})
+		if err != nil {
+			return report, fmt.Errorf("failed to save manifest list: %w", err)
+		}
+	}
+
+	if !utils.IsEmptyString(mopts.PushDest) {
+		err = p.step("PUSH "+mopts.PushDest, func(io.Writer, io.Writer) error {
+			var pErr error
+			report.PushDigest, pErr = PushManifestList(ctx, img.ImageName, mopts.PushDest, mopts.Push)
+			return pErr
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// validatePlatforms 는 Platforms 가 비어 있지 않고, 올바르며, 중복되지 않는지 확인한다.
+func (img *ImageConfig) validatePlatforms() error {
+	if len(img.Platforms) == 0 {
+		return errors.New("no platforms to build")
+	}
+	seen := make(map[string]bool, len(img.Platforms))
+	for _, platform := range img.Platforms {
+		if _, _, _, err := parsePlatform(platform); err != nil {
+			return err
+		}
+		if seen[platform] {
+			return fmt.Errorf("duplicate platform %q", platform)
+		}
+		seen[platform] = true
+	}
+	return nil
+}
+
+// multiArchSteps 는 CreateMultiArchImage 가 실행하는 단계 수이다.
+// 플랫폼마다 CreateImage 의 단계에서 저장을 뺀 만큼 실행하고, 마지막에 리스트 생성, 저장, push 를 한다.
+func (img *ImageConfig) multiArchSteps(mopts *MultiArchOptions) int {
+	n := len(img.Platforms)*(img.buildSteps()-1) + 1
+	if !mopts.SkipSave {
+		n++
+	}
+	if !utils.IsEmptyString(mopts.PushDest) {
+		n++
+	}
+	return n
+}
+
+// buildPlatform 은 platform 용 베이스 이미지로 빌드해서 플랫폼별 이름으로 커밋한다.
+// 지문이 같은 이미지가 이미 있으면 빌드를 건너뛴다.
+func (img *ImageConfig) buildPlatform(ctx context.Context, platform string, o *imageBuildOptions, p *buildProgress) (res PlatformResult) {
+	start := time.Now()
+	res = PlatformResult{Platform: platform, ImageName: platformImageName(img.ImageName, platform)}
+	defer func() {
+		res.Duration = time.Since(start)
+	}()
+	p.note("Building %s for %s", res.ImageName, platform)
+
+	res.Emulated, res.Err = img.checkEmulation(platform)
+	if res.Err != nil {
+		return res
+	}
+
+	builder, err := newPlatformBuilder(ctx, img.SourceImageName, platform)
+	if err != nil {
+		res.Err = fmt.Errorf("failed to create new builder: %w", err)
+		return res
+	}
+	defer func() {
+		if dErr := builder.Delete(); dErr != nil {
+			Log.Warnf("Failed to delete builder for %s: %v", platform, dErr)
+		}
+	}()
+
+	// 플랫폼별 이미지 이름으로 지문을 확인한다. 베이스 이미지 ID 가 플랫폼마다 다르므로 지문도 다르다.
+	pimg := *img
+	pimg.ImageName = res.ImageName
+	signedBy, err := o.signingIdentity()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	fp, err := pimg.fingerprint(builder.FromImageID, signedBy)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if !o.noCache {
+		cachedID, err := pimg.cachedImage(ctx, fp)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		if cachedID != "" {
+			p.note("Using cached image %s (%s)", res.ImageName, cachedID)
+			res.ImageID, res.Cached = cachedID, true
+			if res.Err = o.checkCachedVulnerabilities(ctx, cachedID, res.ImageName, p); res.Err == nil {
+				res.Err = o.signCachedImage(ctx, cachedID, res.ImageName, p)
+			}
+			return res
+		}
+	}
+
+	if err := o.checkSourceVulnerabilities(builder, p); err != nil {
+		res.Err = err
+		return res
+	}
+	if err := pimg.setup(builder, p); err != nil {
+		res.Err = err
+		return res
+	}
+	if err := o.checkVulnerabilities(builder, res.ImageName, p); err != nil {
+		res.Err = err
+		return res
+	}
+	builder.SetLabel(FingerprintLabel, fp)
+	builder.SetLabel(ImageNameLabel, res.ImageName)
+	sbom, err := pimg.attachSBOM(builder, p)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if res.ImageID, res.Err = commitImage(ctx, builder, res.ImageName, p); res.Err != nil {
+		return res
+	}
+	if res.Err = o.signImage(ctx, res.ImageName, p); res.Err != nil {
+		return res
+	}
+	if pimg.ImageSavePath != "" {
+		res.Err = sbom.save(pimg.ImageSavePath)
+	}
+	return res
+}
+
+// checkEmulation 은 platform 이 호스트와 다른 아키텍처인데 빌드 중에 명령을 실행해야 하면 에뮬레이터가 등록되어 있는지 확인한다.
+func (img *ImageConfig) checkEmulation(platform string) (bool, error) {
+	goos, arch, _, err := parsePlatform(platform)
+	if err != nil {
+		return false, err
+	}
+	if (goos == runtime.GOOS && arch == runtime.GOARCH) || !img.runsCommands() {
+		return false, nil
+	}
+	if utils.Contains(registeredEmulators(), goos+"/"+arch) {
+		return true, nil
+	}
+	return true, fmt.Errorf("no emulator registered for %s: install qemu-user-static or build on a %s node", platform, platform)
+}
+
+// runsCommands 는 빌드 중에 이미지 안에서 명령을 실행하는지 여부이다.
+// 기본 단계와 사용자 생성은 명령을 실행하고, Steps 는 run 단계가 있을 때만 실행한다.
+func (img *ImageConfig) runsCommands() bool {
+	if len(img.Steps) == 0 || img.User != nil {
+		return true
+	}
+	for _, s := range img.Steps {
+		if s.Type == StepRun {
+			return true
+		}
+	}
+	return false
+}
+
+// newPlatformBuilder 는 platform 용 베이스 이미지로 builder 를 만든다. 로컬에 해당 플랫폼의 이미지가 없으면 pull 한다.
+func newPlatformBuilder(ctx context.Context, fromImage, platform string) (*buildah.Builder, error) {
+	goos, arch, variant, err := parsePlatform(platform)
+	if err != nil {
+		return nil, err
+	}
+	sysCtx := systemContext()
+	sysCtx.OSChoice = goos
+	sysCtx.ArchitectureChoice = arch
+	sysCtx.VariantChoice = variant
+	return newBuilderWithSystemContext(ctx, pbStore, fromImage, sysCtx)
+}
+
+// platformImageName 은 플랫폼별 이미지 이름이다. 태그 뒤에 플랫폼을 붙인다. (예: "tester:latest" -> "tester:latest-linux-arm64")
+func platformImageName(name, platform string) string {
+	suffix := strings.ReplaceAll(platform, "/", "-")
+	if strings.LastIndex(name, ":") > strings.LastIndex(name, "/") {
+		return name + "-" + suffix
+	}
+	return name + ":" + suffix
+}
+
+// parsePlatform 은 "os/arch[/variant]" 형식의 플랫폼을 나눈다.
+func parsePlatform(platform string) (goos, arch, variant string, err error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("invalid platform %q: expected os/arch[/variant]", platform)
+	}
+	if len(parts) == 3 {
+		variant = parts[2]
+	}
+	return parts[0], parts[1], variant, nil
+}
+
+// createManifestList 는 플랫폼별 이미지들을 name 이름의 매니페스트 리스트로 로컬 스토리지에 저장한다.
+// 같은 이름의 이미지나 리스트가 있으면 이름을 새 리스트로 옮긴다.
+func createManifestList(ctx context.Context, name string, results []PlatformResult) (string, error) {
+	sysCtx := systemContext()
+	list := libmanifests.Create()
+	for _, res := range results {
+		ref, err := is.Transport.ParseStoreReference(pbStore, res.ImageName)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse image reference %s: %w", res.ImageName, err)
+		}
+		if _, err := list.Add(ctx, sysCtx, ref, false); err != nil {
+			return "", fmt.Errorf("failed to add %s to manifest list: %w", res.ImageName, err)
+		}
+	}
+	return list.SaveToImage(pbStore, "", []string{name}, manifest.DockerV2ListMediaType)
+}
+
+// saveManifestList 는 매니페스트 리스트와 모든 플랫폼의 이미지를 dir 아래 하나의 oci-archive 또는 oci 디렉토리로 저장한다.
+func saveManifestList(ctx context.Context, dir, name string, opts *SaveOptions) (*SaveReport, error) {
+	if utils.IsEmptyString(dir) {
+		return nil, errors.New("save directory cannot be empty")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	archivePath := filepath.Join(dir, opts.fileName(name))
+	transport := archive.Transport.Name()
+	if opts.format() == SaveFormatOCIDir {
+		transport = layout.Transport.Name()
+	}
+	ref, err := ociReference(transport, archivePath, name)
+	if err != nil {
+		return nil, err
+	}
+
+	_, list, err := libmanifests.LoadFromImage(pbStore, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load manifest list %s: %w", name, err)
+	}
+	sysCtx := systemContext()
+	pushOpts := libmanifests.PushOptions{
+		Store:              pbStore,
+		SystemContext:      sysCtx,
+		ImageListSelection: cp.CopyAllImages,
+		ManifestType:       v1.MediaTypeImageIndex,
+	}
+	if err := setLayerCompression(sysCtx, opts); err != nil {
+		return nil, err
+	}
+	pushOpts.ForceCompressionFormat = sysCtx.CompressionFormat != nil
+	if _, _, err := list.Push(ctx, ref, pushOpts); err != nil {
+		return nil, fmt.Errorf("failed to save manifest list %s as %s: %w", name, transport, err)
+	}
+	if transport == layout.Transport.Name() {
+		return &SaveReport{Path: archivePath}, nil
+	}
+
+	sum, err := pathDigest(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", archivePath, err)
+	}
+	st, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Checksum {
+		if err := writeChecksumFile(archivePath, sum); err != nil {
+			return nil, err
+		}
+	}
+	return &SaveReport{Path: archivePath, Digest: sum, Size: st.Size()}, nil
+}
+
+// setLayerCompression 은 opts 의 압축 설정을 sysCtx 에 적용한다. 압축하지 않으면 바꾸지 않는다.
+func setLayerCompression(sysCtx *imageTypes.SystemContext, opts *SaveOptions) error {
+	comp := opts.compression()
+	if comp == CompressionNone {
+		return nil
+	}
+	algo, err := compression.AlgorithmByName(comp)
+	if err != nil {
+		return fmt.Errorf("unknown compression %q: %w", comp, err)
+	}
+	sysCtx.CompressionFormat = &algo
+	sysCtx.CompressionLevel = opts.CompressionLevel
+	return nil
+}
+
+// newBuilderWithSystemContext 는 newBuilder 와 같지만 이미지를 가져올 때 사용할 sysCtx 를 받는다.
+func newBuilderWithSystemContext(ctx context.Context, store storage.Store, idName string, sysCtx *imageTypes.SystemContext) (*buildah.Builder, error) {
+	return NewBuilder(ctx, store,
+		WithFromImage(idName),
+		WithPullPolicy(builderPullPolicy()),
+		WithIsolation(define.IsolationOCI),
+		WithCommonBuildOptions(nil),
+		WithSystemContext(sysCtx),
+		WithNetworkConfiguration(buildah.NetworkDefault),
+		WithFormat(buildah.Dockerv2ImageManifest),
+		WithCapabilities())
+}
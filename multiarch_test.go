@@ -0,0 +1,127 @@
+package podbridge5
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParsePlatform(t *testing.T) {
+	goos, arch, variant, err := parsePlatform("linux/arm64/v8")
+	if err != nil || goos != "linux" || arch != "arm64" || variant != "v8" {
+		t.Fatalf("unexpected result %q %q %q (%v)", goos, arch, variant, err)
+	}
+	for _, bad := range []string{"", "linux", "linux/", "/amd64", "linux/arm/v7/extra"} {
+		if _, _, _, err := parsePlatform(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestPlatformImageName(t *testing.T) {
+	tests := map[string]string{
+		"tester-internal:latest":               "tester-internal:latest-linux-arm64",
+		"tester-internal":                      "tester-internal:linux-arm64",
+		"registry.internal:5000/team/tester":   "registry.internal:5000/team/tester:linux-arm64",
+		"registry.internal:5000/team/tester:1": "registry.internal:5000/team/tester:1-linux-arm64",
+	}
+	for name, want := range tests {
+		if got := platformImageName(name, "linux/arm64"); got != want {
+			t.Errorf("platformImageName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestImageConfigValidatePlatforms(t *testing.T) {
+	img := &ImageConfig{}
+	if err := img.validatePlatforms(); err == nil {
+		t.Error("expected error without platforms")
+	}
+	img.Platforms = []string{"linux/amd64", "linux/amd64"}
+	if err := img.validatePlatforms(); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("expected duplicate platform error, got %v", err)
+	}
+	img.Platforms = []string{"linux/amd64", "linux/arm64"}
+	if err := img.validatePlatforms(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestImageConfigCheckEmulation(t *testing.T) {
+	orig := registeredEmulators
+	t.Cleanup(func() { registeredEmulators = orig })
+
+	foreign := "linux/arm64"
+	if runtime.GOARCH == "arm64" {
+		foreign = "linux/amd64"
+	}
+	img := NewConfig("docker.io/library/alpine:latest").Image
+
+	if emulated, err := img.checkEmulation(runtime.GOOS + "/" + runtime.GOARCH); emulated || err != nil {
+		t.Errorf("expected native build, got emulated=%v err=%v", emulated, err)
+	}
+
+	registeredEmulators = func() []string { return nil }
+	if _, err := img.checkEmulation(foreign); err == nil {
+		t.Error("expected error without a registered emulator")
+	}
+
+	registeredEmulators = func() []string { return []string{foreign} }
+	if emulated, err := img.checkEmulation(foreign); !emulated || err != nil {
+		t.Errorf("expected emulated build, got emulated=%v err=%v", emulated, err)
+	}
+
+	// run 단계가 없으면 에뮬레이터 없이도 빌드할 수 있다.
+	registeredEmulators = func() []string { return nil }
+	img.Steps = []BuildStep{{Type: StepCopy, Src: []string{"./executor.sh"}, Dest: "/app/"}}
+	if emulated, err := img.checkEmulation(foreign); emulated || err != nil {
+		t.Errorf("expected copy-only build without emulation, got emulated=%v err=%v", emulated, err)
+	}
+}
+
+func TestMultiArchOptionsSaveOptions(t *testing.T) {
+	opts, err := (&MultiArchOptions{}).saveOptions()
+	if err != nil || opts.Format != SaveFormatOCIArchive {
+		t.Fatalf("expected oci-archive by default, got %+v (%v)", opts, err)
+	}
+	opts, err = (&MultiArchOptions{Save: &SaveOptions{Compression: CompressionZstd}}).saveOptions()
+	if err != nil || opts.Format != SaveFormatOCIArchive || opts.Compression != CompressionZstd {
+		t.Fatalf("unexpected options %+v (%v)", opts, err)
+	}
+	if _, err := (&MultiArchOptions{Save: &SaveOptions{Format: SaveFormatDockerArchive}}).saveOptions(); err == nil {
+		t.Error("expected error for docker-archive")
+	}
+	if _, err := (&MultiArchOptions{Save: &SaveOptions{Writer: &strings.Builder{}}}).saveOptions(); err == nil {
+		t.Error("expected error for writer")
+	}
+	if _, err := (&MultiArchOptions{Save: &SaveOptions{Compression: "lz4"}}).saveOptions(); err == nil {
+		t.Error("expected error for unknown compression")
+	}
+	if _, err := (&MultiArchOptions{Save: &SaveOptions{Encryption: &ImageEncryption{}}}).saveOptions(); err == nil {
+		t.Error("expected error for encryption")
+	}
+}
+
+func TestImageConfigMultiArchSteps(t *testing.T) {
+	img := NewConfig("docker.io/library/alpine:latest").Image
+	img.Platforms = []string{"linux/amd64", "linux/arm64"}
+	perPlatform := img.buildSteps() - 1
+	if got := img.multiArchSteps(&MultiArchOptions{}); got != 2*perPlatform+2 {
+		t.Errorf("expected %d steps, got %d", 2*perPlatform+2, got)
+	}
+	if got := img.multiArchSteps(&MultiArchOptions{SkipSave: true, PushDest: "registry.internal/tester"}); got != 2*perPlatform+2 {
+		t.Errorf("expected %d steps, got %d", 2*perPlatform+2, got)
+	}
+}
+
+func TestMultiArchReportFailed(t *testing.T) {
+	r := &MultiArchReport{Platforms: []PlatformResult{
+		{Platform: "linux/amd64", ImageID: "sha256:a"},
+		{Platform: "linux/arm64", Err: errors.New("no emulator")},
+	}}
+	failed := r.Failed()
+	if len(failed) != 1 || failed[0].Platform != "linux/arm64" {
+		t.Errorf("unexpected failed platforms %+v", failed)
+	}
+}
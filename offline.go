@@ -0,0 +1,299 @@
+package podbridge5
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/containers/buildah/define"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/seoyhaein/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// helperImage 는 볼륨 읽기/쓰기와 init container 에서 사용하는 보조 이미지이다.
+// 오프라인 번들을 만들 때 항상 포함된다.
+const helperImage = "docker.io/library/alpine:latest"
+
+// bundleManifestFileName 은 PrepareOfflineBundle 이 번들 디렉토리에 작성하는 목록 파일이다.
+const bundleManifestFileName = "bundle.json"
+
+var (
+	ErrImageNotInBundle = errors.New("image not found in offline bundle")
+
+	// offlineMu 는 오프라인 모드 설정을 보호한다. pbBundleDir 가 비어 있지 않으면 오프라인 모드이다.
+	offlineMu   sync.RWMutex
+	pbBundleDir string
+)
+
+// BundleManifest 는 오프라인 번들에 들어 있는 이미지 아카이브 목록이다.
+type BundleManifest struct {
+	Images []BundleImage `json:"images"`
+}
+
+// BundleImage 는 번들 안의 이미지 아카이브 하나를 나타낸다.
+type BundleImage struct {
+	Name   string `json:"name"`   // 예: "docker.io/library/alpine:latest"
+	ID     string `json:"id"`     // 이미지 ID
+	File   string `json:"file"`   // 번들 디렉토리 기준 아카이브 파일 이름
+	Digest string `json:"digest"` // 아카이브 파일의 sha256 digest
+}
+
+// EnableOfflineMode 는 폐쇄망용 오프라인 모드를 켠다.
+// 오프라인 모드에서는 images.Pull 을 호출하지 않고, 필요한 이미지를 bundleDir 의 아카이브(saveImage 결과물)에서 로드한다.
+// 빌드 역시 pull 하지 않도록 PullNever 정책을 사용한다.
+func EnableOfflineMode(bundleDir string) error {
+	if utils.IsEmptyString(bundleDir) {
+		return errors.New("bundle directory cannot be empty")
+	}
+	st, err := os.Stat(bundleDir)
+	if err != nil {
+		return fmt.Errorf("failed to stat bundle directory: %w", err)
+	}
+	if !st.IsDir() {
+		return fmt.Errorf("bundle path is not a directory: %s", bundleDir)
+	}
+	offlineMu.Lock()
+	pbBundleDir = bundleDir
+	offlineMu.Unlock()
+	return nil
+}
+
+// DisableOfflineMode 는 오프라인 모드를 끈다.
+func DisableOfflineMode() {
+	offlineMu.Lock()
+	pbBundleDir = ""
+	offlineMu.Unlock()
+}
+
+// IsOfflineMode 는 오프라인 모드 여부를 반환한다.
+func IsOfflineMode() bool {
+	return offlineBundleDir() != ""
+}
+
+func offlineBundleDir() string {
+	offlineMu.RLock()
+	defer offlineMu.RUnlock()
+	return pbBundleDir
+}
+
+// builderPullPolicy 는 오프라인 모드이면 PullNever, 아니면 PullIfMissing 을 반환한다.
+func builderPullPolicy() define.PullPolicy {
+	if IsOfflineMode() {
+		return define.PullNever
+	}
+	return define.PullIfMissing
+}
+
+// ensureImage 는 이미지가 로컬에 없으면 온라인 모드에서는 pull 하고, 오프라인 모드에서는 번들에서 로드한다.
+func ensureImage(ctx context.Context, image string) error {
+	exists, err := images.Exists(ctx, image, nil)
+	if err != nil {
+		return fmt.Errorf("failed to check if image %q exists: %w", image, err)
+	}
+	if exists {
+		return nil
+	}
+	if dir := offlineBundleDir(); dir != "" {
+		Log.Infof("Loading %s image from offline bundle %s...", image, dir)
+		if err := loadFromBundle(ctx, dir, image); err != nil {
+			return fmt.Errorf("failed to load image %q from bundle: %w", image, err)
+		}
+		return nil
+	}
+	Log.Infof("Pulling %s image...", image)
+	if err := pullImage(ctx, image); err != nil {
+		return fmt.Errorf("failed to pull image %q: %w", image, err)
+	}
+	return nil
+}
+
+// PrepareOfflineBundle 은 파이프라인에 필요한 이미지들을 bundleDir 에 아카이브로 내보내고 bundle.json 을 작성한다.
+// helperImage 는 항상 포함된다. 인터넷이 되는 쪽에서 실행한 뒤, bundleDir 을 폐쇄망으로 옮겨 EnableOfflineMode 에 지정하면 된다.
+func PrepareOfflineBundle(ctx context.Context, bundleDir string, imageNames []string, compress bool) (*BundleManifest, error) {
+	if utils.IsEmptyString(bundleDir) {
+		return nil, errors.New("bundle directory cannot be empty")
+	}
+	if err := os.MkdirAll(bundleDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", bundleDir, err)
+	}
+
+	names := append([]string{helperImage}, imageNames...)
+	manifest := &BundleManifest{}
+	seen := make(map[string]bool)
+	files := make(map[string]string)
+	for _, name := range names {
+		if utils.IsEmptyString(name) {
+			continue
+		}
+		normalized, err := normalizeImageName(name)
+		if err != nil {
+			return nil, err
+		}
+		if seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+
+		fileName := archiveFileName(normalized, compress)
+		if other, ok := files[fileName]; ok {
+			return nil, fmt.Errorf("images %q and %q map to the same archive file %s", other, normalized, fileName)
+		}
+		files[fileName] = normalized
+
+		if err := ensureImage(ctx, normalized); err != nil {
+			return nil, err
+		}
+		report, err := images.GetImage(ctx, normalized, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect image %q: %w", normalized, err)
+		}
+		// 이름으로 export 해야 아카이브에 태그가 남는다.
+		if err := saveImage(ctx, bundleDir, normalized, normalized, compress); err != nil {
+			return nil, fmt.Errorf("failed to save image %q: %w", normalized, err)
+		}
+		sum, err := fileDigest(filepath.Join(bundleDir, fileName))
+		if err != nil {
+			return nil, err
+		}
+		manifest.Images = append(manifest.Images, BundleImage{
+			Name:   normalized,
+			ID:     report.ID,
+			File:   fileName,
+			Digest: sum,
+		})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(bundleDir, bundleManifestFileName), data, 0o644); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// RequiredImages 는 이 BuildConfig 로 파이프라인을 돌릴 때 필요한 이미지 목록을 반환한다. PrepareOfflineBundle 에 그대로 넘길 수 있다.
+func (config *BuildConfig) RequiredImages() []string {
+	var names []string
+	for _, name := range []string{config.Image.SourceImageName, config.Image.ImageName} {
+		if !utils.IsEmptyString(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ReadBundleManifest 는 bundleDir 의 bundle.json 을 읽는다.
+func ReadBundleManifest(bundleDir string) (*BundleManifest, error) {
+	data, err := os.ReadFile(filepath.Join(bundleDir, bundleManifestFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle manifest: %w", err)
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode bundle manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// findInBundle 은 bundle.json 에서 이미지를 찾고, 목록이 없거나 목록에 없으면 saveImage 의 파일 이름 규칙으로 찾는다.
+func findInBundle(bundleDir, image string) (*BundleImage, error) {
+	normalized, err := normalizeImageName(image)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := ReadBundleManifest(bundleDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if manifest != nil {
+		for _, entry := range manifest.Images {
+			if n, nErr := normalizeImageName(entry.Name); nErr == nil && n == normalized {
+				e := entry
+				return &e, nil
+			}
+		}
+	}
+
+	// CreateImage 가 saveImage 로 남긴 아카이브는 목록 없이 파일 이름으로만 찾을 수 있다.
+	for _, compress := range []bool{false, true} {
+		fileName := archiveFileName(normalized, compress)
+		if exists, _, _ := utils.FileExists(filepath.Join(bundleDir, fileName)); exists {
+			return &BundleImage{Name: normalized, File: fileName}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", normalized, ErrImageNotInBundle)
+}
+
+// loadFromBundle 은 번들에서 이미지 아카이브를 찾아 로드하고, 필요하면 요청한 이름으로 태그를 붙인다.
+func loadFromBundle(ctx context.Context, bundleDir, image string) error {
+	entry, err := findInBundle(bundleDir, image)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(bundleDir, entry.File)
+	if entry.Digest != "" {
+		sum, err := fileDigest(path)
+		if err != nil {
+			return err
+		}
+		if sum != entry.Digest {
+			return fmt.Errorf("digest mismatch for %s: expected %s, got %s", path, entry.Digest, sum)
+		}
+	}
+
+	report, err := LoadImage(ctx, path)
+	if err != nil {
+		return err
+	}
+	if len(report.Images) == 0 {
+		return fmt.Errorf("no image loaded from %s", path)
+	}
+	for _, img := range report.Images {
+		if utils.Contains(img.Names, entry.Name) {
+			return nil
+		}
+	}
+	// ID 로 export 된 아카이브는 태그가 없으므로 요청한 이름을 붙여준다.
+	named, err := reference.ParseNormalizedNamed(entry.Name)
+	if err != nil {
+		return fmt.Errorf("failed to parse image reference %q: %w", entry.Name, err)
+	}
+	return tagAs(ctx, report.Images[0].ID, reference.TagNameOnly(named))
+}
+
+// normalizeImageName 은 "alpine" 같은 짧은 이름을 "docker.io/library/alpine:latest" 형태로 바꾼다.
+func normalizeImageName(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference %q: %w", image, err)
+	}
+	return reference.TagNameOnly(named).String(), nil
+}
+
+// fileDigest 는 파일의 sha256 digest 를 "sha256:<hex>" 형태로 반환한다.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer func() {
+		if cErr := f.Close(); cErr != nil {
+			Log.Warnf("Failed to close file: %v", cErr)
+		}
+	}()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,92 @@
+package podbridge5
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/containers/buildah/define"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOfflineModeToggle(t *testing.T) {
+	t.Cleanup(DisableOfflineMode)
+
+	if err := EnableOfflineMode(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("expected error for missing bundle directory")
+	}
+	if IsOfflineMode() {
+		t.Fatal("offline mode must stay disabled after failed enable")
+	}
+	if builderPullPolicy() != define.PullIfMissing {
+		t.Errorf("expected PullIfMissing when online, got %v", builderPullPolicy())
+	}
+
+	if err := EnableOfflineMode(t.TempDir()); err != nil {
+		t.Fatalf("EnableOfflineMode failed: %v", err)
+	}
+	if !IsOfflineMode() {
+		t.Fatal("expected offline mode to be enabled")
+	}
+	if builderPullPolicy() != define.PullNever {
+		t.Errorf("expected PullNever when offline, got %v", builderPullPolicy())
+	}
+
+	DisableOfflineMode()
+	if IsOfflineMode() {
+		t.Fatal("expected offline mode to be disabled")
+	}
+}
+
+func TestFindInBundle(t *testing.T) {
+	dir := t.TempDir()
+
+	// bundle.json 없이 saveImage 파일 이름 규칙으로 찾는다.
+	legacy := archiveFileName("docker.io/library/tester-internal:latest", false)
+	if err := os.WriteFile(filepath.Join(dir, legacy), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := findInBundle(dir, "tester-internal")
+	if err != nil {
+		t.Fatalf("findInBundle by file name failed: %v", err)
+	}
+	if entry.File != legacy || entry.Digest != "" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	// bundle.json 이 있으면 목록을 우선 사용한다.
+	manifest := BundleManifest{Images: []BundleImage{{
+		Name:   "docker.io/library/alpine:latest",
+		File:   "alpine-latest.tar",
+		Digest: "sha256:abc",
+	}}}
+	data, _ := json.Marshal(manifest)
+	if err := os.WriteFile(filepath.Join(dir, bundleManifestFileName), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = findInBundle(dir, "alpine")
+	if err != nil {
+		t.Fatalf("findInBundle by manifest failed: %v", err)
+	}
+	if entry.Digest != "sha256:abc" {
+		t.Errorf("expected manifest entry, got %+v", entry)
+	}
+
+	if _, err := findInBundle(dir, "busybox:1.36"); !errors.Is(err, ErrImageNotInBundle) {
+		t.Errorf("expected ErrImageNotInBundle, got %v", err)
+	}
+}
+
+func TestRequiredImages(t *testing.T) {
+	config := NewConfig("docker.io/library/alpine:latest")
+	got := config.RequiredImages()
+	want := []string{"docker.io/library/alpine:latest", "docker.io/library/alpine-internal:latest"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %q at %d, got %q", want[i], i, got[i])
+		}
+	}
+}
@@ -0,0 +1,676 @@
+package podbridge5
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// osvAdvisory 는 OSV(https://ossf.github.io/osv-schema/) 형식의 권고 하나이다. 검사에 필요한 필드만 읽는다.
+type osvAdvisory struct {
+	ID               string         `json:"id"`
+	Aliases          []string       `json:"aliases"`
+	Summary          string         `json:"summary"`
+	Withdrawn        string         `json:"withdrawn"`
+	Severity         []osvSeverity  `json:"severity"`
+	Affected         []osvAffected  `json:"affected"`
+	DatabaseSpecific map[string]any `json:"database_specific"`
+}
+
+type osvSeverity struct {
+	Type  string `json:"type"`  // CVSS_V3, CVSS_V4 등
+	Score string `json:"score"` // CVSS 벡터
+}
+
+type osvAffected struct {
+	Package           osvPackage     `json:"package"`
+	Ranges            []osvRange     `json:"ranges"`
+	Versions          []string       `json:"versions"`
+	Severity          []osvSeverity  `json:"severity"`
+	EcosystemSpecific map[string]any `json:"ecosystem_specific"`
+	DatabaseSpecific  map[string]any `json:"database_specific"`
+}
+
+type osvPackage struct {
+	Ecosystem string `json:"ecosystem"` // 예: "Alpine:v3.20", "Debian:12"
+	Name      string `json:"name"`
+}
+
+type osvRange struct {
+	Type   string     `json:"type"` // 배포판 패키지는 ECOSYSTEM
+	Events []osvEvent `json:"events"`
+}
+
+type osvEvent struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+	Limit        string `json:"limit,omitempty"`
+}
+
+func (e osvEvent) version() string {
+	switch {
+	case e.Introduced != "":
+		return e.Introduced
+	case e.Fixed != "":
+		return e.Fixed
+	case e.LastAffected != "":
+		return e.LastAffected
+	}
+	return e.Limit
+}
+
+// osvEcosystems 는 OSV ecosystem 이름과 패키지 종류, os-release ID 의 대응이다.
+var osvEcosystems = map[string]struct{ pkgType, osID string }{
+	"Alpine":      {"apk", "alpine"},
+	"Debian":      {"deb", "debian"},
+	"Ubuntu":      {"deb", "ubuntu"},
+	"AlmaLinux":   {"rpm", "almalinux"},
+	"Rocky Linux": {"rpm", "rocky"},
+	"Red Hat":     {"rpm", "rhel"},
+}
+
+// versionComparators 는 패키지 종류별 버전 비교 함수이다.
+var versionComparators = map[string]func(a, b string) int{
+	"apk": compareApkVersion,
+	"deb": compareDebVersion,
+	"rpm": compareRPMVersion,
+}
+
+// readAdvisories 는 path 의 OSV 권고를 하나씩 fn 으로 넘긴다.
+// path 는 OSV JSON 파일(권고 하나 또는 배열), JSON 파일들이 있는 디렉토리, 또는 OSV 가 배포하는 ecosystem 덤프(all.zip)일 수 있다.
+// 덤프 전체를 메모리에 올리지 않도록 파일 단위로 읽는다.
+func readAdvisories(path string, fn func(*osvAdvisory)) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat advisory database: %w", err)
+	}
+	switch {
+	case st.IsDir():
+		return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || filepath.Ext(p) != ".json" {
+				return nil
+			}
+			return readAdvisoryFile(p, fn)
+		})
+	case filepath.Ext(path) == ".zip":
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return fmt.Errorf("failed to open advisory database %s: %w", path, err)
+		}
+		defer func() { _ = zr.Close() }()
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() || filepath.Ext(f.Name) != ".json" {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("failed to open %s in %s: %w", f.Name, path, err)
+			}
+			err = decodeAdvisories(rc, f.Name, fn)
+			_ = rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return readAdvisoryFile(path, fn)
+}
+
+func readAdvisoryFile(path string, fn func(*osvAdvisory)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open advisory file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	return decodeAdvisories(f, path, fn)
+}
+
+// decodeAdvisories 는 권고 하나 또는 권고 배열로 된 JSON 을 읽는다.
+func decodeAdvisories(r io.Reader, name string, fn func(*osvAdvisory)) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read advisory file %s: %w", name, err)
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var advs []*osvAdvisory
+		if err := json.Unmarshal(data, &advs); err != nil {
+			return fmt.Errorf("invalid advisory file %s: %w", name, err)
+		}
+		for _, adv := range advs {
+			fn(adv)
+		}
+		return nil
+	}
+	var adv osvAdvisory
+	if err := json.Unmarshal(data, &adv); err != nil {
+		return fmt.Errorf("invalid advisory file %s: %w", name, err)
+	}
+	fn(&adv)
+	return nil
+}
+
+// ecosystemPackageType 은 OSV ecosystem 이 이미지의 배포판(os-release)에 해당하면 패키지 종류를 반환한다.
+// os-release 가 없으면 패키지 종류만으로 대응시킨다. Red Hat 의 ecosystem 은 제품 이름을 담고 있어 릴리스는 비교하지 않는다.
+func ecosystemPackageType(ecosystem string, osRelease map[string]string) (string, bool) {
+	name, release, _ := strings.Cut(ecosystem, ":")
+	eco, ok := osvEcosystems[name]
+	if !ok {
+		return "", false
+	}
+	if id := osRelease["ID"]; id != "" && id != eco.osID {
+		return "", false
+	}
+	version := osRelease["VERSION_ID"]
+	if release == "" || version == "" || name == "Red Hat" {
+		return eco.pkgType, true
+	}
+	// "Ubuntu:22.04:LTS" 처럼 뒤에 붙는 값은 무시한다
+	release, _, _ = strings.Cut(release, ":")
+	parts := strings.Split(version, ".")
+	switch name {
+	case "Alpine":
+		if len(parts) < 2 || release != "v"+parts[0]+"."+parts[1] {
+			return "", false
+		}
+	default:
+		if release != version && release != parts[0] {
+			return "", false
+		}
+	}
+	return eco.pkgType, true
+}
+
+// affects 는 version 이 영향을 받는 버전이면 true 와 (알 수 있으면) 수정된 버전을 반환한다.
+func (a *osvAffected) affects(version string, cmp func(a, b string) int) (bool, string) {
+	for _, v := range a.Versions {
+		if v == version {
+			return true, ""
+		}
+	}
+	for _, r := range a.Ranges {
+		if r.Type != "ECOSYSTEM" {
+			continue
+		}
+		if affected, fixed := r.affects(version, cmp); affected {
+			return true, fixed
+		}
+	}
+	return false, ""
+}
+
+// affects 는 이벤트를 버전 순으로 정렬한 뒤 version 보다 작거나 같은 마지막 이벤트로 영향 여부를 정한다.
+func (r osvRange) affects(version string, cmp func(a, b string) int) (bool, string) {
+	events := append([]osvEvent(nil), r.Events...)
+	sort.SliceStable(events, func(i, j int) bool {
+		vi, vj := events[i].version(), events[j].version()
+		if vi == "0" || vj == "0" {
+			return vi == "0" && vj != "0"
+		}
+		return cmp(vi, vj) < 0
+	})
+
+	affected := false
+	for _, e := range events {
+		switch {
+		case e.Introduced != "":
+			if e.Introduced != "0" && cmp(version, e.Introduced) < 0 {
+				return affected, ""
+			}
+			affected = true
+		case e.Fixed != "":
+			if cmp(version, e.Fixed) < 0 {
+				if affected {
+					return true, e.Fixed
+				}
+				return false, ""
+			}
+			affected = false
+		case e.LastAffected != "":
+			if cmp(version, e.LastAffected) <= 0 {
+				return affected, ""
+			}
+			affected = false
+		case e.Limit != "":
+			if cmp(version, e.Limit) < 0 {
+				return affected, ""
+			}
+			affected = false
+		}
+	}
+	return affected, ""
+}
+
+// severity 는 권고의 심각도를 정한다. 배포판이 매긴 등급을 먼저 사용하고, 없으면 CVSS v3 벡터로 계산한다.
+func (adv *osvAdvisory) severity(aff *osvAffected) string {
+	for _, m := range []map[string]any{aff.EcosystemSpecific, aff.DatabaseSpecific, adv.DatabaseSpecific} {
+		for _, key := range []string{"severity", "urgency"} {
+			if s, ok := m[key].(string); ok {
+				if sev := normalizeSeverity(s); sev != "" {
+					return sev
+				}
+			}
+		}
+	}
+	for _, list := range [][]osvSeverity{aff.Severity, adv.Severity} {
+		for _, s := range list {
+			if s.Type != "CVSS_V3" {
+				continue
+			}
+			if score, err := cvss3BaseScore(s.Score); err == nil {
+				return cvssSeverity(score)
+			}
+		}
+	}
+	return SeverityUnknown
+}
+
+// normalizeSeverity 는 배포판마다 다른 등급 이름을 low, medium, high, critical 로 맞춘다.
+func normalizeSeverity(s string) string {
+	switch strings.ToLower(strings.TrimRight(strings.TrimSpace(s), "*")) {
+	case "critical":
+		return SeverityCritical
+	case "high", "important":
+		return SeverityHigh
+	case "medium", "moderate":
+		return SeverityMedium
+	case "low", "negligible", "unimportant":
+		return SeverityLow
+	}
+	return ""
+}
+
+func cvssSeverity(score float64) string {
+	switch {
+	case score >= 9.0:
+		return SeverityCritical
+	case score >= 7.0:
+		return SeverityHigh
+	case score >= 4.0:
+		return SeverityMedium
+	}
+	return SeverityLow
+}
+
+// cvss3Weights 는 CVSS v3.x 기본 지표의 값이다. PR 은 Scope 에 따라 달라지므로 따로 계산한다.
+var cvss3Weights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"UI": {"N": 0.85, "R": 0.62},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+// cvss3BaseScore 는 CVSS v3.0/v3.1 벡터(예: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H")의 기본 점수를 계산한다.
+func cvss3BaseScore(vector string) (float64, error) {
+	parts := strings.Split(vector, "/")
+	if len(parts) < 9 || !strings.HasPrefix(parts[0], "CVSS:3.") {
+		return 0, fmt.Errorf("invalid CVSS v3 vector %q", vector)
+	}
+	metrics := make(map[string]string)
+	for _, part := range parts[1:] {
+		k, v, ok := strings.Cut(part, ":")
+		if !ok {
+			return 0, fmt.Errorf("invalid CVSS v3 vector %q", vector)
+		}
+		metrics[k] = v
+	}
+	w := make(map[string]float64)
+	for metric, values := range cvss3Weights {
+		value, ok := values[metrics[metric]]
+		if !ok {
+			return 0, fmt.Errorf("invalid CVSS v3 metric %s in %q", metric, vector)
+		}
+		w[metric] = value
+	}
+	changed := metrics["S"] == "C"
+	if !changed && metrics["S"] != "U" {
+		return 0, fmt.Errorf("invalid CVSS v3 metric S in %q", vector)
+	}
+	switch metrics["PR"] {
+	case "N":
+		w["PR"] = 0.85
+	case "L":
+		w["PR"] = 0.62
+		if changed {
+			w["PR"] = 0.68
+		}
+	case "H":
+		w["PR"] = 0.27
+		if changed {
+			w["PR"] = 0.5
+		}
+	default:
+		return 0, fmt.Errorf("invalid CVSS v3 metric PR in %q", vector)
+	}
+
+	iss := 1 - (1-w["C"])*(1-w["I"])*(1-w["A"])
+	impact := 6.42 * iss
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	if impact <= 0 {
+		return 0, nil
+	}
+	exploitability := 8.22 * w["AV"] * w["AC"] * w["PR"] * w["UI"]
+	if changed {
+		return cvssRoundUp(math.Min(1.08*(impact+exploitability), 10)), nil
+	}
+	return cvssRoundUp(math.Min(impact+exploitability, 10)), nil
+}
+
+// cvssRoundUp 은 CVSS v3.1 명세의 Roundup 함수이다. (소수점 첫째 자리로 올림)
+func cvssRoundUp(x float64) float64 {
+	i := int64(math.Round(x * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return float64(i/10000+1) / 10
+}
+
+// compareDebVersion 은 dpkg 의 규칙으로 "[epoch:]upstream[-revision]" 버전을 비교한다.
+func compareDebVersion(a, b string) int {
+	ea, ua, ra := splitEVR(a)
+	eb, ub, rb := splitEVR(b)
+	if c := compareEpoch(ea, eb); c != 0 {
+		return c
+	}
+	if c := debVerrevcmp(ua, ub); c != 0 {
+		return c
+	}
+	return debVerrevcmp(ra, rb)
+}
+
+// splitEVR 은 "[epoch:]version[-release]" 를 나눈다. release 는 마지막 '-' 뒤이다.
+func splitEVR(v string) (epoch, version, release string) {
+	if e, rest, ok := strings.Cut(v, ":"); ok {
+		epoch, v = e, rest
+	}
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		return epoch, v[:i], v[i+1:]
+	}
+	return epoch, v, ""
+}
+
+func compareEpoch(a, b string) int {
+	ea, _ := strconv.Atoi(a)
+	eb, _ := strconv.Atoi(b)
+	return compareInt(ea, eb)
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// debOrder 는 dpkg 의 문자 순서이다. '~' 는 문자열의 끝보다도 앞선다.
+func debOrder(s string, i int) int {
+	if i >= len(s) {
+		return 0
+	}
+	c := s[i]
+	switch {
+	case isDigit(c):
+		return 0
+	case isAlpha(c):
+		return int(c)
+	case c == '~':
+		return -1
+	}
+	return int(c) + 256
+}
+
+// debVerrevcmp 는 dpkg 의 verrevcmp 이다. 숫자가 아닌 부분은 debOrder 로, 숫자 부분은 값으로 비교한다.
+func debVerrevcmp(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			ac, bc := debOrder(a, i), debOrder(b, j)
+			if ac != bc {
+				return compareInt(ac, bc)
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		firstDiff := 0
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = compareInt(int(a[i]), int(b[j]))
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return firstDiff
+		}
+	}
+	return 0
+}
+
+// compareRPMVersion 은 rpm 의 규칙으로 "[epoch:]version-release" 버전을 비교한다.
+func compareRPMVersion(a, b string) int {
+	ea, va, ra := splitEVR(a)
+	eb, vb, rb := splitEVR(b)
+	if c := compareEpoch(ea, eb); c != 0 {
+		return c
+	}
+	if c := rpmvercmp(va, vb); c != 0 {
+		return c
+	}
+	if ra == "" || rb == "" {
+		// 권고에 release 가 없으면 version 만 비교한다
+		return 0
+	}
+	return rpmvercmp(ra, rb)
+}
+
+// rpmvercmp 는 rpm 의 rpmvercmp 이다. 영숫자 구간을 나눠 숫자는 값으로, 문자는 사전 순으로 비교한다.
+// '~' 는 어떤 값보다도 앞서고, '^' 는 끝보다 뒤지만 다른 값보다 앞선다.
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	isSep := func(c byte) bool { return !isDigit(c) && !isAlpha(c) && c != '~' && c != '^' }
+	for len(a) > 0 || len(b) > 0 {
+		for len(a) > 0 && isSep(a[0]) {
+			a = a[1:]
+		}
+		for len(b) > 0 && isSep(b[0]) {
+			b = b[1:]
+		}
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			switch {
+			case len(a) == 0:
+				return -1
+			case len(b) == 0:
+				return 1
+			case a[0] != '^':
+				return 1
+			case b[0] != '^':
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if len(a) == 0 || len(b) == 0 {
+			break
+		}
+
+		isNum := isDigit(a[0])
+		take := func(s string) (string, string) {
+			n := 0
+			for n < len(s) && ((isNum && isDigit(s[n])) || (!isNum && isAlpha(s[n]))) {
+				n++
+			}
+			return s[:n], s[n:]
+		}
+		var sa, sb string
+		sa, a = take(a)
+		sb, b = take(b)
+		if sb == "" {
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+		if isNum {
+			sa, sb = strings.TrimLeft(sa, "0"), strings.TrimLeft(sb, "0")
+			if c := compareInt(len(sa), len(sb)); c != 0 {
+				return c
+			}
+		}
+		if c := strings.Compare(sa, sb); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return -1
+	}
+	return 1
+}
+
+// apkSuffixes 는 apk 버전 접미사의 순서이다. 접미사가 없는 버전은 _rc 와 _cvs 사이에 온다.
+var apkSuffixes = map[string]int{
+	"alpha": -4, "beta": -3, "pre": -2, "rc": -1,
+	"cvs": 1, "svn": 2, "git": 3, "hg": 4, "p": 5,
+}
+
+// apkVersion 은 "1.2.3a_rc1_p2-r4" 형식의 apk 버전을 나눈 것이다.
+type apkVersion struct {
+	numbers  []string // 점으로 구분된 숫자들
+	letter   byte
+	suffixes [][2]int // (접미사 순서, 번호)
+	release  int
+}
+
+func parseApkVersion(v string) (apkVersion, bool) {
+	var av apkVersion
+	// "~<커밋 해시>" 는 비교하지 않는다
+	v, _, _ = strings.Cut(v, "~")
+	if i := strings.LastIndex(v, "-r"); i >= 0 {
+		r, err := strconv.Atoi(v[i+2:])
+		if err != nil {
+			return av, false
+		}
+		av.release, v = r, v[:i]
+	}
+	main, rest, _ := strings.Cut(v, "_")
+	if main != "" && isAlpha(main[len(main)-1]) {
+		av.letter, main = main[len(main)-1], main[:len(main)-1]
+	}
+	for _, n := range strings.Split(main, ".") {
+		if n == "" || strings.TrimLeft(n, "0123456789") != "" {
+			return av, false
+		}
+		av.numbers = append(av.numbers, n)
+	}
+	if rest != "" {
+		for _, s := range strings.Split(rest, "_") {
+			name := strings.TrimRight(s, "0123456789")
+			order, ok := apkSuffixes[name]
+			if !ok {
+				return av, false
+			}
+			n, _ := strconv.Atoi(s[len(name):])
+			av.suffixes = append(av.suffixes, [2]int{order, n})
+		}
+	}
+	return av, true
+}
+
+// compareApkVersion 은 apk 의 규칙으로 버전을 비교한다. 해석할 수 없는 버전은 dpkg 규칙으로 비교한다.
+func compareApkVersion(a, b string) int {
+	va, okA := parseApkVersion(a)
+	vb, okB := parseApkVersion(b)
+	if !okA || !okB {
+		return compareDebVersion(a, b)
+	}
+	for i := 0; i < len(va.numbers) && i < len(vb.numbers); i++ {
+		na, nb := va.numbers[i], vb.numbers[i]
+		if i > 0 && (strings.HasPrefix(na, "0") || strings.HasPrefix(nb, "0")) {
+			// 첫 번째가 아닌 구간이 0 으로 시작하면 소수처럼 문자열로 비교한다
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			continue
+		}
+		if c := rpmvercmp(na, nb); c != 0 {
+			return c
+		}
+	}
+	if c := compareInt(len(va.numbers), len(vb.numbers)); c != 0 {
+		return c
+	}
+	if c := compareInt(int(va.letter), int(vb.letter)); c != 0 {
+		return c
+	}
+	for i := 0; i < len(va.suffixes) || i < len(vb.suffixes); i++ {
+		sa, sb := [2]int{}, [2]int{}
+		if i < len(va.suffixes) {
+			sa = va.suffixes[i]
+		}
+		if i < len(vb.suffixes) {
+			sb = vb.suffixes[i]
+		}
+		if c := compareInt(sa[0], sb[0]); c != 0 {
+			return c
+		}
+		if c := compareInt(sa[1], sb[1]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(va.release, vb.release)
+}
@@ -0,0 +1,218 @@
+package podbridge5
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareDebVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0-1", "1.0-2", -1},
+		{"1:1.0", "2.0", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"2.36-9+deb12u7", "2.36-9+deb12u4", 1},
+		{"2.36-9+deb12u10", "2.36-9+deb12u9", 1},
+		{"5.2.15-2+b7", "5.2.15-2", 1},
+		{"1.0a", "1.0", 1},
+		{"1.01", "1.1", 0},
+	}
+	for _, tt := range tests {
+		if got := compareDebVersion(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareDebVersion(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCompareRPMVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"5.1.8-9.el9", "5.1.8-9.el9", 0},
+		{"5.1.8-9.el9", "5.1.8-10.el9", -1},
+		{"2:2024a-1.el9", "2024b-1.el9", 1},
+		{"1.0~rc1-1", "1.0-1", -1},
+		{"1.0^git1-1", "1.0-1", 1},
+		{"1.0^git1-1", "1.0.1-1", -1},
+		{"1.10-1", "1.9-1", 1},
+		{"1.0a-1", "1.0-1", 1},
+		{"3.0.7-27.el9", "3.0.7", 0},
+	}
+	for _, tt := range tests {
+		if got := compareRPMVersion(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareRPMVersion(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCompareApkVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.36.1-r29", "1.36.1-r29", 0},
+		{"1.36.1-r29", "1.36.1-r30", -1},
+		{"3.3.2-r0", "3.3.10-r0", -1},
+		{"1.0_rc1-r0", "1.0-r0", -1},
+		{"1.0_p1-r0", "1.0-r0", 1},
+		{"1.0_alpha1", "1.0_beta1", -1},
+		{"1.2a", "1.2", 1},
+		{"1.2.3", "1.2", 1},
+		{"1.02", "1.1", -1},
+		{"2.9.14-r0~abc123", "2.9.14-r0", 0},
+	}
+	for _, tt := range tests {
+		if got := compareApkVersion(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareApkVersion(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCVSS3BaseScore(t *testing.T) {
+	tests := []struct {
+		vector string
+		want   float64
+	}{
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", 9.8},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N", 6.1},
+		{"CVSS:3.0/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:N/A:N", 5.5},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:N", 0},
+	}
+	for _, tt := range tests {
+		got, err := cvss3BaseScore(tt.vector)
+		if err != nil || got != tt.want {
+			t.Errorf("cvss3BaseScore(%q) = %v, %v, want %v", tt.vector, got, err, tt.want)
+		}
+	}
+	for _, v := range []string{"CVSS:4.0/AV:N/AC:L/AT:N/PR:N/UI:N/VC:H/VI:H/VA:H/SC:N/SI:N/SA:N", "CVSS:3.1/AV:X/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"} {
+		if _, err := cvss3BaseScore(v); err == nil {
+			t.Errorf("expected error for %q", v)
+		}
+	}
+}
+
+func TestEcosystemPackageType(t *testing.T) {
+	alpine := map[string]string{"ID": "alpine", "VERSION_ID": "3.20.3"}
+	debian := map[string]string{"ID": "debian", "VERSION_ID": "12"}
+	rocky := map[string]string{"ID": "rocky", "VERSION_ID": "9.4"}
+	tests := []struct {
+		ecosystem string
+		osRelease map[string]string
+		want      string
+		ok        bool
+	}{
+		{"Alpine:v3.20", alpine, "apk", true},
+		{"Alpine:v3.19", alpine, "", false},
+		{"Debian:12", debian, "deb", true},
+		{"Debian:11", debian, "", false},
+		{"Debian:12", alpine, "", false},
+		{"Ubuntu:22.04:LTS", map[string]string{"ID": "ubuntu", "VERSION_ID": "22.04"}, "deb", true},
+		{"Rocky Linux:9", rocky, "rpm", true},
+		{"Debian", nil, "deb", true},
+		{"PyPI", debian, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := ecosystemPackageType(tt.ecosystem, tt.osRelease)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ecosystemPackageType(%q) = %q, %v, want %q, %v", tt.ecosystem, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestOSVRangeAffects(t *testing.T) {
+	r := osvRange{Type: "ECOSYSTEM", Events: []osvEvent{{Fixed: "3.3.2-r0"}, {Introduced: "0"}}}
+	if affected, fixed := r.affects("3.3.1-r0", compareApkVersion); !affected || fixed != "3.3.2-r0" {
+		t.Errorf("expected 3.3.1-r0 to be affected and fixed in 3.3.2-r0, got %v %q", affected, fixed)
+	}
+	if affected, _ := r.affects("3.3.2-r0", compareApkVersion); affected {
+		t.Error("expected fixed version not to be affected")
+	}
+
+	r = osvRange{Type: "ECOSYSTEM", Events: []osvEvent{{Introduced: "2.0"}, {LastAffected: "2.5"}}}
+	for v, want := range map[string]bool{"1.9": false, "2.0": true, "2.5": true, "2.6": false} {
+		if affected, _ := r.affects(v, compareDebVersion); affected != want {
+			t.Errorf("affects(%q) = %v, want %v", v, affected, want)
+		}
+	}
+
+	aff := osvAffected{Versions: []string{"1.0-1"}, Ranges: []osvRange{{Type: "GIT", Events: []osvEvent{{Introduced: "0"}}}}}
+	if affected, _ := aff.affects("1.0-1", compareDebVersion); !affected {
+		t.Error("expected listed version to be affected")
+	}
+	if affected, _ := aff.affects("1.0-2", compareDebVersion); affected {
+		t.Error("expected GIT ranges to be ignored")
+	}
+}
+
+func TestAdvisorySeverity(t *testing.T) {
+	adv := &osvAdvisory{
+		DatabaseSpecific: map[string]any{"severity": "Moderate"},
+		Severity:         []osvSeverity{{Type: "CVSS_V3", Score: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"}},
+	}
+	if got := adv.severity(&osvAffected{EcosystemSpecific: map[string]any{"urgency": "unimportant"}}); got != SeverityLow {
+		t.Errorf("expected ecosystem urgency to win, got %s", got)
+	}
+	if got := adv.severity(&osvAffected{}); got != SeverityMedium {
+		t.Errorf("expected database severity, got %s", got)
+	}
+	adv.DatabaseSpecific = nil
+	if got := adv.severity(&osvAffected{}); got != SeverityCritical {
+		t.Errorf("expected CVSS severity, got %s", got)
+	}
+	if got := (&osvAdvisory{}).severity(&osvAffected{}); got != SeverityUnknown {
+		t.Errorf("expected unknown severity, got %s", got)
+	}
+}
+
+func TestReadAdvisories(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		"db/ALPINE-1.json":     `{"id": "ALPINE-1"}`,
+		"db/nested/list.json":  `[{"id": "DSA-1"}, {"id": "DSA-2"}]`,
+		"db/README.md":         "ignored",
+		"invalid/broken.json":  `{"id": `,
+		"single/CVE-2024.json": `{"id": "CVE-2024"}`,
+	})
+
+	collect := func(path string) ([]string, error) {
+		var ids []string
+		err := readAdvisories(path, func(adv *osvAdvisory) { ids = append(ids, adv.ID) })
+		return ids, err
+	}
+	if ids, err := collect(filepath.Join(dir, "db")); err != nil || len(ids) != 3 {
+		t.Errorf("expected 3 advisories from directory, got %v (%v)", ids, err)
+	}
+	if ids, err := collect(filepath.Join(dir, "single", "CVE-2024.json")); err != nil || len(ids) != 1 || ids[0] != "CVE-2024" {
+		t.Errorf("unexpected advisories from file %v (%v)", ids, err)
+	}
+	if _, err := collect(filepath.Join(dir, "invalid")); err == nil {
+		t.Error("expected error for invalid advisory")
+	}
+
+	zipPath := filepath.Join(dir, "all.zip")
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, id := range []string{"ALPINE-CVE-1", "ALPINE-CVE-2"} {
+		w, err := zw.Create(id + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(`{"id": "` + id + `"}`))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if ids, err := collect(zipPath); err != nil || len(ids) != 2 {
+		t.Errorf("expected 2 advisories from zip, got %v (%v)", ids, err)
+	}
+}
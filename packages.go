@@ -0,0 +1,314 @@
+package podbridge5
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/containers/buildah"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 지원하는 패키지 매니저
+const (
+	PackageManagerApk      = "apk"
+	PackageManagerApt      = "apt"
+	PackageManagerDnf      = "dnf"
+	PackageManagerYum      = "yum"
+	PackageManagerMicrodnf = "microdnf"
+	PackageManagerZypper   = "zypper"
+)
+
+// ErrUnsupportedOS 는 베이스 이미지의 OS 에 맞는 패키지 매니저를 찾지 못했을 때 반환된다.
+var ErrUnsupportedOS = errors.New("unsupported base image OS")
+
+// osReleaseSeparator 는 detectOSScript 의 출력에서 os-release 와 패키지 매니저 목록을 나눈다.
+const osReleaseSeparator = "--- package managers ---"
+
+// detectOSScript 는 os-release 를 출력한 뒤 이미지 안에 있는 패키지 매니저 실행 파일을 출력한다.
+const detectOSScript = "cat /etc/os-release 2>/dev/null || cat /usr/lib/os-release 2>/dev/null; " +
+	"echo '" + osReleaseSeparator + "'; " +
+	"for pm in apk apt-get dnf microdnf yum zypper; do command -v $pm >/dev/null 2>&1 && echo $pm; done; true"
+
+// osFamilyManagers 는 os-release 의 ID (또는 ID_LIKE) 별로 사용할 수 있는 패키지 매니저이다. 앞에 있는 것을 먼저 사용한다.
+var osFamilyManagers = map[string][]string{
+	"alpine":   {PackageManagerApk},
+	"debian":   {PackageManagerApt},
+	"ubuntu":   {PackageManagerApt},
+	"fedora":   {PackageManagerDnf, PackageManagerMicrodnf, PackageManagerYum},
+	"rhel":     {PackageManagerDnf, PackageManagerMicrodnf, PackageManagerYum},
+	"centos":   {PackageManagerDnf, PackageManagerMicrodnf, PackageManagerYum},
+	"suse":     {PackageManagerZypper},
+	"opensuse": {PackageManagerZypper},
+	"sles":     {PackageManagerZypper},
+}
+
+// packageNamePattern 은 패키지 이름과 버전 지정(예: "curl=7.88.1-10", "python3-pip")을 허용한다. 옵션으로 해석되지 않도록 '-' 로 시작할 수 없다.
+var packageNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9+._:=~<>*-]*$`)
+
+// OSInfo 는 베이스 이미지의 /etc/os-release 와 사용할 패키지 매니저이다.
+type OSInfo struct {
+	ID             string   // 예: "alpine", "ubuntu", "rocky"
+	IDLike         []string // 예: ["rhel", "centos", "fedora"]
+	VersionID      string   // 예: "3.20.0", "22.04"
+	PrettyName     string   // 예: "Ubuntu 22.04.4 LTS"
+	PackageManager string   // apk, apt, dnf, yum, microdnf, zypper
+}
+
+// DetectOS 는 builder 안의 /etc/os-release 와 패키지 매니저 실행 파일로 베이스 이미지의 OS 를 확인한다.
+// 지원하지 않는 OS 이면 ErrUnsupportedOS 를 감싼 에러를 반환한다.
+func DetectOS(builder *buildah.Builder) (*OSInfo, error) {
+	var stdout, stderr bytes.Buffer
+	opts := defaultRunOptions
+	opts.Stdout = &stdout
+	opts.Stderr = &stderr
+	if err := builder.Run([]string{"/bin/sh", "-c", detectOSScript}, opts); err != nil {
+		return nil, fmt.Errorf("failed to detect base image OS: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseOSInfo(stdout.String())
+}
+
+// parseOSInfo 는 detectOSScript 의 출력으로 OSInfo 를 만든다.
+func parseOSInfo(output string) (*OSInfo, error) {
+	release, managers, _ := strings.Cut(output, osReleaseSeparator)
+	fields := parseOSRelease(release)
+	info := &OSInfo{
+		ID:         fields["ID"],
+		IDLike:     strings.Fields(fields["ID_LIKE"]),
+		VersionID:  fields["VERSION_ID"],
+		PrettyName: fields["PRETTY_NAME"],
+	}
+	if info.ID == "" {
+		return nil, fmt.Errorf("%w: /etc/os-release not found", ErrUnsupportedOS)
+	}
+
+	available := make(map[string]bool)
+	for _, pm := range strings.Fields(managers) {
+		if pm == "apt-get" {
+			pm = PackageManagerApt
+		}
+		available[pm] = true
+	}
+	for _, id := range append([]string{info.ID}, info.IDLike...) {
+		for _, pm := range osFamilyManagers[id] {
+			if available[pm] {
+				info.PackageManager = pm
+				return info, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: %s (ID=%s, ID_LIKE=%s); supported: %s",
+		ErrUnsupportedOS, info.name(), info.ID, strings.Join(info.IDLike, " "), strings.Join(supportedOSFamilies(), ", "))
+}
+
+// parseOSRelease 는 os-release 형식(KEY=value, 값은 따옴표로 감쌀 수 있음)을 읽는다.
+func parseOSRelease(data string) map[string]string {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		fields[key] = strings.Trim(value, `"'`)
+	}
+	return fields
+}
+
+func (info *OSInfo) name() string {
+	if info.PrettyName != "" {
+		return info.PrettyName
+	}
+	return strings.TrimSpace(info.ID + " " + info.VersionID)
+}
+
+func supportedOSFamilies() []string {
+	families := make([]string, 0, len(osFamilyManagers))
+	for id := range osFamilyManagers {
+		families = append(families, id)
+	}
+	sort.Strings(families)
+	return families
+}
+
+// installCommands 는 패키지 매니저로 packages 를 설치하는 명령들이다. 셸을 거치지 않고 순서대로 실행한다.
+// 이미지 크기를 줄이기 위해 설치 후 패키지 캐시를 지운다.
+func installCommands(packageManager string, packages []string) ([][]string, error) {
+	switch packageManager {
+	case PackageManagerApk:
+		return [][]string{append([]string{"apk", "add", "--no-cache"}, packages...)}, nil
+	case PackageManagerApt:
+		return [][]string{
+			{"apt-get", "update"},
+			append([]string{"apt-get", "install", "-y", "--no-install-recommends"}, packages...),
+			{"apt-get", "clean"},
+			{"rm", "-rf", "/var/lib/apt/lists"},
+		}, nil
+	case PackageManagerDnf, PackageManagerYum, PackageManagerMicrodnf:
+		return [][]string{
+			append([]string{packageManager, "install", "-y"}, packages...),
+			{packageManager, "clean", "all"},
+		}, nil
+	case PackageManagerZypper:
+		return [][]string{
+			append([]string{"zypper", "--non-interactive", "install", "--no-recommends"}, packages...),
+			{"zypper", "clean", "--all"},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown package manager %q", packageManager)
+}
+
+// installEnv 는 설치 명령에 더할 환경 변수이다. apt 는 tzdata 처럼 설정을 묻는 패키지가 빌드를 멈추지 않도록 비대화식으로 실행한다.
+func installEnv(packageManager string) []string {
+	if packageManager == PackageManagerApt {
+		return []string{"DEBIAN_FRONTEND=noninteractive"}
+	}
+	return nil
+}
+
+// validatePackages 는 패키지 이름이 명령 인자로 안전한지 확인한다.
+func validatePackages(packages []string) error {
+	for _, pkg := range packages {
+		if !packageNamePattern.MatchString(pkg) {
+			return fmt.Errorf("invalid package name %q", pkg)
+		}
+	}
+	return nil
+}
+
+// packageSet 은 설치할 패키지 목록이다. Packages 는 모든 OS 에, DistroPackages 는 패키지 매니저가 같을 때만 설치한다.
+type packageSet struct {
+	common []string
+	distro map[string][]string // 패키지 매니저 이름별 추가 패키지
+}
+
+func (s packageSet) empty() bool {
+	return len(s.common) == 0 && len(s.distro) == 0
+}
+
+// forManager 는 packageManager 에서 설치할 패키지 목록이다. dnf, yum, microdnf 는 같은 패키지 이름을 사용하므로 "dnf" 키도 함께 본다.
+func (s packageSet) forManager(packageManager string) []string {
+	pkgs := append([]string(nil), s.common...)
+	pkgs = append(pkgs, s.distro[packageManager]...)
+	if packageManager == PackageManagerYum || packageManager == PackageManagerMicrodnf {
+		pkgs = append(pkgs, s.distro[PackageManagerDnf]...)
+	}
+	return pkgs
+}
+
+func (s packageSet) validate() error {
+	if err := validatePackages(s.common); err != nil {
+		return err
+	}
+	for pm, pkgs := range s.distro {
+		if _, err := installCommands(pm, nil); err != nil {
+			return fmt.Errorf("distroPackages: %w", err)
+		}
+		if err := validatePackages(pkgs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installedPackagesScript 는 인자로 받은 패키지 중 이미 설치된 패키지의 이름을 한 줄에 하나씩 출력한다.
+// 패키지 데이터베이스(dpkg, rpm, apk)가 없는 이미지에서는 같은 이름의 명령이 있으면 설치된 것으로 본다.
+const installedPackagesScript = `if command -v dpkg-query >/dev/null 2>&1; then dpkg-query -W -f='${db:Status-Abbrev} ${Package}\n' "$@" 2>/dev/null; ` +
+	`elif command -v rpm >/dev/null 2>&1; then rpm -q --qf '%{NAME}\n' "$@" 2>/dev/null; ` +
+	`elif command -v apk >/dev/null 2>&1; then apk info -e "$@" 2>/dev/null; ` +
+	`else for pkg; do command -v "$pkg" >/dev/null 2>&1 && echo "$pkg"; done; fi; true`
+
+// missingPackages 는 packages 중 builder 에 설치되어 있지 않은 패키지들이다. 버전을 지정한 패키지는 항상 설치한다.
+func missingPackages(builder *buildah.Builder, packages []string) ([]string, error) {
+	var stdout, stderr bytes.Buffer
+	opts := defaultRunOptions
+	opts.Stdout = &stdout
+	opts.Stderr = &stderr
+	args := append([]string{"/bin/sh", "-c", installedPackagesScript, "sh"}, packages...)
+	if err := builder.Run(args, opts); err != nil {
+		return nil, fmt.Errorf("failed to check installed packages: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	installed := parseInstalledPackages(stdout.String())
+	var missing []string
+	for _, pkg := range packages {
+		if !installed[pkg] {
+			missing = append(missing, pkg)
+		}
+	}
+	return missing, nil
+}
+
+// parseInstalledPackages 는 installedPackagesScript 의 출력을 읽는다.
+// dpkg-query 는 "ii  bash" 처럼 상태와 이름을, 나머지는 이름만 출력한다. rpm 의 "package x is not installed" 같은 줄은 무시한다.
+func parseInstalledPackages(output string) map[string]bool {
+	installed := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 1:
+			installed[fields[0]] = true
+		case len(fields) == 2 && fields[0] == "ii":
+			installed[fields[1]] = true
+		}
+	}
+	return installed
+}
+
+// installPackages 는 베이스 이미지의 OS 를 확인하고 그 패키지 매니저로 packages 중 설치되지 않은 것만 설치한다.
+// 설치할 패키지가 없으면 아무것도 하지 않는다. 모두 설치되어 있으면 패키지 저장소에 접근하지 않으므로
+// 오프라인 모드에서도, 지원하지 않는 OS 의 이미지에서도 빌드할 수 있다.
+func installPackages(builder *buildah.Builder, packages packageSet, p *buildProgress) error {
+	if packages.empty() {
+		return nil
+	}
+	info, detectErr := DetectOS(builder)
+	if detectErr != nil && !errors.Is(detectErr, ErrUnsupportedOS) {
+		return detectErr
+	}
+
+	// 패키지 매니저를 모르면 모든 OS 에 설치하는 Packages 만 확인할 수 있다.
+	pkgs := packages.common
+	if detectErr == nil {
+		p.note("Detected %s, using %s", info.name(), info.PackageManager)
+		pkgs = packages.forManager(info.PackageManager)
+	}
+	if len(pkgs) == 0 {
+		return nil
+	}
+	return p.step("INSTALL "+strings.Join(pkgs, " "), func(stdout, stderr io.Writer) error {
+		missing, err := missingPackages(builder, pkgs)
+		if err != nil {
+			return err
+		}
+		if len(missing) == 0 {
+			if stdout != nil {
+				_, _ = fmt.Fprintln(stdout, "all packages are already installed")
+			}
+			return nil
+		}
+		if detectErr != nil {
+			return fmt.Errorf("cannot install %s: %w", strings.Join(missing, " "), detectErr)
+		}
+		cmds, err := installCommands(info.PackageManager, missing)
+		if err != nil {
+			return err
+		}
+		for _, args := range cmds {
+			opts := defaultRunOptions
+			opts.Stdout = stdout
+			opts.Stderr = stderr
+			opts.Env = append(append([]string(nil), opts.Env...), installEnv(info.PackageManager)...)
+			if err := builder.Run(args, opts); err != nil {
+				return fmt.Errorf("%s: %w", strings.Join(args, " "), err)
+			}
+		}
+		return nil
+	})
+}
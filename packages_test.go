@@ -0,0 +1,165 @@
+package podbridge5
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseOSInfo(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		wantID   string
+		wantPM   string
+		wantName string
+	}{
+		{
+			name:     "alpine",
+			output:   "NAME=\"Alpine Linux\"\nID=alpine\nVERSION_ID=3.20.0\nPRETTY_NAME=\"Alpine Linux v3.20\"\n" + osReleaseSeparator + "\napk\n",
+			wantID:   "alpine",
+			wantPM:   PackageManagerApk,
+			wantName: "Alpine Linux v3.20",
+		},
+		{
+			name:     "ubuntu",
+			output:   "ID=ubuntu\nID_LIKE=debian\nVERSION_ID=\"22.04\"\n" + osReleaseSeparator + "\napt-get\n",
+			wantID:   "ubuntu",
+			wantPM:   PackageManagerApt,
+			wantName: "ubuntu 22.04",
+		},
+		{
+			name:   "rocky via ID_LIKE",
+			output: "ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\n" + osReleaseSeparator + "\ndnf\nyum\n",
+			wantID: "rocky",
+			wantPM: PackageManagerDnf,
+		},
+		{
+			name:   "ubi minimal",
+			output: "ID=\"rhel\"\n" + osReleaseSeparator + "\nmicrodnf\n",
+			wantID: "rhel",
+			wantPM: PackageManagerMicrodnf,
+		},
+		{
+			name:   "centos 7",
+			output: "ID=\"centos\"\nID_LIKE=\"rhel fedora\"\n" + osReleaseSeparator + "\nyum\n",
+			wantID: "centos",
+			wantPM: PackageManagerYum,
+		},
+		{
+			name:   "opensuse",
+			output: "ID=\"opensuse-leap\"\nID_LIKE=\"suse opensuse\"\n" + osReleaseSeparator + "\nzypper\n",
+			wantID: "opensuse-leap",
+			wantPM: PackageManagerZypper,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := parseOSInfo(tt.output)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.ID != tt.wantID || info.PackageManager != tt.wantPM {
+				t.Errorf("got ID=%q pm=%q, want %q %q", info.ID, info.PackageManager, tt.wantID, tt.wantPM)
+			}
+			if tt.wantName != "" && info.name() != tt.wantName {
+				t.Errorf("got name %q, want %q", info.name(), tt.wantName)
+			}
+		})
+	}
+}
+
+func TestParseOSInfo_Unsupported(t *testing.T) {
+	for name, output := range map[string]string{
+		"no os-release":          osReleaseSeparator + "\n",
+		"unknown distribution":   "ID=gentoo\n" + osReleaseSeparator + "\n",
+		"package manager absent": "ID=debian\n" + osReleaseSeparator + "\n", // distroless
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseOSInfo(output); !errors.Is(err, ErrUnsupportedOS) {
+				t.Errorf("expected ErrUnsupportedOS, got %v", err)
+			}
+		})
+	}
+}
+
+func TestInstallCommands(t *testing.T) {
+	cmds, err := installCommands(PackageManagerApt, []string{"bash", "curl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"apt-get", "install", "-y", "--no-install-recommends", "bash", "curl"}
+	if len(cmds) != 4 || !reflect.DeepEqual(cmds[1], want) {
+		t.Errorf("unexpected apt commands %v", cmds)
+	}
+	cmds, err = installCommands(PackageManagerMicrodnf, []string{"bash"})
+	if err != nil || !reflect.DeepEqual(cmds[0], []string{"microdnf", "install", "-y", "bash"}) {
+		t.Errorf("unexpected microdnf commands %v (%v)", cmds, err)
+	}
+	if _, err := installCommands("pacman", []string{"bash"}); err == nil {
+		t.Error("expected error for unknown package manager")
+	}
+	if env := installEnv(PackageManagerApt); !reflect.DeepEqual(env, []string{"DEBIAN_FRONTEND=noninteractive"}) {
+		t.Errorf("expected apt to run noninteractively, got %v", env)
+	}
+	if env := installEnv(PackageManagerApk); env != nil {
+		t.Errorf("expected no extra env for apk, got %v", env)
+	}
+}
+
+func TestPackageSet(t *testing.T) {
+	s := packageSet{
+		common: []string{"bash"},
+		distro: map[string][]string{PackageManagerApt: {"procps"}, PackageManagerDnf: {"procps-ng"}},
+	}
+	if err := s.validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := s.forManager(PackageManagerApt); !reflect.DeepEqual(got, []string{"bash", "procps"}) {
+		t.Errorf("unexpected apt packages %v", got)
+	}
+	if got := s.forManager(PackageManagerYum); !reflect.DeepEqual(got, []string{"bash", "procps-ng"}) {
+		t.Errorf("expected dnf packages for yum, got %v", got)
+	}
+	if got := s.forManager(PackageManagerApk); !reflect.DeepEqual(got, []string{"bash"}) {
+		t.Errorf("unexpected apk packages %v", got)
+	}
+
+	for _, bad := range []packageSet{
+		{common: []string{"--allow-untrusted"}},
+		{common: []string{"bash; rm -rf /"}},
+		{distro: map[string][]string{"pacman": {"bash"}}},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("expected error for %+v", bad)
+		}
+	}
+}
+
+func TestNewConfig_DefaultPackages(t *testing.T) {
+	// executor.sh, healthcheck.sh 가 쓰는 도구들을 install.sh 대신 설치한다.
+	config := NewConfig("docker.io/library/ubuntu:latest")
+	if !reflect.DeepEqual(config.Image.Packages, defaultPackages) || !reflect.DeepEqual(config.Container.Packages, defaultPackages) {
+		t.Errorf("expected default packages, got %v and %v", config.Image.Packages, config.Container.Packages)
+	}
+	config.Image.Packages[0] = "changed"
+	if defaultPackages[0] != "bash" || config.Container.Packages[0] != "bash" {
+		t.Error("expected NewConfig to copy the default packages")
+	}
+}
+
+func TestParseInstalledPackages(t *testing.T) {
+	for name, tt := range map[string]struct {
+		output string
+		want   map[string]bool
+	}{
+		"dpkg": {"ii  bash\nrc  procps\nii  coreutils\n", map[string]bool{"bash": true, "coreutils": true}},
+		"rpm":  {"bash\npackage procps-ng is not installed\n", map[string]bool{"bash": true}},
+		"apk":  {"bash\ncoreutils\n", map[string]bool{"bash": true, "coreutils": true}},
+		"none": {"", map[string]bool{}},
+	} {
+		if got := parseInstalledPackages(tt.output); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", name, got, tt.want)
+		}
+	}
+}
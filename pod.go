@@ -4,9 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/containers/podman/v5/libpod/define"
+	"github.com/containers/podman/v5/pkg/bindings/containers"
 	"github.com/containers/podman/v5/pkg/bindings/pods"
 	"github.com/containers/podman/v5/pkg/domain/entities"
 	"github.com/containers/podman/v5/pkg/specgen"
+	"github.com/seoyhaein/utils"
+	"sort"
+	"time"
 )
 
 // TODO 여기서 부터 시작.
@@ -49,12 +54,12 @@ func NewPod(ctx context.Context, opts ...PodOption) (*Pod, error) {
 		}
 	}
 
-	report, err := pods.CreatePodFromSpec(ctx, spec)
+	id, err := CreatePod(ctx, spec)
 	if err != nil {
-		return nil, fmt.Errorf("pod creation failed: %w", err)
+		return nil, err
 	}
 
-	return &Pod{Spec: spec, ID: report.Id}, nil
+	return &Pod{Spec: spec, ID: id}, nil
 }
 
 func (p *Pod) Remove(ctx context.Context, force bool) error {
@@ -110,7 +115,11 @@ func WithPodSharedNamespaces(namespaces ...string) PodOption {
 
 // CreatePod creates a new pod using a prepared PodSpec.
 // It assumes the context has been initialized with a Podman client connection.
+// ContextWithDryRun 의 ctx 이면 spec 검증과 이름 확인만 하고 생성은 기록만 하며, 빈 ID 를 반환한다.
 func CreatePod(ctx context.Context, podSpec *entities.PodSpec) (string, error) {
+	if d := dryRunFrom(ctx); d != nil {
+		return "", d.createPod(ctx, podSpec)
+	}
 	report, err := pods.CreatePodFromSpec(ctx, podSpec)
 	if err != nil {
 		return "", fmt.Errorf("pod creation failed: %w", err)
@@ -134,3 +143,165 @@ func RemovePod(ctx context.Context, podID string, force bool) error {
 	}
 	return nil
 }
+
+// StartPod starts all containers in the pod. 이미 실행 중이면 아무것도 하지 않는다.
+func StartPod(ctx context.Context, nameOrID string) error {
+	report, err := pods.Start(ctx, nameOrID, nil)
+	if err == nil {
+		err = errors.Join(report.Errs...)
+	}
+	return podActionError("start", nameOrID, err)
+}
+
+// StopPod stops all containers in the pod.
+// 각 컨테이너가 timeout 안에 종료되지 않으면 강제로 종료한다. timeout 이 0 이하이면 podman 의 기본값(10초)을 사용한다.
+func StopPod(ctx context.Context, nameOrID string, timeout time.Duration) error {
+	opts := &pods.StopOptions{}
+	if timeout > 0 {
+		// 초 단위로 올림
+		seconds := int((timeout + time.Second - 1) / time.Second)
+		opts.Timeout = &seconds
+	}
+	report, err := pods.Stop(ctx, nameOrID, opts)
+	if err == nil {
+		err = errors.Join(report.Errs...)
+	}
+	return podActionError("stop", nameOrID, err)
+}
+
+// PausePod pauses all running containers in the pod.
+func PausePod(ctx context.Context, nameOrID string) error {
+	report, err := pods.Pause(ctx, nameOrID, nil)
+	if err == nil {
+		err = errors.Join(report.Errs...)
+	}
+	return podActionError("pause", nameOrID, err)
+}
+
+// UnpausePod unpauses all paused containers in the pod.
+func UnpausePod(ctx context.Context, nameOrID string) error {
+	report, err := pods.Unpause(ctx, nameOrID, nil)
+	if err == nil {
+		err = errors.Join(report.Errs...)
+	}
+	return podActionError("unpause", nameOrID, err)
+}
+
+// RestartPod restarts all containers in the pod.
+func RestartPod(ctx context.Context, nameOrID string) error {
+	report, err := pods.Restart(ctx, nameOrID, nil)
+	if err == nil {
+		err = errors.Join(report.Errs...)
+	}
+	return podActionError("restart", nameOrID, err)
+}
+
+// podActionError 는 pod 작업의 에러에 작업과 pod 이름을 붙인다. err 이 nil 이면 nil 이다.
+func podActionError(action, nameOrID string, err error) error {
+	if err != nil {
+		return fmt.Errorf("pod %s failed for %q: %w", action, nameOrID, err)
+	}
+	return nil
+}
+
+// InspectPod returns the pod's configuration and the state of its containers.
+func InspectPod(ctx context.Context, nameOrID string) (*define.InspectPodData, error) {
+	report, err := pods.Inspect(ctx, nameOrID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("inspect pod %q: %w", nameOrID, err)
+	}
+	return report.InspectPodData, nil
+}
+
+// PodStatus 는 pod 에 속한 컨테이너들의 상태를 모은 것이다. infra 컨테이너는 포함하지 않는다.
+// 파이프라인의 단계를 pod 하나로 묶어 실행할 때, 단계 전체가 정상인지 실패했는지를 한 번에 확인하는 데 사용한다.
+type PodStatus struct {
+	Name       string
+	ID         string
+	State      string                     // podman 의 pod 상태 (Created, Running, Degraded, Exited, Paused 등)
+	Containers map[string]ContainerStatus // 컨테이너 이름별 상태. 실행 중인 컨테이너는 healthcheck 결과(Healthy, Unhealthy)를 반영한다
+	Running    int                        // 실행 중인 컨테이너 수 (Running, Healthy, Unhealthy)
+	Failed     []string                   // 실패한 컨테이너 이름 (ExitedErr, Dead, Unhealthy). 이름 순
+}
+
+// AllHealthy 는 컨테이너가 하나 이상 있고 모두 실행 중이며 healthcheck 에 실패한 컨테이너가 없으면 true 이다.
+func (s *PodStatus) AllHealthy() bool {
+	if len(s.Containers) == 0 {
+		return false
+	}
+	for _, status := range s.Containers {
+		if status != Running && status != Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// AnyFailed 는 실패한 컨테이너가 하나라도 있으면 true 이다.
+func (s *PodStatus) AnyFailed() bool {
+	return len(s.Failed) > 0
+}
+
+// GetPodStatus 는 pod 를 inspect 하고 infra 를 제외한 컨테이너들의 상태를 모은다.
+// 확인하는 사이에 지워진 컨테이너는 포함하지 않는다.
+func GetPodStatus(ctx context.Context, nameOrID string) (*PodStatus, error) {
+	data, err := InspectPod(ctx, nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]ContainerStatus, len(data.Containers))
+	for _, c := range data.Containers {
+		if c.ID == data.InfraContainerID {
+			continue
+		}
+		info, err := containers.Inspect(ctx, c.ID, &containers.InspectOptions{Size: utils.PFalse})
+		if err != nil {
+			if isNotFoundErr(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to inspect container %q in pod %q: %w", c.Name, nameOrID, err)
+		}
+		statuses[c.Name] = podMemberStatus(info.State)
+	}
+	return newPodStatus(data, statuses), nil
+}
+
+// podMemberStatus 는 containerStatus 에 실행 중인 컨테이너의 healthcheck 결과를 더한다.
+func podMemberStatus(s *define.InspectContainerState) ContainerStatus {
+	status := containerStatus(s)
+	if status != Running || s.Health == nil {
+		return status
+	}
+	switch s.Health.Status {
+	case define.HealthCheckHealthy:
+		return Healthy
+	case define.HealthCheckUnhealthy:
+		return Unhealthy
+	default:
+		// healthcheck 가 아직 시작 중(starting)인 경우
+		return Running
+	}
+}
+
+// newPodStatus 는 컨테이너 이름별 상태로 실행 중인 컨테이너 수와 실패한 컨테이너 목록을 계산한다.
+func newPodStatus(data *define.InspectPodData, statuses map[string]ContainerStatus) *PodStatus {
+	ps := &PodStatus{
+		Name:       data.Name,
+		ID:         data.ID,
+		State:      data.State,
+		Containers: statuses,
+	}
+	for name, status := range statuses {
+		switch status {
+		case Running, Healthy:
+			ps.Running++
+		case Unhealthy:
+			ps.Running++
+			ps.Failed = append(ps.Failed, name)
+		case ExitedErr, Dead:
+			ps.Failed = append(ps.Failed, name)
+		}
+	}
+	sort.Strings(ps.Failed)
+	return ps
+}
@@ -3,10 +3,13 @@ package podbridge5
 import (
 	"context"
 	"errors"
+	"github.com/containers/podman/v5/libpod/define"
 	"github.com/containers/podman/v5/pkg/domain/entities"
 	"github.com/containers/podman/v5/pkg/specgen"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewPodSpec_NoOptions(t *testing.T) {
@@ -125,3 +128,70 @@ func TestWithPodNameSpec(t *testing.T) {
 		t.Errorf("expected spec.PodSpecGen.Name 'testpod', got %q", spec.PodSpecGen.Name)
 	}
 }
+
+func TestPodMemberStatus(t *testing.T) {
+	for _, tt := range []struct {
+		state *define.InspectContainerState
+		want  ContainerStatus
+	}{
+		{&define.InspectContainerState{Status: "created"}, Created},
+		{&define.InspectContainerState{Status: "running", Running: true}, Running},
+		{&define.InspectContainerState{Status: "running", Running: true, Health: &define.HealthCheckResults{Status: define.HealthCheckHealthy}}, Healthy},
+		{&define.InspectContainerState{Status: "running", Running: true, Health: &define.HealthCheckResults{Status: define.HealthCheckUnhealthy}}, Unhealthy},
+		{&define.InspectContainerState{Status: "running", Running: true, Health: &define.HealthCheckResults{Status: define.HealthCheckStarting}}, Running},
+		{&define.InspectContainerState{Status: "paused", Paused: true}, Paused},
+		{&define.InspectContainerState{Status: "exited"}, Exited},
+		{&define.InspectContainerState{Status: "exited", ExitCode: 2, Health: &define.HealthCheckResults{Status: define.HealthCheckHealthy}}, ExitedErr},
+	} {
+		if got := podMemberStatus(tt.state); got != tt.want {
+			t.Errorf("podMemberStatus(%+v) = %d, want %d", tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestNewPodStatus(t *testing.T) {
+	data := &define.InspectPodData{ID: "abc", Name: "pipeline", State: define.PodStateDegraded}
+	ps := newPodStatus(data, map[string]ContainerStatus{
+		"align":  Healthy,
+		"count":  Running,
+		"qc":     Unhealthy,
+		"trim":   ExitedErr,
+		"fetch":  Exited,
+		"report": Created,
+	})
+	if ps.Name != "pipeline" || ps.ID != "abc" || ps.State != define.PodStateDegraded {
+		t.Errorf("unexpected pod %+v", ps)
+	}
+	if ps.Running != 3 {
+		t.Errorf("expected 3 running containers, got %d", ps.Running)
+	}
+	if !reflect.DeepEqual(ps.Failed, []string{"qc", "trim"}) || !ps.AnyFailed() || ps.AllHealthy() {
+		t.Errorf("expected qc and trim to fail, got %v", ps.Failed)
+	}
+
+	ps = newPodStatus(data, map[string]ContainerStatus{"align": Healthy, "count": Running})
+	if !ps.AllHealthy() || ps.AnyFailed() || ps.Running != 2 {
+		t.Errorf("expected a healthy pod, got %+v", ps)
+	}
+	if newPodStatus(data, map[string]ContainerStatus{}).AllHealthy() {
+		t.Error("expected a pod without containers not to be healthy")
+	}
+}
+
+func TestPodLifecycle_NoConnection(t *testing.T) {
+	ctx := context.Background()
+	for action, fn := range map[string]func() error{
+		"start":   func() error { return StartPod(ctx, "pod1") },
+		"stop":    func() error { return StopPod(ctx, "pod1", time.Second) },
+		"pause":   func() error { return PausePod(ctx, "pod1") },
+		"unpause": func() error { return UnpausePod(ctx, "pod1") },
+		"restart": func() error { return RestartPod(ctx, "pod1") },
+	} {
+		if err := fn(); err == nil || !strings.Contains(err.Error(), "pod "+action+` failed for "pod1"`) {
+			t.Errorf("%s: unexpected error %v", action, err)
+		}
+	}
+	if _, err := GetPodStatus(ctx, "pod1"); err == nil || !strings.Contains(err.Error(), `inspect pod "pod1"`) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
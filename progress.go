@@ -0,0 +1,312 @@
+package podbridge5
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/containers/buildah"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 빌드 이벤트 종류
+const (
+	BuildEventStepStart  = "step-start"  // 단계 시작
+	BuildEventOutput     = "output"      // 단계의 stdout/stderr 한 줄
+	BuildEventStepDone   = "step-done"   // 단계 성공
+	BuildEventStepFailed = "step-failed" // 단계 실패
+)
+
+// 출력 스트림 이름
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// stepErrorTailLines 는 StepError 에 남길 마지막 출력 줄 수이다.
+const stepErrorTailLines = 20
+
+// podman build 출력의 단계 줄. 예: "STEP 2/5: RUN apk add curl"
+var podmanStepLine = regexp.MustCompile(`^STEP (\d+)(?:/(\d+))?: (.*)$`)
+
+// BuildEvent 는 빌드 진행 상황 하나를 나타낸다.
+type BuildEvent struct {
+	Type     string        // step-start, output, step-done, step-failed
+	Step     int           // 1 부터 시작하는 단계 번호
+	Total    int           // 전체 단계 수. 알 수 없으면 0
+	Command  string        // 단계에서 실행하는 명령 (예: "RUN mkdir -p /app")
+	Stream   string        // output 이벤트의 stdout 또는 stderr
+	Line     string        // output 이벤트의 출력 한 줄
+	Duration time.Duration // step-done, step-failed 이벤트의 소요 시간
+	Err      error         // step-failed 이벤트의 에러
+	Time     time.Time
+}
+
+// StepError 는 빌드 단계가 실패했을 때 반환되는 에러이다. 실패한 단계의 마지막 출력을 함께 담는다.
+type StepError struct {
+	Step    int
+	Command string
+	Output  []string // 마지막 출력 줄들 (stdout, stderr 합침)
+	Err     error
+}
+
+func (e *StepError) Error() string {
+	msg := fmt.Sprintf("step %d (%s) failed: %v", e.Step, e.Command, e.Err)
+	if len(e.Output) > 0 {
+		msg += "\n" + strings.Join(e.Output, "\n")
+	}
+	return msg
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// ImageBuildOption 은 CreateImage, CreateImageWithDockerfile 의 선택 설정이다.
+type ImageBuildOption func(*imageBuildOptions)
+
+type imageBuildOptions struct {
+	output     io.Writer
+	events     chan<- BuildEvent
+	noCache    bool
+	signingKey *SigningKey
+	vulnCheck  *VulnerabilityCheck
+	dryRun     *DryRun
+}
+
+func newImageBuildOptions(opts ...ImageBuildOption) *imageBuildOptions {
+	o := &imageBuildOptions{}
+	for _, apply := range opts {
+		apply(o)
+	}
+	return o
+}
+
+// WithBuildOutput 은 빌드 출력을 사람이 읽을 수 있는 형태로 w 에 쓴다.
+func WithBuildOutput(w io.Writer) ImageBuildOption {
+	return func(o *imageBuildOptions) {
+		o.output = w
+	}
+}
+
+// WithBuildEvents 는 빌드 이벤트를 ch 로 보낸다. 호출자는 빌드가 끝날 때까지 ch 를 계속 읽어야 한다.
+// ch 는 닫지 않는다.
+func WithBuildEvents(ch chan<- BuildEvent) ImageBuildOption {
+	return func(o *imageBuildOptions) {
+		o.events = ch
+	}
+}
+
+// buildProgress 는 빌드 단계를 세고, 이벤트와 출력을 전달한다. nil 이면 아무것도 하지 않는다.
+type buildProgress struct {
+	ctx    context.Context
+	output io.Writer
+	events chan<- BuildEvent
+	total  int
+
+	mu      sync.Mutex
+	current int
+	command string
+	started time.Time
+	tail    []string
+}
+
+// newBuildProgress 는 출력이나 이벤트 채널이 지정되지 않았으면 nil 을 반환한다.
+func newBuildProgress(ctx context.Context, total int, o *imageBuildOptions) *buildProgress {
+	if o.output == nil && o.events == nil {
+		return nil
+	}
+	return &buildProgress{ctx: ctx, output: o.output, events: o.events, total: total}
+}
+
+// step 은 command 단계를 시작하고 fn 을 실행한 뒤 결과를 알린다. fn 에는 단계의 stdout, stderr 가 전달된다.
+// fn 이 실패하면 마지막 출력을 담은 StepError 를 반환한다.
+func (p *buildProgress) step(command string, fn func(stdout, stderr io.Writer) error) error {
+	if p == nil {
+		return fn(nil, nil)
+	}
+	p.start(command)
+	stdout := p.lineWriter(StreamStdout)
+	stderr := p.lineWriter(StreamStderr)
+	err := fn(stdout, stderr)
+	stdout.flush()
+	stderr.flush()
+	return p.finish(err)
+}
+
+// run 은 builder 안에서 args 를 하나의 단계로 실행한다.
+func (p *buildProgress) run(builder *buildah.Builder, args []string) error {
+	return p.step("RUN "+strings.Join(args, " "), func(stdout, stderr io.Writer) error {
+		opts := defaultRunOptions
+		opts.Stdout = stdout
+		opts.Stderr = stderr
+		return builder.Run(args, opts)
+	})
+}
+
+// note 는 단계와 상관없는 안내 문구를 출력한다.
+func (p *buildProgress) note(format string, args ...any) {
+	if p == nil {
+		return
+	}
+	p.printf(format+"\n", args...)
+}
+
+// extend 는 지금까지의 단계 뒤에 n 개의 단계가 더 있다고 알린다.
+func (p *buildProgress) extend(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.total = p.current + n
+	p.mu.Unlock()
+}
+
+func (p *buildProgress) start(command string) {
+	p.mu.Lock()
+	p.current++
+	p.command = command
+	p.started = time.Now()
+	p.tail = nil
+	step, total := p.current, p.total
+	p.mu.Unlock()
+
+	if total > 0 {
+		p.printf("STEP %d/%d: %s\n", step, total, command)
+	} else {
+		p.printf("STEP %d: %s\n", step, command)
+	}
+	p.emit(BuildEvent{Type: BuildEventStepStart, Step: step, Total: total, Command: command})
+}
+
+func (p *buildProgress) finish(err error) error {
+	p.mu.Lock()
+	step, total, command := p.current, p.total, p.command
+	elapsed := time.Since(p.started)
+	tail := append([]string(nil), p.tail...)
+	p.mu.Unlock()
+
+	if err != nil {
+		p.printf("--> step %d failed after %s: %v\n", step, elapsed.Round(time.Millisecond), err)
+		p.emit(BuildEvent{Type: BuildEventStepFailed, Step: step, Total: total, Command: command, Duration: elapsed, Err: err})
+		return &StepError{Step: step, Command: command, Output: tail, Err: err}
+	}
+	p.printf("--> step %d done in %s\n", step, elapsed.Round(time.Millisecond))
+	p.emit(BuildEvent{Type: BuildEventStepDone, Step: step, Total: total, Command: command, Duration: elapsed})
+	return nil
+}
+
+func (p *buildProgress) line(stream, text string) {
+	p.mu.Lock()
+	step, total, command := p.current, p.total, p.command
+	p.tail = append(p.tail, text)
+	if len(p.tail) > stepErrorTailLines {
+		p.tail = p.tail[len(p.tail)-stepErrorTailLines:]
+	}
+	p.mu.Unlock()
+
+	p.printf("%s\n", text)
+	p.emit(BuildEvent{Type: BuildEventOutput, Step: step, Total: total, Command: command, Stream: stream, Line: text})
+}
+
+func (p *buildProgress) printf(format string, args ...any) {
+	if p.output == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := fmt.Fprintf(p.output, format, args...); err != nil {
+		Log.Warnf("failed to write build output: %v", err)
+	}
+}
+
+// emit 은 이벤트를 보낸다. 컨텍스트가 취소되면 보내지 않는다.
+func (p *buildProgress) emit(ev BuildEvent) {
+	if p.events == nil {
+		return
+	}
+	ev.Time = time.Now()
+	select {
+	case p.events <- ev:
+	case <-p.ctx.Done():
+	}
+}
+
+// podmanOutput 은 podman build 의 출력을 받아 "STEP n/m: ..." 줄을 단계 이벤트로 바꾸는 Writer 를 반환한다.
+// 반환된 함수는 빌드가 끝난 뒤 마지막 단계를 마무리하기 위해 호출해야 한다.
+func (p *buildProgress) podmanOutput() (io.Writer, func(err error) error) {
+	if p == nil {
+		return nil, func(err error) error { return err }
+	}
+	inStep := false
+	w := &progressLineWriter{emit: func(text string) {
+		if m := podmanStepLine.FindStringSubmatch(text); m != nil {
+			if inStep {
+				_ = p.finish(nil)
+			}
+			p.mu.Lock()
+			if n, err := strconv.Atoi(m[2]); err == nil {
+				p.total = n
+			}
+			// podman 이 알려준 번호를 따르도록 start 에서 증가시킬 값을 맞춘다.
+			if n, err := strconv.Atoi(m[1]); err == nil {
+				p.current = n - 1
+			}
+			p.mu.Unlock()
+			p.start(m[3])
+			inStep = true
+			return
+		}
+		p.line(StreamStdout, text)
+	}}
+	return w, func(err error) error {
+		w.flush()
+		if !inStep {
+			return err
+		}
+		if fErr := p.finish(err); fErr != nil {
+			return fErr
+		}
+		return err
+	}
+}
+
+func (p *buildProgress) lineWriter(stream string) *progressLineWriter {
+	return &progressLineWriter{emit: func(text string) { p.line(stream, text) }}
+}
+
+// progressLineWriter 는 쓰여진 바이트를 줄 단위로 나누어 emit 을 호출한다.
+type progressLineWriter struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	emit func(string)
+}
+
+func (w *progressLineWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(b)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		text := strings.TrimRight(string(w.buf.Next(i+1)), "\r\n")
+		w.emit(text)
+	}
+	return len(b), nil
+}
+
+// flush 는 줄바꿈 없이 남아 있는 출력을 내보낸다.
+func (w *progressLineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf.Len() > 0 {
+		w.emit(strings.TrimRight(w.buf.String(), "\r\n"))
+		w.buf.Reset()
+	}
+}
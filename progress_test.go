@@ -0,0 +1,161 @@
+package podbridge5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// collectEvents 는 fn 이 끝날 때까지 보낸 이벤트를 모은다.
+func collectEvents(t *testing.T, total int, fn func(p *buildProgress)) ([]BuildEvent, string) {
+	t.Helper()
+	ch := make(chan BuildEvent)
+	var out bytes.Buffer
+	p := newBuildProgress(context.Background(), total, newImageBuildOptions(WithBuildEvents(ch), WithBuildOutput(&out)))
+
+	done := make(chan []BuildEvent)
+	go func() {
+		var events []BuildEvent
+		for ev := range ch {
+			events = append(events, ev)
+		}
+		done <- events
+	}()
+	fn(p)
+	close(ch)
+	return <-done, out.String()
+}
+
+func TestNewBuildProgress_NoOptions(t *testing.T) {
+	p := newBuildProgress(context.Background(), 3, newImageBuildOptions(WithNoCache()))
+	if p != nil {
+		t.Fatal("expected nil progress without options")
+	}
+	// nil 이어도 단계는 그대로 실행되어야 한다.
+	called := false
+	if err := p.step("RUN true", func(stdout, stderr io.Writer) error {
+		called = true
+		if stdout != nil || stderr != nil {
+			t.Error("expected nil writers without progress")
+		}
+		return nil
+	}); err != nil || !called {
+		t.Fatalf("expected step to run, called=%v err=%v", called, err)
+	}
+	p.extend(2)
+}
+
+func TestBuildProgress_StepEvents(t *testing.T) {
+	events, out := collectEvents(t, 2, func(p *buildProgress) {
+		if err := p.step("RUN echo hi", func(stdout, stderr io.Writer) error {
+			fmt.Fprint(stdout, "hello\nwor")
+			fmt.Fprint(stdout, "ld\n")
+			fmt.Fprint(stderr, "warning")
+			return nil
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	var types []string
+	for _, ev := range events {
+		types = append(types, ev.Type)
+		if ev.Step != 1 || ev.Total != 2 || ev.Command != "RUN echo hi" {
+			t.Errorf("unexpected event %+v", ev)
+		}
+	}
+	want := []string{BuildEventStepStart, BuildEventOutput, BuildEventOutput, BuildEventOutput, BuildEventStepDone}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected events %v, got %v", want, types)
+	}
+	if events[1].Line != "hello" || events[2].Line != "world" || events[3].Line != "warning" || events[3].Stream != StreamStderr {
+		t.Errorf("unexpected output events %+v", events[1:4])
+	}
+	if !strings.HasPrefix(out, "STEP 1/2: RUN echo hi\nhello\nworld\nwarning\n--> step 1 done in ") {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestBuildProgress_StepError(t *testing.T) {
+	cause := errors.New("exit status 1")
+	var stepErr *StepError
+	events, _ := collectEvents(t, 0, func(p *buildProgress) {
+		err := p.step("RUN /app/install.sh", func(stdout, stderr io.Writer) error {
+			for i := 0; i < stepErrorTailLines+5; i++ {
+				fmt.Fprintf(stderr, "line %d\n", i)
+			}
+			return cause
+		})
+		if !errors.As(err, &stepErr) {
+			t.Fatalf("expected StepError, got %v", err)
+		}
+		if !errors.Is(err, cause) {
+			t.Error("expected StepError to unwrap to the cause")
+		}
+	})
+
+	if len(stepErr.Output) != stepErrorTailLines || stepErr.Output[len(stepErr.Output)-1] != fmt.Sprintf("line %d", stepErrorTailLines+4) {
+		t.Errorf("expected last %d lines, got %v", stepErrorTailLines, stepErr.Output)
+	}
+	if !strings.Contains(stepErr.Error(), "RUN /app/install.sh") || !strings.Contains(stepErr.Error(), "line 24") {
+		t.Errorf("expected command and output in error, got %q", stepErr.Error())
+	}
+	last := events[len(events)-1]
+	if last.Type != BuildEventStepFailed || !errors.Is(last.Err, cause) {
+		t.Errorf("expected step-failed event, got %+v", last)
+	}
+}
+
+func TestBuildProgress_PodmanOutput(t *testing.T) {
+	events, _ := collectEvents(t, 0, func(p *buildProgress) {
+		w, done := p.podmanOutput()
+		fmt.Fprint(w, "STEP 1/2: FROM alpine\nSTEP 2/2: RUN apk add curl\nfetch index\n")
+		fmt.Fprint(w, "COMMIT tester")
+		if err := done(nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// Dockerfile 빌드 뒤의 단계는 이어서 번호가 매겨진다.
+		p.extend(1)
+		_ = p.step("COMMIT tester-internal", func(io.Writer, io.Writer) error { return nil })
+	})
+
+	var starts []string
+	for _, ev := range events {
+		if ev.Type == BuildEventStepStart {
+			starts = append(starts, fmt.Sprintf("%d/%d %s", ev.Step, ev.Total, ev.Command))
+		}
+	}
+	want := []string{"1/2 FROM alpine", "2/2 RUN apk add curl", "3/3 COMMIT tester-internal"}
+	if strings.Join(starts, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected steps %v, got %v", want, starts)
+	}
+}
+
+func TestBuildProgress_PodmanOutputError(t *testing.T) {
+	cause := errors.New("build failed")
+	collectEvents(t, 0, func(p *buildProgress) {
+		w, done := p.podmanOutput()
+		fmt.Fprint(w, "STEP 1/1: RUN false\nerror running container\n")
+		err := done(cause)
+		var stepErr *StepError
+		if !errors.As(err, &stepErr) || stepErr.Step != 1 || !errors.Is(err, cause) {
+			t.Fatalf("expected StepError for step 1, got %v", err)
+		}
+	})
+}
+
+func TestImageConfigBuildSteps(t *testing.T) {
+	img := NewConfig("docker.io/library/alpine:latest").Image
+	// mkdir 2 + COPY 3 + chmod + install + commit + save
+	if got := img.buildSteps(); got != 9 {
+		t.Errorf("expected 9 steps, got %d", got)
+	}
+	img.Packages = nil
+	if got := img.buildSteps(); got != 8 {
+		t.Errorf("expected no package install step, got %d steps", got)
+	}
+}
@@ -0,0 +1,233 @@
+package podbridge5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/containers/podman/v5/pkg/bindings/manifests"
+	"github.com/seoyhaein/utils"
+	"io"
+	"time"
+)
+
+// push 할 때 사용할 매니페스트 형식 (podman push --format 값)
+const (
+	ManifestFormatOCI        = "oci"
+	ManifestFormatDockerV2S2 = "v2s2"
+	ManifestFormatDockerV2S1 = "v2s1"
+)
+
+const (
+	defaultPushRetries    = 3
+	defaultPushRetryDelay = time.Second
+	maxPushRetryDelay     = time.Minute
+)
+
+// NoPushRetries 를 PushOptions.Retries 에 넣으면 실패해도 다시 시도하지 않는다.
+const NoPushRetries = -1
+
+// CredentialsFunc 는 레지스트리 도메인(예: "registry.internal:5000")을 받아 사용자 이름과 비밀번호를 돌려준다.
+type CredentialsFunc func(registry string) (username, password string, err error)
+
+// PushOptions 는 PushImage 의 설정이다.
+type PushOptions struct {
+	AuthFile         string           // 인증 파일 경로 (containers-auth.json 형식). Credentials 보다 우선한다.
+	Credentials      CredentialsFunc  // 인증 파일이 없을 때 사용할 콜백
+	SkipTLSVerify    *bool            // nil 이면 등록된 RegistryConfig 의 insecure 설정을 따른다.
+	Retries          int              // 실패 시 재시도 횟수. 0 이면 3, NoPushRetries(음수)면 재시도하지 않는다
+	RetryDelay       time.Duration    // 첫 재시도 대기 시간, 이후 두 배씩 늘어난다(최대 1분). 0 이면 1초
+	Progress         io.Writer        // 진행 상황 출력. nil 이면 출력하지 않는다.
+	Format           string           // oci, v2s2, v2s1. 비어 있으면 v2s2 (CreateImage 의 커밋 형식과 동일)
+	RemoveSignatures bool             // 기존 서명을 제거하고 push
+	SigningKey       *SigningKey      // 있으면 push 하기 전에 이 키로 서명한다 (PushImage 만 해당)
+	Encryption       *ImageEncryption // 있으면 레이어를 ocicrypt 로 암호화해서 oci 형식으로 push 한다 (PushImage 만 해당)
+}
+
+// PushImage 는 로컬 스토리지의 이미지(name)를 레지스트리(dest)로 push 한다.
+// dest 가 비어 있으면 name 과 같은 이름으로 push 한다.
+// 실패하면 RetryDelay 부터 두 배씩(최대 1분) 늘려가며 Retries 만큼 다시 시도한다.
+func PushImage(ctx context.Context, name, dest string, opts *PushOptions) error {
+	if opts != nil && opts.Encryption != nil {
+		// 암호화하면 매니페스트가 바뀌므로 기존 서명은 유효하지 않게 된다.
+		if opts.SigningKey != nil {
+			return errors.New("cannot sign an image that is pushed encrypted")
+		}
+		if opts.Format != "" && opts.Format != ManifestFormatOCI {
+			return fmt.Errorf("layer encryption requires the %s manifest format", ManifestFormatOCI)
+		}
+		if err := opts.Encryption.Validate(); err != nil {
+			return err
+		}
+		return pushWithRetry(ctx, name, dest, opts, func(dest string, pushOpts *images.PushOptions) error {
+			return pushEncrypted(ctx, name, dest, opts.Encryption, pushOpts)
+		})
+	}
+	if opts != nil && opts.SigningKey != nil {
+		if opts.RemoveSignatures {
+			return errors.New("cannot sign and remove signatures at the same time")
+		}
+		if err := SignImage(ctx, name, opts.SigningKey); err != nil {
+			return err
+		}
+	}
+	return pushWithRetry(ctx, name, dest, opts, func(dest string, pushOpts *images.PushOptions) error {
+		return images.Push(ctx, name, dest, pushOpts)
+	})
+}
+
+// PushManifestList 는 매니페스트 리스트(name)와 리스트에 포함된 모든 플랫폼의 이미지를 레지스트리(dest)로 push 하고, 리스트의 digest 를 반환한다.
+// dest 와 opts 는 PushImage 와 같다.
+func PushManifestList(ctx context.Context, name, dest string, opts *PushOptions) (string, error) {
+	if opts != nil && opts.Encryption != nil {
+		return "", errors.New("layer encryption is not supported for manifest lists")
+	}
+	var listDigest string
+	err := pushWithRetry(ctx, name, dest, opts, func(dest string, pushOpts *images.PushOptions) error {
+		var err error
+		listDigest, err = manifests.Push(ctx, name, dest, pushOpts.WithAll(true))
+		return err
+	})
+	return listDigest, err
+}
+
+// pushWithRetry 는 dest 를 해석하고 인증, TLS 설정을 적용한 뒤 push 를 재시도한다.
+func pushWithRetry(ctx context.Context, name, dest string, opts *PushOptions, push func(dest string, pushOpts *images.PushOptions) error) error {
+	if utils.IsEmptyString(name) {
+		return errors.New("image name cannot be empty")
+	}
+	if utils.IsEmptyString(dest) {
+		dest = name
+	}
+	if opts == nil {
+		opts = &PushOptions{}
+	}
+
+	named, err := reference.ParseNormalizedNamed(dest)
+	if err != nil {
+		return fmt.Errorf("failed to parse destination %q: %w", dest, err)
+	}
+	named = reference.TagNameOnly(named)
+	registry := reference.Domain(named)
+
+	pushOpts, err := opts.toBindings(registry, named.String())
+	if err != nil {
+		return err
+	}
+
+	retries := opts.Retries
+	switch {
+	case retries < 0:
+		retries = 0
+	case retries == 0:
+		retries = defaultPushRetries
+	}
+	delay := opts.RetryDelay
+	if delay <= 0 {
+		delay = defaultPushRetryDelay
+	}
+
+	attempt := 0
+	err = pushBackoff(ctx, retries, delay, func() error {
+		attempt++
+		if attempt > 1 {
+			Log.Warnf("retrying push of %s to %s (attempt %d)", name, named.String(), attempt)
+		}
+		return push(named.String(), pushOpts)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push image %s to %s: %w", name, named.String(), err)
+	}
+	return nil
+}
+
+// pushBackoff 는 fn 이 실패하면 delay 부터 두 배씩 늘려가며 retries 만큼 다시 시도한다.
+// volume 쪽 withRetry 는 대기 시간을 2초로 제한하므로, 레지스트리 장애를 기다리는 push 는 따로 둔다.
+func pushBackoff(ctx context.Context, retries int, delay time.Duration, fn func() error) error {
+	var err error
+	for i := 0; ; i++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err = fn(); err == nil || i == retries {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay = nextPushDelay(delay)
+	}
+}
+
+// nextPushDelay 는 다음 재시도 대기 시간이다. 두 배로 늘리되 maxPushRetryDelay 를 넘지 않는다.
+func nextPushDelay(delay time.Duration) time.Duration {
+	if delay >= maxPushRetryDelay/2 {
+		return maxPushRetryDelay
+	}
+	return delay * 2
+}
+
+// toBindings 는 PushOptions 를 podman bindings 의 PushOptions 로 변환한다.
+func (o *PushOptions) toBindings(registry, dest string) (*images.PushOptions, error) {
+	format := o.Format
+	if format == "" {
+		format = ManifestFormatDockerV2S2
+	}
+	switch format {
+	case ManifestFormatOCI, ManifestFormatDockerV2S2, ManifestFormatDockerV2S1:
+	default:
+		return nil, fmt.Errorf("unknown manifest format %q", format)
+	}
+
+	// 재시도는 PushImage 에서 하므로 podman 쪽 재시도는 끈다.
+	pushOpts := new(images.PushOptions).
+		WithFormat(format).
+		WithRetry(0).
+		WithRemoveSignatures(o.RemoveSignatures)
+
+	if o.Progress != nil {
+		pushOpts = pushOpts.WithProgressWriter(o.Progress)
+	} else {
+		pushOpts = pushOpts.WithQuiet(true)
+	}
+
+	switch {
+	case !utils.IsEmptyString(o.AuthFile):
+		if exists, _, err := utils.FileExists(o.AuthFile); err != nil || !exists {
+			return nil, fmt.Errorf("auth file %s does not exist", o.AuthFile)
+		}
+		pushOpts = pushOpts.WithAuthfile(o.AuthFile)
+	case o.Credentials != nil:
+		user, pass, err := o.Credentials(registry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get credentials for %s: %w", registry, err)
+		}
+		pushOpts = pushOpts.WithUsername(user).WithPassword(pass)
+	}
+
+	if o.SkipTLSVerify != nil {
+		pushOpts = pushOpts.WithSkipTLSVerify(*o.SkipTLSVerify)
+	} else if insecureRegistry(dest) {
+		pushOpts = pushOpts.WithSkipTLSVerify(true)
+	}
+	return pushOpts, nil
+}
+
+// insecureRegistry 는 SetRegistryConfig 로 등록된 설정에서 ref 의 레지스트리가 insecure 인지 확인한다.
+func insecureRegistry(ref string) bool {
+	registryMu.RLock()
+	sysCtx := pbSysCtx
+	registryMu.RUnlock()
+	if sysCtx == nil {
+		return false
+	}
+	reg, err := sysregistriesv2.FindRegistry(sysCtx, ref)
+	if err != nil || reg == nil {
+		return false
+	}
+	return reg.Insecure
+}
@@ -0,0 +1,145 @@
+package podbridge5
+
+import (
+	"context"
+	"errors"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPushOptionsToBindings(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		opts, err := (&PushOptions{}).toBindings("docker.io", "docker.io/library/tester:latest")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if opts.GetFormat() != ManifestFormatDockerV2S2 {
+			t.Errorf("expected default format %q, got %q", ManifestFormatDockerV2S2, opts.GetFormat())
+		}
+		if opts.GetRetry() != 0 {
+			t.Errorf("expected podman retry to be disabled, got %d", opts.GetRetry())
+		}
+		if !opts.GetQuiet() {
+			t.Error("expected quiet push without progress writer")
+		}
+		if opts.SkipTLSVerify != nil {
+			t.Error("expected TLS verification to be left to podman")
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		if _, err := (&PushOptions{Format: "tarball"}).toBindings("docker.io", "docker.io/library/tester:latest"); err == nil {
+			t.Fatal("expected error for unknown format")
+		}
+	})
+
+	t.Run("missing auth file", func(t *testing.T) {
+		o := &PushOptions{AuthFile: filepath.Join(t.TempDir(), "auth.json")}
+		if _, err := o.toBindings("docker.io", "docker.io/library/tester:latest"); err == nil {
+			t.Fatal("expected error for missing auth file")
+		}
+	})
+
+	t.Run("credentials callback", func(t *testing.T) {
+		var asked string
+		o := &PushOptions{Credentials: func(registry string) (string, string, error) {
+			asked = registry
+			return "user", "secret", nil
+		}}
+		opts, err := o.toBindings("registry.internal:5000", "registry.internal:5000/tester:latest")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if asked != "registry.internal:5000" {
+			t.Errorf("expected callback for registry.internal:5000, got %q", asked)
+		}
+		if opts.GetUsername() != "user" || opts.GetPassword() != "secret" {
+			t.Error("expected credentials from callback")
+		}
+	})
+
+	t.Run("credentials callback error", func(t *testing.T) {
+		o := &PushOptions{Credentials: func(string) (string, string, error) { return "", "", errors.New("vault sealed") }}
+		if _, err := o.toBindings("docker.io", "docker.io/library/tester:latest"); err == nil {
+			t.Fatal("expected callback error to be returned")
+		}
+	})
+
+	t.Run("insecure registry from registry config", func(t *testing.T) {
+		t.Cleanup(func() { _ = SetRegistryConfig(nil, "") })
+		if err := SetRegistryConfig(&RegistryConfig{Insecure: []string{"registry.internal:5000"}}, t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		opts, err := (&PushOptions{}).toBindings("registry.internal:5000", "registry.internal:5000/tester:latest")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !opts.GetSkipTLSVerify() {
+			t.Error("expected TLS verification to be skipped for insecure registry")
+		}
+	})
+
+	t.Run("auth file", func(t *testing.T) {
+		authFile := filepath.Join(t.TempDir(), "auth.json")
+		if err := os.WriteFile(authFile, []byte(`{"auths":{}}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		opts, err := (&PushOptions{AuthFile: authFile}).toBindings("docker.io", "docker.io/library/tester:latest")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if opts.GetAuthfile() != authFile {
+			t.Errorf("expected auth file %q, got %q", authFile, opts.GetAuthfile())
+		}
+	})
+}
+
+func TestPushImage_InvalidInput(t *testing.T) {
+	if err := PushImage(context.Background(), "", "", nil); err == nil {
+		t.Fatal("expected error for empty image name")
+	}
+	if err := PushImage(context.Background(), "tester", "INVALID//dest", nil); err == nil {
+		t.Fatal("expected error for invalid destination")
+	}
+}
+
+func TestPushWithRetry_Attempts(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+		want    int
+	}{
+		{"no retries", NoPushRetries, 1},
+		{"two retries", 2, 3},
+		{"default", 0, defaultPushRetries + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := pushWithRetry(context.Background(), "tester:latest", "", &PushOptions{Retries: tt.retries, RetryDelay: time.Millisecond}, func(string, *images.PushOptions) error {
+				calls++
+				return errors.New("registry unavailable")
+			})
+			if err == nil {
+				t.Fatal("expected push to fail")
+			}
+			if calls != tt.want {
+				t.Errorf("expected %d attempts, got %d", tt.want, calls)
+			}
+		})
+	}
+}
+
+func TestNextPushDelay(t *testing.T) {
+	// withRetry 와 달리 2초에서 멈추지 않고 두 배씩 늘어나며, maxPushRetryDelay 에서 멈춘다.
+	delay := 2 * time.Second
+	for _, want := range []time.Duration{4 * time.Second, 8 * time.Second, 16 * time.Second, 32 * time.Second, maxPushRetryDelay, maxPushRetryDelay} {
+		delay = nextPushDelay(delay)
+		if delay != want {
+			t.Fatalf("expected %v, got %v", want, delay)
+		}
+	}
+}
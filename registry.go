@@ -0,0 +1,504 @@
+package podbridge5
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/shortnames"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/transports"
+	imageTypes "github.com/containers/image/v5/types"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/opencontainers/go-digest"
+	"github.com/seoyhaein/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// policy.json 에서 사용하는 서명 정책 타입
+const (
+	PolicyInsecureAcceptAnything = "insecureAcceptAnything"
+	PolicyReject                 = "reject"
+	PolicySignedBy               = "signedBy"
+	PolicySigstoreSigned         = "sigstoreSigned"
+)
+
+const (
+	registriesConfFileName = "registries.conf"
+	policyFileName         = "policy.json"
+)
+
+var (
+	ErrRegistryBlocked = errors.New("registry is blocked")
+	ErrPolicyRejected  = errors.New("image rejected by signature policy")
+
+	// registryMu 는 SetRegistryConfig 로 설정된 시스템 컨텍스트를 보호한다.
+	registryMu sync.RWMutex
+	pbSysCtx   *imageTypes.SystemContext
+)
+
+// RegistryConfig 는 빌드와 pull 에 사용할 레지스트리 설정이다.
+// 호스트 전역 설정(/etc/containers/registries.conf, policy.json)을 건드리지 않고
+// podbridge5 전용 파일로 렌더링되어 SystemContext 를 통해 전달된다.
+// 폐쇄망에서는 Mirrors 로 내부 미러를 지정하면 된다.
+type RegistryConfig struct {
+	SearchRegistries []string            `json:"searchRegistries"` // 예: ["registry.internal:5000", "docker.io"]
+	Mirrors          map[string][]string `json:"mirrors"`          // 레지스트리 prefix -> 미러 위치 목록 (순서대로 시도)
+	Blocked          []string            `json:"blocked"`          // pull 을 차단할 레지스트리 prefix
+	Insecure         []string            `json:"insecure"`         // TLS 검증을 하지 않을 레지스트리 또는 미러 위치
+	DefaultPolicy    string              `json:"defaultPolicy"`    // 비어 있으면 insecureAcceptAnything
+	Policies         []SignaturePolicy   `json:"policies"`         // scope 별 서명 정책
+}
+
+// SignaturePolicy 는 policy.json 의 transport/scope 하나에 대한 요구 사항이다.
+type SignaturePolicy struct {
+	Transport string `json:"transport"` // 비어 있으면 "docker"
+	Scope     string `json:"scope"`     // 예: "registry.internal:5000/pipeline"
+	Type      string `json:"type"`      // insecureAcceptAnything, reject, signedBy, sigstoreSigned
+	KeyPath   string `json:"keyPath"`   // signedBy, sigstoreSigned 일 때 공개키 경로
+}
+
+// Validate 는 RegistryConfig 의 값들이 렌더링 가능한지 확인한다.
+func (rc *RegistryConfig) Validate() error {
+	if rc == nil {
+		return errors.New("registry config is nil")
+	}
+	for prefix, mirrors := range rc.Mirrors {
+		if utils.IsEmptyString(prefix) {
+			return errors.New("mirror prefix cannot be empty")
+		}
+		if len(mirrors) == 0 {
+			return fmt.Errorf("no mirror locations for %q", prefix)
+		}
+		for _, m := range mirrors {
+			if utils.IsEmptyString(m) {
+				return fmt.Errorf("empty mirror location for %q", prefix)
+			}
+		}
+	}
+	if rc.DefaultPolicy != "" && rc.DefaultPolicy != PolicyInsecureAcceptAnything && rc.DefaultPolicy != PolicyReject {
+		return fmt.Errorf("default policy must be %q or %q, got %q", PolicyInsecureAcceptAnything, PolicyReject, rc.DefaultPolicy)
+	}
+	for _, p := range rc.Policies {
+		if _, err := p.requirement(); err != nil {
+			return fmt.Errorf("policy for scope %q: %w", p.Scope, err)
+		}
+	}
+	return nil
+}
+
+// Render 는 dir 아래에 registries.conf 와 policy.json 을 작성하고, 이 파일들을 가리키는 SystemContext 를 반환한다.
+// dir 이 비어 있으면 사용자 설정 디렉토리 아래 podbridge5 디렉토리를 사용한다.
+func (rc *RegistryConfig) Render(dir string) (*imageTypes.SystemContext, error) {
+	if err := rc.Validate(); err != nil {
+		return nil, err
+	}
+	if utils.IsEmptyString(dir) {
+		confDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user config dir: %w", err)
+		}
+		dir = filepath.Join(confDir, "podbridge5")
+	}
+	// registries.conf.d 는 비워둔 디렉토리를 지정해 호스트의 drop-in 설정이 섞이지 않도록 한다.
+	dropInDir := filepath.Join(dir, "registries.conf.d")
+	if err := os.MkdirAll(dropInDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dropInDir, err)
+	}
+
+	regData, err := rc.registriesConf()
+	if err != nil {
+		return nil, err
+	}
+	regPath := filepath.Join(dir, registriesConfFileName)
+	if err := writeFileAtomic(regPath, regData, 0o600); err != nil {
+		return nil, err
+	}
+
+	policyData, err := rc.policyJSON()
+	if err != nil {
+		return nil, err
+	}
+	policyPath := filepath.Join(dir, policyFileName)
+	if err := writeFileAtomic(policyPath, policyData, 0o600); err != nil {
+		return nil, err
+	}
+
+	return &imageTypes.SystemContext{
+		SystemRegistriesConfPath:    regPath,
+		SystemRegistriesConfDirPath: dropInDir,
+		SignaturePolicyPath:         policyPath,
+	}, nil
+}
+
+// SetRegistryConfig 는 rc 를 dir 에 렌더링하고, 이후 newBuilder, buildImageFromDockerfile, CreateContainer 의 pull 에서 사용되도록 등록한다.
+// rc 가 nil 이면 등록된 설정을 해제하고 기본 설정으로 되돌린다.
+func SetRegistryConfig(rc *RegistryConfig, dir string) error {
+	if rc == nil {
+		registryMu.Lock()
+		pbSysCtx = nil
+		registryMu.Unlock()
+		sysregistriesv2.InvalidateCache()
+		return nil
+	}
+	sysCtx, err := rc.Render(dir)
+	if err != nil {
+		return fmt.Errorf("failed to render registry config: %w", err)
+	}
+	// 렌더링 결과가 containers/image 에서 읽히는지 미리 확인한다.
+	sysregistriesv2.InvalidateCache()
+	if _, err := sysregistriesv2.GetRegistries(sysCtx); err != nil {
+		return fmt.Errorf("rendered registries.conf is invalid: %w", err)
+	}
+	if _, err := signature.DefaultPolicy(sysCtx); err != nil {
+		return fmt.Errorf("rendered policy.json is invalid: %w", err)
+	}
+
+	registryMu.Lock()
+	pbSysCtx = sysCtx
+	registryMu.Unlock()
+	return nil
+}
+
+// systemContext 는 등록된 SystemContext 의 복사본을 반환한다. 등록된 것이 없으면 빈 SystemContext 를 반환한다.
+func systemContext() *imageTypes.SystemContext {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	if pbSysCtx == nil {
+		return &imageTypes.SystemContext{}
+	}
+	sysCtx := *pbSysCtx
+	return &sysCtx
+}
+
+// pullImage 는 등록된 레지스트리 설정에 따라 이미지를 pull 한다.
+// 짧은 이름은 SearchRegistries 순서대로 시도하고, 처음 pull 에 성공한 이름을 사용한다.
+// 차단된 레지스트리는 에러를 반환하고, 미러가 있으면 미러부터 순서대로 시도한 뒤 원래 이름으로 태그를 붙인다.
+// podman 은 자신의 policy.json 으로 pull 하므로, 렌더링한 policy.json 은 pullVerified 가 pull 전에 직접 적용한다.
+// podman 서비스는 자신의 registries.conf 를 사용하므로, 짧은 이름과 미러 해석은 여기서 직접 수행한다.
+func pullImage(ctx context.Context, image string) error {
+	registryMu.RLock()
+	sysCtx := pbSysCtx
+	registryMu.RUnlock()
+	if sysCtx == nil {
+		if _, err := images.Pull(ctx, image, &images.PullOptions{}); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	candidates, err := resolvePullNames(sysCtx, image)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, named := range candidates {
+		err := pullNamed(ctx, sysCtx, named)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return fmt.Errorf("failed to pull %q: %w", image, errors.Join(errs...))
+}
+
+// resolvePullNames 는 image 를 pull 할 완전한 이름들로 해석한다.
+// 짧은 이름은 short-name alias 가 있으면 그것을, 없으면 SearchRegistries 마다 하나씩 후보를 만든다.
+// SearchRegistries 가 없으면 이전처럼 docker.io 로 해석한다.
+func resolvePullNames(sysCtx *imageTypes.SystemContext, image string) ([]reference.Named, error) {
+	if shortnames.IsShortName(image) {
+		search, err := sysregistriesv2.UnqualifiedSearchRegistries(sysCtx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read search registries: %w", err)
+		}
+		if len(search) == 0 {
+			named, err := reference.ParseNormalizedNamed(image)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse image reference %q: %w", image, err)
+			}
+			return []reference.Named{reference.TagNameOnly(named)}, nil
+		}
+	}
+	// 라이브러리가 터미널에서 후보를 고르라고 묻지 않도록 short-name 모드를 끈다. 후보는 모두 순서대로 시도한다.
+	resolveCtx := *sysCtx
+	disabled := imageTypes.ShortNameModeDisabled
+	resolveCtx.ShortNameMode = &disabled
+	resolved, err := shortnames.Resolve(&resolveCtx, image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve image name %q: %w", image, err)
+	}
+	if desc := resolved.Description(); desc != "" {
+		Log.Debug(desc)
+	}
+	names := make([]reference.Named, 0, len(resolved.PullCandidates))
+	for _, c := range resolved.PullCandidates {
+		names = append(names, c.Value)
+	}
+	return names, nil
+}
+
+// pullNamed 는 완전한 이름 named 를 레지스트리 설정(차단, 미러)에 따라 pull 한다.
+func pullNamed(ctx context.Context, sysCtx *imageTypes.SystemContext, named reference.Named) error {
+	reg, err := sysregistriesv2.FindRegistry(sysCtx, named.String())
+	if err != nil {
+		return fmt.Errorf("failed to find registry for %q: %w", named.String(), err)
+	}
+	if reg == nil {
+		return pullVerified(ctx, sysCtx, named, named, false)
+	}
+	if reg.Blocked {
+		return fmt.Errorf("pull %q: %w", named.String(), ErrRegistryBlocked)
+	}
+
+	sources, err := reg.PullSourcesFromReference(named)
+	if err != nil {
+		return fmt.Errorf("failed to resolve pull sources for %q: %w", named.String(), err)
+	}
+
+	var errs []error
+	for _, src := range sources {
+		ref := src.Reference.String()
+		if pErr := pullVerified(ctx, sysCtx, src.Reference, named, src.Endpoint.Insecure); pErr != nil {
+			Log.Warnf("failed to pull %s: %v", ref, pErr)
+			errs = append(errs, fmt.Errorf("%s: %w", ref, pErr))
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("failed to pull %q from any source: %w", named.String(), errors.Join(errs...))
+}
+
+// pullVerified 는 src 를 policy.json 으로 검사한 뒤, 검사한 매니페스트의 digest 로 pull 하고 named 로 태그를 붙인다.
+// digest 로 pull 하므로 검사와 pull 사이에 태그가 다른 이미지로 옮겨가도 검사하지 않은 이미지를 받지 않는다.
+func pullVerified(ctx context.Context, sysCtx *imageTypes.SystemContext, src, named reference.Named, insecure bool) error {
+	ref, err := docker.NewReference(src)
+	if err != nil {
+		return fmt.Errorf("failed to create reference for %q: %w", src.String(), err)
+	}
+	srcCtx := *sysCtx
+	if insecure {
+		srcCtx.DockerInsecureSkipTLSVerify = imageTypes.OptionalBoolTrue
+	}
+	d, err := checkPullPolicy(ctx, &srcCtx, ref)
+	if err != nil {
+		return err
+	}
+	pinned, err := reference.WithDigest(reference.TrimNamed(src), d)
+	if err != nil {
+		return fmt.Errorf("failed to pin %q to %s: %w", src.String(), d, err)
+	}
+
+	opts := new(images.PullOptions)
+	if insecure {
+		opts = opts.WithSkipTLSVerify(true)
+	}
+	if _, err := images.Pull(ctx, pinned.String(), opts); err != nil {
+		return err
+	}
+	return tagAs(ctx, pinned.String(), named)
+}
+
+// checkPullPolicy 는 ref 의 매니페스트와 서명을 sysCtx 의 policy.json 으로 검사하고, 검사한 매니페스트의 digest 를 반환한다.
+func checkPullPolicy(ctx context.Context, sysCtx *imageTypes.SystemContext, ref imageTypes.ImageReference) (digest.Digest, error) {
+	policy, err := signature.DefaultPolicy(sysCtx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read signature policy: %w", err)
+	}
+	pc, err := signature.NewPolicyContext(policy)
+	if err != nil {
+		return "", fmt.Errorf("failed to create policy context: %w", err)
+	}
+	defer func() { _ = pc.Destroy() }()
+
+	src, err := ref.NewImageSource(ctx, sysCtx)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", transports.ImageName(ref), err)
+	}
+	defer src.Close()
+	unparsed := image.UnparsedInstance(src, nil)
+	if allowed, err := pc.IsRunningImageAllowed(ctx, unparsed); !allowed {
+		if err == nil {
+			return "", fmt.Errorf("%s: %w", transports.ImageName(ref), ErrPolicyRejected)
+		}
+		return "", fmt.Errorf("%s: %w: %v", transports.ImageName(ref), ErrPolicyRejected, err)
+	}
+	raw, _, err := unparsed.Manifest(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read manifest of %s: %w", transports.ImageName(ref), err)
+	}
+	d, err := manifest.Digest(raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to digest manifest of %s: %w", transports.ImageName(ref), err)
+	}
+	return d, nil
+}
+
+// tagAs 는 미러에서 받은 이미지에 원래 이름의 태그를 붙인다. digest 참조는 태그를 붙일 수 없으므로 건너뛴다.
+func tagAs(ctx context.Context, pulled string, named reference.Named) error {
+	tagged, ok := named.(reference.NamedTagged)
+	if !ok {
+		return nil
+	}
+	if err := images.Tag(ctx, pulled, tagged.Tag(), tagged.Name(), nil); err != nil {
+		return fmt.Errorf("failed to tag %s as %s: %w", pulled, named.String(), err)
+	}
+	return nil
+}
+
+// ------------------------------------------------------
+// registries.conf / policy.json rendering
+// ------------------------------------------------------
+
+// registriesConf 는 registries.conf (v2) 형식의 TOML 을 생성한다.
+func (rc *RegistryConfig) registriesConf() ([]byte, error) {
+	insecure := make(map[string]bool, len(rc.Insecure))
+	for _, loc := range rc.Insecure {
+		insecure[loc] = true
+	}
+	blocked := make(map[string]bool, len(rc.Blocked))
+	for _, prefix := range rc.Blocked {
+		blocked[prefix] = true
+	}
+
+	// 출력이 매번 같도록 prefix 를 정렬해 둔다.
+	prefixes := make([]string, 0, len(rc.Mirrors)+len(rc.Blocked)+len(rc.Insecure))
+	seen := make(map[string]bool)
+	for _, list := range [][]string{mapKeys(rc.Mirrors), rc.Blocked, rc.Insecure} {
+		for _, p := range list {
+			if !seen[p] {
+				seen[p] = true
+				prefixes = append(prefixes, p)
+			}
+		}
+	}
+	sort.Strings(prefixes)
+
+	conf := sysregistriesv2.V2RegistriesConf{
+		UnqualifiedSearchRegistries: rc.SearchRegistries,
+		ShortNameMode:               "permissive",
+	}
+	for _, prefix := range prefixes {
+		reg := sysregistriesv2.Registry{
+			Prefix:   prefix,
+			Endpoint: sysregistriesv2.Endpoint{Location: prefix, Insecure: insecure[prefix]},
+			Blocked:  blocked[prefix],
+		}
+		for _, m := range rc.Mirrors[prefix] {
+			reg.Mirrors = append(reg.Mirrors, sysregistriesv2.Endpoint{Location: m, Insecure: insecure[m]})
+		}
+		conf.Registries = append(conf.Registries, reg)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by podbridge5. Do not edit.\n")
+	if err := toml.NewEncoder(&buf).Encode(conf); err != nil {
+		return nil, fmt.Errorf("failed to encode registries.conf: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// policyJSON 은 policy.json 을 생성한다.
+// 로컬 스토리지(containers-storage)는 커밋 시 읽혀야 하므로 항상 허용한다.
+func (rc *RegistryConfig) policyJSON() ([]byte, error) {
+	def := signature.NewPRInsecureAcceptAnything()
+	if rc.DefaultPolicy == PolicyReject {
+		def = signature.NewPRReject()
+	}
+	policy := &signature.Policy{
+		Default: signature.PolicyRequirements{def},
+		Transports: map[string]signature.PolicyTransportScopes{
+			"containers-storage": {"": signature.PolicyRequirements{signature.NewPRInsecureAcceptAnything()}},
+		},
+	}
+	for _, p := range rc.Policies {
+		req, err := p.requirement()
+		if err != nil {
+			return nil, fmt.Errorf("policy for scope %q: %w", p.Scope, err)
+		}
+		transport := p.Transport
+		if transport == "" {
+			transport = "docker"
+		}
+		if policy.Transports[transport] == nil {
+			policy.Transports[transport] = signature.PolicyTransportScopes{}
+		}
+		policy.Transports[transport][p.Scope] = append(policy.Transports[transport][p.Scope], req)
+	}
+	data, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode policy.json: %w", err)
+	}
+	return data, nil
+}
+
+// requirement 는 SignaturePolicy 를 containers/image 의 PolicyRequirement 로 변환한다.
+func (p SignaturePolicy) requirement() (signature.PolicyRequirement, error) {
+	switch p.Type {
+	case PolicyInsecureAcceptAnything:
+		return signature.NewPRInsecureAcceptAnything(), nil
+	case PolicyReject:
+		return signature.NewPRReject(), nil
+	case PolicySignedBy:
+		if utils.IsEmptyString(p.KeyPath) {
+			return nil, errors.New("signedBy policy requires keyPath")
+		}
+		return signature.NewPRSignedByKeyPath(signature.SBKeyTypeGPGKeys, p.KeyPath, signature.NewPRMMatchRepoDigestOrExact())
+	case PolicySigstoreSigned:
+		if utils.IsEmptyString(p.KeyPath) {
+			return nil, errors.New("sigstoreSigned policy requires keyPath")
+		}
+		return signature.NewPRSigstoreSignedKeyPath(p.KeyPath, signature.NewPRMMatchRepoDigestOrExact())
+	default:
+		return nil, fmt.Errorf("unknown policy type %q", p.Type)
+	}
+}
+
+// writeFileAtomic 은 임시 파일에 쓴 뒤 rename 하여 부분적으로 쓰여진 파일이 읽히지 않도록 한다.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", path, err)
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		if rErr := os.Remove(tmpName); rErr != nil && !os.IsNotExist(rErr) {
+			Log.Warnf("failed to remove temporary file %s: %v", tmpName, rErr)
+		}
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to chmod %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", path, err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", path, err)
+	}
+	return nil
+}
+
+func mapKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
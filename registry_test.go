@@ -0,0 +1,224 @@
+package podbridge5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/signature"
+	"github.com/opencontainers/go-digest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRegistryConfigRender(t *testing.T) {
+	dir := t.TempDir()
+	rc := &RegistryConfig{
+		SearchRegistries: []string{"registry.internal:5000"},
+		Mirrors: map[string][]string{
+			"docker.io": {"mirror.internal:5000"},
+		},
+		Blocked:       []string{"quay.io"},
+		Insecure:      []string{"mirror.internal:5000"},
+		DefaultPolicy: PolicyReject,
+		Policies: []SignaturePolicy{
+			{Scope: "docker.io", Type: PolicyInsecureAcceptAnything},
+			{Scope: "mirror.internal:5000", Type: PolicyInsecureAcceptAnything},
+		},
+	}
+
+	sysCtx, err := rc.Render(dir)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if sysCtx.SystemRegistriesConfPath != filepath.Join(dir, registriesConfFileName) {
+		t.Errorf("unexpected registries.conf path %q", sysCtx.SystemRegistriesConfPath)
+	}
+	fi, err := os.Stat(sysCtx.SignaturePolicyPath)
+	if err != nil {
+		t.Fatalf("policy.json not written: %v", err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("expected policy.json mode 0600, got %o", fi.Mode().Perm())
+	}
+
+	sysregistriesv2.InvalidateCache()
+	t.Cleanup(sysregistriesv2.InvalidateCache)
+
+	search, err := sysregistriesv2.UnqualifiedSearchRegistries(sysCtx)
+	if err != nil {
+		t.Fatalf("failed to load rendered registries.conf: %v", err)
+	}
+	if len(search) != 1 || search[0] != "registry.internal:5000" {
+		t.Errorf("unexpected search registries %v", search)
+	}
+
+	blocked, err := sysregistriesv2.FindRegistry(sysCtx, "quay.io/foo/bar:latest")
+	if err != nil || blocked == nil || !blocked.Blocked {
+		t.Errorf("expected quay.io to be blocked, got %+v (err %v)", blocked, err)
+	}
+
+	reg, err := sysregistriesv2.FindRegistry(sysCtx, "docker.io/library/alpine:latest")
+	if err != nil || reg == nil {
+		t.Fatalf("expected docker.io registry entry, got %+v (err %v)", reg, err)
+	}
+	named, _ := reference.ParseNormalizedNamed("alpine:latest")
+	sources, err := reg.PullSourcesFromReference(named)
+	if err != nil {
+		t.Fatalf("PullSourcesFromReference failed: %v", err)
+	}
+	if len(sources) != 2 {
+		t.Fatalf("expected mirror and primary sources, got %d", len(sources))
+	}
+	if got := sources[0].Reference.String(); got != "mirror.internal:5000/library/alpine:latest" {
+		t.Errorf("expected mirror first, got %q", got)
+	}
+	if !sources[0].Endpoint.Insecure {
+		t.Error("expected mirror endpoint to be insecure")
+	}
+
+	policy, err := signature.NewPolicyFromFile(sysCtx.SignaturePolicyPath)
+	if err != nil {
+		t.Fatalf("failed to parse rendered policy.json: %v", err)
+	}
+	if _, ok := policy.Transports["docker"]["docker.io"]; !ok {
+		t.Error("expected docker.io scope in policy.json")
+	}
+	if _, ok := policy.Transports["containers-storage"]; !ok {
+		t.Error("expected containers-storage transport to be allowed")
+	}
+}
+
+func TestRegistryConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rc   *RegistryConfig
+	}{
+		{"nil config", nil},
+		{"empty mirror list", &RegistryConfig{Mirrors: map[string][]string{"docker.io": {}}}},
+		{"bad default policy", &RegistryConfig{DefaultPolicy: "maybe"}},
+		{"signedBy without key", &RegistryConfig{Policies: []SignaturePolicy{{Scope: "docker.io", Type: PolicySignedBy}}}},
+		{"unknown policy type", &RegistryConfig{Policies: []SignaturePolicy{{Scope: "docker.io", Type: "trustme"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rc.Validate(); err == nil {
+				t.Error("expected validation error, got nil")
+			}
+		})
+	}
+}
+
+func TestSetRegistryConfig(t *testing.T) {
+	t.Cleanup(func() { _ = SetRegistryConfig(nil, "") })
+
+	if err := SetRegistryConfig(&RegistryConfig{SearchRegistries: []string{"docker.io"}}, t.TempDir()); err != nil {
+		t.Fatalf("SetRegistryConfig failed: %v", err)
+	}
+	sysCtx := systemContext()
+	if sysCtx.SystemRegistriesConfPath == "" || sysCtx.SignaturePolicyPath == "" {
+		t.Fatalf("expected rendered paths in system context, got %+v", sysCtx)
+	}
+	// 반환값은 복사본이어야 한다.
+	sysCtx.SignaturePolicyPath = "changed"
+	if systemContext().SignaturePolicyPath == "changed" {
+		t.Error("systemContext must return a copy")
+	}
+
+	if err := SetRegistryConfig(nil, ""); err != nil {
+		t.Fatalf("reset failed: %v", err)
+	}
+	if systemContext().SystemRegistriesConfPath != "" {
+		t.Error("expected empty system context after reset")
+	}
+}
+
+func TestResolvePullNames(t *testing.T) {
+	sysregistriesv2.InvalidateCache()
+	t.Cleanup(sysregistriesv2.InvalidateCache)
+
+	names := func(rc *RegistryConfig, image string) []string {
+		t.Helper()
+		sysCtx, err := rc.Render(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resolved, err := resolvePullNames(sysCtx, image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, n := range resolved {
+			out = append(out, n.String())
+		}
+		return out
+	}
+
+	search := &RegistryConfig{SearchRegistries: []string{"registry.internal:5000", "docker.io"}}
+	want := []string{"registry.internal:5000/alpine:latest", "docker.io/library/alpine:latest"}
+	if got := names(search, "alpine"); !reflect.DeepEqual(got, want) {
+		t.Errorf("short name: got %v, want %v", got, want)
+	}
+	if got := names(search, "quay.io/foo/bar:1.0"); !reflect.DeepEqual(got, []string{"quay.io/foo/bar:1.0"}) {
+		t.Errorf("qualified name: got %v", got)
+	}
+	// SearchRegistries 가 없으면 docker.io 로 해석한다.
+	if got := names(&RegistryConfig{}, "alpine:3.20"); !reflect.DeepEqual(got, []string{"docker.io/library/alpine:3.20"}) {
+		t.Errorf("no search registries: got %v", got)
+	}
+}
+
+// writeOCILayout 은 dir 에 레이어가 없는 이미지 하나로 된 OCI 레이아웃을 만들고 매니페스트의 digest 를 반환한다.
+func writeOCILayout(t *testing.T, dir string) digest.Digest {
+	t.Helper()
+	writeBlob := func(data []byte) digest.Digest {
+		d := digest.FromBytes(data)
+		writeContextFiles(t, dir, map[string]string{filepath.Join("blobs", "sha256", d.Encoded()): string(data)})
+		return d
+	}
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`)
+	configDigest := writeBlob(config)
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",`+
+		`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"%s","size":%d},"layers":[]}`, configDigest, len(config)))
+	manifestDigest := writeBlob(manifest)
+	writeContextFiles(t, dir, map[string]string{
+		"oci-layout": `{"imageLayoutVersion":"1.0.0"}`,
+		"index.json": fmt.Sprintf(`{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"%s","size":%d}]}`,
+			manifestDigest, len(manifest)),
+	})
+	return manifestDigest
+}
+
+func TestCheckPullPolicy(t *testing.T) {
+	layoutDir := t.TempDir()
+	want := writeOCILayout(t, layoutDir)
+	ref, err := layout.NewReference(layoutDir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// podman 의 policy.json 이 아니라 렌더링한 policy.json 을 적용해야 한다.
+	rejecting, err := (&RegistryConfig{DefaultPolicy: PolicyReject}).Render(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkPullPolicy(context.Background(), rejecting, ref); !errors.Is(err, ErrPolicyRejected) {
+		t.Fatalf("expected ErrPolicyRejected, got %v", err)
+	}
+
+	accepting, err := (&RegistryConfig{}).Render(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := checkPullPolicy(context.Background(), accepting, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("expected manifest digest %s, got %s", want, got)
+	}
+}
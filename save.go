@@ -0,0 +1,432 @@
+package podbridge5
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/containers/buildah"
+	"github.com/containers/image/v5/oci/archive"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/pkg/compression"
+	imageTypes "github.com/containers/image/v5/types"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/klauspost/compress/zstd"
+	"github.com/seoyhaein/utils"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 저장 형식
+const (
+	SaveFormatDockerArchive = ArchiveFormatDocker
+	SaveFormatOCIArchive    = ArchiveFormatOCI
+	SaveFormatOCIDir        = "oci-dir"
+)
+
+// CompressionZstdChunked 는 레이어 단위 zstd:chunked 압축이다. OCI 형식에서만 사용할 수 있다.
+const CompressionZstdChunked = "zstd:chunked"
+
+// checksumSuffix 는 SaveImage 가 작성하는 체크섬 사이드카 파일의 확장자이다.
+const checksumSuffix = ".sha256"
+
+// SaveOptions 는 SaveImage 의 설정이다.
+//
+// docker-archive 는 레이어를 압축하지 않고 저장하므로 Compression 은 아카이브 파일 전체에 적용된다. (gzip, zstd)
+// oci-archive, oci-dir 은 레이어 자체를 압축할 수 있으므로 Compression 은 레이어 단위로 적용된다. (gzip, zstd, zstd:chunked)
+type SaveOptions struct {
+	Format           string    `json:"format"`           // docker-archive(기본), oci-archive, oci-dir
+	Compression      string    `json:"compression"`      // none(기본), gzip, zstd, zstd:chunked
+	CompressionLevel *int      `json:"compressionLevel"` // nil 이면 각 알고리즘의 기본값
+	FileName         string    `json:"fileName"`         // 비어 있으면 첫 번째 이미지 이름으로 만든다.
+	Checksum         bool      `json:"checksum"`         // <archive>.sha256 사이드카 파일 작성
+	Writer           io.Writer `json:"-"`                // 설정하면 파일 대신 Writer 로 스트리밍한다. (oci-dir 은 지원하지 않음)
+
+	// Encryption 이 있으면 레이어를 ocicrypt 로 암호화해서 저장한다. oci-archive, oci-dir 만 가능하며,
+	// 워커 노드에서는 LoadEncryptedImage 로 복호화하며 로드한다.
+	Encryption *ImageEncryption `json:"encryption,omitempty"`
+}
+
+// SaveReport 는 SaveImage 의 결과이다.
+type SaveReport struct {
+	Path   string // 저장된 파일 또는 디렉토리. Writer 로 스트리밍한 경우 비어 있다.
+	Digest string // 아카이브의 sha256 digest. oci-dir 은 비어 있다.
+	Size   int64  // 아카이브 크기 (바이트)
+}
+
+// SaveImage 는 imageNames 의 이미지들을 dir 아래 하나의 아카이브로 저장한다.
+// 여러 이미지를 하나의 아카이브에 담는 것은 docker-archive 에서만 가능하다.
+// oci-archive, oci-dir 은 레이어 압축을 위해 로컬 스토리지(pbStore)를 사용하므로 Init 이 먼저 호출되어 있어야 한다.
+func SaveImage(ctx context.Context, dir string, imageNames []string, opts *SaveOptions) (*SaveReport, error) {
+	if opts == nil {
+		opts = &SaveOptions{}
+	}
+	if err := opts.validate(imageNames); err != nil {
+		return nil, err
+	}
+	format := opts.format()
+
+	if opts.Writer != nil {
+		return opts.writeArchive(ctx, opts.Writer, imageNames)
+	}
+
+	if utils.IsEmptyString(dir) {
+		return nil, errors.New("save directory cannot be empty")
+	}
+	// archive 파일이 위치할 디렉토리 생성
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	archivePath := filepath.Join(dir, opts.fileName(imageNames[0]))
+
+	if format == SaveFormatOCIDir {
+		if err := pushToOCI(ctx, layout.Transport.Name(), archivePath, imageNames[0], opts); err != nil {
+			return nil, err
+		}
+		return &SaveReport{Path: archivePath}, nil
+	}
+
+	report, err := writeArchiveFile(archivePath, func(w io.Writer) (*SaveReport, error) {
+		return opts.writeArchive(ctx, w, imageNames)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Checksum {
+		if err := writeChecksumFile(archivePath, report.Digest); err != nil {
+			return nil, err
+		}
+	} else if err := removeChecksumFile(archivePath); err != nil {
+		// 이전 저장의 사이드카가 남아 있으면 로드할 때 ErrDigestMismatch 가 나므로 지운다.
+		return nil, err
+	}
+	return report, nil
+}
+
+// writeArchiveFile 은 같은 디렉토리의 임시 파일에 아카이브를 쓴 뒤 성공하면 archivePath 로 rename 한다.
+// 쓰기가 실패하면 임시 파일을 지우므로 archivePath 에 잘린 아카이브가 남지 않는다.
+func writeArchiveFile(archivePath string, write func(io.Writer) (*SaveReport, error)) (*SaveReport, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(archivePath), "."+filepath.Base(archivePath)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file %s: %w", archivePath, err)
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		if rErr := os.Remove(tmpName); rErr != nil && !os.IsNotExist(rErr) {
+			Log.Warnf("failed to remove temporary file %s: %v", tmpName, rErr)
+		}
+	}()
+
+	report, err := write(tmp)
+	if cErr := tmp.Close(); cErr != nil && err == nil {
+		err = fmt.Errorf("failed to close output file %s: %w", archivePath, cErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmpName, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to chmod %s: %w", archivePath, err)
+	}
+	if err := os.Rename(tmpName, archivePath); err != nil {
+		return nil, fmt.Errorf("failed to rename %s: %w", archivePath, err)
+	}
+	report.Path = archivePath
+	return report, nil
+}
+
+func (o *SaveOptions) format() string {
+	if o.Format == "" {
+		return SaveFormatDockerArchive
+	}
+	return o.Format
+}
+
+func (o *SaveOptions) compression() string {
+	if o.Compression == "" {
+		return CompressionNone
+	}
+	return o.Compression
+}
+
+func (o *SaveOptions) validate(imageNames []string) error {
+	if len(imageNames) == 0 {
+		return errors.New("no image to save")
+	}
+	for _, name := range imageNames {
+		if utils.IsEmptyString(name) {
+			return errors.New("image name cannot be empty")
+		}
+	}
+	format, comp := o.format(), o.compression()
+	switch format {
+	case SaveFormatDockerArchive:
+		if comp == CompressionZstdChunked {
+			return errors.New("zstd:chunked compression requires an OCI format")
+		}
+		if o.Encryption != nil {
+			return errors.New("layer encryption requires an OCI format")
+		}
+	case SaveFormatOCIArchive, SaveFormatOCIDir:
+		if len(imageNames) > 1 {
+			return fmt.Errorf("multi-image archives are only supported with %s", SaveFormatDockerArchive)
+		}
+	default:
+		return fmt.Errorf("unknown save format %q", format)
+	}
+	switch comp {
+	case CompressionNone, CompressionGzip, CompressionZstd, CompressionZstdChunked:
+	default:
+		return fmt.Errorf("unknown compression %q", comp)
+	}
+	if o.Encryption != nil {
+		if err := o.Encryption.Validate(); err != nil {
+			return err
+		}
+	}
+	if format == SaveFormatOCIDir {
+		if o.Writer != nil {
+			return errors.New("oci-dir cannot be streamed to a writer")
+		}
+		if o.Checksum {
+			return errors.New("checksum sidecar is not supported for oci-dir")
+		}
+	}
+	return nil
+}
+
+// fileName 은 형식과 압축에 맞는 아카이브 파일 이름을 만든다.
+func (o *SaveOptions) fileName(imageName string) string {
+	if o.FileName != "" {
+		return o.FileName
+	}
+	base := strings.TrimSuffix(archiveFileName(imageName, false), ".tar")
+	switch o.format() {
+	case SaveFormatOCIDir:
+		return base + ".oci"
+	case SaveFormatOCIArchive:
+		return base + ".oci.tar"
+	}
+	switch o.compression() {
+	case CompressionGzip:
+		return base + ".tar.gz"
+	case CompressionZstd:
+		return base + ".tar.zst"
+	}
+	return base + ".tar"
+}
+
+// writeArchive 는 아카이브를 w 로 쓰면서 sha256 과 크기를 계산한다.
+func (o *SaveOptions) writeArchive(ctx context.Context, w io.Writer, imageNames []string) (*SaveReport, error) {
+	counter := &countingWriter{w: w, h: sha256.New()}
+
+	switch o.format() {
+	case SaveFormatOCIArchive:
+		if err := o.writeOCIArchive(ctx, counter, imageNames[0]); err != nil {
+			return nil, err
+		}
+	default:
+		if err := o.writeDockerArchive(ctx, counter, imageNames); err != nil {
+			return nil, err
+		}
+	}
+	return &SaveReport{
+		Digest: "sha256:" + hex.EncodeToString(counter.h.Sum(nil)),
+		Size:   counter.n,
+	}, nil
+}
+
+// writeDockerArchive 는 podman 의 export 로 docker-archive 를 만들고, 필요하면 파일 전체를 압축한다.
+func (o *SaveOptions) writeDockerArchive(ctx context.Context, w io.Writer, imageNames []string) (err error) {
+	var writer io.Writer = w
+	var closer io.Closer
+
+	switch o.compression() {
+	case CompressionGzip:
+		level := gzip.DefaultCompression
+		if o.CompressionLevel != nil {
+			level = *o.CompressionLevel
+		}
+		gz, gErr := gzip.NewWriterLevel(w, level)
+		if gErr != nil {
+			return fmt.Errorf("invalid gzip compression level: %w", gErr)
+		}
+		writer, closer = gz, gz
+	case CompressionZstd:
+		zOpts := []zstd.EOption{}
+		if o.CompressionLevel != nil {
+			zOpts = append(zOpts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(*o.CompressionLevel)))
+		}
+		zw, zErr := zstd.NewWriter(w, zOpts...)
+		if zErr != nil {
+			return fmt.Errorf("failed to create zstd writer: %w", zErr)
+		}
+		writer, closer = zw, zw
+	}
+	if closer != nil {
+		defer func() {
+			// 압축 스트림의 끝을 써야 하므로 Close 에러도 반환한다.
+			if cErr := closer.Close(); cErr != nil && err == nil {
+				err = fmt.Errorf("failed to close compressor: %w", cErr)
+			}
+		}()
+	}
+
+	exportOptions := new(images.ExportOptions).WithFormat(SaveFormatDockerArchive)
+	if err := images.Export(ctx, imageNames, writer, exportOptions); err != nil {
+		return fmt.Errorf("failed to export image %s: %w", strings.Join(imageNames, ", "), err)
+	}
+	return nil
+}
+
+// writeOCIArchive 는 로컬 스토리지에서 레이어를 압축해 임시 oci-archive 로 만든 뒤 w 로 복사한다.
+func (o *SaveOptions) writeOCIArchive(ctx context.Context, w io.Writer, imageName string) error {
+	tmpDir, err := os.MkdirTemp("", "podbridge5-save-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() {
+		if rErr := os.RemoveAll(tmpDir); rErr != nil {
+			Log.Warnf("failed to remove temporary directory %s: %v", tmpDir, rErr)
+		}
+	}()
+
+	tmpPath := filepath.Join(tmpDir, "image.tar")
+	if err := pushToOCI(ctx, archive.Transport.Name(), tmpPath, imageName, o); err != nil {
+		return err
+	}
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to open oci archive: %w", err)
+	}
+	defer func() {
+		if cErr := f.Close(); cErr != nil {
+			Log.Warnf("Failed to close oci archive: %v", cErr)
+		}
+	}()
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("failed to copy oci archive: %w", err)
+	}
+	return nil
+}
+
+// pushToOCI 는 buildah.Push 로 로컬 스토리지의 이미지를 oci-archive 또는 oci 디렉토리로 내보낸다.
+func pushToOCI(ctx context.Context, transport, dest, imageName string, o *SaveOptions) error {
+	if pbStore == nil {
+		return errors.New("pbStore is nil: call Init before saving OCI images")
+	}
+	ref, err := ociReference(transport, dest, imageName)
+	if err != nil {
+		return err
+	}
+
+	pushOpts := buildah.PushOptions{
+		Store:         pbStore,
+		SystemContext: systemContext(),
+		ManifestType:  buildah.OCIv1ImageManifest,
+		Quiet:         true,
+	}
+	if comp := o.compression(); comp != CompressionNone {
+		algo, err := compression.AlgorithmByName(comp)
+		if err != nil {
+			return fmt.Errorf("unknown compression %q: %w", comp, err)
+		}
+		pushOpts.CompressionFormat = &algo
+		pushOpts.CompressionLevel = o.CompressionLevel
+		pushOpts.ForceCompressionFormat = true
+	}
+	if o.Encryption != nil {
+		encConfig, layers, err := o.Encryption.encryptConfig()
+		if err != nil {
+			return err
+		}
+		pushOpts.OciEncryptConfig = encConfig
+		pushOpts.OciEncryptLayers = layers
+		pushOpts.RemoveSignatures = true
+	}
+	if _, _, err := buildah.Push(ctx, imageName, ref, pushOpts); err != nil {
+		return fmt.Errorf("failed to save image %s as %s: %w", imageName, transport, err)
+	}
+	return nil
+}
+
+// ociReference 는 oci-archive 파일 또는 oci 디렉토리의 참조를 만든다.
+func ociReference(transport, dest, imageName string) (imageTypes.ImageReference, error) {
+	var ref imageTypes.ImageReference
+	var err error
+	switch transport {
+	case archive.Transport.Name():
+		ref, err = archive.NewReference(dest, imageName)
+	default:
+		ref, err = layout.NewReference(dest, imageName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s reference for %s: %w", transport, dest, err)
+	}
+	return ref, nil
+}
+
+// writeChecksumFile 은 sha256sum 과 같은 형식("<hex>  <파일 이름>")으로 사이드카 파일을 작성한다.
+func writeChecksumFile(archivePath, digest string) error {
+	line := fmt.Sprintf("%s  %s\n", strings.TrimPrefix(digest, "sha256:"), filepath.Base(archivePath))
+	return writeFileAtomic(archivePath+checksumSuffix, []byte(line), 0o644)
+}
+
+// removeChecksumFile 은 archivePath 의 사이드카 파일이 있으면 지운다.
+func removeChecksumFile(archivePath string) error {
+	if err := os.Remove(archivePath + checksumSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale checksum file: %w", err)
+	}
+	return nil
+}
+
+// readChecksumFile 은 사이드카 파일이 있으면 "sha256:<hex>" 를 반환한다. 없으면 빈 문자열을 반환한다.
+func readChecksumFile(archivePath string) (string, error) {
+	data, err := os.ReadFile(archivePath + checksumSuffix)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read checksum file: %w", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid checksum file %s", archivePath+checksumSuffix)
+	}
+	return "sha256:" + fields[0], nil
+}
+
+// verifyChecksumFile 은 SaveImage 가 남긴 사이드카 파일이 있으면 아카이브의 sha256 과 비교한다.
+func verifyChecksumFile(archivePath string) error {
+	expected, err := readChecksumFile(archivePath)
+	if err != nil || expected == "" {
+		return err
+	}
+	actual, err := fileDigest(archivePath)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("%s: expected %s, got %s: %w", archivePath, expected, actual, ErrDigestMismatch)
+	}
+	return nil
+}
+
+// countingWriter 는 쓰여진 바이트 수와 해시를 함께 계산한다.
+type countingWriter struct {
+	w io.Writer
+	h hash.Hash
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.h.Write(p[:n])
+	c.n += int64(n)
+	return n, err
+}
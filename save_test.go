@@ -0,0 +1,244 @@
+package podbridge5
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    SaveOptions
+		images  []string
+		wantErr bool
+	}{
+		{"defaults", SaveOptions{}, []string{"tester:latest"}, false},
+		{"multi-image docker archive", SaveOptions{Compression: CompressionZstd}, []string{"a:1", "b:1"}, false},
+		{"oci archive zstd chunked", SaveOptions{Format: SaveFormatOCIArchive, Compression: CompressionZstdChunked}, []string{"a:1"}, false},
+		{"no images", SaveOptions{}, nil, true},
+		{"empty image name", SaveOptions{}, []string{" "}, true},
+		{"unknown format", SaveOptions{Format: "tarball"}, []string{"a:1"}, true},
+		{"unknown compression", SaveOptions{Compression: "xz"}, []string{"a:1"}, true},
+		{"zstd chunked docker archive", SaveOptions{Compression: CompressionZstdChunked}, []string{"a:1"}, true},
+		{"multi-image oci archive", SaveOptions{Format: SaveFormatOCIArchive}, []string{"a:1", "b:1"}, true},
+		{"oci dir writer", SaveOptions{Format: SaveFormatOCIDir, Writer: io.Discard}, []string{"a:1"}, true},
+		{"oci dir checksum", SaveOptions{Format: SaveFormatOCIDir, Checksum: true}, []string{"a:1"}, true},
+		{"encrypted docker archive", SaveOptions{Encryption: &ImageEncryption{Recipients: []string{"jwe:/keys/pub.pem"}}}, []string{"a:1"}, true},
+		{"encryption without recipients", SaveOptions{Format: SaveFormatOCIArchive, Encryption: &ImageEncryption{}}, []string{"a:1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.validate(tt.images)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSaveOptionsFileName(t *testing.T) {
+	const image = "docker.io/library/tester-internal:latest"
+	tests := []struct {
+		opts SaveOptions
+		want string
+	}{
+		{SaveOptions{}, "tester-internal-latest.tar"},
+		{SaveOptions{Compression: CompressionGzip}, "tester-internal-latest.tar.gz"},
+		{SaveOptions{Compression: CompressionZstd}, "tester-internal-latest.tar.zst"},
+		{SaveOptions{Format: SaveFormatOCIArchive, Compression: CompressionZstd}, "tester-internal-latest.oci.tar"},
+		{SaveOptions{Format: SaveFormatOCIDir}, "tester-internal-latest.oci"},
+		{SaveOptions{FileName: "custom.tar"}, "custom.tar"},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.fileName(image); got != tt.want {
+			t.Errorf("fileName(%+v) = %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+	// saveImage 의 기존 파일 이름 규칙과 같아야 한다.
+	if got, want := (&SaveOptions{Compression: CompressionGzip}).fileName(image), archiveFileName(image, true); got != want {
+		t.Errorf("fileName and archiveFileName differ: %q vs %q", got, want)
+	}
+}
+
+func TestSaveImage_InvalidInput(t *testing.T) {
+	if _, err := SaveImage(context.Background(), t.TempDir(), nil, nil); err == nil {
+		t.Fatal("expected error for no images")
+	}
+	if _, err := SaveImage(context.Background(), "", []string{"tester:latest"}, nil); err == nil {
+		t.Fatal("expected error for empty directory")
+	}
+}
+
+func TestChecksumFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tester-latest.tar")
+	data := []byte("archive-data")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 사이드카가 없으면 검증을 건너뛴다.
+	if err := verifyChecksumFile(path); err != nil {
+		t.Fatalf("unexpected error without sidecar: %v", err)
+	}
+
+	digest := "sha256:" + sha256Hex(data)
+	if err := writeChecksumFile(path, digest); err != nil {
+		t.Fatal(err)
+	}
+	sidecar, err := os.ReadFile(path + checksumSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sha256Hex(data) + "  tester-latest.tar\n"; string(sidecar) != want {
+		t.Errorf("unexpected sidecar content %q, want %q", sidecar, want)
+	}
+	if got, err := readChecksumFile(path); err != nil || got != digest {
+		t.Errorf("readChecksumFile() = %q, %v; want %q", got, err, digest)
+	}
+	if err := verifyChecksumFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("tampered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyChecksumFile(path); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected ErrDigestMismatch, got %v", err)
+	}
+
+	if err := os.WriteFile(path+checksumSuffix, []byte("not-a-digest\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readChecksumFile(path); err == nil {
+		t.Fatal("expected error for invalid sidecar")
+	}
+}
+
+func TestRemoveChecksumFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tester-latest.tar")
+	if err := removeChecksumFile(path); err != nil {
+		t.Fatalf("unexpected error without sidecar: %v", err)
+	}
+	if err := writeChecksumFile(path, "sha256:"+sha256Hex([]byte("old"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeChecksumFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + checksumSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected stale sidecar to be removed, got %v", err)
+	}
+}
+
+func TestWriteArchiveFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tester-latest.tar")
+	if err := os.WriteFile(path, []byte("previous"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 쓰기가 실패하면 기존 아카이브는 그대로 두고 임시 파일도 남기지 않는다.
+	_, err := writeArchiveFile(path, func(w io.Writer) (*SaveReport, error) {
+		_, _ = io.WriteString(w, "trunc")
+		return nil, errors.New("export failed")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "previous" {
+		t.Errorf("expected previous archive to be kept, got %q", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected temporary file to be removed, got %d entries", len(entries))
+	}
+
+	report, err := writeArchiveFile(path, func(w io.Writer) (*SaveReport, error) {
+		_, err := io.WriteString(w, "archive-data")
+		return &SaveReport{Size: 12}, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Path != path {
+		t.Errorf("expected path %s, got %s", path, report.Path)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "archive-data" {
+		t.Errorf("unexpected archive content %q", data)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o644 {
+		t.Errorf("expected mode 0644, got %v, %v", fi, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected temporary file to be renamed, got %d entries", len(entries))
+	}
+}
+
+func TestCountingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	cw := &countingWriter{w: &buf, h: sha256.New()}
+	for _, chunk := range []string{"hello ", "world"} {
+		if _, err := cw.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cw.n != int64(len("hello world")) {
+		t.Errorf("expected %d bytes, got %d", len("hello world"), cw.n)
+	}
+	if got := strings.TrimSpace(buf.String()); got != "hello world" {
+		t.Errorf("unexpected output %q", got)
+	}
+	if got, want := hex.EncodeToString(cw.h.Sum(nil)), sha256Hex([]byte("hello world")); got != want {
+		t.Errorf("expected sha256 %s, got %s", want, got)
+	}
+}
+
+// 압축 스트림이 openArchive 로 다시 열리는지 확인한다.
+func TestCompressionLevels(t *testing.T) {
+	level := 9
+	payload := bytes.Repeat([]byte("podbridge5"), 1024)
+
+	var gzBuf bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&gzBuf, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw.Write(payload)
+	gw.Close()
+
+	var zBuf bytes.Buffer
+	zw, err := zstd.NewWriter(&zBuf, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(payload)
+	zw.Close()
+
+	for comp, data := range map[string][]byte{CompressionGzip: gzBuf.Bytes(), CompressionZstd: zBuf.Bytes()} {
+		path := filepath.Join(t.TempDir(), "archive")
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		rc, detected, err := openArchive(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if detected != comp || !bytes.Equal(got, payload) {
+			t.Errorf("%s: round trip failed (detected %s)", comp, detected)
+		}
+	}
+}
@@ -0,0 +1,536 @@
+package podbridge5
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/containers/buildah"
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/opencontainers/go-digest"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 지원하는 SBOM 형식
+const (
+	SBOMFormatSPDX      = "spdx"      // SPDX 2.3 JSON
+	SBOMFormatCycloneDX = "cyclonedx" // CycloneDX 1.5 JSON
+)
+
+const (
+	// SBOMLabel 은 이미지와 함께 저장한 SBOM 파일 이름 라벨이다. (예: "tester-internal-latest.spdx.json")
+	SBOMLabel = "io.podbridge5.sbom"
+	// SBOMDigestLabel 은 SBOM 파일 내용의 digest 라벨이다. 이미지 옆에 있는 SBOM 이 이 이미지의 것인지 확인할 때 사용한다.
+	SBOMDigestLabel = "io.podbridge5.sbom.digest"
+)
+
+// 패키지 데이터베이스 경로
+const (
+	apkInstalledDB = "/lib/apk/db/installed"
+	dpkgStatusDB   = "/var/lib/dpkg/status"
+	dpkgStatusDir  = "/var/lib/dpkg/status.d" // distroless 이미지
+)
+
+// rpmDBDirs 는 rpm 데이터베이스가 있을 수 있는 디렉토리들이다. rpm 데이터베이스는 읽으려면 rpm 이 필요하다.
+var rpmDBDirs = []string{"/var/lib/rpm", "/usr/lib/sysimage/rpm"}
+
+// rpmQueryFormat 은 rpm -qa 의 출력 형식이다. 이름, (epoch:)버전-릴리스, 아키텍처, 라이선스, 소스 rpm 을 탭으로 구분한다.
+const rpmQueryFormat = `%{NAME}\t%|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE}\t%{ARCH}\t%{LICENSE}\t%{SOURCERPM}\n`
+
+// SBOMPackage 는 이미지에 설치된 패키지 하나이다.
+type SBOMPackage struct {
+	Type    string // "apk", "deb", "rpm"
+	Name    string
+	Version string
+	Arch    string
+	License string // 패키지 데이터베이스에 적힌 그대로. dpkg 는 라이선스를 기록하지 않으므로 비어 있다
+	Source  string // 소스 패키지 이름
+}
+
+// sbomDocument 는 만들어진 SBOM 파일이다.
+type sbomDocument struct {
+	fileName string
+	data     []byte
+	digest   string
+}
+
+// validateSBOMFormat 은 ImageConfig.SBOM 값을 확인한다. 비어 있으면 SBOM 을 만들지 않는다.
+func validateSBOMFormat(format string) error {
+	switch format {
+	case "", SBOMFormatSPDX, SBOMFormatCycloneDX:
+		return nil
+	}
+	return fmt.Errorf("unsupported SBOM format %q (supported: %s, %s)", format, SBOMFormatSPDX, SBOMFormatCycloneDX)
+}
+
+// sbomFileName 은 saveImage 의 아카이브 옆에 둘 SBOM 파일 이름이다. (예: "tester-internal-latest.spdx.json")
+func sbomFileName(imageName, format string) string {
+	ext := ".spdx.json"
+	if format == SBOMFormatCycloneDX {
+		ext = ".cdx.json"
+	}
+	return strings.TrimSuffix(archiveFileName(imageName, false), ".tar") + ext
+}
+
+// attachSBOM 은 builder 의 rootfs 에서 패키지 데이터베이스를 읽어 SBOM 을 만들고, 파일 이름과 digest 를 이미지 라벨로 남긴다.
+// 커밋하기 전에 호출해야 한다. ImageConfig.SBOM 이 비어 있으면 nil 을 반환한다.
+func (img *ImageConfig) attachSBOM(builder *buildah.Builder, p *buildProgress) (*sbomDocument, error) {
+	if img.SBOM == "" {
+		return nil, nil
+	}
+	if err := validateSBOMFormat(img.SBOM); err != nil {
+		return nil, err
+	}
+	var doc *sbomDocument
+	err := p.step("SBOM "+img.SBOM, func(stdout, stderr io.Writer) error {
+		pkgs, osInfo, err := builderPackages(builder, stderr)
+		if err != nil {
+			return err
+		}
+		data, err := encodeSBOM(img.SBOM, img.ImageName, osInfo, pkgs, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		doc = &sbomDocument{fileName: sbomFileName(img.ImageName, img.SBOM), data: data, digest: digest.FromBytes(data).String()}
+		_, _ = fmt.Fprintf(stdout, "%d packages\n", len(pkgs))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate SBOM: %w", err)
+	}
+	builder.SetLabel(SBOMLabel, doc.fileName)
+	builder.SetLabel(SBOMDigestLabel, doc.digest)
+	return doc, nil
+}
+
+// save 는 SBOM 을 dir 에 저장한다. doc 이 nil 이면 아무것도 하지 않는다.
+func (doc *sbomDocument) save(dir string) error {
+	if doc == nil {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, doc.fileName), doc.data, 0644); err != nil {
+		return fmt.Errorf("failed to write SBOM: %w", err)
+	}
+	return nil
+}
+
+// builderPackages 는 builder 의 rootfs 를 마운트해서 apk, dpkg 데이터베이스를 읽고, rpm 데이터베이스가 있으면 이미지 안의 rpm 으로 조회한다.
+// 네트워크에 접근하지 않는다.
+func builderPackages(builder *buildah.Builder, stderr io.Writer) ([]SBOMPackage, map[string]string, error) {
+	rootfs, err := builder.Mount(builder.MountLabel)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if uErr := builder.Unmount(); uErr != nil {
+			Log.Warnf("Failed to unmount builder: %v", uErr)
+		}
+	}()
+
+	osRelease, err := readRootfsFile(rootfs, "/etc/os-release")
+	if errors.Is(err, fs.ErrNotExist) {
+		osRelease, err = readRootfsFile(rootfs, "/usr/lib/os-release")
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+	pkgs, err := rootfsPackages(rootfs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, dir := range rpmDBDirs {
+		if !rootfsExists(rootfs, dir) {
+			continue
+		}
+		var out bytes.Buffer
+		opts := defaultRunOptions
+		opts.Stdout = &out
+		opts.Stderr = stderr
+		if err := builder.Run([]string{"rpm", "-qa", "--qf", rpmQueryFormat}, opts); err != nil {
+			return nil, nil, fmt.Errorf("rpm database found at %s but rpm query failed: %w", dir, err)
+		}
+		pkgs = append(pkgs, parseRPMQuery(out.String())...)
+		break
+	}
+	sortPackages(pkgs)
+	return pkgs, parseOSRelease(string(osRelease)), nil
+}
+
+// rootfsPackages 는 rootfs 의 apk, dpkg 데이터베이스에서 패키지들을 읽는다.
+func rootfsPackages(rootfs string) ([]SBOMPackage, error) {
+	var pkgs []SBOMPackage
+	if data, err := readRootfsFile(rootfs, apkInstalledDB); err == nil {
+		pkgs = append(pkgs, parseApkInstalled(data)...)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if data, err := readRootfsFile(rootfs, dpkgStatusDB); err == nil {
+		pkgs = append(pkgs, parseDpkgStatus(data)...)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	dir, err := securejoin.SecureJoin(rootfs, dpkgStatusDir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasSuffix(e.Name(), ".md5sums") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, parseDpkgStatus(data)...)
+	}
+	return pkgs, nil
+}
+
+// readRootfsFile 은 rootfs 안의 name 을 읽는다. 심볼릭 링크는 rootfs 밖으로 나가지 않도록 rootfs 기준으로 해석한다.
+func readRootfsFile(rootfs, name string) ([]byte, error) {
+	p, err := securejoin.SecureJoin(rootfs, name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+func rootfsExists(rootfs, name string) bool {
+	p, err := securejoin.SecureJoin(rootfs, name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+// parseApkInstalled 는 apk 데이터베이스(/lib/apk/db/installed)를 읽는다. 패키지는 빈 줄로 구분되고, 한 글자 키와 ':' 뒤에 값이 온다.
+func parseApkInstalled(data []byte) []SBOMPackage {
+	var pkgs []SBOMPackage
+	var cur SBOMPackage
+	flush := func() {
+		if cur.Name != "" {
+			cur.Type = "apk"
+			pkgs = append(pkgs, cur)
+		}
+		cur = SBOMPackage{}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "P":
+			cur.Name = value
+		case "V":
+			cur.Version = value
+		case "A":
+			cur.Arch = value
+		case "L":
+			cur.License = value
+		case "o":
+			cur.Source = value
+		}
+	}
+	flush()
+	return pkgs
+}
+
+// parseDpkgStatus 는 dpkg 데이터베이스(/var/lib/dpkg/status)를 읽는다. 설치된("install ok installed") 패키지만 반환한다.
+func parseDpkgStatus(data []byte) []SBOMPackage {
+	var pkgs []SBOMPackage
+	var cur SBOMPackage
+	installed := true
+	flush := func() {
+		if cur.Name != "" && installed {
+			cur.Type = "deb"
+			pkgs = append(pkgs, cur)
+		}
+		cur, installed = SBOMPackage{}, true
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue // 여러 줄 값(Description 등)
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Package":
+			cur.Name = value
+		case "Version":
+			cur.Version = value
+		case "Architecture":
+			cur.Arch = value
+		case "Source":
+			// "Source: glibc (2.36-9)" 처럼 버전이 붙을 수 있다
+			cur.Source, _, _ = strings.Cut(value, " ")
+		case "Status":
+			installed = strings.HasSuffix(value, " installed")
+		}
+	}
+	flush()
+	return pkgs
+}
+
+// parseRPMQuery 는 rpmQueryFormat 형식의 rpm -qa 출력을 읽는다.
+func parseRPMQuery(output string) []SBOMPackage {
+	var pkgs []SBOMPackage
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 || fields[0] == "" || fields[0] == "gpg-pubkey" {
+			continue
+		}
+		license := fields[3]
+		if license == "(none)" {
+			license = ""
+		}
+		pkgs = append(pkgs, SBOMPackage{
+			Type:    "rpm",
+			Name:    fields[0],
+			Version: fields[1],
+			Arch:    fields[2],
+			License: license,
+			Source:  strings.TrimSuffix(fields[4], ".src.rpm"),
+		})
+	}
+	return pkgs
+}
+
+func sortPackages(pkgs []SBOMPackage) {
+	sort.SliceStable(pkgs, func(i, j int) bool {
+		if pkgs[i].Type != pkgs[j].Type {
+			return pkgs[i].Type < pkgs[j].Type
+		}
+		if pkgs[i].Name != pkgs[j].Name {
+			return pkgs[i].Name < pkgs[j].Name
+		}
+		return pkgs[i].Version < pkgs[j].Version
+	})
+}
+
+// purl 은 패키지의 package URL 이다. (예: "pkg:apk/alpine/busybox@1.36.1-r29?arch=x86_64&distro=alpine-3.20.0")
+// osRelease 의 ID 를 네임스페이스로 사용한다.
+func (pkg SBOMPackage) purl(osRelease map[string]string) string {
+	namespace := osRelease["ID"]
+	if namespace == "" {
+		namespace = "unknown"
+	}
+	q := url.Values{}
+	if pkg.Arch != "" {
+		q.Set("arch", pkg.Arch)
+	}
+	if id, ver := osRelease["ID"], osRelease["VERSION_ID"]; id != "" && ver != "" {
+		q.Set("distro", id+"-"+ver)
+	}
+	s := fmt.Sprintf("pkg:%s/%s/%s@%s", pkg.Type, purlEscape(namespace), purlEscape(pkg.Name), purlEscape(pkg.Version))
+	if len(q) > 0 {
+		s += "?" + q.Encode()
+	}
+	return s
+}
+
+// purlEscape 는 purl 의 경로 구성 요소를 인코딩한다. purl 에서 '+' 는 "%2B" 로 써야 한다.
+func purlEscape(s string) string {
+	return strings.ReplaceAll(url.PathEscape(s), "+", "%2B")
+}
+
+// encodeSBOM 은 패키지 목록을 format 형식의 JSON 문서로 만든다.
+func encodeSBOM(format, imageName string, osRelease map[string]string, pkgs []SBOMPackage, created time.Time) ([]byte, error) {
+	var doc any
+	switch format {
+	case SBOMFormatSPDX:
+		doc = newSPDXDocument(imageName, osRelease, pkgs, created)
+	case SBOMFormatCycloneDX:
+		doc = newCycloneDXDocument(imageName, osRelease, pkgs, created)
+	default:
+		return nil, validateSBOMFormat(format)
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// SPDX 2.3 JSON 중 사용하는 필드들
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	LicenseComments  string            `json:"licenseComments,omitempty"`
+	SourceInfo       string            `json:"sourceInfo,omitempty"`
+	PrimaryPurpose   string            `json:"primaryPackagePurpose,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// newSPDXDocument 는 이미지를 나타내는 패키지가 설치된 패키지들을 포함(CONTAINS)하는 SPDX 문서를 만든다.
+// 패키지 데이터베이스의 라이선스 표기는 SPDX 라이선스 식이 아닐 수 있으므로 licenseComments 에 그대로 남기고 licenseDeclared 는 NOASSERTION 으로 둔다.
+func newSPDXDocument(imageName string, osRelease map[string]string, pkgs []SBOMPackage, created time.Time) *spdxDocument {
+	const imageID = "SPDXRef-Image"
+	doc := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              imageName,
+		DocumentNamespace: "https://podbridge5/spdx/" + url.PathEscape(imageName) + "-" + created.Format("20060102T150405Z"),
+		CreationInfo: spdxCreationInfo{
+			Created:  created.Format(time.RFC3339),
+			Creators: []string{"Tool: podbridge5"},
+		},
+		Packages: []spdxPackage{{
+			Name:             imageName,
+			SPDXID:           imageID,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			PrimaryPurpose:   "CONTAINER",
+		}},
+		Relationships: []spdxRelationship{{SPDXElementID: "SPDXRef-DOCUMENT", RelationshipType: "DESCRIBES", RelatedSPDXElement: imageID}},
+	}
+	for i, pkg := range pkgs {
+		id := fmt.Sprintf("SPDXRef-Package-%s-%d", pkg.Type, i+1)
+		sp := spdxPackage{
+			Name:             pkg.Name,
+			SPDXID:           id,
+			VersionInfo:      pkg.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			LicenseComments:  pkg.License,
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  pkg.purl(osRelease),
+			}},
+		}
+		if pkg.Source != "" {
+			sp.SourceInfo = "built from source package " + pkg.Source
+		}
+		doc.Packages = append(doc.Packages, sp)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: imageID, RelationshipType: "CONTAINS", RelatedSPDXElement: id})
+	}
+	return doc
+}
+
+// CycloneDX 1.5 JSON 중 사용하는 필드들
+type cdxDocument struct {
+	BOMFormat   string         `json:"bomFormat"`
+	SpecVersion string         `json:"specVersion"`
+	Version     int            `json:"version"`
+	Metadata    cdxMetadata    `json:"metadata"`
+	Components  []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type     string       `json:"type"`
+	BOMRef   string       `json:"bom-ref,omitempty"`
+	Name     string       `json:"name"`
+	Version  string       `json:"version,omitempty"`
+	PURL     string       `json:"purl,omitempty"`
+	Licenses []cdxLicense `json:"licenses,omitempty"`
+}
+
+type cdxLicense struct {
+	License cdxLicenseName `json:"license"`
+}
+
+type cdxLicenseName struct {
+	Name string `json:"name"`
+}
+
+// newCycloneDXDocument 는 이미지를 metadata.component 로, 설치된 패키지들을 components 로 하는 CycloneDX 문서를 만든다.
+func newCycloneDXDocument(imageName string, osRelease map[string]string, pkgs []SBOMPackage, created time.Time) *cdxDocument {
+	doc := &cdxDocument{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Metadata: cdxMetadata{
+			Timestamp: created.Format(time.RFC3339),
+			Tools:     cdxTools{Components: []cdxComponent{{Type: "application", Name: "podbridge5"}}},
+			Component: cdxComponent{Type: "container", Name: imageName},
+		},
+		Components: make([]cdxComponent, 0, len(pkgs)),
+	}
+	for _, pkg := range pkgs {
+		purl := pkg.purl(osRelease)
+		c := cdxComponent{Type: "library", BOMRef: purl, Name: pkg.Name, Version: pkg.Version, PURL: purl}
+		if pkg.License != "" {
+			c.Licenses = []cdxLicense{{License: cdxLicenseName{Name: pkg.License}}}
+		}
+		doc.Components = append(doc.Components, c)
+	}
+	return doc
+}
@@ -0,0 +1,198 @@
+package podbridge5
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testApkInstalled = `C:Q1abc=
+P:musl
+V:1.2.5-r0
+A:x86_64
+L:MIT
+o:musl
+
+C:Q1def=
+P:busybox
+V:1.36.1-r29
+A:x86_64
+L:GPL-2.0-only
+o:busybox
+`
+
+const testDpkgStatus = `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Source: glibc (2.36-9+deb12u7)
+Version: 2.36-9+deb12u7
+Description: GNU C Library
+ Contains the standard libraries.
+
+Package: removed-pkg
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+
+Package: bash
+Status: install ok installed
+Architecture: amd64
+Version: 5.2.15-2+b7
+`
+
+func TestParseApkInstalled(t *testing.T) {
+	got := parseApkInstalled([]byte(testApkInstalled))
+	want := []SBOMPackage{
+		{Type: "apk", Name: "musl", Version: "1.2.5-r0", Arch: "x86_64", License: "MIT", Source: "musl"},
+		{Type: "apk", Name: "busybox", Version: "1.36.1-r29", Arch: "x86_64", License: "GPL-2.0-only", Source: "busybox"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseApkInstalled() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseDpkgStatus(t *testing.T) {
+	got := parseDpkgStatus([]byte(testDpkgStatus))
+	want := []SBOMPackage{
+		{Type: "deb", Name: "libc6", Version: "2.36-9+deb12u7", Arch: "amd64", Source: "glibc"},
+		{Type: "deb", Name: "bash", Version: "5.2.15-2+b7", Arch: "amd64"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDpkgStatus() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseRPMQuery(t *testing.T) {
+	out := "bash\t5.1.8-9.el9\tx86_64\tGPLv3+\tbash-5.1.8-9.el9.src.rpm\n" +
+		"gpg-pubkey\t8483c65d-5ccc5b19\t(none)\t(none)\t(none)\n" +
+		"tzdata\t2:2024a-1.el9\tnoarch\t(none)\ttzdata-2024a-1.el9.src.rpm\n"
+	got := parseRPMQuery(out)
+	want := []SBOMPackage{
+		{Type: "rpm", Name: "bash", Version: "5.1.8-9.el9", Arch: "x86_64", License: "GPLv3+", Source: "bash-5.1.8-9.el9"},
+		{Type: "rpm", Name: "tzdata", Version: "2:2024a-1.el9", Arch: "noarch", Source: "tzdata-2024a-1.el9"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRPMQuery() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRootfsPackages(t *testing.T) {
+	rootfs := t.TempDir()
+	writeContextFiles(t, rootfs, map[string]string{
+		"usr/lib/apk/db/installed":           testApkInstalled,
+		"var/lib/dpkg/status.d/base":         "Package: base-files\nVersion: 12.4\nArchitecture: amd64\n",
+		"var/lib/dpkg/status.d/base.md5sums": "ignored",
+		"var/lib/dpkg/status.d/tzdata":       "Package: tzdata\nVersion: 2024a\nArchitecture: all\n",
+		"usr/lib/os-release":                 "ID=alpine\n",
+	})
+	// /lib 이 /usr/lib 를 가리키는 merged-usr 이미지
+	if err := os.Symlink("usr/lib", filepath.Join(rootfs, "lib")); err != nil {
+		t.Fatal(err)
+	}
+
+	pkgs, err := rootfsPackages(rootfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortPackages(pkgs)
+	var names []string
+	for _, p := range pkgs {
+		names = append(names, p.Type+":"+p.Name)
+	}
+	if got := strings.Join(names, ","); got != "apk:busybox,apk:musl,deb:base-files,deb:tzdata" {
+		t.Errorf("unexpected packages %s", got)
+	}
+}
+
+func TestSBOMPackagePurl(t *testing.T) {
+	pkg := SBOMPackage{Type: "apk", Name: "busybox", Version: "1.36.1-r29", Arch: "x86_64"}
+	got := pkg.purl(map[string]string{"ID": "alpine", "VERSION_ID": "3.20.0"})
+	if want := "pkg:apk/alpine/busybox@1.36.1-r29?arch=x86_64&distro=alpine-3.20.0"; got != want {
+		t.Errorf("purl() = %q, want %q", got, want)
+	}
+	if got := (SBOMPackage{Type: "deb", Name: "bash", Version: "5.2"}).purl(nil); got != "pkg:deb/unknown/bash@5.2" {
+		t.Errorf("purl() without os-release = %q", got)
+	}
+}
+
+func TestEncodeSBOM_SPDX(t *testing.T) {
+	pkgs := parseApkInstalled([]byte(testApkInstalled))
+	data, err := encodeSBOM(SBOMFormatSPDX, "tester-internal:latest", map[string]string{"ID": "alpine"}, pkgs, time.Unix(0, 0).UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc spdxDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.SPDXVersion != "SPDX-2.3" || doc.CreationInfo.Created != "1970-01-01T00:00:00Z" {
+		t.Errorf("unexpected document header %+v", doc)
+	}
+	// 이미지 패키지 + 설치된 패키지
+	if len(doc.Packages) != 3 || doc.Packages[1].Name != "musl" || doc.Packages[1].LicenseComments != "MIT" {
+		t.Fatalf("unexpected packages %+v", doc.Packages)
+	}
+	if len(doc.Relationships) != 3 || doc.Relationships[1].RelationshipType != "CONTAINS" {
+		t.Errorf("unexpected relationships %+v", doc.Relationships)
+	}
+}
+
+func TestEncodeSBOM_CycloneDX(t *testing.T) {
+	pkgs := parseDpkgStatus([]byte(testDpkgStatus))
+	data, err := encodeSBOM(SBOMFormatCycloneDX, "tester-internal:latest", map[string]string{"ID": "debian"}, pkgs, time.Unix(0, 0).UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc cdxDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.BOMFormat != "CycloneDX" || doc.Metadata.Component.Type != "container" || len(doc.Components) != 2 {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+	if doc.Components[0].PURL != "pkg:deb/debian/libc6@2.36-9%2Bdeb12u7?arch=amd64" || doc.Components[0].Licenses != nil {
+		t.Errorf("unexpected component %+v", doc.Components[0])
+	}
+
+	if _, err := encodeSBOM("syft", "x", nil, nil, time.Now()); err == nil {
+		t.Error("expected error for unsupported format")
+	}
+}
+
+func TestSBOMFileNameAndSteps(t *testing.T) {
+	if got := sbomFileName("docker.io/library/tester-internal:latest", SBOMFormatSPDX); got != "tester-internal-latest.spdx.json" {
+		t.Errorf("sbomFileName() = %q", got)
+	}
+	if got := sbomFileName("tester-internal:v1", SBOMFormatCycloneDX); got != "tester-internal-v1.cdx.json" {
+		t.Errorf("sbomFileName() = %q", got)
+	}
+
+	img := &ImageConfig{Steps: []BuildStep{{Type: StepRun, Shell: "true"}}}
+	before := img.buildSteps()
+	img.SBOM = SBOMFormatSPDX
+	if got := img.buildSteps(); got != before+1 {
+		t.Errorf("expected SBOM to add a build step, got %d -> %d", before, got)
+	}
+	if err := validateSBOMFormat("spdx-tag-value"); err == nil {
+		t.Error("expected error for unsupported format")
+	}
+}
+
+func TestSBOMDocumentSave(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "images")
+	doc := &sbomDocument{fileName: "tester-internal-latest.spdx.json", data: []byte("{}")}
+	if err := doc.save(dir); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, doc.fileName)); err != nil || string(data) != "{}" {
+		t.Errorf("unexpected SBOM file %q, %v", data, err)
+	}
+	var none *sbomDocument
+	if err := none.save(dir); err != nil {
+		t.Errorf("expected nil document to be ignored, got %v", err)
+	}
+}
@@ -0,0 +1,422 @@
+package podbridge5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	cp "github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/signature/signer"
+	"github.com/containers/image/v5/signature/sigstore"
+	is "github.com/containers/image/v5/storage"
+	imageTypes "github.com/containers/image/v5/types"
+	"github.com/containers/podman/v5/pkg/bindings/images"
+	"github.com/containers/podman/v5/pkg/bindings/system"
+	"github.com/containers/storage"
+	"github.com/seoyhaein/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ImageTrustPolicy 의 동작 방식
+const (
+	TrustModeEnforce = "enforce" // 서명이 없거나 신뢰하는 키의 서명이 아니면 컨테이너를 만들지 않는다
+	TrustModeWarn    = "warn"    // 경고만 남기고 컨테이너를 만든다
+)
+
+// GenerateSigningKey 가 만드는 키 파일 이름
+const (
+	signingKeyFileName = "podbridge5.key"
+	verifyKeyFileName  = "podbridge5.pub"
+)
+
+// ErrImageNotTrusted 는 이미지에 신뢰하는 키로 만든 서명이 없을 때 반환된다.
+var ErrImageNotTrusted = errors.New("image is not signed by a trusted key")
+
+var (
+	// trustMu 는 서명 검증 정책을 보호한다. pbTrustPolicy 가 nil 이면 검증하지 않는다.
+	trustMu       sync.RWMutex
+	pbTrustPolicy *ImageTrustPolicy
+)
+
+// SigningKey 는 이미지를 서명할 로컬 개인 키이다. sigstore(cosign) 형식의 암호화된 PEM 파일이다.
+// containers_image_openpgp 빌드 태그로는 GPG 서명을 만들 수 없으므로 sigstore 키만 지원한다.
+type SigningKey struct {
+	PrivateKeyPath string // GenerateSigningKey 또는 "cosign generate-key-pair" 로 만든 개인 키
+	Passphrase     []byte // 개인 키의 암호. 암호 없이 만든 키는 비워 둔다
+}
+
+func (k *SigningKey) newSigner() (*signer.Signer, error) {
+	if utils.IsEmptyString(k.PrivateKeyPath) {
+		return nil, errors.New("private key path cannot be empty")
+	}
+	passphrase := k.Passphrase
+	if passphrase == nil {
+		passphrase = []byte{}
+	}
+	s, err := sigstore.NewSigner(sigstore.WithPrivateKeyFile(k.PrivateKeyPath, passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing key %s: %w", k.PrivateKeyPath, err)
+	}
+	return s, nil
+}
+
+// GenerateSigningKey 는 dir 에 sigstore 형식의 키 쌍(podbridge5.key, podbridge5.pub)을 만든다.
+// 개인 키는 passphrase 로 암호화해서 소유자만 읽을 수 있도록 저장한다. 이미 키가 있으면 덮어쓰지 않고 에러를 반환한다.
+func GenerateSigningKey(dir string, passphrase []byte) (privateKeyPath, publicKeyPath string, err error) {
+	if utils.IsEmptyString(dir) {
+		return "", "", errors.New("key directory cannot be empty")
+	}
+	if passphrase == nil {
+		passphrase = []byte{}
+	}
+	keys, err := sigstore.GenerateKeyPair(passphrase)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate key pair: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	privateKeyPath = filepath.Join(dir, signingKeyFileName)
+	publicKeyPath = filepath.Join(dir, verifyKeyFileName)
+	if err := writeNewFile(privateKeyPath, keys.PrivateKey, 0o600); err != nil {
+		return "", "", err
+	}
+	if err := writeNewFile(publicKeyPath, keys.PublicKey, 0o644); err != nil {
+		_ = os.Remove(privateKeyPath)
+		return "", "", err
+	}
+	return privateKeyPath, publicKeyPath, nil
+}
+
+// writeNewFile 은 path 가 없을 때만 data 를 쓴다.
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// SignImage 는 로컬 스토리지의 이미지(name)를 key 로 서명하고, 서명을 스토리지에 이미지와 함께 저장한다.
+// 서명은 name 을 이미지의 이름으로 인증하므로 태그가 있는 이름을 사용해야 한다. 서명은 PushImage 로 push 할 때 함께 전송된다.
+// (레지스트리에 sigstore 서명을 저장하려면 podman 서비스의 registries.d 에 use-sigstore-attachments 설정이 필요하다.)
+func SignImage(ctx context.Context, name string, key *SigningKey) error {
+	if pbStore == nil {
+		return errors.New("pbStore is nil: call Init before signing images")
+	}
+	if key == nil {
+		return errors.New("signing key cannot be nil")
+	}
+	if utils.IsEmptyString(name) {
+		return errors.New("image name cannot be empty")
+	}
+	s, err := key.newSigner()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	ref, err := is.Transport.ParseStoreReference(pbStore, name)
+	if err != nil {
+		return fmt.Errorf("failed to parse image reference %s: %w", name, err)
+	}
+	// 로컬 이미지를 같은 자리에 다시 복사하면서 서명을 더한다. 레이어는 이미 있으므로 복사하지 않는다.
+	pc, err := signature.NewPolicyContext(&signature.Policy{Default: signature.PolicyRequirements{signature.NewPRInsecureAcceptAnything()}})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = pc.Destroy() }()
+	sysCtx := systemContext()
+	_, err = cp.Image(ctx, pc, ref, ref, &cp.Options{
+		SourceCtx:      sysCtx,
+		DestinationCtx: sysCtx,
+		Signers:        []*signer.Signer{s},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to sign image %s: %w", name, err)
+	}
+	Log.Infof("Signed image %s with %s", name, key.PrivateKeyPath)
+	return nil
+}
+
+// WithSigningKey 는 커밋한 이미지를 key 로 서명한다.
+// 키 파일의 내용이 빌드 지문에 들어가므로 서명 없이 또는 다른 키로 빌드한 이미지는 캐시를 쓰지 않고 다시 빌드한다.
+// 같은 키로 빌드한 캐시 이미지에 서명이 없으면(커밋 후 서명에 실패한 경우) 그 이미지를 서명한다.
+func WithSigningKey(key *SigningKey) ImageBuildOption {
+	return func(o *imageBuildOptions) {
+		o.signingKey = key
+	}
+}
+
+// signSteps 는 서명 단계 수이다.
+func (o *imageBuildOptions) signSteps() int {
+	if o.signingKey == nil {
+		return 0
+	}
+	return 1
+}
+
+// signImage 는 WithSigningKey 가 주어졌으면 name 을 서명한다.
+func (o *imageBuildOptions) signImage(ctx context.Context, name string, p *buildProgress) error {
+	if o.signingKey == nil {
+		return nil
+	}
+	return p.step("SIGN "+name, func(io.Writer, io.Writer) error {
+		return SignImage(ctx, name, o.signingKey)
+	})
+}
+
+// signingIdentity 는 지문에 넣을 서명 키 파일의 digest 이다. WithSigningKey 가 없으면 비어 있다.
+func (o *imageBuildOptions) signingIdentity() (string, error) {
+	if o.signingKey == nil {
+		return "", nil
+	}
+	sum, err := pathDigest(o.signingKey.PrivateKeyPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash signing key %s: %w", o.signingKey.PrivateKeyPath, err)
+	}
+	return sum, nil
+}
+
+// signCachedImage 는 WithSigningKey 가 주어졌고 캐시된 이미지(imageID)에 서명이 없으면 name 으로 서명한다.
+// 이미 서명이 있으면 다시 서명하지 않는다. 서명할 때마다 서명이 쌓이기 때문이다.
+func (o *imageBuildOptions) signCachedImage(ctx context.Context, imageID, name string, p *buildProgress) error {
+	if o.signingKey == nil {
+		return nil
+	}
+	if pbStore == nil {
+		return errors.New("pbStore is nil: call Init before signing images")
+	}
+	// containers/image 는 기본 인스턴스의 서명을 "signatures" 데이터에 모아 저장한다.
+	size, err := pbStore.ImageBigDataSize(imageID, "signatures")
+	if errors.Is(err, storage.ErrImageUnknown) {
+		return fmt.Errorf("failed to look up image %s: %w", imageID, err)
+	}
+	if err == nil && size > 0 {
+		return nil
+	}
+	return o.signImage(ctx, name, p)
+}
+
+// ImageTrustPolicy 는 CreateContainer 가 이미지를 실행하기 전에 확인하는 서명 검증 정책이다.
+// 키 중 하나로 만든 서명이 있으면 통과한다.
+// init container 의 보조 이미지(docker.io/library/alpine:latest)도 CreateContainer 로 실행하므로, 서명하지 않았다면 SkipImages 에 넣어야 한다.
+// RegistryConfig.Policies 는 pull 할 때 적용되고, 이 정책은 이미 로컬에 있는 이미지를 실행할 때 적용된다.
+type ImageTrustPolicy struct {
+	Mode        string   // enforce 또는 warn. 비어 있으면 enforce
+	PublicKeys  []string // sigstore 공개 키(PEM) 경로. SignImage 로 만든 서명을 검증한다
+	GPGKeyrings []string // GPG 공개 키링 경로. skopeo, podman 으로 만든 simple signing 서명을 검증한다
+	SkipImages  []string // 검증하지 않을 이미지. 짧은 이름은 docker.io 로 해석한다
+}
+
+func (p *ImageTrustPolicy) mode() string {
+	if p.Mode == "" {
+		return TrustModeEnforce
+	}
+	return p.Mode
+}
+
+// Validate 는 Mode 가 올바르고, 키가 하나 이상 있으며, 키 파일이 있는지 확인한다.
+func (p *ImageTrustPolicy) Validate() error {
+	switch p.mode() {
+	case TrustModeEnforce, TrustModeWarn:
+	default:
+		return fmt.Errorf("invalid trust mode %q", p.Mode)
+	}
+	if len(p.PublicKeys)+len(p.GPGKeyrings) == 0 {
+		return errors.New("trust policy requires at least one public key or GPG keyring")
+	}
+	for _, path := range append(append([]string(nil), p.PublicKeys...), p.GPGKeyrings...) {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("signature key: %w", err)
+		}
+	}
+	for _, name := range p.SkipImages {
+		if _, err := reference.ParseNormalizedNamed(name); err != nil {
+			return fmt.Errorf("invalid skip image %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// requirements 는 키마다 하나씩 만든 정책 요구 사항이다. 서명은 이미지의 이름(또는 같은 저장소의 digest)을 인증해야 한다.
+func (p *ImageTrustPolicy) requirements() ([]signature.PolicyRequirement, error) {
+	var reqs []signature.PolicyRequirement
+	for _, key := range p.PublicKeys {
+		req, err := signature.NewPRSigstoreSignedKeyPath(key, signature.NewPRMMatchRepoDigestOrExact())
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s: %w", key, err)
+		}
+		reqs = append(reqs, req)
+	}
+	for _, keyring := range p.GPGKeyrings {
+		req, err := signature.NewPRSignedByKeyPath(signature.SBKeyTypeGPGKeys, keyring, signature.NewPRMMatchRepoDigestOrExact())
+		if err != nil {
+			return nil, fmt.Errorf("invalid GPG keyring %s: %w", keyring, err)
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// skips 는 image 가 SkipImages 에 있는지 확인한다.
+func (p *ImageTrustPolicy) skips(image string) bool {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return false
+	}
+	named = reference.TagNameOnly(named)
+	for _, name := range p.SkipImages {
+		if skip, err := reference.ParseNormalizedNamed(name); err == nil && reference.TagNameOnly(skip).String() == named.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// SetImageTrustPolicy 는 CreateContainer 가 이미지를 실행하기 전에 서명을 검증하도록 한다.
+func SetImageTrustPolicy(policy *ImageTrustPolicy) error {
+	if policy == nil {
+		return errors.New("trust policy cannot be nil")
+	}
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	p := *policy
+	trustMu.Lock()
+	pbTrustPolicy = &p
+	trustMu.Unlock()
+	return nil
+}
+
+// ClearImageTrustPolicy 는 서명 검증을 끈다.
+func ClearImageTrustPolicy() {
+	trustMu.Lock()
+	pbTrustPolicy = nil
+	trustMu.Unlock()
+}
+
+func imageTrustPolicy() *ImageTrustPolicy {
+	trustMu.RLock()
+	defer trustMu.RUnlock()
+	return pbTrustPolicy
+}
+
+// VerifyImage 는 로컬 스토리지의 이미지(name)에 policy 의 키 중 하나로 만든 서명이 있는지 확인한다.
+// 서명이 없거나 맞지 않으면 ErrImageNotTrusted 를 감싼 에러를 반환한다. policy.Mode 는 보지 않는다.
+// CreateContainer 가 실행할 이미지를 검증하도록, podman 서비스가 name 을 해석한 이미지 ID 의 서명을 읽는다.
+// podman 서비스와 pbStore 가 다른 스토리지를 쓰면 서명을 확인할 수 없으므로 에러를 반환한다.
+func VerifyImage(ctx context.Context, name string, policy *ImageTrustPolicy) error {
+	if pbStore == nil {
+		return errors.New("pbStore is nil: call Init before verifying images")
+	}
+	if policy == nil {
+		return errors.New("trust policy cannot be nil")
+	}
+	reqs, err := policy.requirements()
+	if err != nil {
+		return err
+	}
+	if err := checkSameStore(ctx); err != nil {
+		return err
+	}
+	// podman 이 짧은 이름을 해석한 결과의 태그들로 확인한다. 서명은 태그(이름)를 인증하기 때문이다.
+	report, err := images.GetImage(ctx, name, nil)
+	if err != nil {
+		return fmt.Errorf("failed to inspect image %s: %w", name, err)
+	}
+	if len(report.RepoTags) == 0 {
+		return fmt.Errorf("%w: %s has no name to verify the signature against", ErrImageNotTrusted, name)
+	}
+
+	var errs []error
+	for _, tag := range report.RepoTags {
+		ref, err := storeReference(tag, report.ID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, req := range reqs {
+			err := verifyReference(ctx, ref, req)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err)
+		}
+	}
+	return fmt.Errorf("%w: %s: %w", ErrImageNotTrusted, name, errors.Join(errs...))
+}
+
+// checkSameStore 는 podman 서비스와 pbStore 가 같은 이미지 스토리지(graph root)를 쓰는지 확인한다.
+func checkSameStore(ctx context.Context) error {
+	info, err := system.Info(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get podman info: %w", err)
+	}
+	if info.Store == nil {
+		return errors.New("podman info has no storage information")
+	}
+	if filepath.Clean(info.Store.GraphRoot) != filepath.Clean(pbStore.GraphRoot()) {
+		return fmt.Errorf("podman service storage %s differs from local storage %s: cannot verify image signatures", info.Store.GraphRoot, pbStore.GraphRoot())
+	}
+	return nil
+}
+
+// storeReference 는 pbStore 에서 tag 이름을 가진 이미지 id 를 가리키는 참조이다.
+// id 로 고정하므로 태그가 그 사이에 다른 이미지로 옮겨가도 podman 이 해석한 이미지의 서명을 읽는다.
+func storeReference(tag, id string) (imageTypes.ImageReference, error) {
+	named, err := reference.ParseNormalizedNamed(tag)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image name %s: %w", tag, err)
+	}
+	return is.Transport.NewStoreReference(pbStore, reference.TagNameOnly(named), id)
+}
+
+// verifyReference 는 ref 의 서명이 req 를 만족하는지 확인한다.
+func verifyReference(ctx context.Context, ref imageTypes.ImageReference, req signature.PolicyRequirement) error {
+	pc, err := signature.NewPolicyContext(&signature.Policy{Default: signature.PolicyRequirements{req}})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = pc.Destroy() }()
+	src, err := ref.NewImageSource(ctx, systemContext())
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	allowed, err := pc.IsRunningImageAllowed(ctx, image.UnparsedInstance(src, nil))
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrImageNotTrusted
+	}
+	return nil
+}
+
+// checkImageSignature 는 SetImageTrustPolicy 로 정책이 설정되어 있으면 이미지의 서명을 검증한다.
+// warn 모드에서는 검증에 실패해도 경고만 남긴다.
+func checkImageSignature(ctx context.Context, name string) error {
+	policy := imageTrustPolicy()
+	if policy == nil || policy.skips(name) {
+		return nil
+	}
+	err := VerifyImage(ctx, name, policy)
+	if err == nil {
+		return nil
+	}
+	if policy.mode() == TrustModeWarn {
+		Log.Warnf("Signature verification failed for %s: %v", name, err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,170 @@
+package podbridge5
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateSigningKey(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+	priv, pub, err := GenerateSigningKey(dir, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != 0o600 {
+		t.Errorf("expected private key mode 0600, got %o", st.Mode().Perm())
+	}
+	if _, err := os.Stat(pub); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := (&SigningKey{PrivateKeyPath: priv, Passphrase: []byte("secret")}).newSigner()
+	if err != nil {
+		t.Fatalf("failed to load generated key: %v", err)
+	}
+	_ = s.Close()
+	if _, err := (&SigningKey{PrivateKeyPath: priv, Passphrase: []byte("wrong")}).newSigner(); err == nil {
+		t.Error("expected error for wrong passphrase")
+	}
+
+	if _, _, err := GenerateSigningKey(dir, []byte("secret")); err == nil {
+		t.Error("expected error when keys already exist")
+	}
+}
+
+func TestImageTrustPolicyValidate(t *testing.T) {
+	key := filepath.Join(t.TempDir(), "cosign.pub")
+	writeContextFiles(t, filepath.Dir(key), map[string]string{"cosign.pub": "key"})
+
+	tests := []struct {
+		name    string
+		policy  ImageTrustPolicy
+		wantErr bool
+	}{
+		{"public key", ImageTrustPolicy{PublicKeys: []string{key}}, false},
+		{"warn mode", ImageTrustPolicy{Mode: TrustModeWarn, GPGKeyrings: []string{key}}, false},
+		{"no keys", ImageTrustPolicy{}, true},
+		{"missing key", ImageTrustPolicy{PublicKeys: []string{key + ".missing"}}, true},
+		{"unknown mode", ImageTrustPolicy{Mode: "audit", PublicKeys: []string{key}}, true},
+		{"invalid skip image", ImageTrustPolicy{PublicKeys: []string{key}, SkipImages: []string{"Not A Name"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestImageTrustPolicyRequirements(t *testing.T) {
+	_, pub, err := GenerateSigningKey(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ImageTrustPolicy{PublicKeys: []string{pub}, GPGKeyrings: []string{pub}}
+	reqs, err := p.requirements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 2 {
+		t.Errorf("expected one requirement per key, got %d", len(reqs))
+	}
+}
+
+func TestImageTrustPolicySkips(t *testing.T) {
+	p := &ImageTrustPolicy{SkipImages: []string{"alpine"}}
+	if !p.skips(helperImage) {
+		t.Errorf("expected %s to be skipped", helperImage)
+	}
+	if p.skips("localhost/tester-internal:latest") || p.skips("alpine:3.20") {
+		t.Error("expected other images not to be skipped")
+	}
+}
+
+func TestSetImageTrustPolicy(t *testing.T) {
+	defer ClearImageTrustPolicy()
+	if err := SetImageTrustPolicy(&ImageTrustPolicy{}); err == nil {
+		t.Fatal("expected error for policy without keys")
+	}
+	if imageTrustPolicy() != nil {
+		t.Fatal("invalid policy must not be set")
+	}
+
+	key := filepath.Join(t.TempDir(), "cosign.pub")
+	writeContextFiles(t, filepath.Dir(key), map[string]string{"cosign.pub": "key"})
+	policy := &ImageTrustPolicy{PublicKeys: []string{key}, SkipImages: []string{helperImage}}
+	if err := SetImageTrustPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	policy.Mode = TrustModeWarn
+	if imageTrustPolicy().mode() != TrustModeEnforce {
+		t.Error("expected policy to be copied when set")
+	}
+	// SkipImages 에 있는 이미지는 스토리지 없이도 통과한다.
+	if err := checkImageSignature(context.Background(), helperImage); err != nil {
+		t.Errorf("expected skipped image to pass, got %v", err)
+	}
+
+	ClearImageTrustPolicy()
+	if err := checkImageSignature(context.Background(), "localhost/tester-internal:latest"); err != nil {
+		t.Errorf("expected no verification without a policy, got %v", err)
+	}
+}
+
+func TestSignSteps(t *testing.T) {
+	if got := newImageBuildOptions().signSteps(); got != 0 {
+		t.Errorf("expected no sign step, got %d", got)
+	}
+	if got := newImageBuildOptions(WithSigningKey(&SigningKey{PrivateKeyPath: "k"})).signSteps(); got != 1 {
+		t.Errorf("expected one sign step, got %d", got)
+	}
+}
+
+func TestSigningIdentity(t *testing.T) {
+	if id, err := newImageBuildOptions().signingIdentity(); err != nil || id != "" {
+		t.Fatalf("expected no identity without a key, got %q, %v", id, err)
+	}
+	dir := t.TempDir()
+	first, _, err := GenerateSigningKey(filepath.Join(dir, "a"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := GenerateSigningKey(filepath.Join(dir, "b"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 서명하지 않은 빌드와 서명한 빌드, 다른 키로 서명한 빌드는 지문이 달라야 캐시를 쓰지 않는다.
+	img := &ImageConfig{ImageName: "tester:latest"}
+	seen := map[string]string{}
+	for name, key := range map[string]*SigningKey{"unsigned": nil, "first": {PrivateKeyPath: first}, "second": {PrivateKeyPath: second}} {
+		o := newImageBuildOptions()
+		if key != nil {
+			o = newImageBuildOptions(WithSigningKey(key))
+		}
+		id, err := o.signingIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fp, err := img.fingerprint("sha256:source", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other, ok := seen[fp]; ok {
+			t.Errorf("%s and %s builds share the fingerprint %s", name, other, fp)
+		}
+		seen[fp] = name
+	}
+
+	if _, err := newImageBuildOptions(WithSigningKey(&SigningKey{PrivateKeyPath: filepath.Join(dir, "missing.key")})).signingIdentity(); err == nil {
+		t.Error("expected an error for a missing key file")
+	}
+}
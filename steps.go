@@ -0,0 +1,465 @@
+package podbridge5
+
+import (
+	"errors"
+	"fmt"
+	"github.com/containers/buildah"
+	"github.com/seoyhaein/utils"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BuildStep 종류
+const (
+	StepRun        = "run"
+	StepCopy       = "copy"
+	StepAdd        = "add"
+	StepEnv        = "env"
+	StepLabel      = "label"
+	StepUser       = "user"
+	StepWorkDir    = "workdir"
+	StepEntrypoint = "entrypoint"
+	StepCmd        = "cmd"
+	StepExpose     = "expose"
+	StepVolume     = "volume"
+)
+
+// BuildStep 은 이미지 빌드 단계 하나이다. Dockerfile 명령과 같은 의미로 buildah.Builder 에 적용된다.
+//
+// config.json 예:
+//
+//	"steps": [
+//	  {"type": "run", "shell": "apk add --no-cache curl"},
+//	  {"type": "copy", "src": ["./executor.sh"], "dest": "/app/", "chmod": "0755"},
+//	  {"type": "env", "env": {"LANG": "C.UTF-8"}},
+//	  {"type": "cmd", "command": ["/app/executor.sh"]}
+//	]
+type BuildStep struct {
+	Type    string            `json:"type"`
+	Command []string          `json:"command,omitempty"` // run (exec 형식), entrypoint, cmd
+	Shell   string            `json:"shell,omitempty"`   // run (셸 형식, /bin/sh -c 로 실행)
+	Src     []string          `json:"src,omitempty"`     // copy, add 의 소스. add 는 URL 과 압축 파일을 지원한다.
+	Dest    string            `json:"dest,omitempty"`    // copy, add 의 목적지
+	Chmod   string            `json:"chmod,omitempty"`   // copy, add (예: "0750"). 비어 있으면 0755 에 setuid/setgid 비트 제거, "preserve" 면 원본 권한 유지
+	Chown   string            `json:"chown,omitempty"`   // copy, add (예: "1000:1000"). 비어 있으면 root:root
+	Env     map[string]string `json:"env,omitempty"`     // env
+	Labels  map[string]string `json:"labels,omitempty"`  // label
+	Value   string            `json:"value,omitempty"`   // user, workdir
+	Values  []string          `json:"values,omitempty"`  // expose (예: "8080/tcp"), volume
+}
+
+// String 은 진행 상황에 표시할 Dockerfile 형태의 문자열을 반환한다.
+func (s *BuildStep) String() string {
+	name := strings.ToUpper(s.Type)
+	switch s.Type {
+	case StepRun:
+		if s.Shell != "" {
+			return name + " " + s.Shell
+		}
+		return name + " " + strings.Join(s.Command, " ")
+	case StepCopy, StepAdd:
+		return name + " " + strings.Join(append(append([]string(nil), s.Src...), s.Dest), " ")
+	case StepEnv:
+		return name + " " + joinPairs(s.Env)
+	case StepLabel:
+		return name + " " + joinPairs(s.Labels)
+	case StepUser, StepWorkDir:
+		return name + " " + s.Value
+	case StepEntrypoint, StepCmd:
+		return name + " " + strings.Join(s.Command, " ")
+	case StepExpose, StepVolume:
+		return name + " " + strings.Join(s.Values, " ")
+	}
+	return name
+}
+
+// Validate 는 단계 종류에 필요한 필드가 채워져 있는지 확인한다.
+func (s *BuildStep) Validate() error {
+	switch s.Type {
+	case StepRun:
+		if utils.IsEmptyString(s.Shell) == (len(s.Command) == 0) {
+			return errors.New("run step requires exactly one of command or shell")
+		}
+	case StepCopy, StepAdd:
+		if len(s.Src) == 0 || utils.IsEmptyString(s.Dest) {
+			return fmt.Errorf("%s step requires src and dest", s.Type)
+		}
+		if err := s.fileMode().Validate(); err != nil {
+			return fmt.Errorf("%s step: %w", s.Type, err)
+		}
+	case StepEnv:
+		if len(s.Env) == 0 {
+			return errors.New("env step requires env")
+		}
+	case StepLabel:
+		if len(s.Labels) == 0 {
+			return errors.New("label step requires labels")
+		}
+	case StepUser, StepWorkDir:
+		if utils.IsEmptyString(s.Value) {
+			return fmt.Errorf("%s step requires value", s.Type)
+		}
+	case StepEntrypoint, StepCmd:
+		if len(s.Command) == 0 {
+			return fmt.Errorf("%s step requires command", s.Type)
+		}
+	case StepExpose, StepVolume:
+		if len(s.Values) == 0 {
+			return fmt.Errorf("%s step requires values", s.Type)
+		}
+	case "":
+		return errors.New("step type cannot be empty")
+	default:
+		return fmt.Errorf("unknown step type %q", s.Type)
+	}
+	return nil
+}
+
+// fileMode 는 copy, add 단계의 Chmod, Chown 을 FileMode 로 바꾼다. ScriptMap 과 같은 기본값을 사용한다.
+func (s *BuildStep) fileMode() FileMode {
+	owner, group, _ := strings.Cut(s.Chown, ":")
+	return FileMode{Mode: s.Chmod, Owner: owner, Group: group}
+}
+
+// validateSteps 는 빌드를 시작하기 전에 모든 단계를 검사한다.
+func validateSteps(steps []BuildStep) error {
+	for i := range steps {
+		if err := steps[i].Validate(); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// applySteps 는 steps 를 순서대로 builder 에 적용한다.
+// run 단계는 앞선 user 단계로 지정된 사용자로 실행되고, 지정되지 않았으면 root 로 실행된다.
+// configDir 은 설정 파일의 디렉토리로, copy, add 단계의 컨텍스트를 정한다. (copyContextDir)
+func applySteps(builder *buildah.Builder, steps []BuildStep, configDir string, p *buildProgress) error {
+	if err := validateSteps(steps); err != nil {
+		return err
+	}
+	for i := range steps {
+		s := &steps[i]
+		err := p.step(s.String(), func(stdout, stderr io.Writer) error {
+			return applyStep(builder, s, configDir, stdout, stderr)
+		})
+		if err != nil {
+			return fmt.Errorf("step %d (%s): %w", i+1, s.Type, err)
+		}
+	}
+	return nil
+}
+
+func applyStep(builder *buildah.Builder, s *BuildStep, configDir string, stdout, stderr io.Writer) error {
+	switch s.Type {
+	case StepRun:
+		args := s.Command
+		if s.Shell != "" {
+			args = []string{"/bin/sh", "-c", s.Shell}
+		}
+		opts := defaultRunOptions
+		if user := builder.User(); user != "" {
+			opts.User = user
+		}
+		opts.Stdout = stdout
+		opts.Stderr = stderr
+		return builder.Run(args, opts)
+	case StepCopy, StepAdd:
+		contextDir, err := copyContextDir(configDir, s.Src...)
+		if err != nil {
+			return err
+		}
+		options := s.fileMode().addAndCopyOptions()
+		options.ContextDir = contextDir
+		return builder.Add(s.Dest, s.Type == StepAdd, options, s.Src...)
+	case StepEnv:
+		for _, k := range sortedStringKeys(s.Env) {
+			builder.SetEnv(k, s.Env[k])
+		}
+	case StepLabel:
+		for _, k := range sortedStringKeys(s.Labels) {
+			builder.SetLabel(k, s.Labels[k])
+		}
+	case StepUser:
+		builder.SetUser(s.Value)
+	case StepWorkDir:
+		builder.SetWorkDir(s.Value)
+	case StepEntrypoint:
+		builder.SetEntrypoint(s.Command)
+	case StepCmd:
+		builder.SetCmd(s.Command)
+	case StepExpose:
+		for _, port := range s.Values {
+			builder.SetPort(port)
+		}
+	case StepVolume:
+		for _, v := range s.Values {
+			builder.AddVolume(v)
+		}
+	}
+	return nil
+}
+
+// setup 은 ImageConfig.Steps 가 있으면 WorkDir, CMD 를 먼저 설정한 뒤 Steps 를 실행한다.
+// Steps 가 없으면 기존 방식대로 디렉토리 생성, 스크립트 복사, 권한 설정, 패키지 설치 후 WorkDir, CMD 를 설정한다.
+// User 가 있으면 빌드 시작 전에 사용자를 만들고, 마지막에 이미지의 USER 로 설정한다.
+func (img *ImageConfig) setup(builder *buildah.Builder, p *buildProgress) error {
+	if err := img.validateSetup(); err != nil {
+		return err
+	}
+	if len(img.Steps) == 0 {
+		return defaultSetup(builder, setupConfig{
+			dirs:            img.Directories,
+			contextDir:      img.configDir,
+			scripts:         img.ScriptMap,
+			modes:           img.ScriptModes,
+			permissionFiles: img.PermissionFiles,
+			workDir:         img.WorkDir,
+			cmd:             img.CMD,
+			user:            img.User,
+			packages:        img.packages(),
+		}, p)
+	}
+	packages := img.packages()
+	if err := packages.validate(); err != nil {
+		return err
+	}
+	if img.User != nil {
+		if err := img.User.Validate(); err != nil {
+			return err
+		}
+		if err := img.User.create(builder, p); err != nil {
+			return err
+		}
+	}
+	// Steps 에서 사용할 수 있도록 패키지를 먼저 설치한다.
+	if err := installPackages(builder, packages, p); err != nil {
+		return fmt.Errorf("failed to install packages: %w", err)
+	}
+	setWorkDirAndCmd(builder, img.WorkDir, img.CMD)
+	if err := applySteps(builder, img.Steps, img.configDir, p); err != nil {
+		return err
+	}
+	if img.User != nil {
+		// Steps 로 만든 파일들이 있는 작업 디렉토리를 사용자 소유로 바꾼다.
+		if err := img.User.chown(builder, img.userOwnedPaths(), p); err != nil {
+			return err
+		}
+		builder.SetUser(img.User.Name)
+	}
+	return nil
+}
+
+// validateSetup 은 setup 이 빌드 작업 전에 검사하는 값들(SBOM 형식, 아카이브 형식, 단계, 스크립트 권한, 패키지 이름, 사용자)을 확인한다.
+// 파일이 있는지는 보지 않는다. 빌드할 때 지문을 계산하거나 복사하면서 확인된다.
+func (img *ImageConfig) validateSetup() error {
+	if err := validateSBOMFormat(img.SBOM); err != nil {
+		return err
+	}
+	if err := img.validateArchiveFormat(); err != nil {
+		return err
+	}
+	if img.Encryption != nil {
+		if err := img.Encryption.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(img.Steps) > 0 {
+		if err := validateSteps(img.Steps); err != nil {
+			return err
+		}
+	} else if err := validateScriptModes(img.ScriptModes); err != nil {
+		return err
+	}
+	if err := img.packages().validate(); err != nil {
+		return err
+	}
+	if img.User != nil {
+		return img.User.Validate()
+	}
+	return nil
+}
+
+// packages 는 Packages, DistroPackages 로 설치할 패키지 목록이다.
+func (img *ImageConfig) packages() packageSet {
+	return packageSet{common: img.Packages, distro: img.DistroPackages}
+}
+
+// userOwnedPaths 는 User 소유로 바꿀 경로들이다. Steps 가 없으면 Directories, 있으면 WorkDir 이다.
+func (img *ImageConfig) userOwnedPaths() []string {
+	if len(img.Steps) == 0 {
+		return img.Directories
+	}
+	if img.WorkDir != "" {
+		return []string{img.WorkDir}
+	}
+	return nil
+}
+
+// setupConfig 는 Steps 가 없을 때 defaultSetup 에 넘기는 값들이다. ImageConfig 와 ContainerConfig 가 같은 과정을 사용한다.
+type setupConfig struct {
+	dirs            []string
+	contextDir      string // 설정 파일의 디렉토리. 스크립트를 복사할 때의 컨텍스트 (copyContextDir)
+	scripts         map[string][]string
+	modes           map[string]FileMode
+	permissionFiles []string
+	workDir         string
+	cmd             []string
+	user            *UserConfig // 있으면 사용자를 만들고 디렉토리와 스크립트를 그 사용자 소유로 한다
+	packages        packageSet
+}
+
+// defaultSetup 은 Steps 가 없을 때 사용하는 고정된 단계들이다.
+func defaultSetup(builder *buildah.Builder, c setupConfig, p *buildProgress) error {
+	if err := validateScriptModes(c.modes); err != nil {
+		return err
+	}
+	if err := c.packages.validate(); err != nil {
+		return err
+	}
+	modes := c.modes
+	if c.user != nil {
+		if err := c.user.Validate(); err != nil {
+			return err
+		}
+		// 사용자가 있어야 디렉토리와 스크립트의 소유자로 지정할 수 있다.
+		if err := c.user.create(builder, p); err != nil {
+			return err
+		}
+		modes = c.user.scriptModes(c.scripts, c.modes)
+	}
+
+	// Directories 에 지정된 디렉토리 생성
+	if err := createDirectories(builder, c.dirs, p); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+	if c.user != nil {
+		if err := c.user.chown(builder, c.dirs, p); err != nil {
+			return err
+		}
+	}
+
+	// ScriptMap 에 지정된 스크립트 복사
+	if err := copyScripts(builder, c.scripts, modes, c.contextDir, p); err != nil {
+		return fmt.Errorf("failed to copy scripts: %w", err)
+	}
+
+	// PermissionFiles 에 지정된 파일 권한 설정. ScriptModes 로 권한을 지정한 파일은 건너뛴다.
+	targets := permissionTargets(c.permissionFiles, explicitModeTargets(c.scripts, c.modes))
+	if err := setFilePermissions(builder, targets, p); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+
+	// 베이스 이미지의 패키지 매니저로 종속성 설치. root 로 실행된다.
+	if err := installPackages(builder, c.packages, p); err != nil {
+		return fmt.Errorf("failed to install packages: %w", err)
+	}
+
+	// 작업 디렉토리 및 CMD 설정
+	builder.SetWorkDir(c.workDir)
+	builder.SetCmd(c.cmd)
+	if c.user != nil {
+		builder.SetUser(c.user.Name)
+	}
+	return nil
+}
+
+// setWorkDirAndCmd 는 Steps 를 사용할 때 비어 있지 않은 WorkDir, CMD 만 기본값으로 설정한다.
+func setWorkDirAndCmd(builder *buildah.Builder, workDir string, cmd []string) {
+	if workDir != "" {
+		builder.SetWorkDir(workDir)
+	}
+	if len(cmd) > 0 {
+		builder.SetCmd(cmd)
+	}
+}
+
+// stepSources 는 copy, add 단계의 로컬 소스 파일 목록이다. URL 은 제외한다.
+func stepSources(steps []BuildStep) []string {
+	var srcs []string
+	for _, s := range steps {
+		if s.Type != StepCopy && s.Type != StepAdd {
+			continue
+		}
+		for _, src := range s.Src {
+			if strings.Contains(src, "://") {
+				continue
+			}
+			// 패턴이면 일치하는 파일들로 펼친다. 일치하는 파일이 없으면 그대로 두어 해시할 때 에러가 나게 한다.
+			if strings.ContainsAny(src, "*?[") {
+				if matches, err := filepath.Glob(src); err == nil && len(matches) > 0 {
+					srcs = append(srcs, matches...)
+					continue
+				}
+			}
+			srcs = append(srcs, src)
+		}
+	}
+	return srcs
+}
+
+// copyContextDir 는 srcs 를 복사할 때의 컨텍스트 디렉토리이다. 소스는 컨텍스트 밖으로 나갈 수 없다.
+// 상대 경로가 있으면 현재 디렉토리 기준이다. 설정 파일에서 읽은 소스는 설정 파일 기준의 절대 경로이므로
+// 설정 파일의 디렉토리(configDir)를 쓴다. configDir 밖의 소스("/etc/shadow", "../x" 등)는 에러이다.
+// configDir 이 없으면(코드로 만든 설정) 소스들의 공통 상위 디렉토리를 쓴다.
+func copyContextDir(configDir string, srcs ...string) (string, error) {
+	var dirs []string
+	relative := false
+	for _, src := range srcs {
+		if strings.Contains(src, "://") {
+			continue
+		}
+		if !filepath.IsAbs(src) {
+			relative = true
+			continue
+		}
+		if configDir != "" {
+			if _, ok := relInside(configDir, src); !ok {
+				return "", fmt.Errorf("source %s is outside the config directory %s", src, configDir)
+			}
+		}
+		dirs = append(dirs, filepath.Dir(src))
+	}
+	switch {
+	case relative:
+		return ".", nil
+	case configDir != "":
+		return configDir, nil
+	case len(dirs) == 0:
+		return ".", nil
+	}
+	return commonDir(dirs), nil
+}
+
+// commonDir 는 절대 경로 dirs 의 가장 깊은 공통 상위 디렉토리이다.
+func commonDir(dirs []string) string {
+	common := filepath.Clean(dirs[0])
+	for _, dir := range dirs[1:] {
+		for {
+			if _, ok := relInside(common, dir); ok {
+				break
+			}
+			common = filepath.Dir(common)
+		}
+	}
+	return common
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinPairs(m map[string]string) string {
+	pairs := make([]string, 0, len(m))
+	for _, k := range sortedStringKeys(m) {
+		pairs = append(pairs, k+"="+m[k])
+	}
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,168 @@
+package podbridge5
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildStepValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    BuildStep
+		wantErr bool
+	}{
+		{"run shell", BuildStep{Type: StepRun, Shell: "apk add curl"}, false},
+		{"run exec", BuildStep{Type: StepRun, Command: []string{"/app/install.sh"}}, false},
+		{"run both", BuildStep{Type: StepRun, Shell: "true", Command: []string{"true"}}, true},
+		{"run neither", BuildStep{Type: StepRun}, true},
+		{"copy", BuildStep{Type: StepCopy, Src: []string{"a.sh"}, Dest: "/app/"}, false},
+		{"copy without dest", BuildStep{Type: StepCopy, Src: []string{"a.sh"}}, true},
+		{"copy invalid chmod", BuildStep{Type: StepCopy, Src: []string{"a.sh"}, Dest: "/app/", Chmod: "rwx"}, true},
+		{"add without src", BuildStep{Type: StepAdd, Dest: "/app/"}, true},
+		{"env", BuildStep{Type: StepEnv, Env: map[string]string{"A": "1"}}, false},
+		{"env empty", BuildStep{Type: StepEnv}, true},
+		{"label empty", BuildStep{Type: StepLabel}, true},
+		{"user", BuildStep{Type: StepUser, Value: "1000:1000"}, false},
+		{"workdir empty", BuildStep{Type: StepWorkDir}, true},
+		{"cmd", BuildStep{Type: StepCmd, Command: []string{"/app/executor.sh"}}, false},
+		{"entrypoint empty", BuildStep{Type: StepEntrypoint}, true},
+		{"expose", BuildStep{Type: StepExpose, Values: []string{"8080/tcp"}}, false},
+		{"volume empty", BuildStep{Type: StepVolume}, true},
+		{"empty type", BuildStep{}, true},
+		{"unknown type", BuildStep{Type: "healthcheck"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.step.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildStepFileMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		step        BuildStep
+		chmod       string
+		chown       string
+		stripSetuid bool
+	}{
+		// chmod, chown 이 없으면 ScriptMap 과 같이 0755, root:root 로 복사하고 setuid/setgid 비트를 지운다.
+		{"default", BuildStep{Type: StepCopy}, "0755", "0:0", true},
+		{"explicit", BuildStep{Type: StepCopy, Chmod: "0750", Chown: "1000:1000"}, "0750", "1000:1000", true},
+		{"owner only", BuildStep{Type: StepAdd, Chown: "app"}, "0755", "app", true},
+		{"preserve", BuildStep{Type: StepCopy, Chmod: FileModePreserve}, "", "0:0", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.step.fileMode().addAndCopyOptions()
+			if opts.Chmod != tt.chmod || opts.Chown != tt.chown {
+				t.Errorf("expected chmod %q chown %q, got %q %q", tt.chmod, tt.chown, opts.Chmod, opts.Chown)
+			}
+			if opts.StripSetuidBit != tt.stripSetuid || opts.StripSetgidBit != tt.stripSetuid {
+				t.Errorf("expected setuid/setgid stripping %v, got %v %v", tt.stripSetuid, opts.StripSetuidBit, opts.StripSetgidBit)
+			}
+		})
+	}
+}
+
+func TestValidateSteps_ReportsIndex(t *testing.T) {
+	steps := []BuildStep{{Type: StepRun, Shell: "true"}, {Type: StepCopy}}
+	err := validateSteps(steps)
+	if err == nil || !strings.HasPrefix(err.Error(), "step 2:") {
+		t.Fatalf("expected error for step 2, got %v", err)
+	}
+}
+
+func TestBuildStepString(t *testing.T) {
+	tests := map[string]BuildStep{
+		"RUN apk add curl":          {Type: StepRun, Shell: "apk add curl"},
+		"COPY a.sh b.sh /app/":      {Type: StepCopy, Src: []string{"a.sh", "b.sh"}, Dest: "/app/"},
+		"ENV A=1 B=2":               {Type: StepEnv, Env: map[string]string{"B": "2", "A": "1"}},
+		"USER app":                  {Type: StepUser, Value: "app"},
+		"CMD /bin/sh -c run":        {Type: StepCmd, Command: []string{"/bin/sh", "-c", "run"}},
+		"EXPOSE 8080/tcp 9090/udp":  {Type: StepExpose, Values: []string{"8080/tcp", "9090/udp"}},
+		"LABEL org.example.team=ml": {Type: StepLabel, Labels: map[string]string{"org.example.team": "ml"}},
+	}
+	for want, step := range tests {
+		if got := step.String(); got != want {
+			t.Errorf("String() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestImageConfigSteps_FromJSON(t *testing.T) {
+	data := []byte(`{
+		"image": {
+			"sourceImageName": "docker.io/library/alpine:latest",
+			"steps": [
+				{"type": "run", "shell": "apk add --no-cache curl"},
+				{"type": "copy", "src": ["./executor.sh"], "dest": "/app/", "chmod": "0755"},
+				{"type": "cmd", "command": ["/app/executor.sh"]}
+			]
+		}
+	}`)
+	var cfg BuildConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Image.Steps) != 3 || cfg.Image.Steps[1].Chmod != "0755" {
+		t.Fatalf("unexpected steps %+v", cfg.Image.Steps)
+	}
+	if err := validateSteps(cfg.Image.Steps); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Steps + commit + save
+	if got := cfg.Image.buildSteps(); got != 5 {
+		t.Errorf("expected 5 build steps, got %d", got)
+	}
+}
+
+func TestStepSources(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{"a.sh": "a", "b.sh": "b", "c.txt": "c"})
+	steps := []BuildStep{
+		{Type: StepCopy, Src: []string{filepath.Join(dir, "*.sh")}, Dest: "/app/"},
+		{Type: StepAdd, Src: []string{"https://example.com/tool.tar.gz", filepath.Join(dir, "c.txt")}, Dest: "/opt/"},
+		{Type: StepRun, Shell: "true"},
+	}
+	got := stepSources(steps)
+	want := []string{filepath.Join(dir, "a.sh"), filepath.Join(dir, "b.sh"), filepath.Join(dir, "c.txt")}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("stepSources() = %v, want %v", got, want)
+	}
+}
+
+func TestImageConfigFingerprint_Steps(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{"run.sh": "#!/bin/sh\n"})
+	img := &ImageConfig{Steps: []BuildStep{
+		{Type: StepCopy, Src: []string{filepath.Join(dir, "run.sh")}, Dest: "/app/"},
+		{Type: StepCmd, Command: []string{"/app/run.sh"}},
+	}}
+	before, err := img.fingerprint("sha256:source", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeContextFiles(t, dir, map[string]string{"run.sh": "#!/bin/sh\necho changed\n"})
+	after, err := img.fingerprint("sha256:source", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Error("expected fingerprint to change when a copied file changes")
+	}
+
+	img.Steps[1].Command = []string{"/app/run.sh", "--verbose"}
+	changed, err := img.fingerprint("sha256:source", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed == after {
+		t.Error("expected fingerprint to change when a step changes")
+	}
+}
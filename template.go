@@ -0,0 +1,309 @@
+package podbridge5
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/containers/podman/v5/pkg/specgen"
+	"github.com/seoyhaein/utils"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HealthCheckTemplate 의 기본값 (podman 의 기본값과 같다)
+const (
+	defaultHealthInterval    = "30s"
+	defaultHealthTimeout     = "30s"
+	defaultHealthStartPeriod = "0s"
+	defaultHealthRetries     = 3
+)
+
+// JobTemplate 은 이름을 붙여 파일로 관리하는 컨테이너 작업 정의이다.
+// Spec 에는 NewSpec(With...) 또는 ContainerConfig.ToSpec 으로 만든 값이 그대로 들어가고,
+// healthcheck 와 실행 시간 제한은 사람이 읽고 고치기 쉬운 형태로 따로 둔다.
+// SaveJobTemplate 으로 저장하고 LoadJobTemplate 으로 읽은 뒤, Override 로 실행마다 값을 바꾸고 ToSpec 으로 CreateContainer 에 넘길 spec 을 만든다.
+type JobTemplate struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Spec        *specgen.SpecGenerator `json:"spec"`
+	Health      *HealthCheckTemplate   `json:"health,omitempty"`    // 있으면 Spec 의 healthconfig 를 덮어쓴다
+	TimeLimit   string                 `json:"timeLimit,omitempty"` // 최대 실행 시간 (예: "2h30m"). WithTimeLimit 참고
+}
+
+// HealthCheckTemplate 은 WithHealthChecker 의 인자이다. 비어 있는 값은 podman 의 기본값을 쓴다.
+type HealthCheckTemplate struct {
+	Command     string `json:"command"`               // 예: "CMD-SHELL /app/healthcheck.sh"
+	Interval    string `json:"interval,omitempty"`    // 기본 30s, "disable" 이면 주기적으로 확인하지 않는다
+	Retries     uint   `json:"retries,omitempty"`     // 기본 3
+	Timeout     string `json:"timeout,omitempty"`     // 기본 30s
+	StartPeriod string `json:"startPeriod,omitempty"` // 기본 0s
+}
+
+// option 은 기본값을 채운 WithHealthChecker 이다.
+func (h *HealthCheckTemplate) option() ContainerOptions {
+	interval, timeout, startPeriod, retries := h.Interval, h.Timeout, h.StartPeriod, h.Retries
+	if interval == "" {
+		interval = defaultHealthInterval
+	}
+	if timeout == "" {
+		timeout = defaultHealthTimeout
+	}
+	if startPeriod == "" {
+		startPeriod = defaultHealthStartPeriod
+	}
+	if retries == 0 {
+		retries = defaultHealthRetries
+	}
+	return WithHealthChecker(h.Command, interval, retries, timeout, startPeriod)
+}
+
+// Validate 는 이름, spec, healthcheck, 실행 시간 제한을 확인한다.
+func (t *JobTemplate) Validate() error {
+	if utils.IsEmptyString(t.Name) {
+		return errors.New("template name cannot be empty")
+	}
+	if t.Spec == nil {
+		return fmt.Errorf("template %s has no spec", t.Name)
+	}
+	if _, err := t.ToSpec(); err != nil {
+		return fmt.Errorf("template %s: %w", t.Name, err)
+	}
+	return nil
+}
+
+// ToSpec 은 템플릿의 spec 복사본에 healthcheck, 실행 시간 제한, opts 를 차례로 적용한다. 템플릿은 바뀌지 않는다.
+// opts 로 WithName, WithEnv, WithPod 처럼 실행마다 다른 값을 준다.
+func (t *JobTemplate) ToSpec(opts ...ContainerOptions) (*specgen.SpecGenerator, error) {
+	if t.Spec == nil {
+		return nil, errors.New("template has no spec")
+	}
+	data, err := json.Marshal(t.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy spec: %w", err)
+	}
+	spec := &specgen.SpecGenerator{}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("failed to copy spec: %w", err)
+	}
+
+	var templateOpts []ContainerOptions
+	if t.Health != nil {
+		templateOpts = append(templateOpts, t.Health.option())
+	}
+	if t.TimeLimit != "" {
+		limit, err := time.ParseDuration(t.TimeLimit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time limit %q: %w", t.TimeLimit, err)
+		}
+		templateOpts = append(templateOpts, WithTimeLimit(limit))
+	}
+	for _, opt := range append(templateOpts, opts...) {
+		if err := opt(spec); err != nil {
+			return nil, err
+		}
+	}
+	return spec, nil
+}
+
+// Override 는 patch 를 템플릿에 덮어쓴 새 템플릿을 반환한다. patch 는 템플릿과 같은 구조의 일부분이며 JSON 또는 YAML 이다.
+// 설정 파일의 include 와 같이 맵(spec.env, spec.labels 등)은 키별로 합치고, 목록과 값은 바꾸며, null 은 값을 지운다.
+//
+//	spec:
+//	  env: {SAMPLE: s2}
+//	timeLimit: 30m
+func (t *JobTemplate) Override(patch []byte) (*JobTemplate, error) {
+	base, err := templateDocument(t)
+	if err != nil {
+		return nil, err
+	}
+	data, err := yaml.YAMLToJSON(patch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid override: %w", err)
+	}
+	var override map[string]any
+	if err := decodeDocument(data, &override); err != nil {
+		return nil, fmt.Errorf("invalid override: %w", err)
+	}
+	mergeConfigDocument(base, override)
+
+	merged, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+	return parseJobTemplate(merged, false)
+}
+
+// SaveJobTemplate 은 템플릿을 path 에 저장한다. 확장자로 JSON(.json) 또는 YAML(.yaml, .yml) 형식을 고른다.
+func SaveJobTemplate(path string, t *JobTemplate) error {
+	if t == nil {
+		return errors.New("template cannot be nil")
+	}
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	format, err := templateFormat(path)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode template %s: %w", t.Name, err)
+	}
+	data = append(data, '\n')
+	if format == ConfigFormatYAML {
+		if data, err = yaml.JSONToYAML(data); err != nil {
+			return fmt.Errorf("failed to encode template %s: %w", t.Name, err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write template %s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadJobTemplate 은 SaveJobTemplate 으로 저장했거나 직접 작성한 템플릿 파일을 읽는다.
+// 알 수 없는 필드는 에러이며, JSON 파일의 에러에는 줄과 열이 들어 있다.
+func LoadJobTemplate(path string) (*JobTemplate, error) {
+	format, err := templateFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+	data, err := configToJSON(raw, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode template %s: %w", path, err)
+	}
+	t, err := parseJobTemplate(data, format == ConfigFormatJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode template %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// TemplateChange 는 두 템플릿 사이에서 바뀐 값 하나이다. 추가된 값은 Old 가, 지워진 값은 New 가 nil 이다.
+type TemplateChange struct {
+	Path string `json:"path"` // 예: "spec.env.SAMPLE", "spec.mounts[0].source", `spec.labels["app.kubernetes.io/name"]`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+func (c TemplateChange) String() string {
+	switch {
+	case c.Old == nil:
+		return fmt.Sprintf("+ %s: %v", c.Path, c.New)
+	case c.New == nil:
+		return fmt.Sprintf("- %s: %v", c.Path, c.Old)
+	default:
+		return fmt.Sprintf("~ %s: %v -> %v", c.Path, c.Old, c.New)
+	}
+}
+
+// DiffJobTemplates 는 a 에서 b 로 바뀐 값들을 경로 순서로 반환한다. 같으면 빈 목록이다.
+// 두 템플릿을 파일에 저장되는 형태로 비교하므로 healthcheck, 실행 시간 제한도 적힌 그대로 비교한다.
+func DiffJobTemplates(a, b *JobTemplate) ([]TemplateChange, error) {
+	if a == nil || b == nil {
+		return nil, errors.New("templates cannot be nil")
+	}
+	docA, err := templateDocument(a)
+	if err != nil {
+		return nil, err
+	}
+	docB, err := templateDocument(b)
+	if err != nil {
+		return nil, err
+	}
+	oldValues, newValues := make(map[string]any), make(map[string]any)
+	flattenDocument("", docA, oldValues)
+	flattenDocument("", docB, newValues)
+
+	var changes []TemplateChange
+	for p, o := range oldValues {
+		if n, ok := newValues[p]; !ok {
+			changes = append(changes, TemplateChange{Path: p, Old: o})
+		} else if n != o {
+			changes = append(changes, TemplateChange{Path: p, Old: o, New: n})
+		}
+	}
+	for p, n := range newValues {
+		if _, ok := oldValues[p]; !ok {
+			changes = append(changes, TemplateChange{Path: p, New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// templateFormat 은 템플릿 파일의 형식이다. 템플릿은 null 로 값을 지울 수 있어야 하므로 TOML 은 지원하지 않는다.
+func templateFormat(path string) (string, error) {
+	format, err := configFormat(path)
+	if err != nil || format == ConfigFormatTOML {
+		return "", fmt.Errorf("unsupported template file extension %q: use .json, .yaml or .yml", filepath.Ext(path))
+	}
+	return format, nil
+}
+
+// parseJobTemplate 은 JSON 템플릿을 엄격하게 읽고 확인한다.
+func parseJobTemplate(data []byte, locate bool) (*JobTemplate, error) {
+	var t JobTemplate
+	if err := decodeStrict(data, &t, locate); err != nil {
+		return nil, err
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// templateDocument 는 템플릿을 저장되는 형태의 일반 문서로 바꾼다.
+func templateDocument(t *JobTemplate) (map[string]any, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode template %s: %w", t.Name, err)
+	}
+	var doc map[string]any
+	if err := decodeDocument(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// decodeDocument 는 숫자를 json.Number 로 보존하며 data 를 읽는다. 큰 정수(메모리 제한 등)가 float 으로 바뀌지 않게 한다.
+func decodeDocument(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// flattenDocument 는 문서의 값들을 경로별로 out 에 담는다. 비어 있는 맵과 목록은 값이 없는 것으로 본다.
+func flattenDocument(prefix string, v any, out map[string]any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			key := k
+			if strings.ContainsAny(k, ".[]\"") {
+				key = fmt.Sprintf("[%q]", k)
+			} else if prefix != "" {
+				key = "." + k
+			}
+			flattenDocument(prefix+key, e, out)
+		}
+	case []any:
+		for i, e := range v {
+			flattenDocument(fmt.Sprintf("%s[%d]", prefix, i), e, out)
+		}
+	case nil:
+	default:
+		out[prefix] = v
+	}
+}
@@ -0,0 +1,193 @@
+package podbridge5
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestJobTemplate(t *testing.T) *JobTemplate {
+	t.Helper()
+	input := t.TempDir()
+	c := ContainerConfig{
+		WorkDir: "/app",
+		Cmd:     []string{"/bin/sh", "-c", "/app/executor.sh"},
+		Env:     map[string]string{"SAMPLE": "s1", "THREADS": "4"},
+		Volumes: []VolumeConfig{{HostPath: input, ContainerPath: "/app/input", ReadOnly: true}},
+	}
+	c.Resources.Memory.MemLimit = 8 << 30
+	spec, err := c.ToSpec("tester:latest", "rnaseq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.Labels = map[string]string{"app.kubernetes.io/name": "rnaseq"}
+	return &JobTemplate{
+		Name:      "rnaseq",
+		Spec:      spec,
+		Health:    &HealthCheckTemplate{Command: "CMD-SHELL /app/healthcheck.sh", Interval: "10s"},
+		TimeLimit: "2h",
+	}
+}
+
+func TestJobTemplateSaveLoad(t *testing.T) {
+	tmpl := newTestJobTemplate(t)
+	want, err := tmpl.ToSpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"rnaseq.json", "rnaseq.yaml"} {
+		path := filepath.Join(t.TempDir(), "templates", name)
+		if err := SaveJobTemplate(path, tmpl); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		loaded, err := LoadJobTemplate(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := loaded.ToSpec()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: spec changed after save and load\n got %+v\nwant %+v", name, got, want)
+		}
+		if changes, err := DiffJobTemplates(tmpl, loaded); err != nil || len(changes) != 0 {
+			t.Errorf("%s: expected no changes, got %v, %v", name, changes, err)
+		}
+	}
+}
+
+func TestJobTemplateToSpec(t *testing.T) {
+	tmpl := newTestJobTemplate(t)
+	spec, err := tmpl.ToSpec(WithName("rnaseq-sample1"), WithEnv("SAMPLE", "sample1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Name != "rnaseq-sample1" || spec.Env["SAMPLE"] != "sample1" || spec.Env["THREADS"] != "4" {
+		t.Errorf("unexpected name %q or env %v", spec.Name, spec.Env)
+	}
+	if spec.Timeout != 7200 {
+		t.Errorf("expected a 2h time limit, got %d seconds", spec.Timeout)
+	}
+	hc := spec.HealthConfig
+	if hc == nil || hc.Interval != 10*time.Second || hc.Timeout != 30*time.Second || hc.Retries != 3 {
+		t.Errorf("expected health check with defaults, got %+v", hc)
+	}
+	if tmpl.Spec.Name != "rnaseq" || tmpl.Spec.Env["SAMPLE"] != "s1" || tmpl.Spec.HealthConfig != nil {
+		t.Error("ToSpec must not modify the template")
+	}
+}
+
+func TestJobTemplateOverride(t *testing.T) {
+	tmpl := newTestJobTemplate(t)
+	over, err := tmpl.Override([]byte(`
+spec:
+  name: rnaseq-sample2
+  env: {SAMPLE: s2, THREADS: null}
+  command: [/app/executor.sh, --fast]
+timeLimit: 30m
+health: null
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := over.ToSpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Name != "rnaseq-sample2" || !reflect.DeepEqual(spec.Env, map[string]string{"SAMPLE": "s2"}) {
+		t.Errorf("unexpected name %q or env %v", spec.Name, spec.Env)
+	}
+	if !reflect.DeepEqual(spec.Command, []string{"/app/executor.sh", "--fast"}) || spec.WorkDir != "/app" {
+		t.Errorf("expected command to be replaced and workdir kept, got %v, %q", spec.Command, spec.WorkDir)
+	}
+	if spec.Timeout != 1800 || spec.HealthConfig != nil {
+		t.Errorf("unexpected time limit %d or health %+v", spec.Timeout, spec.HealthConfig)
+	}
+	if *spec.ResourceLimits.Memory.Limit != 8<<30 {
+		t.Errorf("expected memory limit to survive the override, got %d", *spec.ResourceLimits.Memory.Limit)
+	}
+	if tmpl.Spec.Env["THREADS"] != "4" || tmpl.TimeLimit != "2h" {
+		t.Error("Override must not modify the template")
+	}
+
+	for patch, want := range map[string]string{
+		"timeLimit: soon":       `invalid time limit "soon"`,
+		"spec: {nmae: x}":       `unknown field "nmae"`,
+		"health: {command: ls}": "invalid healthcheck config",
+		"name: null":            "template name cannot be empty",
+		"spec: [":               "invalid override",
+	} {
+		if _, err := tmpl.Override([]byte(patch)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Override(%q): expected %q, got %v", patch, want, err)
+		}
+	}
+}
+
+func TestDiffJobTemplates(t *testing.T) {
+	a := newTestJobTemplate(t)
+	b, err := a.Override([]byte(`{"spec": {"env": {"SAMPLE": "s2", "THREADS": null, "DEBUG": "1"}, "labels": {"app.kubernetes.io/name": "star"}}, "timeLimit": null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := DiffJobTemplates(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"+ spec.env.DEBUG: 1",
+		"~ spec.env.SAMPLE: s1 -> s2",
+		"- spec.env.THREADS: 4",
+		`~ spec.labels["app.kubernetes.io/name"]: rnaseq -> star`,
+		"- timeLimit: 2h",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected diff\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestLoadJobTemplate_Errors(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"job.toml":    "name = \"x\"\n",
+		"nospec.yaml": "name: x\n",
+		"typo.json":   "{\n  \"name\": \"x\",\n  \"spec\": {},\n  \"timeLimt\": \"1h\"\n}",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]string{
+		"job.toml":    `unsupported template file extension ".toml"`,
+		"nospec.yaml": "template x has no spec",
+		"typo.json":   `line 4, column 3: json: unknown field "timeLimt"`,
+	} {
+		if _, err := LoadJobTemplate(filepath.Join(dir, name)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected %q, got %v", name, want, err)
+		}
+	}
+	if err := SaveJobTemplate(filepath.Join(dir, "empty.json"), &JobTemplate{Name: "empty"}); err == nil {
+		t.Error("expected an error when saving a template without spec")
+	}
+}
+
+func TestWithTimeLimit(t *testing.T) {
+	spec, err := NewSpec(WithTimeLimit(90*time.Second + time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Timeout != 91 {
+		t.Errorf("expected time limit to round up to 91 seconds, got %d", spec.Timeout)
+	}
+	if _, err := NewSpec(WithTimeLimit(0)); err == nil {
+		t.Error("expected an error for a zero time limit")
+	}
+}
@@ -0,0 +1,153 @@
+package podbridge5
+
+import (
+	"errors"
+	"fmt"
+	"github.com/containers/buildah"
+	"github.com/containers/podman/v5/pkg/specgen"
+	"github.com/seoyhaein/utils"
+	"regexp"
+)
+
+// defaultUID 는 UserConfig.UID 가 0 일 때 사용하는 UID 이다.
+const defaultUID = 1000
+
+// userNamePattern 은 useradd, adduser 가 공통으로 받아들이는 이름이다. 셸 명령에 그대로 들어가므로 엄격하게 검사한다.
+var userNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// UserConfig 는 빌드 중에 만들어 이미지의 USER 로 설정할 사용자이다.
+// 빌드 단계(패키지 설치, run 단계)는 root 로 실행되고, 완성된 이미지의 컨테이너만 이 사용자로 실행된다.
+//
+// config.json 예:
+//
+//	"user": {"name": "app", "uid": 1000}
+type UserConfig struct {
+	Name  string `json:"name"`  // 사용자 이름 (예: "app")
+	UID   int    `json:"uid"`   // 0 이면 1000
+	Group string `json:"group"` // 비어 있으면 Name
+	GID   int    `json:"gid"`   // 0 이면 UID
+}
+
+// Validate 는 root 가 아닌 사용자인지, 이름이 올바른지 확인한다.
+func (u *UserConfig) Validate() error {
+	if utils.IsEmptyString(u.Name) {
+		return errors.New("user name cannot be empty")
+	}
+	if u.Name == "root" || u.Group == "root" {
+		return errors.New("user and group must not be root")
+	}
+	if !userNamePattern.MatchString(u.Name) {
+		return fmt.Errorf("invalid user name %q", u.Name)
+	}
+	if u.Group != "" && !userNamePattern.MatchString(u.Group) {
+		return fmt.Errorf("invalid group name %q", u.Group)
+	}
+	if u.UID < 0 || u.GID < 0 {
+		return errors.New("uid and gid must not be negative")
+	}
+	return nil
+}
+
+func (u *UserConfig) group() string {
+	if u.Group == "" {
+		return u.Name
+	}
+	return u.Group
+}
+
+func (u *UserConfig) uid() int {
+	if u.UID == 0 {
+		return defaultUID
+	}
+	return u.UID
+}
+
+func (u *UserConfig) gid() int {
+	if u.GID == 0 {
+		return u.uid()
+	}
+	return u.GID
+}
+
+// owner 는 chown 에 넣을 "user:group" 값이다.
+func (u *UserConfig) owner() string {
+	return u.Name + ":" + u.group()
+}
+
+// createCommand 는 사용자와 그룹을 만드는 셸 명령이다. 이미 있으면 만들지 않는다.
+// useradd 가 있으면(Debian, RHEL 계열) useradd 를, 없으면(Alpine, busybox) adduser 를 사용한다.
+func (u *UserConfig) createCommand() string {
+	name, group, uid, gid := u.Name, u.group(), u.uid(), u.gid()
+	return fmt.Sprintf("if command -v useradd >/dev/null 2>&1; then "+
+		"grep -q '^%[2]s:' /etc/group || groupadd -g %[4]d %[2]s; "+
+		"id -u %[1]s >/dev/null 2>&1 || useradd -u %[3]d -g %[2]s -M -s /sbin/nologin %[1]s; "+
+		"else "+
+		"grep -q '^%[2]s:' /etc/group || addgroup -S -g %[4]d %[2]s; "+
+		"id -u %[1]s >/dev/null 2>&1 || adduser -S -D -H -u %[3]d -G %[2]s %[1]s; "+
+		"fi", name, group, uid, gid)
+}
+
+// create 는 builder 안에 사용자와 그룹을 만든다.
+func (u *UserConfig) create(builder *buildah.Builder, p *buildProgress) error {
+	if err := p.run(builder, []string{"/bin/sh", "-c", u.createCommand()}); err != nil {
+		return fmt.Errorf("failed to create user %s: %w", u.Name, err)
+	}
+	return nil
+}
+
+// chown 은 paths 의 소유자를 이 사용자로 바꾼다.
+func (u *UserConfig) chown(builder *buildah.Builder, paths []string, p *buildProgress) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	args := append([]string{"chown", "-R", u.owner()}, paths...)
+	if err := p.run(builder, args); err != nil {
+		return fmt.Errorf("failed to change owner to %s: %w", u.owner(), err)
+	}
+	return nil
+}
+
+// scriptModes 는 소유자를 지정하지 않은 ScriptMap 파일들을 이 사용자 소유로 복사하도록 modes 를 채운다.
+// 권한(Mode)은 그대로 두고, modes 는 수정하지 않는다.
+func (u *UserConfig) scriptModes(scripts map[string][]string, modes map[string]FileMode) map[string]FileMode {
+	result := make(map[string]FileMode, len(modes))
+	for src, m := range modes {
+		result[src] = m
+	}
+	for _, srcs := range scripts {
+		for _, src := range srcs {
+			m := result[src]
+			if m.Owner == "" && m.Group == "" {
+				m.Owner, m.Group = u.Name, u.group()
+			}
+			result[src] = m
+		}
+	}
+	return result
+}
+
+// WithUser 는 컨테이너를 실행할 사용자를 지정한다. (예: "app", "1000", "1000:1000")
+// 지정하지 않으면 이미지의 USER 를 사용한다.
+func WithUser(user string) ContainerOptions {
+	return func(spec *specgen.SpecGenerator) error {
+		if utils.IsEmptyString(user) {
+			return errors.New("user cannot be empty")
+		}
+		spec.User = user
+		return nil
+	}
+}
+
+// WithUserNS 는 컨테이너의 user namespace 를 지정한다.
+// "keep-id" 는 호스트 사용자의 UID/GID 를 컨테이너 안에서도 그대로 사용하고(rootless), "auto" 는 겹치지 않는 UID 범위를 자동으로 할당한다.
+// "keep-id:uid=1000,gid=1000", "auto:size=65536" 처럼 podman 의 --userns 값을 그대로 사용할 수 있다.
+func WithUserNS(mode string) ContainerOptions {
+	ns, err := specgen.ParseUserNamespace(mode)
+	return func(spec *specgen.SpecGenerator) error {
+		if err != nil {
+			return fmt.Errorf("invalid userns %q: %w", mode, err)
+		}
+		spec.UserNS = ns
+		return nil
+	}
+}
@@ -0,0 +1,103 @@
+package podbridge5
+
+import (
+	"github.com/containers/podman/v5/pkg/specgen"
+	"strings"
+	"testing"
+)
+
+func TestUserConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    UserConfig
+		wantErr bool
+	}{
+		{"name only", UserConfig{Name: "app"}, false},
+		{"with ids", UserConfig{Name: "app", UID: 1001, Group: "jobs", GID: 2000}, false},
+		{"empty name", UserConfig{}, true},
+		{"root", UserConfig{Name: "root"}, true},
+		{"root group", UserConfig{Name: "app", Group: "root"}, true},
+		{"shell characters", UserConfig{Name: "app;rm -rf /"}, true},
+		{"uppercase", UserConfig{Name: "App"}, true},
+		{"negative uid", UserConfig{Name: "app", UID: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.user.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserConfigDefaults(t *testing.T) {
+	u := &UserConfig{Name: "app"}
+	if u.uid() != defaultUID || u.gid() != defaultUID || u.owner() != "app:app" {
+		t.Errorf("unexpected defaults uid=%d gid=%d owner=%s", u.uid(), u.gid(), u.owner())
+	}
+	cmd := u.createCommand()
+	for _, want := range []string{"groupadd -g 1000 app", "useradd -u 1000 -g app", "addgroup -S -g 1000 app", "adduser -S -D -H -u 1000 -G app app"} {
+		if !strings.Contains(cmd, want) {
+			t.Errorf("expected %q in %q", want, cmd)
+		}
+	}
+}
+
+func TestUserConfigScriptModes(t *testing.T) {
+	u := &UserConfig{Name: "app", Group: "jobs"}
+	scripts := map[string][]string{"/app": {"./executor.sh", "./install.sh"}}
+	modes := map[string]FileMode{"./install.sh": {Mode: "0700", Owner: "0"}}
+	got := u.scriptModes(scripts, modes)
+	if m := got["./executor.sh"]; m.Owner != "app" || m.Group != "jobs" || m.Mode != "" {
+		t.Errorf("expected executor.sh owned by app:jobs, got %+v", m)
+	}
+	if m := got["./install.sh"]; m.Owner != "0" || m.Mode != "0700" {
+		t.Errorf("expected explicit owner to be kept, got %+v", m)
+	}
+	if len(modes) != 1 || modes["./install.sh"].Owner != "0" {
+		t.Error("expected input modes to be left unchanged")
+	}
+}
+
+func TestImageConfigBuildSteps_User(t *testing.T) {
+	img := NewConfig("docker.io/library/alpine:latest").Image
+	base := img.buildSteps()
+	img.User = &UserConfig{Name: "app"}
+	// 사용자 생성 + chown
+	if got := img.buildSteps(); got != base+2 {
+		t.Errorf("expected %d steps, got %d", base+2, got)
+	}
+
+	before, err := (&ImageConfig{}).fingerprint("sha256:source", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := (&ImageConfig{User: &UserConfig{Name: "app"}}).fingerprint("sha256:source", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Error("expected fingerprint to change when user changes")
+	}
+}
+
+func TestWithUserAndUserNS(t *testing.T) {
+	spec, err := NewSpec(WithUser("app"), WithUserNS("keep-id:uid=1000,gid=1000"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if spec.User != "app" || spec.UserNS.NSMode != specgen.KeepID || spec.UserNS.Value != "uid=1000,gid=1000" {
+		t.Errorf("unexpected spec user=%q userns=%+v", spec.User, spec.UserNS)
+	}
+	spec, err = NewSpec(WithUserNS("auto"))
+	if err != nil || spec.UserNS.NSMode != specgen.Auto {
+		t.Errorf("expected auto userns, got %+v (%v)", spec.UserNS, err)
+	}
+	if _, err := NewSpec(WithUser("")); err == nil {
+		t.Error("expected error for empty user")
+	}
+	if _, err := NewSpec(WithUserNS("bogus:value")); err == nil {
+		t.Error("expected error for invalid userns")
+	}
+}
@@ -7,13 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/containers/podman/v5/pkg/bindings/containers"
-	"github.com/containers/podman/v5/pkg/bindings/images"
 	"github.com/containers/podman/v5/pkg/bindings/volumes"
 	"github.com/containers/podman/v5/pkg/domain/entities/types"
 	"github.com/containers/podman/v5/pkg/specgen"
 	"io"
 	"io/fs"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -87,7 +85,11 @@ func WithNamedVolume(volumeName, dest, subPath string, options ...string) Contai
 // TODO nfs, lustre 로 volume 을 원격지에 둘경우 대응해줘야 함. 지금은 local 만 해줌
 
 // CreateVolume 주어진 볼륨 이름을 기반으로 볼륨 만들어줌. ignoreIfExists true 이면, 동일한 볼륨이 있으면 에러 리턴하지 않고 그대로 사용.
+// ContextWithDryRun 의 ctx 이면 이름 충돌만 확인하고 생성은 기록만 한다.
 func CreateVolume(ctx context.Context, volumeName string, ignoreIfExists bool) (*types.VolumeConfigResponse, error) {
+	if d := dryRunFrom(ctx); d != nil {
+		return d.createVolume(ctx, volumeName, ignoreIfExists)
+	}
 	volConfig := types.VolumeCreateOptions{
 		Name:           volumeName,
 		IgnoreIfExists: ignoreIfExists, // 만약 true 이면, 동일한 이름의 볼륨이 있으면 생성하지 않고 기존 볼륨을 사용
@@ -199,6 +201,7 @@ func VolumeExists(ctx context.Context, name string) (bool, error) {
 
 // WriteFolderToVolume TODO 일단 테스트 필요 일단 붙이면서 보자. 동시성 문제의 경우 ctx 관련해서 생각해보자. 중요.
 // TODO 부가적으로 시간 또는 퍼센트를 나타내는 것을 추가할지 고민해야 함. 일단 합치는 것 부터 하고 나머지 진행하기로 함.
+// ContextWithDryRun 의 ctx 이면 hostDir 검증, 볼륨 확인, 이미지 확인만 하고 볼륨과 임시 컨테이너 작업은 기록만 한다.
 func WriteFolderToVolume(parentCtx context.Context, volumeName, mountPath, hostDir string, mode VolumeMode) error {
 	ctx, cancel := context.WithCancel(parentCtx)
 	defer cancel()
@@ -220,6 +223,11 @@ func WriteFolderToVolume(parentCtx context.Context, volumeName, mountPath, hostD
 		return fmt.Errorf("WriteFolderToVolume: check volume existence: %w", err)
 	}
 
+	// dry-run 에서는 볼륨 작업과 복사할 파일들을 기록만 한다.
+	if d := dryRunFrom(ctx); d != nil {
+		return d.writeFolderToVolume(ctx, volumeName, mountPath, hostDir, mode, exists)
+	}
+
 	switch mode {
 	case ModeOverwrite:
 		// OverwriteVolume 내부에서 다시 체크하므로 그대로 호출
@@ -267,30 +275,14 @@ func WriteFolderToVolume(parentCtx context.Context, volumeName, mountPath, hostD
 		}),
 		WithNamedVolume(vcr.Name, mountPath, ""),
 	)*/
-	spec, err := NewSpec(
-		WithImageName("docker.io/library/alpine:latest"),
-		WithName("temp-folder-writer"),
-		WithEnv("MOUNT", mountPath),
-		WithCommand([]string{
-			"sh", "-c",
-			"mkdir -p \"$MOUNT\"; exec tail -f /dev/null",
-		}),
-		WithNamedVolume(vcr.Name, mountPath, ""),
-	)
-
+	spec, err := folderWriterSpec(vcr.Name, mountPath)
 	if err != nil {
 		return fmt.Errorf("WriteFolderToVolume: build container spec: %w", err)
 	}
 
-	// 2. 이미지 확인/풀
-	ok, err := images.Exists(ctx, spec.Image, nil)
-	if err != nil {
-		return fmt.Errorf("WriteFolderToVolume: image exists check: %w", err)
-	}
-	if !ok {
-		if _, err := images.Pull(ctx, spec.Image, &images.PullOptions{}); err != nil {
-			return fmt.Errorf("WriteFolderToVolume: image pull: %w", err)
-		}
+	// 2. 이미지 확인/풀 (오프라인 모드에서는 번들에서 로드)
+	if err := ensureImage(ctx, spec.Image); err != nil {
+		return fmt.Errorf("WriteFolderToVolume: %w", err)
 	}
 
 	// 3. 컨테이너 생성 & 시작
@@ -429,11 +421,25 @@ func WriteFolderToVolume(parentCtx context.Context, volumeName, mountPath, hostD
 	return nil
 }
 
+// folderWriterSpec 은 WriteFolderToVolume 이 볼륨을 mountPath 에 마운트해 파일을 복사할 때 쓰는 임시 컨테이너의 spec 이다.
+func folderWriterSpec(volumeName, mountPath string) (*specgen.SpecGenerator, error) {
+	return NewSpec(
+		WithImageName(helperImage),
+		WithName("temp-folder-writer"),
+		WithEnv("MOUNT", mountPath),
+		WithCommand([]string{
+			"sh", "-c",
+			"mkdir -p \"$MOUNT\"; exec tail -f /dev/null",
+		}),
+		WithNamedVolume(volumeName, mountPath, ""),
+	)
+}
+
 // ReadDataFromVolume TODO 이거 생각해보자. 필요한지
 func ReadDataFromVolume(ctx context.Context, volumeName, mountPath, fileName string) (string, error) {
 	// 1. Build the container specification.
 	spec, err := NewSpec(
-		WithImageName("docker.io/library/alpine:latest"),
+		WithImageName(helperImage),
 		WithName("temp-data-reader"),
 		WithCommand([]string{"sh", "-c", "mkdir -p /data && sleep infinity"}),
 		WithNamedVolume(volumeName, mountPath, ""),
@@ -442,16 +448,9 @@ func ReadDataFromVolume(ctx context.Context, volumeName, mountPath, fileName str
 		return "", fmt.Errorf("failed to build container spec: %w", err)
 	}
 
-	// 2. Check if the image exists; if not, pull it.
-	imageExists, err := images.Exists(ctx, spec.Image, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to check if image %q exists: %w", spec.Image, err)
-	}
-	if !imageExists {
-		log.Printf("Pulling image %s...", spec.Image)
-		if _, err := images.Pull(ctx, spec.Image, &images.PullOptions{}); err != nil {
-			return "", fmt.Errorf("failed to pull image %q: %w", spec.Image, err)
-		}
+	// 2. Check if the image exists; if not, pull it (or load it from the offline bundle).
+	if err := ensureImage(ctx, spec.Image); err != nil {
+		return "", err
 	}
 
 	// 3. Create the temporary container.
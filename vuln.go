@@ -0,0 +1,336 @@
+package podbridge5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/containers/buildah"
+	"github.com/seoyhaein/utils"
+	"io"
+	"sort"
+	"strings"
+)
+
+// 취약점 심각도
+const (
+	SeverityUnknown  = "unknown" // 권고에 등급도 CVSS v3 벡터도 없는 경우
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// severityRank 는 심각도의 순서이다.
+var severityRank = map[string]int{
+	SeverityUnknown:  0,
+	SeverityLow:      1,
+	SeverityMedium:   2,
+	SeverityHigh:     3,
+	SeverityCritical: 4,
+}
+
+// ErrVulnerabilityThreshold 는 VulnerabilityCheck.FailOn 이상의 취약점이 있을 때 반환되는 *VulnerabilityError 가 감싸는 에러이다.
+var ErrVulnerabilityThreshold = errors.New("vulnerabilities at or above the threshold")
+
+// VulnerabilityCheck 는 이미지에 설치된 패키지를 로컬 권고 데이터베이스와 비교하는 설정이다. 네트워크에 접근하지 않는다.
+// 패키지는 SBOM 과 같은 방식으로 apk, dpkg, rpm 데이터베이스에서 읽고, os-release 의 배포판과 릴리스가 같은 권고만 비교한다.
+type VulnerabilityCheck struct {
+	Database string                     // OSV JSON 파일, OSV JSON 파일들이 있는 디렉토리 또는 OSV 의 ecosystem 덤프(all.zip)
+	FailOn   string                     // 이 심각도 이상인 취약점이 있으면 빌드를 실패시킨다. 비어 있으면 보고만 한다
+	Ignore   []string                   // 무시할 취약점 ID 또는 alias (예: "CVE-2024-1234")
+	OnReport func(*VulnerabilityReport) // 검사 결과를 받는다. 빌드가 실패하는 경우에도 호출된다
+}
+
+// VulnerabilityFinding 은 설치된 패키지 하나에서 발견된 취약점이다.
+type VulnerabilityFinding struct {
+	ID           string
+	Aliases      []string
+	Summary      string
+	Severity     string
+	Package      SBOMPackage
+	FixedVersion string // 수정된 버전. 아직 수정되지 않았거나 알 수 없으면 비어 있다
+}
+
+// VulnerabilityReport 는 이미지 하나의 검사 결과이다.
+type VulnerabilityReport struct {
+	Image    string
+	OS       string // os-release 의 "ID VERSION_ID"
+	Packages int    // 검사한 패키지 수
+	Findings []VulnerabilityFinding
+}
+
+// VulnerabilityError 는 FailOn 이상의 취약점이 있어 빌드를 멈췄을 때 반환된다.
+type VulnerabilityError struct {
+	Threshold string
+	Report    *VulnerabilityReport
+}
+
+func (e *VulnerabilityError) Error() string {
+	findings := e.Report.AtOrAbove(e.Threshold)
+	ids := make([]string, 0, len(findings))
+	for _, f := range findings {
+		ids = append(ids, f.ID+" ("+f.Package.Name+")")
+	}
+	return fmt.Sprintf("%s has %d vulnerabilities at or above %s: %s", e.Report.Image, len(findings), e.Threshold, strings.Join(ids, ", "))
+}
+
+func (e *VulnerabilityError) Unwrap() error {
+	return ErrVulnerabilityThreshold
+}
+
+// Validate 는 데이터베이스 경로와 FailOn 을 확인한다.
+func (c *VulnerabilityCheck) Validate() error {
+	if utils.IsEmptyString(c.Database) {
+		return errors.New("advisory database cannot be empty")
+	}
+	if exists, _, err := utils.FileExists(c.Database); err != nil || !exists {
+		return fmt.Errorf("advisory database %s does not exist", c.Database)
+	}
+	if c.FailOn != "" {
+		if _, ok := severityRank[c.FailOn]; !ok || c.FailOn == SeverityUnknown {
+			return fmt.Errorf("unknown severity %q (supported: %s, %s, %s, %s)", c.FailOn, SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical)
+		}
+	}
+	return nil
+}
+
+// BySeverity 는 발견된 취약점을 심각도별로 묶는다.
+func (r *VulnerabilityReport) BySeverity() map[string][]VulnerabilityFinding {
+	groups := make(map[string][]VulnerabilityFinding)
+	for _, f := range r.Findings {
+		groups[f.Severity] = append(groups[f.Severity], f)
+	}
+	return groups
+}
+
+// AtOrAbove 는 severity 이상인 취약점들이다.
+func (r *VulnerabilityReport) AtOrAbove(severity string) []VulnerabilityFinding {
+	var findings []VulnerabilityFinding
+	for _, f := range r.Findings {
+		if severityRank[f.Severity] >= severityRank[severity] {
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// summary 는 "12 packages, 3 vulnerabilities (critical 1, high 2)" 형식의 요약이다.
+func (r *VulnerabilityReport) summary() string {
+	groups := r.BySeverity()
+	var counts []string
+	for _, sev := range []string{SeverityCritical, SeverityHigh, SeverityMedium, SeverityLow, SeverityUnknown} {
+		if n := len(groups[sev]); n > 0 {
+			counts = append(counts, fmt.Sprintf("%s %d", sev, n))
+		}
+	}
+	s := fmt.Sprintf("%d packages, %d vulnerabilities", r.Packages, len(r.Findings))
+	if len(counts) > 0 {
+		s += " (" + strings.Join(counts, ", ") + ")"
+	}
+	return s
+}
+
+// scan 은 패키지들을 권고 데이터베이스와 비교한다. 결과는 심각도가 높은 순이다.
+func (c *VulnerabilityCheck) scan(imageName string, pkgs []SBOMPackage, osRelease map[string]string) (*VulnerabilityReport, error) {
+	// 권고는 소스 패키지 이름으로 된 경우가 많으므로(Debian, Alpine) 바이너리와 소스 이름 모두로 찾는다.
+	byName := make(map[string][]int)
+	for i, pkg := range pkgs {
+		byName[pkg.Type+"/"+pkg.Name] = append(byName[pkg.Type+"/"+pkg.Name], i)
+		if src := pkg.sourceName(); src != "" && src != pkg.Name {
+			byName[pkg.Type+"/"+src] = append(byName[pkg.Type+"/"+src], i)
+		}
+	}
+
+	report := &VulnerabilityReport{
+		Image:    imageName,
+		OS:       strings.TrimSpace(osRelease["ID"] + " " + osRelease["VERSION_ID"]),
+		Packages: len(pkgs),
+	}
+	seen := make(map[string]bool)
+	err := readAdvisories(c.Database, func(adv *osvAdvisory) {
+		if adv.Withdrawn != "" || c.ignores(adv) {
+			return
+		}
+		for i := range adv.Affected {
+			aff := &adv.Affected[i]
+			pkgType, ok := ecosystemPackageType(aff.Package.Ecosystem, osRelease)
+			if !ok {
+				continue
+			}
+			for _, idx := range byName[pkgType+"/"+aff.Package.Name] {
+				pkg := pkgs[idx]
+				key := adv.ID + "/" + pkg.Type + "/" + pkg.Name
+				if seen[key] {
+					continue
+				}
+				affected, fixed := aff.affects(pkg.Version, versionComparators[pkgType])
+				if !affected {
+					continue
+				}
+				seen[key] = true
+				report.Findings = append(report.Findings, VulnerabilityFinding{
+					ID:           adv.ID,
+					Aliases:      adv.Aliases,
+					Summary:      adv.Summary,
+					Severity:     adv.severity(aff),
+					Package:      pkg,
+					FixedVersion: fixed,
+				})
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		fi, fj := report.Findings[i], report.Findings[j]
+		if severityRank[fi.Severity] != severityRank[fj.Severity] {
+			return severityRank[fi.Severity] > severityRank[fj.Severity]
+		}
+		if fi.ID != fj.ID {
+			return fi.ID < fj.ID
+		}
+		return fi.Package.Name < fj.Package.Name
+	})
+	return report, nil
+}
+
+func (c *VulnerabilityCheck) ignores(adv *osvAdvisory) bool {
+	if utils.Contains(c.Ignore, adv.ID) {
+		return true
+	}
+	for _, alias := range adv.Aliases {
+		if utils.Contains(c.Ignore, alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate 는 FailOn 이상의 취약점이 있으면 *VulnerabilityError 를 반환한다.
+func (c *VulnerabilityCheck) evaluate(report *VulnerabilityReport) error {
+	if c.FailOn == "" || len(report.AtOrAbove(c.FailOn)) == 0 {
+		return nil
+	}
+	return &VulnerabilityError{Threshold: c.FailOn, Report: report}
+}
+
+// sourceName 은 소스 패키지 이름이다. rpm 의 소스 rpm 이름("bash-5.1.8-9.el9")에서는 버전과 릴리스를 뗀다.
+func (pkg SBOMPackage) sourceName() string {
+	if pkg.Type != "rpm" {
+		return pkg.Source
+	}
+	name := pkg.Source
+	for i := 0; i < 2; i++ {
+		if j := strings.LastIndex(name, "-"); j > 0 {
+			name = name[:j]
+		}
+	}
+	return name
+}
+
+// ScanImageVulnerabilities 는 로컬 스토리지의 이미지(name)를 check 의 데이터베이스와 비교한 결과를 반환한다.
+// FailOn 은 적용하지 않는다. Init 이 먼저 호출되어 있어야 한다.
+func ScanImageVulnerabilities(ctx context.Context, name string, check *VulnerabilityCheck) (*VulnerabilityReport, error) {
+	if pbStore == nil {
+		return nil, errors.New("pbStore is nil: call Init before scanning images")
+	}
+	if check == nil {
+		return nil, errors.New("vulnerability check cannot be nil")
+	}
+	if err := check.Validate(); err != nil {
+		return nil, err
+	}
+	builder, err := newBuilder(ctx, pbStore, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create builder for %s: %w", name, err)
+	}
+	defer func() {
+		if dErr := builder.Delete(); dErr != nil {
+			Log.Warnf("Failed to delete builder: %v", dErr)
+		}
+	}()
+	pkgs, osRelease, err := builderPackages(builder, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+	return check.scan(name, pkgs, osRelease)
+}
+
+// WithVulnerabilityCheck 는 check 로 베이스 이미지를 빌드하기 전에, 결과 이미지를 커밋하기 전에 한 번씩 취약점을 검사한다.
+// FailOn 이상의 취약점이 있으면 빌드를 멈추고 *VulnerabilityError 를 반환한다. OnReport 는 검사마다 호출된다.
+// 캐시된 이미지를 사용하는 경우에도 그 이미지를 다시 검사하므로 데이터베이스가 갱신되면 새 권고가 반영된다.
+func WithVulnerabilityCheck(check *VulnerabilityCheck) ImageBuildOption {
+	return func(o *imageBuildOptions) {
+		o.vulnCheck = check
+	}
+}
+
+// vulnCheckSteps 는 취약점 검사 단계 수이다. 베이스 이미지와 결과 이미지를 한 번씩 검사한다.
+func (o *imageBuildOptions) vulnCheckSteps() int {
+	if o.vulnCheck == nil {
+		return 0
+	}
+	return 2
+}
+
+// checkSourceVulnerabilities 는 setup 하기 전에 베이스 이미지(builder.FromImage)를 검사한다.
+// 베이스 이미지가 기준을 넘으면 빌드 단계나 패키지 설치 같은 작업을 하기 전에 실패한다.
+// 빌드 단계가 설치한 패키지는 커밋하기 전에 checkVulnerabilities 로 다시 검사한다.
+func (o *imageBuildOptions) checkSourceVulnerabilities(builder *buildah.Builder, p *buildProgress) error {
+	return o.checkVulnerabilities(builder, builder.FromImage, p)
+}
+
+// checkVulnerabilities 는 WithVulnerabilityCheck 가 주어졌으면 builder 의 rootfs 를 검사한다.
+func (o *imageBuildOptions) checkVulnerabilities(builder *buildah.Builder, imageName string, p *buildProgress) error {
+	c := o.vulnCheck
+	if c == nil {
+		return nil
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	var report *VulnerabilityReport
+	err := p.step("VULNCHECK "+imageName, func(stdout, stderr io.Writer) error {
+		pkgs, osRelease, err := builderPackages(builder, stderr)
+		if err != nil {
+			return err
+		}
+		if report, err = c.scan(imageName, pkgs, osRelease); err != nil {
+			return err
+		}
+		for _, f := range report.Findings {
+			line := fmt.Sprintf("%s %s %s %s", strings.ToUpper(f.Severity), f.ID, f.Package.Name, f.Package.Version)
+			if f.FixedVersion != "" {
+				line += " (fixed in " + f.FixedVersion + ")"
+			}
+			_, _ = fmt.Fprintln(stdout, line)
+		}
+		_, _ = fmt.Fprintln(stdout, report.summary())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check vulnerabilities: %w", err)
+	}
+	if c.OnReport != nil {
+		c.OnReport(report)
+	}
+	return c.evaluate(report)
+}
+
+// checkCachedVulnerabilities 는 캐시된 이미지(imageID)로 임시 builder 를 만들어 검사한다.
+func (o *imageBuildOptions) checkCachedVulnerabilities(ctx context.Context, imageID, imageName string, p *buildProgress) error {
+	if o.vulnCheck == nil {
+		return nil
+	}
+	builder, err := newBuilder(ctx, pbStore, imageID)
+	if err != nil {
+		return fmt.Errorf("failed to create builder for cached image %s: %w", imageName, err)
+	}
+	defer func() {
+		if dErr := builder.Delete(); dErr != nil {
+			Log.Warnf("Failed to delete builder: %v", dErr)
+		}
+	}()
+	return o.checkVulnerabilities(builder, imageName, p)
+}
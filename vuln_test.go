@@ -0,0 +1,173 @@
+package podbridge5
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testAlpineAdvisories = `[
+{
+  "id": "ALPINE-CVE-2024-0001",
+  "aliases": ["CVE-2024-0001"],
+  "summary": "busybox heap overflow",
+  "affected": [{
+    "package": {"ecosystem": "Alpine:v3.20", "name": "busybox"},
+    "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "1.36.1-r30"}]}]
+  }],
+  "severity": [{"type": "CVSS_V3", "score": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"}]
+},
+{
+  "id": "ALPINE-CVE-2024-0002",
+  "affected": [{
+    "package": {"ecosystem": "Alpine:v3.20", "name": "musl"},
+    "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "1.2.5-r1"}]}],
+    "database_specific": {"severity": "low"}
+  }]
+},
+{
+  "id": "ALPINE-CVE-2024-0003",
+  "affected": [{
+    "package": {"ecosystem": "Alpine:v3.20", "name": "musl"},
+    "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "1.2.4-r0"}]}]
+  }]
+},
+{
+  "id": "ALPINE-CVE-2024-0004",
+  "withdrawn": "2024-06-01T00:00:00Z",
+  "affected": [{"package": {"ecosystem": "Alpine:v3.20", "name": "musl"}, "versions": ["1.2.5-r0"]}]
+},
+{
+  "id": "ALPINE-CVE-2023-0005",
+  "affected": [{
+    "package": {"ecosystem": "Alpine:v3.18", "name": "busybox"},
+    "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}]}]
+  }]
+}
+]`
+
+func testVulnerabilityCheck(t *testing.T) *VulnerabilityCheck {
+	t.Helper()
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{"alpine.json": testAlpineAdvisories})
+	return &VulnerabilityCheck{Database: filepath.Join(dir, "alpine.json")}
+}
+
+func TestVulnerabilityCheckScan(t *testing.T) {
+	c := testVulnerabilityCheck(t)
+	pkgs := parseApkInstalled([]byte(testApkInstalled))
+	osRelease := map[string]string{"ID": "alpine", "VERSION_ID": "3.20.3"}
+
+	report, err := c.scan("tester-internal:latest", pkgs, osRelease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Findings) != 2 {
+		t.Fatalf("expected 2 findings, got %+v", report.Findings)
+	}
+	top := report.Findings[0]
+	if top.ID != "ALPINE-CVE-2024-0001" || top.Severity != SeverityCritical || top.FixedVersion != "1.36.1-r30" || top.Package.Name != "busybox" {
+		t.Errorf("unexpected finding %+v", top)
+	}
+	if report.Findings[1].ID != "ALPINE-CVE-2024-0002" || report.Findings[1].Severity != SeverityLow {
+		t.Errorf("unexpected finding %+v", report.Findings[1])
+	}
+	if groups := report.BySeverity(); len(groups[SeverityCritical]) != 1 || len(groups[SeverityLow]) != 1 {
+		t.Errorf("unexpected groups %v", groups)
+	}
+	if got := report.summary(); got != "2 packages, 2 vulnerabilities (critical 1, low 1)" {
+		t.Errorf("summary() = %q", got)
+	}
+
+	c.Ignore = []string{"CVE-2024-0001"}
+	report, err = c.scan("tester-internal:latest", pkgs, osRelease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Findings) != 1 || report.Findings[0].ID != "ALPINE-CVE-2024-0002" {
+		t.Errorf("expected ignored alias to be skipped, got %+v", report.Findings)
+	}
+}
+
+func TestVulnerabilityCheckScan_SourcePackage(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{"DSA-1.json": `{
+  "id": "DSA-1",
+  "affected": [{
+    "package": {"ecosystem": "Debian:12", "name": "glibc"},
+    "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "2.36-9+deb12u8"}]}],
+    "ecosystem_specific": {"urgency": "high"}
+  }]
+}`})
+	c := &VulnerabilityCheck{Database: dir}
+	report, err := c.scan("tester-internal:latest", parseDpkgStatus([]byte(testDpkgStatus)), map[string]string{"ID": "debian", "VERSION_ID": "12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Findings) != 1 || report.Findings[0].Package.Name != "libc6" || report.Findings[0].Severity != SeverityHigh {
+		t.Errorf("expected libc6 to match its source package, got %+v", report.Findings)
+	}
+}
+
+func TestVulnerabilityCheckEvaluate(t *testing.T) {
+	report := &VulnerabilityReport{Image: "tester-internal:latest", Findings: []VulnerabilityFinding{
+		{ID: "CVE-1", Severity: SeverityHigh, Package: SBOMPackage{Name: "openssl"}},
+		{ID: "CVE-2", Severity: SeverityLow, Package: SBOMPackage{Name: "zlib"}},
+	}}
+	if err := (&VulnerabilityCheck{}).evaluate(report); err != nil {
+		t.Errorf("expected no error without FailOn, got %v", err)
+	}
+	if err := (&VulnerabilityCheck{FailOn: SeverityCritical}).evaluate(report); err != nil {
+		t.Errorf("expected no error below threshold, got %v", err)
+	}
+	err := (&VulnerabilityCheck{FailOn: SeverityHigh}).evaluate(report)
+	var vErr *VulnerabilityError
+	if !errors.As(err, &vErr) || !errors.Is(err, ErrVulnerabilityThreshold) {
+		t.Fatalf("expected VulnerabilityError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "CVE-1 (openssl)") || strings.Contains(err.Error(), "CVE-2") {
+		t.Errorf("unexpected error message %q", err.Error())
+	}
+}
+
+func TestVulnerabilityCheckValidate(t *testing.T) {
+	c := testVulnerabilityCheck(t)
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []VulnerabilityCheck{
+		{},
+		{Database: c.Database + ".missing"},
+		{Database: c.Database, FailOn: "severe"},
+		{Database: c.Database, FailOn: SeverityUnknown},
+	}
+	for _, tt := range tests {
+		if err := tt.Validate(); err == nil {
+			t.Errorf("expected error for %+v", tt)
+		}
+	}
+}
+
+func TestSBOMPackageSourceName(t *testing.T) {
+	tests := map[SBOMPackage]string{
+		{Type: "rpm", Name: "bash", Source: "bash-5.1.8-9.el9"}:               "bash",
+		{Type: "rpm", Name: "python3-libs", Source: "python3.9-3.9.18-3.el9"}: "python3.9",
+		{Type: "deb", Name: "libc6", Source: "glibc"}:                         "glibc",
+		{Type: "apk", Name: "busybox"}:                                        "",
+	}
+	for pkg, want := range tests {
+		if got := pkg.sourceName(); got != want {
+			t.Errorf("sourceName(%+v) = %q, want %q", pkg, got, want)
+		}
+	}
+}
+
+func TestVulnCheckSteps(t *testing.T) {
+	if got := newImageBuildOptions().vulnCheckSteps(); got != 0 {
+		t.Errorf("expected no vulnerability check step, got %d", got)
+	}
+	if got := newImageBuildOptions(WithVulnerabilityCheck(&VulnerabilityCheck{Database: "db"})).vulnCheckSteps(); got != 2 {
+		t.Errorf("expected the source and the built image to be checked, got %d", got)
+	}
+}